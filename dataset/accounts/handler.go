@@ -0,0 +1,263 @@
+package accounts
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	_ "embed"
+
+	"github.com/gorilla/sessions"
+	"nicolas.galipot.net/taxonomia/dataset/database"
+)
+
+//go:embed header.html
+var headerTemplateTxt string
+
+//go:embed login.html
+var loginTemplateTxt string
+
+//go:embed account.html
+var accountTemplateTxt string
+
+//go:embed users.html
+var usersTemplateTxt string
+
+// Accesses required by handlers besides the roles of database.Roles.
+const (
+	// Public handlers are open to everyone.
+	Public = ""
+	// SignedIn handlers are open to the users who are logged in, whatever
+	// their roles.
+	SignedIn = "signed-in"
+)
+
+// loginLifetime is how long users stay logged in.
+const loginLifetime = 30 * 24 * time.Hour
+
+var (
+	errAuthenticationRequired = errors.New("authentication required")
+	errForbidden              = errors.New("your account does not have access to this page")
+	errCrossSite              = errors.New("cross-site request refused")
+	errInvalidToken           = errors.New("invalid API token")
+)
+
+type Handler struct {
+	reg      *database.DatasetRegistry
+	store    *sessions.CookieStore
+	template *template.Template
+}
+
+// NewHandler returns the handler of the login and account pages, which
+// also guards the other handlers of the server. The cookie only keeps the
+// id of the user with a stamp of the password, so that changing it logs
+// the other browsers out.
+func NewHandler(reg *database.DatasetRegistry, sessionKey string) *Handler {
+	h := &Handler{reg: reg, store: sessions.NewCookieStore([]byte(sessionKey))}
+	h.store.Options = &sessions.Options{
+		Path:     "/",
+		MaxAge:   int(loginLifetime / time.Second),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	tpl := template.New("accounts").Funcs(template.FuncMap{
+		"roles":     func() []string { return database.Roles },
+		"roleOn":    func(user *database.User, datasetId string) string { return user.Roles[datasetId] },
+		"formatDay": func(t time.Time) string { return t.Format("2006-01-02") },
+	})
+	templates := []struct {
+		name string
+		txt  string
+	}{
+		{"header", headerTemplateTxt},
+		{"login", loginTemplateTxt},
+		{"account", accountTemplateTxt},
+		{"users", usersTemplateTxt},
+	}
+	for _, t := range templates {
+		if _, err := tpl.Parse(t.txt); err != nil {
+			log.Fatalf("cannot parse template %q: %q", t.name, err.Error())
+		}
+	}
+	h.template = tpl
+	return h
+}
+
+type contextKey int
+
+const (
+	userKey contextKey = iota
+	roleKey
+)
+
+// CurrentUser returns the user making a request that went through Require,
+// nil if the user is anonymous.
+func CurrentUser(r *http.Request) *database.User {
+	user, _ := r.Context().Value(userKey).(*database.User)
+	return user
+}
+
+// CurrentRole returns the role on the current dataset of the user making a
+// request that went through Require, empty if the user has none.
+func CurrentRole(r *http.Request) string {
+	role, _ := r.Context().Value(roleKey).(string)
+	return role
+}
+
+// currentDatasetId returns the id of the dataset served, empty if there is
+// none yet.
+func (h *Handler) currentDatasetId() (string, error) {
+	info, err := h.reg.GetDatasetInfo()
+	if err != nil || info == nil {
+		return "", err
+	}
+	return info.Id, nil
+}
+
+// authenticate returns the user of a request from its API token or else its
+// login cookie, nil if it is anonymous, and whether the cookie was used.
+func (h *Handler) authenticate(r *http.Request) (*database.User, bool, error) {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		const prefix = "Bearer "
+		if len(auth) < len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+			return nil, false, errInvalidToken
+		}
+		user, err := h.reg.AuthenticateToken(strings.TrimSpace(auth[len(prefix):]))
+		if err == nil && user == nil {
+			err = errInvalidToken
+		}
+		return user, false, err
+	}
+	cookie, _ := h.store.Get(r, "account")
+	id, ok := cookie.Values["user"].(int64)
+	if !ok {
+		return nil, false, nil
+	}
+	user, err := h.reg.GetUser(id)
+	if err != nil || user == nil || cookie.Values["stamp"] != user.Stamp() {
+		return nil, false, err
+	}
+	return user, true, nil
+}
+
+// Require lets through to a handler the requests of the users having the
+// access it needs: Public, SignedIn or a role on the current dataset. The
+// viewer role is not needed to see public datasets. Forms posted to the
+// users logged in with a cookie are refused when they come from other
+// sites, as browsers send cookies along with any request.
+func (h *Handler) Require(access string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, byCookie, err := h.authenticate(r)
+		if err == errInvalidToken {
+			h.deny(w, r, nil, err)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if byCookie && r.Method != http.MethodGet && r.Method != http.MethodHead && !sameOrigin(r) {
+			h.deny(w, r, user, errCrossSite)
+			return
+		}
+		datasetId, err := h.currentDatasetId()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		role := ""
+		if user != nil {
+			role = user.RoleOn(datasetId)
+		}
+		allowed := true
+		switch access {
+		case Public:
+		case SignedIn:
+			allowed = user != nil
+		case database.RoleViewer:
+			if role == "" {
+				private, err := h.reg.IsDatasetPrivate(datasetId)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				allowed = !private
+			}
+		default:
+			allowed = role != "" && database.RoleAllows(role, access)
+		}
+		if !allowed {
+			if user == nil {
+				h.deny(w, r, nil, errAuthenticationRequired)
+			} else {
+				h.deny(w, r, user, errForbidden)
+			}
+			return
+		}
+		ctx := context.WithValue(r.Context(), userKey, user)
+		ctx = context.WithValue(ctx, roleKey, role)
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// deny refuses a request, sending anonymous visitors of pages to the login
+// page. API clients get their error as JSON.
+func (h *Handler) deny(w http.ResponseWriter, r *http.Request, user *database.User, err error) {
+	isAPI := strings.HasPrefix(r.URL.Path, "/api/")
+	status := http.StatusForbidden
+	if user == nil && err != errCrossSite {
+		if !isAPI && r.Method == http.MethodGet && r.Header.Get("Authorization") == "" {
+			http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="Taxonomia"`)
+		status = http.StatusUnauthorized
+	}
+	if isAPI {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(struct {
+			Error string `json:"error"`
+		}{err.Error()})
+		return
+	}
+	http.Error(w, err.Error(), status)
+}
+
+// sameOrigin tells if a request comes from a page of the server. Requests
+// telling neither their origin nor their referer are refused, as nothing
+// shows where they come from.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		origin = r.Header.Get("Referer")
+	}
+	if origin == "" {
+		return false
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// localPath returns a path of the server to go to after a form, the
+// fallback when the path would leave the server.
+func localPath(path, fallback string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.HasPrefix(path, "/\\") {
+		return fallback
+	}
+	return path
+}
+
+// render executes a template with the status of the request.
+func (h *Handler) render(w http.ResponseWriter, status int, name string, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := h.template.ExecuteTemplate(w, name, data); err != nil {
+		log.Printf("cannot render template %q: %q", name, err.Error())
+	}
+}
@@ -0,0 +1,186 @@
+package accounts
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"nicolas.galipot.net/taxonomia/dataset"
+	"nicolas.galipot.net/taxonomia/dataset/database"
+)
+
+const testPassword = "test password"
+
+// newTestHandler returns a handler over a registry holding the dataset ds,
+// with a user of each role on it whose API tokens are given by role.
+func newTestHandler(t *testing.T) (*Handler, map[string]string) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "db.sq3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := database.CreateTables(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.InsertStandardContent(db); err != nil {
+		t.Fatal(err)
+	}
+	reg := database.NewRegistry(db)
+	ds, err := dataset.ReadHazo(strings.NewReader(`{ "id": "ds", "taxons": [ { "id": "t1", "name": "Quercus" } ] }`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reg.InsertDataset(ds); err != nil {
+		t.Fatal(err)
+	}
+	tokens := map[string]string{}
+	for _, role := range database.Roles {
+		user, err := reg.CreateUser(role, testPassword)
+		if err == nil {
+			err = reg.SetUserRole(user.Id, "ds", role)
+		}
+		if err == nil {
+			tokens[role], _, err = reg.CreateApiToken(user.Id, "test")
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewHandler(reg, "key"), tokens
+}
+
+func TestLocalPath(t *testing.T) {
+	cases := []struct{ path, want string }{
+		{"/admin?tab=users", "/admin?tab=users"},
+		{"", "/account"},
+		{"admin", "/account"},
+		{"//other.org/", "/account"},
+		{"/\\other.org/", "/account"},
+		{"https://other.org/", "/account"},
+	}
+	for _, c := range cases {
+		if got := localPath(c.path, "/account"); got != c.want {
+			t.Errorf("localPath(%q): expected %q, got %q.", c.path, c.want, got)
+		}
+	}
+}
+
+func TestRequireChecksRoles(t *testing.T) {
+	h, tokens := newTestHandler(t)
+	tokens["bogus"] = "tx_bogus"
+	var reached *database.User
+	next := func(w http.ResponseWriter, r *http.Request) { reached = CurrentUser(r) }
+	cases := []struct {
+		access, user, path string
+		status             int
+	}{
+		{Public, "", "/lang", 200},
+		{database.RoleViewer, "", "/identify", 200},
+		{SignedIn, "", "/account", 303},
+		{SignedIn, "viewer", "/account", 200},
+		{database.RoleEditor, "", "/admin", 303},
+		{database.RoleEditor, "", "/api/jobs", 401},
+		{database.RoleEditor, "bogus", "/admin", 401},
+		{Public, "bogus", "/lang", 401},
+		{database.RoleEditor, "viewer", "/admin", 403},
+		{database.RoleEditor, "editor", "/admin", 200},
+		{database.RoleEditor, "admin", "/admin", 200},
+		{database.RoleAdmin, "editor", "/admin/users", 403},
+	}
+	for _, c := range cases {
+		reached = nil
+		r := httptest.NewRequest("GET", c.path, nil)
+		if c.user != "" {
+			r.Header.Set("Authorization", "Bearer "+tokens[c.user])
+		}
+		w := httptest.NewRecorder()
+		h.Require(c.access, next)(w, r)
+		if w.Code != c.status {
+			t.Errorf("%s by %q: expected status %d, got %d.", c.path, c.user, c.status, w.Code)
+		}
+		if c.status == 200 && c.user != "" && (reached == nil || reached.Name != c.user) {
+			t.Errorf("%s by %q: expected the handler to get the user, got %+v.", c.path, c.user, reached)
+		}
+		if location := w.Header().Get("Location"); c.status == 303 && location != "/login?next="+url.QueryEscape(c.path) {
+			t.Errorf("%s: expected to be sent to the login page, got %q.", c.path, location)
+		}
+	}
+	if err := h.reg.SetDatasetPrivate("ds", true); err != nil {
+		t.Fatal(err)
+	}
+	for user, status := range map[string]int{"": 303, "viewer": 200} {
+		r := httptest.NewRequest("GET", "/identify", nil)
+		if user != "" {
+			r.Header.Set("Authorization", "Bearer "+tokens[user])
+		}
+		w := httptest.NewRecorder()
+		h.Require(database.RoleViewer, next)(w, r)
+		if w.Code != status {
+			t.Errorf("Private dataset by %q: expected status %d, got %d.", user, status, w.Code)
+		}
+	}
+}
+
+func TestLoginCookie(t *testing.T) {
+	h, _ := newTestHandler(t)
+	login := func(password, origin string) *httptest.ResponseRecorder {
+		form := url.Values{"name": {"editor"}, "password": {password}, "next": {"/admin"}}
+		r := httptest.NewRequest("POST", "http://example.com/login", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		w := httptest.NewRecorder()
+		h.LoginFunc(w, r)
+		return w
+	}
+	if w := login(testPassword, ""); w.Code != http.StatusForbidden || len(w.Result().Cookies()) != 0 {
+		t.Errorf("Expected a login without origin to be refused, got %d.", w.Code)
+	}
+	if w := login("wrong password", "http://example.com"); w.Code != http.StatusUnauthorized || len(w.Result().Cookies()) != 0 {
+		t.Errorf("Expected a wrong password to be refused, got %d.", w.Code)
+	}
+	w := login(testPassword, "http://example.com")
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/admin" || len(w.Result().Cookies()) != 1 {
+		t.Fatalf("Expected to be logged in and sent to /admin, got %d to %q.", w.Code, w.Header().Get("Location"))
+	}
+	cookie := w.Result().Cookies()[0]
+	request := func(method, origin string) int {
+		r := httptest.NewRequest(method, "http://example.com/admin", nil)
+		r.AddCookie(cookie)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		w := httptest.NewRecorder()
+		h.Require(database.RoleEditor, func(w http.ResponseWriter, r *http.Request) {})(w, r)
+		return w.Code
+	}
+	if status := request("GET", ""); status != http.StatusOK {
+		t.Errorf("Expected the cookie to sign in, got %d.", status)
+	}
+	if status := request("POST", "http://example.com"); status != http.StatusOK {
+		t.Errorf("Expected forms of the server to be accepted, got %d.", status)
+	}
+	if status := request("POST", "https://other.org"); status != http.StatusForbidden {
+		t.Errorf("Expected forms of other sites to be refused, got %d.", status)
+	}
+	if status := request("POST", ""); status != http.StatusForbidden {
+		t.Errorf("Expected forms without origin to be refused, got %d.", status)
+	}
+	// Changing the password logs the other browsers out.
+	user, err := h.reg.Authenticate("editor", testPassword)
+	if err != nil || user == nil {
+		t.Fatal(err)
+	}
+	if err := h.reg.SetUserPassword(user.Id, "new password"); err != nil {
+		t.Fatal(err)
+	}
+	if status := request("GET", ""); status != http.StatusSeeOther {
+		t.Errorf("Expected the cookie to be outdated, got %d.", status)
+	}
+}
@@ -0,0 +1,141 @@
+package accounts
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"nicolas.galipot.net/taxonomia/dataset/database"
+)
+
+type LoginTemplateData struct {
+	Name  string
+	Next  string
+	Error string
+}
+
+// logIn stores the user in the login cookie of the browser.
+func (h *Handler) logIn(w http.ResponseWriter, r *http.Request, user *database.User) error {
+	cookie, _ := h.store.Get(r, "account")
+	cookie.Values["user"] = user.Id
+	cookie.Values["stamp"] = user.Stamp()
+	cookie.Options.MaxAge = h.store.Options.MaxAge
+	return cookie.Save(r, w)
+}
+
+// LoginFunc serves the login form, going to the next parameter once the
+// user is logged in.
+func (h *Handler) LoginFunc(w http.ResponseWriter, r *http.Request) {
+	tplData := LoginTemplateData{Next: localPath(r.FormValue("next"), "/account")}
+	if r.Method != http.MethodPost {
+		h.render(w, http.StatusOK, "login", tplData)
+		return
+	}
+	if !sameOrigin(r) {
+		http.Error(w, errCrossSite.Error(), http.StatusForbidden)
+		return
+	}
+	tplData.Name = r.PostFormValue("name")
+	user, err := h.reg.Authenticate(tplData.Name, r.PostFormValue("password"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		tplData.Error = "Wrong name or password."
+		h.render(w, http.StatusUnauthorized, "login", tplData)
+		return
+	}
+	if err := h.logIn(w, r, user); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, tplData.Next, http.StatusSeeOther)
+}
+
+// LogoutFunc removes the user from the login cookie of the browser.
+func (h *Handler) LogoutFunc(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Log out with a POST request.", http.StatusMethodNotAllowed)
+		return
+	}
+	if !sameOrigin(r) {
+		http.Error(w, errCrossSite.Error(), http.StatusForbidden)
+		return
+	}
+	cookie, _ := h.store.Get(r, "account")
+	cookie.Options.MaxAge = -1
+	if err := cookie.Save(r, w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/identify", http.StatusSeeOther)
+}
+
+type AccountTemplateData struct {
+	User     *database.User
+	Role     string
+	Tokens   []database.ApiToken
+	NewToken string
+	Error    string
+	Saved    bool
+}
+
+// AccountFunc serves the page where users change their password and
+// manage their API tokens. New tokens are shown once, in the response to
+// the form creating them.
+func (h *Handler) AccountFunc(w http.ResponseWriter, r *http.Request) {
+	user := CurrentUser(r)
+	tplData := AccountTemplateData{User: user, Role: CurrentRole(r), Saved: r.URL.Query().Get("saved") != ""}
+	status := http.StatusOK
+	if r.Method == http.MethodPost {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		form := r.PostForm
+		var err error
+		switch action := form.Get("action"); action {
+		case "password":
+			if current, _ := h.reg.Authenticate(user.Name, form.Get("current")); current == nil {
+				err = errWrongPassword
+			} else if err = h.reg.SetUserPassword(user.Id, form.Get("password")); err == nil {
+				// The stamp of the password changed, which would log this
+				// browser out with the others.
+				if user, err = h.reg.GetUser(user.Id); err == nil {
+					err = h.logIn(w, r, user)
+				}
+			}
+		case "create-token":
+			tplData.NewToken, _, err = h.reg.CreateApiToken(user.Id, form.Get("name"))
+		case "delete-token":
+			if id, parseErr := strconv.ParseInt(form.Get("token"), 10, 64); parseErr != nil {
+				err = errUnknownToken
+			} else {
+				_, err = h.reg.DeleteApiToken(user.Id, id)
+			}
+		default:
+			err = unknownAction(action)
+		}
+		switch {
+		case err == nil && tplData.NewToken == "":
+			http.Redirect(w, r, "/account?saved=1", http.StatusSeeOther)
+			return
+		case err == nil:
+		case errors.Is(err, database.ErrInvalidAccount):
+			tplData.Error = err.Error()
+			status = http.StatusBadRequest
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	tokens, err := h.reg.GetUserApiTokens(user.Id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tplData.Tokens = tokens
+	h.render(w, status, "account", tplData)
+}
@@ -0,0 +1,121 @@
+package accounts
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"nicolas.galipot.net/taxonomia/dataset/database"
+)
+
+var (
+	errWrongPassword = fmt.Errorf("%w: the current password is wrong", database.ErrInvalidAccount)
+	errOwnAccount    = fmt.Errorf("%w: ask another admin to change the roles of your own account or delete it", database.ErrInvalidAccount)
+	errUnknownUser   = fmt.Errorf("%w: unknown user", database.ErrInvalidAccount)
+	errUnknownToken  = fmt.Errorf("%w: unknown API token", database.ErrInvalidAccount)
+)
+
+func unknownAction(action string) error {
+	return fmt.Errorf("%w: unknown action %q", database.ErrInvalidAccount, action)
+}
+
+type UsersTemplateData struct {
+	User      *database.User
+	Users     []*database.User
+	DatasetId string
+	Private   bool
+	Error     string
+	Saved     bool
+}
+
+// formDataset returns the dataset a role is given on in a form, which is
+// the current one or all of them.
+func formDataset(value, datasetId string) (string, error) {
+	if value != datasetId && value != database.AllDatasets {
+		return "", fmt.Errorf("%w: unknown dataset %q", database.ErrInvalidAccount, value)
+	}
+	return value, nil
+}
+
+// editUser applies the action of a form to the users or to the access of
+// the current dataset.
+func (h *Handler) editUser(tplData *UsersTemplateData, r *http.Request) error {
+	form := r.PostForm
+	action := form.Get("action")
+	if action == "private" {
+		return h.reg.SetDatasetPrivate(tplData.DatasetId, form.Get("private") != "")
+	}
+	if action == "create" {
+		datasetId, err := formDataset(form.Get("dataset"), tplData.DatasetId)
+		if err != nil {
+			return err
+		}
+		user, err := h.reg.CreateUser(form.Get("name"), form.Get("password"))
+		if err != nil || form.Get("role") == "" {
+			return err
+		}
+		return h.reg.SetUserRole(user.Id, datasetId, form.Get("role"))
+	}
+	id, err := strconv.ParseInt(form.Get("user"), 10, 64)
+	if err != nil {
+		return errUnknownUser
+	}
+	if id == tplData.User.Id && (action == "role" || action == "delete") {
+		return errOwnAccount
+	}
+	switch action {
+	case "role":
+		datasetId, err := formDataset(form.Get("dataset"), tplData.DatasetId)
+		if err != nil {
+			return err
+		}
+		return h.reg.SetUserRole(id, datasetId, form.Get("role"))
+	case "password":
+		return h.reg.SetUserPassword(id, form.Get("password"))
+	case "delete":
+		return h.reg.DeleteUser(id)
+	default:
+		return unknownAction(action)
+	}
+}
+
+// UsersFunc serves the page where admins create users, give them roles on
+// the current dataset or on all of them, and make the dataset private.
+// Admins cannot change their own roles, so that there always remains one.
+func (h *Handler) UsersFunc(w http.ResponseWriter, r *http.Request) {
+	tplData := UsersTemplateData{User: CurrentUser(r), Saved: r.URL.Query().Get("saved") != ""}
+	var err error
+	if tplData.DatasetId, err = h.currentDatasetId(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	status := http.StatusOK
+	if r.Method == http.MethodPost {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err := h.editUser(&tplData, r)
+		switch {
+		case err == nil:
+			http.Redirect(w, r, "/admin/users?saved=1", http.StatusSeeOther)
+			return
+		case errors.Is(err, database.ErrInvalidAccount):
+			tplData.Error = err.Error()
+			status = http.StatusBadRequest
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	tplData.Users, err = h.reg.GetUsers()
+	if err == nil {
+		tplData.Private, err = h.reg.IsDatasetPrivate(tplData.DatasetId)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.render(w, status, "users", tplData)
+}
@@ -0,0 +1,140 @@
+package admin
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"nicolas.galipot.net/taxonomia/dataset"
+)
+
+type CharacterTemplateData struct {
+	Character *dataset.Character
+	ParentId  string
+	Parents   []TreeOption
+	Languages []dataset.Lang
+	Error     string
+	Saved     bool
+}
+
+// CharacterFunc serves the form editing a character: its names, its place
+// in the hierarchy, its pictures and the order of its states.
+func (h *Handler) CharacterFunc(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/admin/character/")
+	character, err := h.reg.GetCharacter(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if character == nil {
+		http.NotFound(w, r)
+		return
+	}
+	tplData := CharacterTemplateData{Character: character, Saved: r.URL.Query().Get("saved") != ""}
+	if tplData.Languages, err = h.translationLangs(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if r.Method == http.MethodPost {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		form := r.PostForm
+		next := r.URL.Path + "?saved=1"
+		switch form.Get("action") {
+		case "":
+			if _, ok := form["name"]; ok {
+				readTexts(form, tplData.Languages, &character.Name, &character.Description)
+				err = h.reg.Apply(&dataset.Command{Op: dataset.OpBatch,
+					Commands: textCommands(id, tplData.Languages, character.Name, character.Description)})
+			}
+		case "move":
+			err = h.reg.Apply(&dataset.Command{Op: dataset.OpMoveCharacter, Id: id, ParentId: form.Get("parent"), Position: formPosition(form)})
+		case "delete":
+			err = h.reg.Apply(&dataset.Command{Op: dataset.OpDeleteCharacter, Id: id})
+			next = "/admin"
+		case "add-state":
+			state := &dataset.State{
+				Id:   strings.TrimSpace(form.Get("id")),
+				Name: dataset.MultilangText{Scientific: strings.TrimSpace(form.Get("name")), NamesByLangRef: map[string]string{}},
+			}
+			err = h.reg.Apply(&dataset.Command{Op: dataset.OpAddState, Id: id, Position: formPosition(form), State: state})
+		case "move-state":
+			err = h.reg.Apply(&dataset.Command{Op: dataset.OpMoveState, Id: form.Get("state"), Position: formPosition(form)})
+		default:
+			err = h.editPictures(id, form)
+		}
+		if finishEdit(w, r, err, next, &tplData.Error) {
+			return
+		}
+	}
+	ancestors, err := h.reg.GetCharacterAncestors(id)
+	if err == nil && len(ancestors) > 0 {
+		tplData.ParentId = ancestors[len(ancestors)-1].Id
+	}
+	var tree []*dataset.Hierarchy
+	if err == nil {
+		tree, err = h.reg.GetCharacterTree()
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tplData.Parents = treeOptions(tree, id)
+	h.template.ExecuteTemplate(w, "character", tplData)
+}
+
+type StateTemplateData struct {
+	State     *dataset.State
+	Character *dataset.Character
+	Languages []dataset.Lang
+	Error     string
+	Saved     bool
+}
+
+// StateFunc serves the form editing a state: its names, color and pictures.
+func (h *Handler) StateFunc(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/admin/state/")
+	state, character, err := h.reg.GetState(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if state == nil {
+		http.NotFound(w, r)
+		return
+	}
+	tplData := StateTemplateData{State: state, Character: character, Saved: r.URL.Query().Get("saved") != ""}
+	if tplData.Languages, err = h.translationLangs(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if r.Method == http.MethodPost {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		form := r.PostForm
+		next := r.URL.Path + "?saved=1"
+		switch form.Get("action") {
+		case "":
+			if _, ok := form["name"]; ok {
+				readTexts(form, tplData.Languages, &state.Name, &state.Description)
+				state.Color = strings.TrimSpace(form.Get("color"))
+				cmds := textCommands(id, tplData.Languages, state.Name, state.Description)
+				cmds = append(cmds, &dataset.Command{Op: dataset.OpSetColor, Id: id, Text: state.Color})
+				err = h.reg.Apply(&dataset.Command{Op: dataset.OpBatch, Commands: cmds})
+			}
+		case "delete":
+			err = h.reg.Apply(&dataset.Command{Op: dataset.OpRemoveState, Id: id})
+			next = "/admin/character/" + url.PathEscape(character.Id)
+		default:
+			err = h.editPictures(id, form)
+		}
+		if finishEdit(w, r, err, next, &tplData.Error) {
+			return
+		}
+	}
+	h.template.ExecuteTemplate(w, "state", tplData)
+}
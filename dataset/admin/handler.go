@@ -0,0 +1,338 @@
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "embed"
+
+	"nicolas.galipot.net/taxonomia/dataset"
+	"nicolas.galipot.net/taxonomia/dataset/database"
+	"nicolas.galipot.net/taxonomia/dataset/jobs"
+)
+
+//go:embed header.html
+var headerTemplateTxt string
+
+//go:embed index.html
+var indexTemplateTxt string
+
+//go:embed taxon.html
+var taxonTemplateTxt string
+
+//go:embed character.html
+var characterTemplateTxt string
+
+//go:embed state.html
+var stateTemplateTxt string
+
+//go:embed matrix.html
+var matrixTemplateTxt string
+
+//go:embed import.html
+var importTemplateTxt string
+
+//go:embed jobs.html
+var jobsTemplateTxt string
+
+type Handler struct {
+	reg      *database.DatasetRegistry
+	template *template.Template
+	jobs     *jobs.Runner
+}
+
+// NewHandler returns the handler of the admin pages, starting imports and
+// other jobs with runner.
+func NewHandler(reg *database.DatasetRegistry, runner *jobs.Runner) *Handler {
+	tpl := template.New("admin").Funcs(template.FuncMap{
+		"add":        func(a, b int) int { return a + b },
+		"indent":     func(depth int) string { return strings.Repeat("· ", depth) },
+		"formatTime": func(t time.Time) string { return t.Format("2006-01-02 15:04") },
+		"percent":    func(done, total int) int { return done * 100 / total },
+		"translations": func(name dataset.MultilangText, langs []dataset.Lang) interface{} {
+			return struct {
+				Name      dataset.MultilangText
+				Languages []dataset.Lang
+			}{name, langs}
+		},
+	})
+	templates := []struct {
+		name string
+		txt  string
+	}{
+		{"header", headerTemplateTxt},
+		{"index", indexTemplateTxt},
+		{"taxon", taxonTemplateTxt},
+		{"character", characterTemplateTxt},
+		{"state", stateTemplateTxt},
+		{"matrix", matrixTemplateTxt},
+		{"import", importTemplateTxt},
+		{"jobs", jobsTemplateTxt},
+	}
+	for _, t := range templates {
+		if _, err := tpl.Parse(t.txt); err != nil {
+			log.Fatalf("cannot parse template %q: %q", t.name, err.Error())
+		}
+	}
+	return &Handler{reg: reg, template: tpl, jobs: runner}
+}
+
+// TreeOption is an item of a hierarchy in a list, Depth telling how far it
+// is below the top.
+type TreeOption struct {
+	Id    string
+	Name  string
+	Depth int
+}
+
+// treeOptions flattens hierarchies in depth-first order, leaving out the
+// subtree of the excluded item.
+func treeOptions(tree []*dataset.Hierarchy, excludedId string) []TreeOption {
+	options := []TreeOption{}
+	var walk func(nodes []*dataset.Hierarchy, depth int)
+	walk = func(nodes []*dataset.Hierarchy, depth int) {
+		for _, node := range nodes {
+			if node.Id == excludedId {
+				continue
+			}
+			options = append(options, TreeOption{Id: node.Id, Name: node.Name.Scientific, Depth: depth})
+			walk(node.Children, depth+1)
+		}
+	}
+	walk(tree, 0)
+	return options
+}
+
+// translationLangs returns the languages names are translated in, scientific
+// names being edited on their own.
+func (h *Handler) translationLangs() ([]dataset.Lang, error) {
+	langs, err := h.reg.GetLanguages()
+	if err != nil {
+		return nil, err
+	}
+	translations := []dataset.Lang{}
+	for _, lang := range langs {
+		if lang.Code != "NS" {
+			translations = append(translations, lang)
+		}
+	}
+	return translations, nil
+}
+
+// readTexts updates a name, its translations and a description with the
+// values of a form, leaving those missing from the form as they are.
+func readTexts(form url.Values, langs []dataset.Lang, name *dataset.MultilangText, description *string) {
+	if values, ok := form["name"]; ok && len(values) > 0 {
+		name.Scientific = strings.TrimSpace(values[0])
+	}
+	if name.NamesByLangRef == nil {
+		name.NamesByLangRef = map[string]string{}
+	}
+	for _, lang := range langs {
+		if values, ok := form["name."+lang.Code]; ok && len(values) > 0 {
+			if text := strings.TrimSpace(values[0]); text != "" {
+				name.NamesByLangRef[lang.Code] = text
+			} else {
+				delete(name.NamesByLangRef, lang.Code)
+			}
+		}
+	}
+	if values, ok := form["description"]; ok && len(values) > 0 {
+		*description = strings.TrimSpace(values[0])
+	}
+}
+
+// textCommands returns the commands saving the names in the languages
+// translated and the description of an item, as read by readTexts.
+func textCommands(id string, langs []dataset.Lang, name dataset.MultilangText, description string) []*dataset.Command {
+	cmds := []*dataset.Command{
+		{Op: dataset.OpRename, Id: id, Text: name.Scientific},
+		{Op: dataset.OpSetDescription, Id: id, Text: description},
+	}
+	for _, lang := range langs {
+		cmds = append(cmds, &dataset.Command{Op: dataset.OpSetTranslation, Id: id, Lang: lang.Code, Text: name.NamesByLangRef[lang.Code]})
+	}
+	return cmds
+}
+
+// formPosition returns the position of an item among its siblings from the
+// 1-based position of a form, -1 putting it last.
+func formPosition(form url.Values) int {
+	n, err := strconv.Atoi(strings.TrimSpace(form.Get("position")))
+	if err != nil || n < 1 {
+		return -1
+	}
+	return n - 1
+}
+
+// editPictures adds, updates or removes a picture of an item as told by the
+// action of a form.
+func (h *Handler) editPictures(itemId string, form url.Values) error {
+	pic := dataset.Picture{
+		Id:     form.Get("picture"),
+		Source: strings.TrimSpace(form.Get("url")),
+		Legend: strings.TrimSpace(form.Get("legend")),
+	}
+	cmd := &dataset.Command{Id: itemId, Position: -1, Picture: &pic}
+	switch action := form.Get("action"); action {
+	case "add-picture":
+		cmd.Op = dataset.OpAddPicture
+	case "update-picture":
+		cmd.Op = dataset.OpUpdatePicture
+	case "remove-picture":
+		cmd.Op = dataset.OpRemovePicture
+	default:
+		return fmt.Errorf("%w: unknown action %q", database.ErrInvalidEdit, action)
+	}
+	return h.reg.Apply(cmd)
+}
+
+// finishEdit redirects to the next page after an edit and tells the request
+// is done. Refused edits are kept in message to be shown again on the page
+// with a 400 status, other errors end the request.
+func finishEdit(w http.ResponseWriter, r *http.Request, err error, next string, message *string) bool {
+	switch {
+	case err == nil:
+		http.Redirect(w, r, next, http.StatusSeeOther)
+		return true
+	case errors.Is(err, database.ErrInvalidEdit):
+		*message = err.Error()
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusBadRequest)
+		return false
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return true
+	}
+}
+
+var errInvalidValues = fmt.Errorf("%w: some values do not match the type of their field", database.ErrInvalidEdit)
+
+type TaxonTemplateData struct {
+	Taxon     *dataset.Taxon
+	ParentId  string
+	Parents   []TreeOption
+	Children  []*dataset.Taxon
+	Ranks     []string
+	Languages []dataset.Lang
+	Fields    []*dataset.ExtraField
+	Values    map[string]string
+	Errors    map[string]string
+	Error     string
+	Saved     bool
+}
+
+// saveTaxon saves the details of a taxon when they are in the form with
+// its extra values, all at once. Nothing is saved when a value does not
+// match the type of its field.
+func (h *Handler) saveTaxon(tplData *TaxonTemplateData, form url.Values) error {
+	taxon := tplData.Taxon
+	edit := &dataset.Command{Op: dataset.OpBatch}
+	if _, ok := form["name"]; ok {
+		readTexts(form, tplData.Languages, &taxon.Name, &taxon.Description)
+		taxon.Author = strings.TrimSpace(form.Get("author"))
+		taxon.Rank = form.Get("rank")
+		edit.Commands = append(textCommands(taxon.Id, tplData.Languages, taxon.Name, taxon.Description),
+			&dataset.Command{Op: dataset.OpSetAuthor, Id: taxon.Id, Text: taxon.Author},
+			&dataset.Command{Op: dataset.OpSetRank, Id: taxon.Id, Text: taxon.Rank})
+	}
+	for _, field := range tplData.Fields {
+		values, ok := form["x."+field.Id]
+		if !ok || len(values) == 0 {
+			continue
+		}
+		value := strings.TrimSpace(values[0])
+		if value == tplData.Values[field.Id] {
+			continue
+		}
+		tplData.Values[field.Id] = value
+		if value != "" {
+			if _, err := field.Normalize(value); err != nil {
+				tplData.Errors[field.Id] = err.Error()
+				continue
+			}
+		}
+		edit.Commands = append(edit.Commands, &dataset.Command{Op: dataset.OpSetExtraInfo, Id: taxon.Id, Key: field.Id, Value: value})
+	}
+	if len(tplData.Errors) > 0 {
+		return errInvalidValues
+	}
+	return h.reg.Apply(edit)
+}
+
+// TaxonFunc serves the form editing a taxon: its names, author, rank and
+// extra values, its place in the hierarchy and its pictures.
+func (h *Handler) TaxonFunc(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/admin/taxon/")
+	taxon, err := h.reg.GetTaxon(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if taxon == nil {
+		http.NotFound(w, r)
+		return
+	}
+	tplData := TaxonTemplateData{
+		Taxon:  taxon,
+		Ranks:  dataset.Ranks,
+		Values: map[string]string{},
+		Errors: map[string]string{},
+		Saved:  r.URL.Query().Get("saved") != "",
+	}
+	if tplData.Fields, err = h.reg.GetExtraFields(); err == nil {
+		tplData.Languages, err = h.translationLangs()
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for key, value := range taxon.ExtraInfo {
+		tplData.Values[key] = dataset.FormatExtraValue(value)
+	}
+	if r.Method == http.MethodPost {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		next := r.URL.Path + "?saved=1"
+		switch r.PostForm.Get("action") {
+		case "":
+			err = h.saveTaxon(&tplData, r.PostForm)
+		case "move":
+			err = h.reg.Apply(&dataset.Command{Op: dataset.OpMoveTaxon, Id: id, ParentId: r.PostForm.Get("parent"), Position: formPosition(r.PostForm)})
+		case "delete":
+			err = h.reg.Apply(&dataset.Command{Op: dataset.OpDeleteTaxon, Id: id})
+			next = "/admin"
+		default:
+			err = h.editPictures(id, r.PostForm)
+		}
+		if finishEdit(w, r, err, next, &tplData.Error) {
+			return
+		}
+	}
+	ancestors, err := h.reg.GetTaxonAncestors(id)
+	if err == nil && len(ancestors) > 0 {
+		tplData.ParentId = ancestors[len(ancestors)-1].Id
+	}
+	var tree []*dataset.Hierarchy
+	if err == nil {
+		tree, err = h.reg.GetTaxonTree()
+	}
+	if err == nil {
+		tplData.Children, err = h.reg.GetTaxonChildren(id)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tplData.Parents = treeOptions(tree, id)
+	h.template.ExecuteTemplate(w, "taxon", tplData)
+}
@@ -0,0 +1,299 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"nicolas.galipot.net/taxonomia/dataset"
+	"nicolas.galipot.net/taxonomia/dataset/accounts"
+	"nicolas.galipot.net/taxonomia/dataset/database"
+)
+
+// maxUploadSize is the size of the largest dataset file accepted.
+const maxUploadSize = 64 << 20
+
+var errDatasetInvalid = errors.New("the dataset has errors")
+
+// uploadFormat returns the format of an uploaded dataset, the one asked for
+// or else the one guessed from its name or content type.
+func uploadFormat(format string, filename string, contentType string) (string, error) {
+	if format != "" {
+		for _, f := range dataset.Formats {
+			if f == format {
+				return format, nil
+			}
+		}
+		return "", fmt.Errorf("unknown dataset format %q", format)
+	}
+	switch {
+	case filename != "":
+		return dataset.FormatOf(filename), nil
+	case strings.Contains(contentType, "xml"):
+		return dataset.FormatSDD, nil
+	case strings.Contains(contentType, "csv"), strings.Contains(contentType, "tab-separated"):
+		return dataset.FormatCSV, nil
+	case strings.Contains(contentType, "zip"):
+		return dataset.FormatDwCA, nil
+	}
+	return dataset.FormatHazo, nil
+}
+
+// readUpload returns an uploaded dataset file with its name and format. The
+// file is the file field of a form, or else the whole body of the request.
+func readUpload(w http.ResponseWriter, r *http.Request) (data []byte, filename string, format string, err error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	contentType := r.Header.Get("Content-Type")
+	if strings.HasPrefix(contentType, "multipart/form-data") {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			return nil, "", "", err
+		}
+		defer file.Close()
+		filename, contentType = header.Filename, header.Header.Get("Content-Type")
+		if data, err = ioutil.ReadAll(file); err != nil {
+			return nil, "", "", err
+		}
+	} else {
+		filename = r.URL.Query().Get("filename")
+		if data, err = ioutil.ReadAll(r.Body); err != nil {
+			return nil, "", "", err
+		}
+	}
+	format, err = uploadFormat(r.FormValue("format"), filename, contentType)
+	return data, filename, format, err
+}
+
+// currentUserId returns the id of the user making a request, 0 if there is
+// none.
+func currentUserId(r *http.Request) int64 {
+	if user := accounts.CurrentUser(r); user != nil {
+		return user.Id
+	}
+	return 0
+}
+
+// createImport validates an uploaded dataset and stores it as a pending
+// import, unless it has errors or the import is only checked. The import
+// is nil in these cases.
+func (h *Handler) createImport(r *http.Request, data []byte, filename string, format string, dryRun bool) (*database.DatasetImport, *ImportCheck, error) {
+	ds, check, err := checkDataset(h.reg, format, data)
+	if err != nil || len(check.Errors) > 0 || dryRun {
+		return nil, check, err
+	}
+	imp := &database.DatasetImport{Format: format, Filename: filename, UserId: currentUserId(r)}
+	if imp.DatasetId, err = importDatasetId(h.reg, ds, filename); err != nil {
+		return nil, nil, err
+	}
+	if err := h.reg.CreateDatasetImport(imp, data); err != nil {
+		return nil, nil, err
+	}
+	return imp, check, nil
+}
+
+type ImportTemplateData struct {
+	Imports  []*database.DatasetImport
+	Formats  []string
+	Filename string
+	Check    *ImportCheck
+	Busy     bool
+	Error    string
+}
+
+// ImportFunc serves the form uploading a dataset to replace the one of the
+// server, which leads to the preview of the import when the file is valid.
+func (h *Handler) ImportFunc(w http.ResponseWriter, r *http.Request) {
+	tplData := ImportTemplateData{Formats: dataset.Formats, Busy: h.importBusy()}
+	status := http.StatusOK
+	if r.Method == http.MethodPost {
+		data, filename, format, err := readUpload(w, r)
+		if err != nil {
+			tplData.Error = err.Error()
+			status = http.StatusBadRequest
+		} else {
+			imp, check, err := h.createImport(r, data, filename, format, false)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if imp != nil {
+				http.Redirect(w, r, fmt.Sprintf("/admin/import/%d", imp.Id), http.StatusSeeOther)
+				return
+			}
+			tplData.Filename, tplData.Check, tplData.Error = filename, check, errDatasetInvalid.Error()
+			status = http.StatusUnprocessableEntity
+		}
+	}
+	imports, err := h.reg.GetDatasetImports(20)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, imp := range imports {
+		h.importProgress(imp)
+	}
+	tplData.Imports = imports
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	h.template.ExecuteTemplate(w, "import", tplData)
+}
+
+// importFromPath returns the import whose id ends the path of a request,
+// with the progress it made if it is running.
+func (h *Handler) importFromPath(r *http.Request, prefix string) (*database.DatasetImport, error) {
+	id, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, prefix), 10, 64)
+	if err != nil {
+		return nil, nil
+	}
+	imp, err := h.reg.GetDatasetImport(id)
+	if imp != nil {
+		h.importProgress(imp)
+	}
+	return imp, err
+}
+
+type ImportStatusTemplateData struct {
+	Import *database.DatasetImport
+	Check  *ImportCheck
+	Error  string
+}
+
+// ImportStatusFunc serves the page of an import: the preview of what it
+// changes until it is started or cancelled, then its progress.
+func (h *Handler) ImportStatusFunc(w http.ResponseWriter, r *http.Request) {
+	imp, err := h.importFromPath(r, "/admin/import/")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if imp == nil {
+		http.NotFound(w, r)
+		return
+	}
+	tplData := ImportStatusTemplateData{Import: imp}
+	status := http.StatusOK
+	if r.Method == http.MethodPost {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		switch action := r.PostForm.Get("action"); action {
+		case "start":
+			err = h.startImport(imp, currentUserId(r))
+		case "cancel":
+			var ok bool
+			if ok, err = h.reg.CancelDatasetImport(imp.Id); err == nil && !ok {
+				err = errImportNotPending
+			}
+		default:
+			http.Error(w, fmt.Sprintf("unknown action %q", action), http.StatusBadRequest)
+			return
+		}
+		switch {
+		case err == nil:
+			http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+			return
+		case err == errImportRunning || err == errImportNotPending:
+			tplData.Error = err.Error()
+			status = http.StatusConflict
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if imp.Status == database.ImportPending {
+		data, err := h.reg.GetDatasetImportData(imp.Id)
+		if err == nil {
+			_, tplData.Check, err = checkDataset(h.reg, imp.Format, data)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	h.template.ExecuteTemplate(w, "import-status", tplData)
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+type apiImport struct {
+	Error  string                  `json:"error,omitempty"`
+	Import *database.DatasetImport `json:"import,omitempty"`
+	*ImportCheck
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// DatasetsFunc imports a dataset file sent with POST, as the file field of
+// a form or as the body. The import runs in the background, its progress
+// being at the address given in the Location header. With dryRun=true the
+// file is only checked.
+func (h *Handler) DatasetsFunc(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, apiError{"method not allowed"})
+		return
+	}
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dryRun"))
+	if !dryRun && h.importBusy() {
+		writeJSON(w, http.StatusConflict, apiError{errImportRunning.Error()})
+		return
+	}
+	data, filename, format, err := readUpload(w, r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{err.Error()})
+		return
+	}
+	imp, check, err := h.createImport(r, data, filename, format, dryRun)
+	switch {
+	case err != nil:
+		writeJSON(w, http.StatusInternalServerError, apiError{err.Error()})
+	case len(check.Errors) > 0:
+		writeJSON(w, http.StatusUnprocessableEntity, apiImport{Error: errDatasetInvalid.Error(), ImportCheck: check})
+	case dryRun:
+		writeJSON(w, http.StatusOK, apiImport{ImportCheck: check})
+	default:
+		if err := h.startImport(imp, currentUserId(r)); err != nil {
+			h.reg.CancelDatasetImport(imp.Id)
+			status := http.StatusInternalServerError
+			if err == errImportRunning {
+				status = http.StatusConflict
+			}
+			writeJSON(w, status, apiError{err.Error()})
+			return
+		}
+		w.Header().Set("Location", fmt.Sprintf("/api/datasets/imports/%d", imp.Id))
+		writeJSON(w, http.StatusAccepted, apiImport{Import: imp, ImportCheck: check})
+	}
+}
+
+// DatasetImportFunc returns the status of an import started by
+// DatasetsFunc, with its progress while it runs.
+func (h *Handler) DatasetImportFunc(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, apiError{"method not allowed"})
+		return
+	}
+	imp, err := h.importFromPath(r, "/api/datasets/imports/")
+	switch {
+	case err != nil:
+		writeJSON(w, http.StatusInternalServerError, apiError{err.Error()})
+	case imp == nil:
+		writeJSON(w, http.StatusNotFound, apiError{"not found"})
+	default:
+		writeJSON(w, http.StatusOK, imp)
+	}
+}
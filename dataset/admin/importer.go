@@ -0,0 +1,110 @@
+package admin
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"path"
+	"strconv"
+	"strings"
+
+	"nicolas.galipot.net/taxonomia/dataset"
+	"nicolas.galipot.net/taxonomia/dataset/database"
+	"nicolas.galipot.net/taxonomia/dataset/jobs"
+)
+
+var (
+	errImportRunning    = errors.New("another import is running, wait for it to finish")
+	errImportNotPending = errors.New("this import was already started or cancelled")
+)
+
+// ImportCheck is what validating an uploaded dataset found. Errors prevent
+// the import, warnings are about values left out of it.
+type ImportCheck struct {
+	Errors   []string                `json:"errors"`
+	Warnings []string                `json:"warnings"`
+	Preview  *database.ImportPreview `json:"preview,omitempty"`
+}
+
+// checkDataset reads an uploaded dataset, reports the ids used by several
+// items and the references to missing items as errors, the wrong ranks
+// and extra values as warnings, and compares it with the stored dataset.
+// The dataset is nil when it cannot be read.
+func checkDataset(reg *database.DatasetRegistry, format string, data []byte) (*dataset.Dataset, *ImportCheck, error) {
+	check := &ImportCheck{Errors: []string{}, Warnings: []string{}}
+	ds, err := dataset.ReadFormat(format, bytes.NewReader(data))
+	if err != nil {
+		check.Errors = append(check.Errors, "cannot read the file: "+err.Error())
+		return nil, check, nil
+	}
+	for _, refErr := range ds.ValidateReferences() {
+		check.Errors = append(check.Errors, refErr.Error())
+	}
+	for _, rankErr := range ds.ValidateRanks() {
+		check.Warnings = append(check.Warnings, rankErr.Error())
+	}
+	for _, valueErr := range ds.ValidateExtraInfo() {
+		check.Warnings = append(check.Warnings, valueErr.Error())
+	}
+	if check.Preview, err = reg.PreviewDataset(ds); err != nil {
+		return nil, nil, err
+	}
+	return ds, check, nil
+}
+
+// importDatasetId returns the id a dataset gets once imported: the id of
+// the dataset it replaces, so that roles and access stay as they are, or
+// else its own id or the name of its file.
+func importDatasetId(reg *database.DatasetRegistry, ds *dataset.Dataset, filename string) (string, error) {
+	info, err := reg.GetDatasetInfo()
+	switch {
+	case err != nil:
+		return "", err
+	case info != nil:
+		return info.Id, nil
+	case ds.Id != "":
+		return ds.Id, nil
+	}
+	if id := strings.SplitN(path.Base(filename), ".", 2)[0]; id != "" && id != "/" {
+		return id, nil
+	}
+	return "dataset", nil
+}
+
+// importBusy tells if an import is waiting for a worker or running.
+func (h *Handler) importBusy() bool {
+	busy, err := h.reg.HasActiveJobs(jobs.KindImport)
+	if err != nil {
+		log.Printf("cannot find the running imports: %q", err.Error())
+	}
+	return busy
+}
+
+// importProgress updates an import with the progress of its job.
+func (h *Handler) importProgress(imp *database.DatasetImport) {
+	if job, ok := h.jobs.Running(imp.JobId); ok {
+		imp.Step, imp.Done, imp.Total = job.Step, job.Done, job.Total
+	}
+}
+
+// startImport runs a pending import as a job.
+func (h *Handler) startImport(imp *database.DatasetImport, userId int64) error {
+	if h.importBusy() {
+		return errImportRunning
+	}
+	job, err := h.jobs.Submit(jobs.KindImport, strconv.FormatInt(imp.Id, 10), userId)
+	if err != nil {
+		return err
+	}
+	ok, err := h.reg.StartDatasetImport(imp.Id, job.Id)
+	if err == nil && !ok {
+		err = errImportNotPending
+	}
+	if err != nil {
+		// The import was cancelled or started meanwhile.
+		h.jobs.Cancel(job.Id)
+		return err
+	}
+	imp.JobId, imp.Status = job.Id, database.ImportRunning
+	return nil
+}
@@ -0,0 +1,69 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"nicolas.galipot.net/taxonomia/dataset"
+	"nicolas.galipot.net/taxonomia/dataset/database"
+)
+
+type IndexTemplateData struct {
+	Taxons     []TreeOption
+	Characters []TreeOption
+	Ranks      []string
+	Dataset    *database.DatasetInfo
+	Error      string
+}
+
+// IndexFunc lists the taxons and characters to edit, and adds new ones.
+func (h *Handler) IndexFunc(w http.ResponseWriter, r *http.Request) {
+	tplData := IndexTemplateData{Ranks: dataset.Ranks}
+	if r.Method == http.MethodPost {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		form := r.PostForm
+		hierarchy := &dataset.Hierarchy{
+			Id:   strings.TrimSpace(form.Get("id")),
+			Name: dataset.MultilangText{Scientific: strings.TrimSpace(form.Get("name")), NamesByLangRef: map[string]string{}},
+		}
+		var err error
+		var next string
+		switch action := form.Get("action"); action {
+		case "add-taxon":
+			taxon := &dataset.TaxonRecord{Id: hierarchy.Id, Name: hierarchy.Name, Rank: form.Get("rank")}
+			err = h.reg.Apply(&dataset.Command{Op: dataset.OpInsertTaxons, ParentId: form.Get("parent"), Position: formPosition(form),
+				Taxons: []*dataset.TaxonRecord{taxon}})
+			next = "/admin/taxon/" + url.PathEscape(taxon.Id)
+		case "add-character":
+			character := &dataset.CharacterRecord{Id: hierarchy.Id, Name: hierarchy.Name}
+			err = h.reg.Apply(&dataset.Command{Op: dataset.OpInsertCharacters, ParentId: form.Get("parent"), Position: formPosition(form),
+				Characters: []*dataset.CharacterRecord{character}})
+			next = "/admin/character/" + url.PathEscape(character.Id)
+		default:
+			err = fmt.Errorf("%w: unknown action %q", database.ErrInvalidEdit, action)
+		}
+		if finishEdit(w, r, err, next, &tplData.Error) {
+			return
+		}
+	}
+	taxons, err := h.reg.GetTaxonTree()
+	var characters []*dataset.Hierarchy
+	if err == nil {
+		characters, err = h.reg.GetCharacterTree()
+	}
+	if err == nil {
+		tplData.Dataset, err = h.reg.GetDatasetInfo()
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tplData.Taxons = treeOptions(taxons, "")
+	tplData.Characters = treeOptions(characters, "")
+	h.template.ExecuteTemplate(w, "index", tplData)
+}
@@ -0,0 +1,206 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"nicolas.galipot.net/taxonomia/dataset/database"
+	"nicolas.galipot.net/taxonomia/dataset/jobs"
+)
+
+var (
+	errJobNotFound = errors.New("no such job")
+	errJobKind     = errors.New("this kind of job cannot be started here")
+)
+
+// startableKinds are the kinds of jobs started from the jobs page and API,
+// imports being started once their file was checked.
+var startableKinds = []string{jobs.KindCacheImages, jobs.KindReindex, jobs.KindKey}
+
+// startJob submits a job of one of the startableKinds.
+func (h *Handler) startJob(r *http.Request, kind string) (*database.Job, error) {
+	for _, k := range startableKinds {
+		if k == kind {
+			return h.jobs.Submit(kind, "", currentUserId(r))
+		}
+	}
+	return nil, fmt.Errorf("%w: %q", errJobKind, kind)
+}
+
+// jobStatus returns the HTTP status of an error acting on a job.
+func jobStatus(err error) int {
+	switch {
+	case errors.Is(err, errJobKind):
+		return http.StatusBadRequest
+	case err == errJobNotFound:
+		return http.StatusNotFound
+	case err == jobs.ErrNotCancelable || err == jobs.ErrNotRetryable:
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// jobAction cancels or retries a job.
+func (h *Handler) jobAction(id int64, action string) error {
+	job, err := h.reg.GetJob(id)
+	if err != nil {
+		return err
+	} else if job == nil {
+		return errJobNotFound
+	}
+	if action == "cancel" {
+		return h.jobs.Cancel(id)
+	}
+	return h.jobs.Retry(id)
+}
+
+type JobsTemplateData struct {
+	Jobs    []*database.Job
+	Kinds   []string
+	Workers int
+	Active  bool
+	Error   string
+}
+
+// JobsPageFunc serves the list of the last jobs with their progress, and
+// the forms starting, cancelling and retrying them.
+func (h *Handler) JobsPageFunc(w http.ResponseWriter, r *http.Request) {
+	tplData := JobsTemplateData{Kinds: startableKinds, Workers: h.jobs.Workers()}
+	status := http.StatusOK
+	if r.Method == http.MethodPost {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var err error
+		switch action := r.PostForm.Get("action"); action {
+		case "start":
+			_, err = h.startJob(r, r.PostForm.Get("kind"))
+		case "cancel", "retry":
+			id, _ := strconv.ParseInt(r.PostForm.Get("job"), 10, 64)
+			err = h.jobAction(id, action)
+		default:
+			http.Error(w, fmt.Sprintf("unknown action %q", action), http.StatusBadRequest)
+			return
+		}
+		if err == nil {
+			http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+			return
+		}
+		if status = jobStatus(err); status == http.StatusInternalServerError {
+			http.Error(w, err.Error(), status)
+			return
+		}
+		tplData.Error = err.Error()
+	}
+	list, err := h.reg.GetJobs(50)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.jobs.Progress(list...)
+	for _, job := range list {
+		if !job.Finished() {
+			tplData.Active = true
+		}
+	}
+	tplData.Jobs = list
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	h.template.ExecuteTemplate(w, "jobs", tplData)
+}
+
+type apiJobKind struct {
+	Kind string `json:"kind"`
+}
+
+// JobsFunc lists the last jobs with GET, and starts one of the kind given
+// in the JSON body with POST, its status being at the address given in the
+// Location header.
+func (h *Handler) JobsFunc(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		list, err := h.reg.GetJobs(50)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, apiError{err.Error()})
+			return
+		}
+		h.jobs.Progress(list...)
+		writeJSON(w, http.StatusOK, list)
+	case http.MethodPost:
+		var body apiJobKind
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeJSON(w, http.StatusBadRequest, apiError{"invalid JSON body: " + err.Error()})
+			return
+		}
+		job, err := h.startJob(r, body.Kind)
+		if err != nil {
+			writeJSON(w, jobStatus(err), apiError{err.Error()})
+			return
+		}
+		w.Header().Set("Location", fmt.Sprintf("/api/jobs/%d", job.Id))
+		writeJSON(w, http.StatusAccepted, job)
+	default:
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, apiError{"method not allowed"})
+	}
+}
+
+// JobFunc returns a job with its progress with GET, and cancels or retries
+// it with POST to its cancel or retry address.
+func (h *Handler) JobFunc(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/api/jobs/"), "/", 2)
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	action := ""
+	if len(parts) > 1 {
+		action = parts[1]
+	}
+	if err != nil || (action != "" && action != "cancel" && action != "retry") {
+		writeJSON(w, http.StatusNotFound, apiError{errJobNotFound.Error()})
+		return
+	}
+	method := http.MethodGet
+	if action != "" {
+		method = http.MethodPost
+	}
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeJSON(w, http.StatusMethodNotAllowed, apiError{"method not allowed"})
+		return
+	}
+	if action != "" {
+		if err := h.jobAction(id, action); err != nil {
+			writeJSON(w, jobStatus(err), apiError{err.Error()})
+			return
+		}
+	}
+	job, err := h.reg.GetJob(id)
+	switch {
+	case err != nil:
+		writeJSON(w, http.StatusInternalServerError, apiError{err.Error()})
+	case job == nil:
+		writeJSON(w, http.StatusNotFound, apiError{errJobNotFound.Error()})
+	default:
+		h.jobs.Progress(job)
+		writeJSON(w, http.StatusOK, job)
+	}
+}
+
+// KeyFunc serves the identification key generated last as text.
+func (h *Handler) KeyFunc(w http.ResponseWriter, r *http.Request) {
+	key, err := h.reg.GetLastIdentificationKey()
+	switch {
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	case key == nil:
+		http.Error(w, "no identification key was generated yet, start a key job", http.StatusNotFound)
+	default:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Last-Modified", key.Created.UTC().Format(http.TimeFormat))
+		fmt.Fprint(w, key.Content)
+	}
+}
@@ -0,0 +1,93 @@
+package admin
+
+import (
+	"net/http"
+	"net/url"
+
+	"nicolas.galipot.net/taxonomia/dataset"
+)
+
+type MatrixTemplateData struct {
+	Characters []TreeOption
+	Character  *dataset.Character
+	Taxons     []TreeOption
+	Coded      map[string]map[string]bool
+	Error      string
+	Saved      bool
+}
+
+func hasValue(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// MatrixFunc serves the grid coding the taxons with the states of one
+// character, the first one with states unless the char parameter tells
+// another. The grid posts the states each taxon was coded with along with
+// the checked ones so that only the cells changed are saved, keeping the
+// codings made meanwhile by other editors.
+func (h *Handler) MatrixFunc(w http.ResponseWriter, r *http.Request) {
+	tree, err := h.reg.GetCharacterTree()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tplData := MatrixTemplateData{Characters: treeOptions(tree, ""), Saved: r.URL.Query().Get("saved") != ""}
+	characterId := r.URL.Query().Get("char")
+	for _, option := range tplData.Characters {
+		if characterId != "" && option.Id != characterId {
+			continue
+		}
+		if tplData.Character, err = h.reg.GetCharacter(option.Id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if characterId != "" || len(tplData.Character.States) > 0 {
+			break
+		}
+		tplData.Character = nil
+	}
+	if characterId != "" && tplData.Character == nil {
+		http.NotFound(w, r)
+		return
+	}
+	taxons, err := h.reg.GetTaxonTree()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tplData.Taxons = treeOptions(taxons, "")
+	if r.Method == http.MethodPost && tplData.Character != nil {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		codings := &dataset.Command{Op: dataset.OpBatch}
+		for _, taxon := range tplData.Taxons {
+			checked, was := r.PostForm["t."+taxon.Id], r.PostForm["was."+taxon.Id]
+			for _, state := range tplData.Character.States {
+				if coded := hasValue(checked, state.Id); coded != hasValue(was, state.Id) {
+					op := dataset.OpUnsetTaxonState
+					if coded {
+						op = dataset.OpSetTaxonState
+					}
+					codings.Commands = append(codings.Commands, &dataset.Command{Op: op, Id: taxon.Id, StateId: state.Id})
+				}
+			}
+		}
+		err := h.reg.Apply(codings)
+		next := "/admin/matrix?" + url.Values{"char": {tplData.Character.Id}, "saved": {"1"}}.Encode()
+		if finishEdit(w, r, err, next, &tplData.Error) {
+			return
+		}
+	}
+	if tplData.Coded, err = h.reg.GetCodedStates(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.template.ExecuteTemplate(w, "matrix", tplData)
+}
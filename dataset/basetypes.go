@@ -22,6 +22,17 @@ func (name MultilangText) Text(langRef string) string {
 	}
 }
 
+// TextIn returns the text in the first of the languages it exists in, the
+// scientific name otherwise.
+func (name MultilangText) TextIn(langRefs ...string) string {
+	for _, langRef := range langRefs {
+		if text, ok := name.NamesByLangRef[langRef]; ok && text != "" {
+			return text
+		}
+	}
+	return name.Scientific
+}
+
 func (text MultilangText) Equals(other MultilangText) bool {
 	if text.Scientific != other.Scientific || len(text.NamesByLangRef) != len(other.NamesByLangRef) {
 		return false
@@ -69,14 +80,17 @@ type State struct {
 }
 
 type BookReference struct {
-	Page   int
-	Fasc   string
-	Detail string
+	BookId string `json:"bookId,omitempty"`
+	Page   int    `json:"page,omitempty"`
+	Fasc   string `json:"fasc,omitempty"`
+	Detail string `json:"detail,omitempty"`
 }
 
 type Taxon struct {
 	*Hierarchy
 	Author     string
+	Rank       string
+	Synonyms   []Synonym
 	States     []*State
 	References []BookReference
 	ExtraInfo  map[string]interface{}
@@ -113,9 +127,21 @@ type ExtraField struct {
 	Id         string
 	Label      string
 	Icon       string
+	Type       string
+	Options    []string
 }
 
 type Book struct {
-	Id    string
-	Title string
+	Id        string   `json:"id"`
+	Kind      string   `json:"kind,omitempty"`
+	Title     string   `json:"title"`
+	Authors   []string `json:"authors,omitempty"`
+	Year      int      `json:"year,omitempty"`
+	Journal   string   `json:"journal,omitempty"`
+	Volume    string   `json:"volume,omitempty"`
+	Issue     string   `json:"issue,omitempty"`
+	Pages     string   `json:"pages,omitempty"`
+	Publisher string   `json:"publisher,omitempty"`
+	Doi       string   `json:"doi,omitempty"`
+	Url       string   `json:"url,omitempty"`
 }
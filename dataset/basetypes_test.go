@@ -0,0 +1,22 @@
+package dataset
+
+import "testing"
+
+func TestMultilangTextIn(t *testing.T) {
+	name := MultilangText{Scientific: "folium", NamesByLangRef: map[string]string{"EN": "leaf", "FR": "feuille", "CN": ""}}
+	cases := []struct {
+		langs    []string
+		expected string
+	}{
+		{[]string{"FR", "EN"}, "feuille"},
+		{[]string{"CN", "EN"}, "leaf"},
+		{[]string{"DE"}, "folium"},
+		{nil, "folium"},
+	}
+	for _, c := range cases {
+		if got := name.TextIn(c.langs...); got != c.expected {
+			t.Logf("Wrong text in %v, expected %q, got %q.", c.langs, c.expected, got)
+			t.Fail()
+		}
+	}
+}
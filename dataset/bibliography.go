@@ -0,0 +1,182 @@
+package dataset
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	BookKindBook    = "book"
+	BookKindArticle = "article"
+)
+
+func copyBook(book Book) Book {
+	if book.Authors != nil {
+		book.Authors = append([]string{}, book.Authors...)
+	}
+	return book
+}
+
+func copyReferences(refs []BookReference) []BookReference {
+	if refs == nil {
+		return nil
+	}
+	return append([]BookReference{}, refs...)
+}
+
+// Citation formats the book as a short human readable reference.
+func (b *Book) Citation() string {
+	parts := []string{}
+	head := strings.Join(b.Authors, ", ")
+	if b.Year != 0 {
+		head = strings.TrimSpace(fmt.Sprintf("%s (%d)", head, b.Year))
+	}
+	if head != "" {
+		parts = append(parts, head)
+	}
+	if b.Title != "" {
+		parts = append(parts, b.Title)
+	}
+	container := b.Journal
+	if b.Volume != "" {
+		container = strings.TrimSpace(container + " " + b.Volume)
+		if b.Issue != "" {
+			container += "(" + b.Issue + ")"
+		}
+	}
+	if b.Pages != "" {
+		if container != "" {
+			container += ": "
+		}
+		container += b.Pages
+	}
+	if container != "" {
+		parts = append(parts, container)
+	}
+	if b.Publisher != "" {
+		parts = append(parts, b.Publisher)
+	}
+	if b.Doi != "" {
+		parts = append(parts, "doi:"+b.Doi)
+	}
+	return strings.Join(parts, ". ")
+}
+
+func (ds *Dataset) bookIndex(id string) int {
+	for i := range ds.Books {
+		if ds.Books[i].Id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (ds *Dataset) FindBook(id string) *Book {
+	if i := ds.bookIndex(id); i >= 0 {
+		return &ds.Books[i]
+	}
+	return nil
+}
+
+// AddBook inserts a book at the given position, generating its id when
+// empty, and returns its id.
+func (ds *Dataset) AddBook(book Book, position int) (string, error) {
+	if book.Id == "" {
+		book.Id = generateNewId("b", len(ds.Books), func(id string) bool {
+			return ds.bookIndex(id) >= 0
+		})
+	} else if ds.bookIndex(book.Id) >= 0 {
+		return "", fmt.Errorf("cannot add book: id %q already exists", book.Id)
+	}
+	if strings.TrimSpace(book.Title) == "" {
+		return "", fmt.Errorf("cannot add book %q: empty title", book.Id)
+	}
+	book = copyBook(book)
+	if position < 0 || position > len(ds.Books) {
+		position = len(ds.Books)
+	}
+	ds.Books = append(ds.Books, Book{})
+	copy(ds.Books[position+1:], ds.Books[position:])
+	ds.Books[position] = book
+	return book.Id, nil
+}
+
+func (ds *Dataset) UpdateBook(book Book) error {
+	i := ds.bookIndex(book.Id)
+	if i < 0 {
+		return fmt.Errorf("cannot update book: no book with id %q", book.Id)
+	}
+	if strings.TrimSpace(book.Title) == "" {
+		return fmt.Errorf("cannot update book %q: empty title", book.Id)
+	}
+	ds.Books[i] = copyBook(book)
+	return nil
+}
+
+func (ds *Dataset) MoveBook(id string, position int) error {
+	i := ds.bookIndex(id)
+	if i < 0 {
+		return fmt.Errorf("cannot move book: no book with id %q", id)
+	}
+	book := ds.Books[i]
+	ds.Books = append(ds.Books[:i], ds.Books[i+1:]...)
+	if position < 0 || position > len(ds.Books) {
+		position = len(ds.Books)
+	}
+	ds.Books = append(ds.Books, Book{})
+	copy(ds.Books[position+1:], ds.Books[position:])
+	ds.Books[position] = book
+	return nil
+}
+
+// RemoveBook removes a book along with the taxon references pointing to it.
+func (ds *Dataset) RemoveBook(id string) error {
+	i := ds.bookIndex(id)
+	if i < 0 {
+		return fmt.Errorf("cannot remove book: no book with id %q", id)
+	}
+	ds.Books = append(ds.Books[:i], ds.Books[i+1:]...)
+	for _, taxon := range ds.TaxonsById {
+		refs := taxon.References[:0]
+		for _, ref := range taxon.References {
+			if ref.BookId != id {
+				refs = append(refs, ref)
+			}
+		}
+		taxon.References = refs
+	}
+	return nil
+}
+
+func (ds *Dataset) SetReferences(taxonId string, refs []BookReference) error {
+	taxon, ok := ds.TaxonsById[taxonId]
+	if !ok {
+		return fmt.Errorf("cannot set references: no taxon with id %q", taxonId)
+	}
+	for _, ref := range refs {
+		if ref.BookId != "" && ds.bookIndex(ref.BookId) < 0 {
+			return fmt.Errorf("cannot set references of taxon %q: no book with id %q", taxonId, ref.BookId)
+		}
+	}
+	taxon.References = copyReferences(refs)
+	return nil
+}
+
+// MergeBooks adds the given books to the dataset, replacing the existing
+// books having the same id, and returns the number of added books.
+func (ds *Dataset) MergeBooks(books []Book) (int, error) {
+	added := 0
+	for _, book := range books {
+		if book.Id != "" && ds.bookIndex(book.Id) >= 0 {
+			if err := ds.UpdateBook(book); err != nil {
+				return added, err
+			}
+			continue
+		}
+		if _, err := ds.AddBook(book, -1); err != nil {
+			return added, err
+		}
+		added++
+	}
+	return added, nil
+}
@@ -0,0 +1,145 @@
+package dataset
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const bibliographyFixture = `{
+	"id": "ds",
+	"books": [ { "id": "b1", "label": "Flora", "authors": ["Linnaeus, Carl"], "year": 1753 }, { "id": "b2", "label": "Notes" } ],
+	"taxons": [
+		{ "id": "t1", "name": "a", "bookInfobyids": { "b2": { "page": "3" }, "b1": { "fasc": "2", "page": "12", "detail": "fig. 1" } } },
+		{ "id": "t2", "name": "b", "bookInfobyids": { "b2": { "page": "" } } }
+	]
+}`
+
+func TestHazoBooksRoundTrip(t *testing.T) {
+	ds := readHazoString(t, bibliographyFixture)
+	expected := []BookReference{{BookId: "b1", Page: 12, Fasc: "2", Detail: "fig. 1"}, {BookId: "b2", Page: 3}}
+	if got := ds.TaxonsById["t1"].References; !reflect.DeepEqual(got, expected) {
+		t.Logf("Wrong references, expected %v, got %v.", expected, got)
+		t.Fail()
+	}
+	if book := ds.FindBook("b1"); book == nil || book.Year != 1753 || book.Title != "Flora" {
+		t.Logf("Wrong book b1: %+v.", book)
+		t.Fail()
+	}
+	expectedOutput := writeHazoString(t, ds, HazoOptions{SortKeys: true})
+	if got := writeHazoString(t, readHazoString(t, expectedOutput), HazoOptions{SortKeys: true}); got != expectedOutput {
+		t.Logf("Output changed after a round trip.\nexpected %s\ngot %s", expectedOutput, got)
+		t.Fail()
+	}
+}
+
+func TestRemoveBookUndo(t *testing.T) {
+	ds := readHazoString(t, bibliographyFixture)
+	before := writeHazoString(t, ds, HazoOptions{SortKeys: true})
+	undo, err := ds.Apply(&Command{Op: OpRemoveBook, Id: "b2"})
+	if err != nil {
+		t.Logf("Unexpected error: %q.", err.Error())
+		t.FailNow()
+	}
+	if len(ds.Books) != 1 || len(ds.TaxonsById["t1"].References) != 1 || len(ds.TaxonsById["t2"].References) != 0 {
+		t.Logf("Removing a book should remove the references to it.")
+		t.Fail()
+	}
+	if _, err := ds.Apply(undo); err != nil {
+		t.Logf("Unexpected error: %q.", err.Error())
+		t.FailNow()
+	}
+	if after := writeHazoString(t, ds, HazoOptions{SortKeys: true}); after != before {
+		t.Logf("Undo did not restore the dataset.\nexpected %s\ngot %s", before, after)
+		t.Fail()
+	}
+	if err := ds.SetReferences("t2", []BookReference{{BookId: "b9"}}); err == nil {
+		t.Logf("Expected an error for a reference to an unknown book.")
+		t.Fail()
+	}
+}
+
+func TestDiffBooks(t *testing.T) {
+	a := readHazoString(t, bibliographyFixture)
+	b := a.Clone()
+	b.RemoveBook("b1")
+	b.AddBook(Book{Id: "b3", Title: "Revision", Kind: BookKindArticle}, 0)
+	b.SetReferences("t2", []BookReference{{BookId: "b3", Page: 7}})
+	b.MoveBook("b2", 0)
+	diff, err := Diff(a, b)
+	if err != nil {
+		t.Logf("Unexpected error: %q.", err.Error())
+		t.FailNow()
+	}
+	if _, err := a.Apply(diff.Patch); err != nil {
+		t.Logf("Unexpected error: %q.", err.Error())
+		t.FailNow()
+	}
+	expected := writeHazoString(t, b, HazoOptions{SortKeys: true})
+	if got := writeHazoString(t, a, HazoOptions{SortKeys: true}); got != expected {
+		t.Logf("Patched dataset differs.\nexpected %s\ngot %s", expected, got)
+		t.Fail()
+	}
+}
+
+func TestBibTeX(t *testing.T) {
+	src := `@comment{ignored}
+@string{fl = "Flora"}
+@Article{smith2001,
+  author = {Smith, John and {Doe}, Jane},
+  title = "A {Revision} of the genus",
+  journal = {Bot. J.},
+  year = 2001,
+  volume = {12}, number = {3},
+  pages = {1--20},
+  doi = {10.1000/xyz}
+}
+@book(lin1753, title = {Species} # { Plantarum}, year = {1753})`
+	books, err := ReadBibTeX(strings.NewReader(src))
+	if err != nil {
+		t.Logf("Unexpected error: %q.", err.Error())
+		t.FailNow()
+	}
+	expected := []Book{
+		{Id: "smith2001", Kind: BookKindArticle, Title: "A Revision of the genus", Authors: []string{"Smith, John", "Doe, Jane"},
+			Year: 2001, Journal: "Bot. J.", Volume: "12", Issue: "3", Pages: "1-20", Doi: "10.1000/xyz"},
+		{Id: "lin1753", Kind: BookKindBook, Title: "Species Plantarum", Year: 1753},
+	}
+	if !reflect.DeepEqual(books, expected) {
+		t.Logf("Wrong books.\nexpected %+v\ngot %+v", expected, books)
+		t.FailNow()
+	}
+	var b bytes.Buffer
+	if err := WriteBibTeX(&b, books); err != nil {
+		t.Logf("Unexpected error: %q.", err.Error())
+		t.FailNow()
+	}
+	roundTrip, err := ReadBibTeX(&b)
+	if err != nil || !reflect.DeepEqual(roundTrip, expected) {
+		t.Logf("Books changed after a round trip: %+v, %v.", roundTrip, err)
+		t.Fail()
+	}
+}
+
+func TestCSLJSON(t *testing.T) {
+	books := []Book{
+		{Id: "smith2001", Kind: BookKindArticle, Title: "A revision", Authors: []string{"Smith, John", "Flora Team"},
+			Year: 2001, Journal: "Bot. J.", Volume: "12", Pages: "1-20", Doi: "10.1000/xyz"},
+		{Id: "lin1753", Kind: BookKindBook, Title: "Species Plantarum", Year: 1753, Publisher: "Salvius"},
+	}
+	var b bytes.Buffer
+	if err := WriteCSLJSON(&b, books); err != nil {
+		t.Logf("Unexpected error: %q.", err.Error())
+		t.FailNow()
+	}
+	if !strings.Contains(b.String(), `"article-journal"`) || !strings.Contains(b.String(), `"family": "Smith"`) {
+		t.Logf("Unexpected CSL-JSON output: %s", b.String())
+		t.Fail()
+	}
+	roundTrip, err := ReadCSLJSON(&b)
+	if err != nil || !reflect.DeepEqual(roundTrip, books) {
+		t.Logf("Books changed after a round trip.\nexpected %+v\ngot %+v (%v)", books, roundTrip, err)
+		t.Fail()
+	}
+}
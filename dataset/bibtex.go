@@ -0,0 +1,240 @@
+package dataset
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+func bibtexType(kind string) string {
+	if kind == "" {
+		return BookKindBook
+	}
+	return kind
+}
+
+// WriteBibTeX writes the books as BibTeX entries keyed by their ids.
+func WriteBibTeX(w io.Writer, books []Book) error {
+	bw := bufio.NewWriter(w)
+	for i, book := range books {
+		if i > 0 {
+			bw.WriteString("\n")
+		}
+		fmt.Fprintf(bw, "@%s{%s,\n", bibtexType(book.Kind), book.Id)
+		field := func(name string, value string) {
+			if value != "" {
+				fmt.Fprintf(bw, "  %s = {%s},\n", name, value)
+			}
+		}
+		field("author", strings.Join(book.Authors, " and "))
+		field("title", book.Title)
+		field("journal", book.Journal)
+		if book.Year != 0 {
+			field("year", strconv.Itoa(book.Year))
+		}
+		field("volume", book.Volume)
+		field("number", book.Issue)
+		field("pages", book.Pages)
+		field("publisher", book.Publisher)
+		field("doi", book.Doi)
+		field("url", book.Url)
+		bw.WriteString("}\n")
+	}
+	return bw.Flush()
+}
+
+type bibtexParser struct {
+	src []rune
+	pos int
+}
+
+func (p *bibtexParser) skipSpaces() {
+	for p.pos < len(p.src) && unicode.IsSpace(p.src[p.pos]) {
+		p.pos++
+	}
+}
+
+func (p *bibtexParser) readUntil(stops string) string {
+	start := p.pos
+	for p.pos < len(p.src) && !strings.ContainsRune(stops, p.src[p.pos]) {
+		p.pos++
+	}
+	return strings.TrimSpace(string(p.src[start:p.pos]))
+}
+
+func (p *bibtexParser) expect(r rune) error {
+	p.skipSpaces()
+	if p.pos >= len(p.src) || p.src[p.pos] != r {
+		return fmt.Errorf("bibtex: expected %q at offset %d", r, p.pos)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *bibtexParser) readBraced() (string, error) {
+	depth := 0
+	start := p.pos + 1
+	for ; p.pos < len(p.src); p.pos++ {
+		switch p.src[p.pos] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				p.pos++
+				return string(p.src[start : p.pos-1]), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("bibtex: unbalanced braces at offset %d", start)
+}
+
+func (p *bibtexParser) readQuoted() (string, error) {
+	depth := 0
+	start := p.pos + 1
+	for p.pos++; p.pos < len(p.src); p.pos++ {
+		switch p.src[p.pos] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case '"':
+			if depth == 0 {
+				p.pos++
+				return string(p.src[start : p.pos-1]), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("bibtex: unterminated string at offset %d", start)
+}
+
+// readValue reads a field value, joining the parts concatenated with #.
+func (p *bibtexParser) readValue(closing rune) (string, error) {
+	var b strings.Builder
+	for {
+		p.skipSpaces()
+		if p.pos >= len(p.src) {
+			return "", fmt.Errorf("bibtex: unexpected end of input")
+		}
+		var part string
+		var err error
+		switch p.src[p.pos] {
+		case '{':
+			part, err = p.readBraced()
+		case '"':
+			part, err = p.readQuoted()
+		default:
+			part = p.readUntil(",#" + string(closing))
+		}
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(part)
+		p.skipSpaces()
+		if p.pos < len(p.src) && p.src[p.pos] == '#' {
+			p.pos++
+			continue
+		}
+		return b.String(), nil
+	}
+}
+
+func cleanBibtexValue(value string) string {
+	value = strings.NewReplacer("{", "", "}", "").Replace(value)
+	return strings.Join(strings.Fields(value), " ")
+}
+
+func bookFromBibtex(kind string, key string, fields map[string]string) Book {
+	book := Book{
+		Id:        key,
+		Kind:      kind,
+		Title:     fields["title"],
+		Journal:   fields["journal"],
+		Volume:    fields["volume"],
+		Issue:     fields["number"],
+		Pages:     strings.Replace(fields["pages"], "--", "-", 1),
+		Publisher: fields["publisher"],
+		Doi:       fields["doi"],
+		Url:       fields["url"],
+	}
+	if book.Journal == "" {
+		book.Journal = fields["booktitle"]
+	}
+	if authors := fields["author"]; authors != "" {
+		for _, author := range strings.Split(authors, " and ") {
+			if author = strings.TrimSpace(author); author != "" {
+				book.Authors = append(book.Authors, author)
+			}
+		}
+	}
+	year := strings.TrimLeftFunc(fields["year"], func(r rune) bool { return !unicode.IsDigit(r) })
+	if end := strings.IndexFunc(year, func(r rune) bool { return !unicode.IsDigit(r) }); end >= 0 {
+		year = year[:end]
+	}
+	book.Year, _ = strconv.Atoi(year)
+	return book
+}
+
+// ReadBibTeX reads the entries of a BibTeX file as books, ignoring comments,
+// string definitions and preambles.
+func ReadBibTeX(r io.Reader) ([]Book, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	p := &bibtexParser{src: []rune(string(data))}
+	books := []Book{}
+	for {
+		p.readUntil("@")
+		if p.pos >= len(p.src) {
+			return books, nil
+		}
+		p.pos++
+		kind := strings.ToLower(p.readUntil("{("))
+		if p.pos >= len(p.src) {
+			return nil, fmt.Errorf("bibtex: unterminated entry %q", kind)
+		}
+		closing := '}'
+		if p.src[p.pos] == '(' {
+			closing = ')'
+		}
+		if kind == "comment" || kind == "string" || kind == "preamble" {
+			if closing == '}' {
+				if _, err := p.readBraced(); err != nil {
+					return nil, err
+				}
+			} else {
+				p.readUntil(")")
+			}
+			continue
+		}
+		p.pos++
+		key := p.readUntil(",}")
+		fields := map[string]string{}
+		for p.pos < len(p.src) && p.src[p.pos] == ',' {
+			p.pos++
+			p.skipSpaces()
+			if p.pos < len(p.src) && p.src[p.pos] == closing {
+				break
+			}
+			name := strings.ToLower(p.readUntil("=" + string(closing)))
+			if err := p.expect('='); err != nil {
+				return nil, err
+			}
+			value, err := p.readValue(closing)
+			if err != nil {
+				return nil, err
+			}
+			fields[name] = cleanBibtexValue(value)
+			p.skipSpaces()
+		}
+		if err := p.expect(closing); err != nil {
+			return nil, err
+		}
+		books = append(books, bookFromBibtex(kind, key, fields))
+	}
+}
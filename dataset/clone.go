@@ -0,0 +1,86 @@
+package dataset
+
+func copyHierarchy(h *Hierarchy, copies map[string]*Hierarchy) *Hierarchy {
+	c := &Hierarchy{
+		Id:          h.Id,
+		Name:        copyMultilangText(h.Name),
+		Description: h.Description,
+		Pictures:    copyPictures(h.Pictures),
+		Children:    make([]*Hierarchy, len(h.Children)),
+	}
+	copies[h.Id] = c
+	for i, child := range h.Children {
+		c.Children[i] = copyHierarchy(child, copies)
+	}
+	return c
+}
+
+func copyStateRefs(states []*State) []*State {
+	if states == nil {
+		return nil
+	}
+	copies := make([]*State, len(states))
+	for i, state := range states {
+		c := copyState(*state)
+		copies[i] = &c
+	}
+	return copies
+}
+
+// Clone returns a deep copy of the dataset, sharing no mutable data with it.
+func (ds *Dataset) Clone() *Dataset {
+	clone := New(ds.Id)
+	taxonHierarchies := map[string]*Hierarchy{}
+	clone.TaxonsHierarchy = copyHierarchy(ds.TaxonsHierarchy, taxonHierarchies)
+	characterHierarchies := map[string]*Hierarchy{}
+	clone.CharactersHierarchy = copyHierarchy(ds.CharactersHierarchy, characterHierarchies)
+	for id, taxon := range ds.TaxonsById {
+		h, ok := taxonHierarchies[id]
+		if !ok {
+			h = copyHierarchy(taxon.Hierarchy, map[string]*Hierarchy{})
+		}
+		c := NewTaxon(h)
+		c.Author = taxon.Author
+		c.Rank = taxon.Rank
+		c.Synonyms = copySynonyms(taxon.Synonyms)
+		c.States = copyStateRefs(taxon.States)
+		c.References = copyReferences(taxon.References)
+		for k, v := range taxon.ExtraInfo {
+			c.ExtraInfo[k] = v
+		}
+		clone.TaxonsById[id] = c
+	}
+	for id, ch := range ds.CharactersById {
+		h, ok := characterHierarchies[id]
+		if !ok {
+			h = copyHierarchy(ch.Hierarchy, map[string]*Hierarchy{})
+		}
+		c := NewCharacter(h)
+		if ch.States != nil {
+			c.States = make([]State, len(ch.States))
+			for i, state := range ch.States {
+				c.States[i] = copyState(state)
+			}
+		}
+		if ch.InherentState != nil {
+			inherent := copyState(*ch.InherentState)
+			c.InherentState = &inherent
+		}
+		c.RequiredStates = copyStateRefs(ch.RequiredStates)
+		c.InapplicableStates = copyStateRefs(ch.InapplicableStates)
+		clone.CharactersById[id] = c
+	}
+	for _, book := range ds.Books {
+		clone.Books = append(clone.Books, copyBook(book))
+	}
+	for _, entry := range ds.DictionaryEntry {
+		entry.Name = copyMultilangText(entry.Name)
+		entry.Definition = copyMultilangText(entry.Definition)
+		clone.DictionaryEntry = append(clone.DictionaryEntry, entry)
+	}
+	for _, field := range ds.ExtraFields {
+		field.Options = append([]string(nil), field.Options...)
+		clone.ExtraFields = append(clone.ExtraFields, field)
+	}
+	return clone
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"nicolas.galipot.net/taxonomia/dataset/accounts"
+)
+
+// TestAdminRoutesRequireSignIn checks that anonymous requests never reach
+// the handlers of the admin area, which edit the dataset.
+func TestAdminRoutesRequireSignIn(t *testing.T) {
+	reg := newTestRegistry(t)
+	server := httptest.NewServer(NewServeMux(reg, testConfig))
+	defer server.Close()
+	client := server.Client()
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error { return http.ErrUseLastResponse }
+	serverRoutes, _ := routes(reg, testConfig, accounts.NewHandler(reg, testConfig.SessionKey))
+	for _, r := range serverRoutes {
+		if !strings.HasPrefix(r.pattern, "/admin") {
+			continue
+		}
+		if r.access == accounts.Public || r.access == accounts.SignedIn {
+			t.Errorf("Admin route %q is open to users without a role.", r.pattern)
+		}
+		path := r.pattern
+		if strings.HasSuffix(path, "/") {
+			path += "t1"
+		}
+		for _, method := range []string{http.MethodGet, http.MethodPost} {
+			req, _ := http.NewRequest(method, server.URL+path, strings.NewReader("name=changed&extra.height=3&action=delete"))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			res, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			location := res.Header.Get("Location")
+			signIn := res.StatusCode == http.StatusUnauthorized ||
+				(res.StatusCode == http.StatusSeeOther && strings.HasPrefix(location, "/login"))
+			if !signIn {
+				t.Errorf("%s %s: expected to be asked to sign in, got %d to %q.", method, path, res.StatusCode, location)
+			}
+		}
+	}
+	taxon, err := reg.GetTaxon("t1")
+	if err != nil || taxon == nil || taxon.Name.Scientific != "Quercus robur" {
+		t.Errorf("Expected t1 to be left as it was, got %+v and %v.", taxon, err)
+	}
+	values, err := reg.GetTaxonExtraValues("t1")
+	if err != nil || len(values) != 0 {
+		t.Errorf("Expected t1 to have no extra values, got %v and %v.", values, err)
+	}
+}
@@ -2,18 +2,33 @@ package cmd
 
 import (
 	"bufio"
+	"context"
+	"crypto/rand"
 	"database/sql"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"nicolas.galipot.net/taxonomia/dataset"
+	"nicolas.galipot.net/taxonomia/dataset/accounts"
+	"nicolas.galipot.net/taxonomia/dataset/admin"
 	"nicolas.galipot.net/taxonomia/dataset/database"
 	"nicolas.galipot.net/taxonomia/dataset/identification"
+	"nicolas.galipot.net/taxonomia/dataset/jobs"
+
+	_ "embed"
 )
 
+//go:embed openapi.json
+var openAPISpec []byte
+
 func getDatabaseOrDie(dbPath string) *sql.DB {
 	db, err := sql.Open("sqlite3", dbPath)
 	if err != nil {
@@ -49,23 +64,59 @@ func Import() {
 	} else {
 		log.Fatalf("Cannot read file '%s': '%s'", dsName, err.Error())
 	}
-	r := bufio.NewReader(f)
-	ds, err := dataset.ReadHazo(r)
+	ds, err := dataset.ReadFormat(dataset.FormatOf(dsName), bufio.NewReader(f))
 	if err != nil {
-		log.Fatalf("Cannot read Hazo dataset file: '%s'\n", err.Error())
+		log.Fatalf("Cannot read dataset file: '%s'\n", err.Error())
+	}
+	if ds.Id == "" {
+		ds.Id = strings.SplitN(filepath.Base(dsName), ".", 2)[0]
+	}
+	for _, refErr := range ds.ValidateReferences() {
+		fmt.Fprintln(os.Stderr, "skipping wrong reference:", refErr.Error())
+	}
+	for _, valueErr := range ds.ValidateExtraInfo() {
+		fmt.Fprintln(os.Stderr, "skipping wrong extra value:", valueErr.Error())
 	}
 	db := getDatabaseOrDie("db.sq3")
 	defer db.Close()
 	reg := database.NewRegistry(db)
-	reg.InsertDataset(ds)
+	if err := reg.InsertDataset(ds); err != nil {
+		log.Fatalf("Cannot import dataset: %q.\n", err.Error())
+	}
 }
 
-func CacheImages() {
-	db := getDatabaseOrDie("db.sq3")
+func CacheImages(args []string) {
+	cacheFS := flag.NewFlagSet("cache", flag.ExitOnError)
+	dbPath := cacheFS.String("db", "db.sq3", "Path to to database file")
+	workers := cacheFS.Int("workers", 4, "How many pictures are downloaded at once")
+	timeout := cacheFS.Duration("timeout", 30*time.Second, "Timeout of each request")
+	retries := cacheFS.Int("retries", 2, "How many times a failed download is tried again")
+	refresh := cacheFS.Bool("refresh", false, "Download again the pictures already cached")
+	cacheFS.Parse(args)
+	if *retries == 0 {
+		*retries = -1
+	}
+	db := getDatabaseOrDie(*dbPath)
+	defer db.Close()
 	reg := database.NewRegistry(db)
-	if err := reg.CacheImages(); err != nil {
+	report, err := reg.CacheImages(context.Background(), database.CacheOptions{
+		Workers: *workers,
+		Timeout: *timeout,
+		Retries: *retries,
+		Refresh: *refresh,
+		Progress: func(done int, total int) {
+			if done%50 == 0 || done == total {
+				fmt.Fprintf(os.Stderr, "%d / %d pictures downloaded\n", done, total)
+			}
+		},
+	})
+	if err != nil {
 		log.Fatalf("Error caching images: %q", err.Error())
 	}
+	for _, failure := range report.Failures {
+		fmt.Fprintf(os.Stderr, "cannot cache %s: %s\n", failure.URL, failure.Err.Error())
+	}
+	fmt.Printf("%d pictures cached, %d already cached, %d failed\n", report.Cached, report.Skipped, len(report.Failures))
 }
 
 func displayCharacter(charactersByids map[string]*dataset.Character, ch *dataset.Character, indentation string) {
@@ -94,7 +145,17 @@ func ListCharacters() {
 	}
 }
 
-func Identify() {
+func Identify(args []string) {
+	identifyFS := flag.NewFlagSet("identify", flag.ExitOnError)
+	rank := identifyFS.String("rank", "", "Rank to which the results are aggregated, for example genus")
+	identifyFS.Parse(args)
+	if *rank != "" {
+		parsed, err := dataset.ParseRank(*rank)
+		if err != nil {
+			log.Fatalf("%s.\n", err.Error())
+		}
+		*rank = parsed
+	}
 	db := getDatabaseOrDie("db.sq3")
 	reg := database.NewRegistry(db)
 	characters, _, err := reg.GetAllCharactersExcept([]string{})
@@ -123,7 +184,12 @@ func Identify() {
 			fmt.Println("Index out of bounds", index)
 			continue
 		}
-		taxons, err := reg.GetTaxonsHavingStates(selectedStates)
+		var taxons []*dataset.Taxon
+		if *rank != "" {
+			taxons, err = reg.GetTaxonsHavingStatesAtRank(selectedStates, *rank)
+		} else {
+			taxons, err = reg.GetTaxonsHavingStates(selectedStates)
+		}
 		if err != nil {
 			log.Fatalf("Cannot retrieve taxons: %q.\n", err.Error())
 		}
@@ -135,26 +201,201 @@ func Identify() {
 		for _, taxon := range taxons {
 			fmt.Println(taxon.Name.Scientific)
 		}
+		if *rank != "" && len(taxons) == 1 {
+			fmt.Printf("identified to %s\n", *rank)
+			return
+		}
+	}
+}
+
+// readPassword returns the password given in the TAXONOMIA_PASSWORD
+// environment variable, or else on the first line of the standard input,
+// so that it does not show in the list of processes.
+func readPassword() string {
+	if password, ok := os.LookupEnv("TAXONOMIA_PASSWORD"); ok {
+		return password
+	}
+	fmt.Fprint(os.Stderr, "Password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return ""
+	}
+	return strings.TrimRight(line, "\r\n")
+}
+
+// User creates an account or updates its password and role, for example
+// to create the first admin:
+//
+//	taxonomia user -role admin alice
+func User(args []string) {
+	userFS := flag.NewFlagSet("user", flag.ExitOnError)
+	dbPath := userFS.String("db", "db.sq3", "Path to to database file")
+	role := userFS.String("role", "", "Role given to the user, viewer, editor or admin, none to remove it")
+	datasetId := userFS.String("dataset", database.AllDatasets, "Id of the dataset the role is given on, * for all of them")
+	keepPassword := userFS.Bool("keep-password", false, "Only change the role of an existing user")
+	remove := userFS.Bool("delete", false, "Delete the user")
+	userFS.Parse(args)
+	if userFS.NArg() != 1 {
+		log.Fatalf("Usage: taxonomia user [-db db.sq3] [-role viewer|editor|admin|none] [-dataset id] [-keep-password] [-delete] name\n")
+	}
+	db := getDatabaseOrDie(*dbPath)
+	defer db.Close()
+	reg := database.NewRegistry(db)
+	user, err := reg.GetUserByName(userFS.Arg(0))
+	if err != nil {
+		log.Fatalf("Cannot read user: %q.\n", err.Error())
+	}
+	switch {
+	case *remove && user == nil:
+		log.Fatalf("There is no user %q.\n", userFS.Arg(0))
+	case *remove:
+		if err := reg.DeleteUser(user.Id); err != nil {
+			log.Fatalf("Cannot delete user: %q.\n", err.Error())
+		}
+		return
+	case user == nil:
+		if user, err = reg.CreateUser(userFS.Arg(0), readPassword()); err != nil {
+			log.Fatalf("Cannot create user: %q.\n", err.Error())
+		}
+	case !*keepPassword:
+		if err := reg.SetUserPassword(user.Id, readPassword()); err != nil {
+			log.Fatalf("Cannot change password: %q.\n", err.Error())
+		}
+	}
+	if *role == "none" {
+		*role = ""
+	} else if *role == "" {
+		return
+	}
+	if err := reg.SetUserRole(user.Id, *datasetId, *role); err != nil {
+		log.Fatalf("Cannot set role: %q.\n", err.Error())
 	}
 }
 
 func Serve(args []string) {
 	serveFS := flag.NewFlagSet("server", flag.ExitOnError)
-	key := serveFS.String("key", "", "Cookie store session key")
+	key := serveFS.String("key", "", "Cookie store session key, random if empty")
+	lifetime := serveFS.Duration("session-lifetime", 30*24*time.Hour, "How long unused identification sessions are kept")
 	dbPath := serveFS.String("db", "db.sq3", "Path to to database file")
 	hostname := serveFS.String("host", "localhost", "The name of the host serving the app.")
 	port := serveFS.String("port", "8080", "The port where the app is served.")
+	workers := serveFS.Int("workers", 2, "How many background jobs run at once")
 	serveFS.Parse(args)
 	fmt.Printf("serving hostname: %s, port: %s\n", *hostname, *port)
 	db := getDatabaseOrDie(*dbPath)
 	defer db.Close()
+	// Databases created by an earlier version get the new tables and columns.
+	if err := database.CreateTables(db); err != nil {
+		log.Fatalf("Cannot upgrade database: %q.\n", err.Error())
+	}
 	reg := database.NewRegistry(db)
-	identificationHandler := identification.NewHandler(reg, *key)
-	http.HandleFunc("/static/", dataset.StaticHandler)
-	http.HandleFunc("/img", database.CachedImageHandler(reg))
-	http.HandleFunc("/favicon.ico", func(w http.ResponseWriter, r *http.Request) {})
-	http.HandleFunc("/identify", identificationHandler.Func)
-	http.ListenAndServe(*hostname+":"+*port, nil)
+	config := ServerConfig{SessionKey: *key, SessionLifetime: *lifetime, Workers: *workers}
+	if config.SessionKey == "" {
+		// Cookies only keep session ids, losing them on restart just logs
+		// browsers out of their current identification, which they can
+		// resume with its code.
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			log.Fatalf("cannot generate session key: %q", err.Error())
+		}
+		config.SessionKey = string(b)
+		log.Println("no session key given, cookies will not survive a restart")
+	}
+	http.ListenAndServe(*hostname+":"+*port, NewServeMux(reg, config))
+}
+
+// ServerConfig holds the settings of the server.
+type ServerConfig struct {
+	// SessionKey authenticates the cookies.
+	SessionKey string
+	// SessionLifetime is how long unused identification sessions are kept.
+	SessionLifetime time.Duration
+	// Workers is how many background jobs run at once.
+	Workers int
+}
+
+// route is a path pattern served by Serve, with its handler and the access
+// it requires, either accounts.Public, accounts.SignedIn or a role.
+type route struct {
+	pattern string
+	access  string
+	handler http.HandlerFunc
+}
+
+// routes returns the routes of the server, with the runner of the background
+// jobs they start.
+func routes(reg *database.DatasetRegistry, config ServerConfig, accountsHandler *accounts.Handler) ([]route, *jobs.Runner) {
+	identificationHandler := identification.NewHandler(reg, config.SessionKey, config.SessionLifetime)
+	runner := jobs.NewRunner(reg, config.Workers)
+	runner.Register(jobs.CacheImages(reg, database.CacheOptions{}), jobs.Reindex(reg), jobs.GenerateKey(reg), jobs.ImportDataset(reg, func() {
+		if err := identificationHandler.ReloadGlossary(); err != nil {
+			log.Printf("cannot reload the glossary: %q", err.Error())
+		}
+	}))
+	if err := runner.Start(); err != nil {
+		log.Printf("cannot start the jobs: %q", err.Error())
+	}
+	adminHandler := admin.NewHandler(reg, runner)
+	return []route{
+		{"/static/", accounts.Public, dataset.StaticHandler},
+		{"/img", database.RoleViewer, database.CachedImageHandler(reg)},
+		{"/favicon.ico", accounts.Public, func(w http.ResponseWriter, r *http.Request) {}},
+		{"/openapi.json", accounts.Public, OpenAPIFunc},
+		{"/login", accounts.Public, accountsHandler.LoginFunc},
+		{"/logout", accounts.Public, accountsHandler.LogoutFunc},
+		{"/account", accounts.SignedIn, accountsHandler.AccountFunc},
+		{"/identify", database.RoleViewer, identificationHandler.Func},
+		{"/identify/qr", database.RoleViewer, identificationHandler.ShareQRFunc},
+		{"/names", database.RoleViewer, identificationHandler.NamesFunc},
+		{"/taxon/", database.RoleViewer, identificationHandler.TaxonFunc},
+		{"/glossary", database.RoleViewer, identificationHandler.GlossaryFunc},
+		{"/search", database.RoleViewer, identificationHandler.SearchFunc},
+		{"/characters", database.RoleViewer, identificationHandler.CharacterTreeFunc},
+		{"/character/", database.RoleViewer, identificationHandler.CharacterFunc},
+		{"/search/suggest", database.RoleViewer, identificationHandler.SuggestFunc},
+		{"/lang", accounts.Public, identificationHandler.LanguageFunc},
+		{"/sessions", database.RoleViewer, identificationHandler.SessionsPageFunc},
+		{"/report", database.RoleViewer, identificationHandler.ReportFunc},
+		{"/api/sessions", database.RoleViewer, identificationHandler.SessionsFunc},
+		{"/api/sessions/", database.RoleViewer, identificationHandler.SessionFunc},
+		{"/admin", database.RoleEditor, adminHandler.IndexFunc},
+		{"/admin/taxon/", database.RoleEditor, adminHandler.TaxonFunc},
+		{"/admin/character/", database.RoleEditor, adminHandler.CharacterFunc},
+		{"/admin/state/", database.RoleEditor, adminHandler.StateFunc},
+		{"/admin/matrix", database.RoleEditor, adminHandler.MatrixFunc},
+		{"/admin/import", database.RoleEditor, adminHandler.ImportFunc},
+		{"/admin/import/", database.RoleEditor, adminHandler.ImportStatusFunc},
+		{"/api/datasets", database.RoleEditor, adminHandler.DatasetsFunc},
+		{"/api/datasets/imports/", database.RoleEditor, adminHandler.DatasetImportFunc},
+		{"/admin/jobs", database.RoleEditor, adminHandler.JobsPageFunc},
+		{"/api/jobs", database.RoleEditor, adminHandler.JobsFunc},
+		{"/api/jobs/", database.RoleEditor, adminHandler.JobFunc},
+		{"/admin/key", database.RoleEditor, adminHandler.KeyFunc},
+		{"/admin/users", database.RoleAdmin, accountsHandler.UsersFunc},
+	}, runner
+}
+
+// NewServeMux returns the handler of every page and API of the server, each
+// guarded by the access of its route.
+func NewServeMux(reg *database.DatasetRegistry, config ServerConfig) *http.ServeMux {
+	mux, _ := newServeMux(reg, config)
+	return mux
+}
+
+func newServeMux(reg *database.DatasetRegistry, config ServerConfig) (*http.ServeMux, *jobs.Runner) {
+	mux := http.NewServeMux()
+	accountsHandler := accounts.NewHandler(reg, config.SessionKey)
+	serverRoutes, runner := routes(reg, config, accountsHandler)
+	for _, r := range serverRoutes {
+		mux.HandleFunc(r.pattern, accountsHandler.Require(r.access, r.handler))
+	}
+	return mux, runner
+}
+
+// OpenAPIFunc serves the OpenAPI description of the server.
+func OpenAPIFunc(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
 }
 
 func Check() {
@@ -165,27 +406,329 @@ func Check() {
 	} else {
 		log.Fatalf("Cannot read file '%s': '%s'", dsName, err.Error())
 	}
-	r := bufio.NewReader(f)
-	ds, err := dataset.ReadHazo(r)
+	ds, err := dataset.ReadFormat(dataset.FormatOf(dsName), bufio.NewReader(f))
 	if err != nil {
-		log.Fatalf("Cannot read Hazo dataset file: '%s'\n", err.Error())
+		log.Fatalf("Cannot read dataset file: '%s'\n", err.Error())
 	}
-	itemIds := make(map[string]bool, len(ds.TaxonsById)+len(ds.CharactersById))
-	for id := range ds.TaxonsById {
-		if _, ok := itemIds[id]; ok {
-			fmt.Println("duplicate key", id)
+	for _, refErr := range ds.ValidateReferences() {
+		fmt.Println("wrong reference:", refErr.Error())
+	}
+	for _, rankErr := range ds.ValidateRanks() {
+		fmt.Println("wrong rank:", rankErr.Error())
+	}
+	for _, valueErr := range ds.ValidateExtraInfo() {
+		fmt.Println("wrong extra value:", valueErr.Error())
+	}
+}
+
+func readHazoFileOrDie(path string) *dataset.Dataset {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatalf("Cannot read file '%s': '%s'", path, err.Error())
+	}
+	defer f.Close()
+	ds, err := dataset.ReadHazo(bufio.NewReader(f))
+	if err != nil {
+		log.Fatalf("Cannot read Hazo dataset file '%s': '%s'\n", path, err.Error())
+	}
+	return ds
+}
+
+func Diff(args []string) {
+	diffFS := flag.NewFlagSet("diff", flag.ExitOnError)
+	asJson := diffFS.Bool("json", false, "Print the JSON patch instead of a report")
+	diffFS.Parse(args)
+	if diffFS.NArg() != 2 {
+		log.Fatalf("Usage: taxonomia diff [-json] old.hazo.json new.hazo.json\n")
+	}
+	old := readHazoFileOrDie(diffFS.Arg(0))
+	new := readHazoFileOrDie(diffFS.Arg(1))
+	diff, err := dataset.Diff(old, new)
+	if err != nil {
+		log.Fatalf("Cannot compare datasets: %q.\n", err.Error())
+	}
+	if *asJson {
+		result, err := json.MarshalIndent(diff.Patch, "", "  ")
+		if err != nil {
+			log.Fatalf("Cannot encode patch: %q.\n", err.Error())
 		}
-		itemIds[id] = true
+		fmt.Println(string(result))
+		return
 	}
-	for id, ch := range ds.CharactersById {
-		if _, ok := itemIds[id]; ok {
-			fmt.Println("duplicate key", id)
+	if len(diff.Changes) == 0 {
+		fmt.Println("no changes")
+	}
+	for _, change := range diff.Changes {
+		fmt.Println(change.String())
+	}
+}
+
+func writeHazoFileOrDie(ds *dataset.Dataset, path string, opts dataset.HazoOptions) {
+	out := os.Stdout
+	if path != "" && path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			log.Fatalf("Cannot create file '%s': '%s'", path, err.Error())
 		}
-		for _, state := range ch.States {
-			if _, ok := itemIds[state.Id]; ok {
-				fmt.Println("duplicate key", state.Id)
-			}
+		defer f.Close()
+		out = f
+	}
+	if err := dataset.WriteHazoWithOptions(out, ds, opts); err != nil {
+		log.Fatalf("Cannot write Hazo dataset: %q.\n", err.Error())
+	}
+}
+
+func Patch(args []string) {
+	patchFS := flag.NewFlagSet("patch", flag.ExitOnError)
+	output := patchFS.String("o", "", "Path of the patched dataset, standard output by default")
+	indent := patchFS.String("indent", "  ", "Indentation of the written dataset, empty for compact output")
+	patchFS.Parse(args)
+	if patchFS.NArg() != 2 {
+		log.Fatalf("Usage: taxonomia patch [-o out.hazo.json] dataset.hazo.json patch.json\n")
+	}
+	ds := readHazoFileOrDie(patchFS.Arg(0))
+	data, err := ioutil.ReadFile(patchFS.Arg(1))
+	if err != nil {
+		log.Fatalf("Cannot read file '%s': '%s'", patchFS.Arg(1), err.Error())
+	}
+	patch := &dataset.Command{}
+	if err := json.Unmarshal(data, patch); err != nil {
+		log.Fatalf("Cannot decode patch: %q.\n", err.Error())
+	}
+	if _, err := ds.Apply(patch); err != nil {
+		log.Fatalf("Cannot apply patch: %q.\n", err.Error())
+	}
+	writeHazoFileOrDie(ds, *output, dataset.HazoOptions{Indent: *indent})
+}
+
+// Merge can be used as a git merge driver, for example with:
+//
+//	git config merge.hazo.driver "taxonomia merge -o %A %O %A %B"
+//
+// It exits with a non-zero status when conflicts remain.
+func Merge(args []string) {
+	mergeFS := flag.NewFlagSet("merge", flag.ExitOnError)
+	output := mergeFS.String("o", "", "Path of the merged dataset, standard output by default")
+	indent := mergeFS.String("indent", "  ", "Indentation of the written dataset, empty for compact output")
+	conflictsPath := mergeFS.String("conflicts", "", "Path of a JSON file receiving the list of conflicts")
+	mergeFS.Parse(args)
+	if mergeFS.NArg() != 3 {
+		log.Fatalf("Usage: taxonomia merge [-o merged.hazo.json] base.hazo.json ours.hazo.json theirs.hazo.json\n")
+	}
+	base := readHazoFileOrDie(mergeFS.Arg(0))
+	ours := readHazoFileOrDie(mergeFS.Arg(1))
+	theirs := readHazoFileOrDie(mergeFS.Arg(2))
+	result, err := dataset.Merge(base, ours, theirs)
+	if err != nil {
+		log.Fatalf("Cannot merge datasets: %q.\n", err.Error())
+	}
+	writeHazoFileOrDie(result.Merged, *output, dataset.HazoOptions{Indent: *indent})
+	if *conflictsPath != "" {
+		data, err := json.MarshalIndent(result.Conflicts, "", "  ")
+		if err != nil {
+			log.Fatalf("Cannot encode conflicts: %q.\n", err.Error())
+		}
+		if err := ioutil.WriteFile(*conflictsPath, data, 0644); err != nil {
+			log.Fatalf("Cannot write file '%s': '%s'", *conflictsPath, err.Error())
+		}
+	}
+	if len(result.Conflicts) > 0 {
+		for _, c := range result.Conflicts {
+			fmt.Fprintln(os.Stderr, "conflict:", c.String())
+		}
+		os.Exit(1)
+	}
+}
+
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func Extract(args []string) {
+	extractFS := flag.NewFlagSet("extract", flag.ExitOnError)
+	var taxonIds, characterIds stringList
+	extractFS.Var(&taxonIds, "taxon", "Id of a taxon whose subtree is extracted, can be repeated")
+	extractFS.Var(&characterIds, "character", "Id of a character whose subtree is extracted, can be repeated")
+	dropConstant := extractFS.Bool("drop-constant", false, "Drop the characters that no longer discriminate the extracted taxons")
+	output := extractFS.String("out", "", "Path of the extracted dataset, standard output by default")
+	indent := extractFS.String("indent", "  ", "Indentation of the written dataset, empty for compact output")
+	extractFS.Parse(args)
+	if extractFS.NArg() > 1 {
+		log.Fatalf("Usage: taxonomia extract [--taxon id]... [--character id]... [--out subset.hazo.json] [dataset.hazo.json]\n")
+	}
+	dsPath := "dataset.hazo.json"
+	if extractFS.NArg() == 1 {
+		dsPath = extractFS.Arg(0)
+	}
+	ds := readHazoFileOrDie(dsPath)
+	subset, err := ds.SubsetWithOptions(taxonIds, characterIds, dataset.SubsetOptions{DropNonDiscriminating: *dropConstant})
+	if err != nil {
+		log.Fatalf("%s.\n", err.Error())
+	}
+	writeHazoFileOrDie(subset, *output, dataset.HazoOptions{Indent: *indent})
+}
+
+func Search(args []string) {
+	searchFS := flag.NewFlagSet("search", flag.ExitOnError)
+	dbPath := searchFS.String("db", "db.sq3", "Path to to database file")
+	lang := searchFS.String("lang", "", "Language of the names to search besides scientific ones, all by default")
+	var kinds stringList
+	searchFS.Var(&kinds, "kind", "Kind of items to search, taxon, character or state, repeatable")
+	searchFS.Parse(args)
+	if searchFS.NArg() != 1 {
+		log.Fatalf("Usage: taxonomia search [-db db.sq3] [-kind taxon]... [-lang EN] term\n")
+	}
+	db := getDatabaseOrDie(*dbPath)
+	defer db.Close()
+	reg := database.NewRegistry(db)
+	results, err := reg.Search(searchFS.Arg(0), kinds, *lang)
+	if err != nil {
+		log.Fatalf("Cannot search: %q.\n", err.Error())
+	}
+	if len(results) == 0 {
+		fmt.Println("there are no results")
+	}
+	highlight := strings.NewReplacer(database.SnippetStart, "[", database.SnippetEnd, "]")
+	for _, result := range results {
+		fmt.Printf("%s %s [%s]: %s\n", result.Kind, result.Name, result.ItemId, highlight.Replace(result.Snippet))
+	}
+}
+
+func Names(args []string) {
+	namesFS := flag.NewFlagSet("names", flag.ExitOnError)
+	dbPath := namesFS.String("db", "db.sq3", "Path to to database file")
+	var where stringList
+	namesFS.Var(&where, "where", "Extra field filter like height>=2, repeatable")
+	namesFS.Parse(args)
+	if namesFS.NArg() > 1 || (namesFS.NArg() == 0 && len(where) == 0) {
+		log.Fatalf("Usage: taxonomia names [-db db.sq3] [-where field=value]... [query]\n")
+	}
+	filters := make([]database.ExtraFilter, len(where))
+	for i, w := range where {
+		filter, err := database.ParseExtraFilter(w)
+		if err != nil {
+			log.Fatalf("Cannot read filter: %q.\n", err.Error())
+		}
+		filters[i] = filter
+	}
+	db := getDatabaseOrDie(*dbPath)
+	defer db.Close()
+	reg := database.NewRegistry(db)
+	matches, err := reg.SearchTaxonNames(namesFS.Arg(0), filters)
+	if err != nil {
+		log.Fatalf("Cannot search names: %q.\n", err.Error())
+	}
+	if len(matches) == 0 {
+		fmt.Println("there are no results")
+	}
+	for _, match := range matches {
+		accepted := strings.TrimSpace(match.Taxon.Name.Scientific + " " + match.Taxon.Author)
+		if match.Synonym == nil {
+			fmt.Printf("%s [%s]\n", accepted, match.Taxon.Id)
+		} else {
+			fmt.Printf("%s -> %s [%s]\n", match.Synonym.String(), accepted, match.Taxon.Id)
 		}
-		itemIds[id] = true
 	}
 }
+
+func ExportDwCA(args []string) {
+	exportFS := flag.NewFlagSet("dwca", flag.ExitOnError)
+	output := exportFS.String("o", "dwca.zip", "Path of the written Darwin Core Archive")
+	exportFS.Parse(args)
+	if exportFS.NArg() != 1 {
+		log.Fatalf("Usage: taxonomia dwca [-o dwca.zip] dataset.hazo.json\n")
+	}
+	ds := readHazoFileOrDie(exportFS.Arg(0))
+	f, err := os.Create(*output)
+	if err != nil {
+		log.Fatalf("Cannot create file '%s': '%s'", *output, err.Error())
+	}
+	defer f.Close()
+	if err := dataset.WriteDwCA(f, ds); err != nil {
+		log.Fatalf("Cannot write Darwin Core Archive: %q.\n", err.Error())
+	}
+}
+
+func bibliographyFormat(format string, path string) string {
+	if format != "" {
+		return format
+	}
+	if strings.HasSuffix(path, ".bib") {
+		return "bibtex"
+	}
+	return "csl"
+}
+
+func ExportBibliography(args []string) {
+	exportFS := flag.NewFlagSet("bibexport", flag.ExitOnError)
+	format := exportFS.String("format", "", "Output format, bibtex or csl, guessed from the output file name by default")
+	output := exportFS.String("o", "", "Path of the written bibliography, standard output by default")
+	exportFS.Parse(args)
+	if exportFS.NArg() != 1 {
+		log.Fatalf("Usage: taxonomia bibexport [-format bibtex|csl] [-o refs.bib] dataset.hazo.json\n")
+	}
+	ds := readHazoFileOrDie(exportFS.Arg(0))
+	w := os.Stdout
+	if *output != "" && *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			log.Fatalf("Cannot create file '%s': '%s'", *output, err.Error())
+		}
+		defer f.Close()
+		w = f
+	}
+	var err error
+	switch bibliographyFormat(*format, *output) {
+	case "bibtex":
+		err = dataset.WriteBibTeX(w, ds.Books)
+	case "csl":
+		err = dataset.WriteCSLJSON(w, ds.Books)
+	default:
+		log.Fatalf("Unknown bibliography format %q.\n", *format)
+	}
+	if err != nil {
+		log.Fatalf("Cannot write bibliography: %q.\n", err.Error())
+	}
+}
+
+func ImportBibliography(args []string) {
+	importFS := flag.NewFlagSet("bibimport", flag.ExitOnError)
+	format := importFS.String("format", "", "Input format, bibtex or csl, guessed from the file name by default")
+	output := importFS.String("o", "", "Path of the updated dataset, standard output by default")
+	indent := importFS.String("indent", "  ", "Indentation of the written dataset, empty for compact output")
+	importFS.Parse(args)
+	if importFS.NArg() != 2 {
+		log.Fatalf("Usage: taxonomia bibimport [-format bibtex|csl] [-o out.hazo.json] dataset.hazo.json refs.bib\n")
+	}
+	ds := readHazoFileOrDie(importFS.Arg(0))
+	f, err := os.Open(importFS.Arg(1))
+	if err != nil {
+		log.Fatalf("Cannot read file '%s': '%s'", importFS.Arg(1), err.Error())
+	}
+	defer f.Close()
+	var books []dataset.Book
+	switch bibliographyFormat(*format, importFS.Arg(1)) {
+	case "bibtex":
+		books, err = dataset.ReadBibTeX(f)
+	case "csl":
+		books, err = dataset.ReadCSLJSON(f)
+	default:
+		log.Fatalf("Unknown bibliography format %q.\n", *format)
+	}
+	if err != nil {
+		log.Fatalf("Cannot read bibliography: %q.\n", err.Error())
+	}
+	added, err := ds.MergeBooks(books)
+	if err != nil {
+		log.Fatalf("Cannot import bibliography: %q.\n", err.Error())
+	}
+	fmt.Fprintf(os.Stderr, "%d books added, %d updated\n", added, len(books)-added)
+	writeHazoFileOrDie(ds, *output, dataset.HazoOptions{Indent: *indent})
+}
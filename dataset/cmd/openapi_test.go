@@ -0,0 +1,645 @@
+package cmd
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"nicolas.galipot.net/taxonomia/dataset"
+	"nicolas.galipot.net/taxonomia/dataset/accounts"
+	"nicolas.galipot.net/taxonomia/dataset/database"
+	"nicolas.galipot.net/taxonomia/dataset/identification"
+	"nicolas.galipot.net/taxonomia/dataset/jobs"
+)
+
+const openAPIFixture = `{
+	"id": "ds",
+	"taxons": [
+		{ "id": "g1", "name": "Quercus", "extra": { "rank": "genus" }, "children": ["t1", "t2"] },
+		{ "id": "t1", "name": "Quercus robur", "descriptions": [{ "descriptorId": "d1", "statesIds": ["s1"] }] },
+		{ "id": "t2", "name": "Quercus ilex", "descriptions": [{ "descriptorId": "d1", "statesIds": ["s2"] }] }
+	],
+	"characters": [ { "id": "d1", "name": "Leaf shape", "states": ["s1", "s2"] } ],
+	"states": [ { "id": "s1", "name": "lobed" }, { "id": "s2", "name": "entire" } ]
+}`
+
+type specSchema struct {
+	Ref                  string                 `json:"$ref"`
+	Type                 string                 `json:"type"`
+	Required             []string               `json:"required"`
+	Properties           map[string]*specSchema `json:"properties"`
+	AdditionalProperties json.RawMessage        `json:"additionalProperties"`
+	Items                *specSchema            `json:"items"`
+	Enum                 []interface{}          `json:"enum"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+}
+
+type specResponse struct {
+	Ref     string `json:"$ref"`
+	Content map[string]struct {
+		Schema *specSchema `json:"schema"`
+	} `json:"content"`
+}
+
+type specOperation struct {
+	Responses map[string]*specResponse `json:"responses"`
+}
+
+type spec struct {
+	Paths      map[string]map[string]*specOperation `json:"paths"`
+	Components struct {
+		Schemas   map[string]*specSchema   `json:"schemas"`
+		Responses map[string]*specResponse `json:"responses"`
+	} `json:"components"`
+}
+
+func readSpec(t *testing.T) *spec {
+	var s spec
+	if err := json.Unmarshal(openAPISpec, &s); err != nil {
+		t.Fatalf("Cannot parse the OpenAPI document: %q.", err.Error())
+	}
+	return &s
+}
+
+// findPath returns the path template of the document matching a request
+// path, the segments between braces matching any segment.
+func (s *spec) findPath(path string) (string, bool) {
+	segments := strings.Split(path, "/")
+	for template := range s.Paths {
+		parts := strings.Split(template, "/")
+		if len(parts) != len(segments) {
+			continue
+		}
+		matches := true
+		for i, part := range parts {
+			isParam := strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}")
+			if (isParam && segments[i] == "") || (!isParam && part != segments[i]) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return template, true
+		}
+	}
+	return "", false
+}
+
+func (s *spec) resolveSchema(schema *specSchema) *specSchema {
+	for schema != nil && schema.Ref != "" {
+		schema = s.Components.Schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+	}
+	return schema
+}
+
+func (s *spec) validate(schema *specSchema, value interface{}, at string) error {
+	schema = s.resolveSchema(schema)
+	if schema == nil {
+		return nil
+	}
+	if len(schema.Enum) > 0 {
+		found := false
+		for _, allowed := range schema.Enum {
+			if allowed == value {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: %v is not one of %v", at, value, schema.Enum)
+		}
+	}
+	switch schema.Type {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected an object, got %T", at, value)
+		}
+		for _, key := range schema.Required {
+			if _, ok := object[key]; !ok {
+				return fmt.Errorf("%s: missing required property %q", at, key)
+			}
+		}
+		for key, v := range object {
+			if property, ok := schema.Properties[key]; ok {
+				if err := s.validate(property, v, at+"."+key); err != nil {
+					return err
+				}
+			} else if string(schema.AdditionalProperties) == "false" {
+				return fmt.Errorf("%s: unexpected property %q", at, key)
+			}
+		}
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected an array, got %T", at, value)
+		}
+		for i, item := range array {
+			if err := s.validate(schema.Items, item, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("%s: expected a string, got %T", at, value)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: expected a boolean, got %T", at, value)
+		}
+	case "number", "integer":
+		n, ok := value.(float64)
+		if !ok {
+			return fmt.Errorf("%s: expected a number, got %T", at, value)
+		}
+		if schema.Type == "integer" && n != float64(int64(n)) {
+			return fmt.Errorf("%s: expected an integer, got %v", at, n)
+		}
+		if (schema.Minimum != nil && n < *schema.Minimum) || (schema.Maximum != nil && n > *schema.Maximum) {
+			return fmt.Errorf("%s: %v is out of bounds", at, n)
+		}
+	}
+	return nil
+}
+
+// checkResponse verifies that the status, content type and JSON body of a
+// response are documented for the operation.
+func (s *spec) checkResponse(op *specOperation, res *http.Response, body []byte) error {
+	response, ok := op.Responses[fmt.Sprint(res.StatusCode)]
+	if !ok {
+		return fmt.Errorf("undocumented status %d", res.StatusCode)
+	}
+	if response.Ref != "" {
+		response = s.Components.Responses[strings.TrimPrefix(response.Ref, "#/components/responses/")]
+	}
+	if len(response.Content) == 0 {
+		return nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	for documented, content := range response.Content {
+		if documented != "*/*" && documented != mediaType {
+			continue
+		}
+		if mediaType != "application/json" || content.Schema == nil {
+			return nil
+		}
+		var value interface{}
+		if err := json.Unmarshal(body, &value); err != nil {
+			return fmt.Errorf("invalid JSON: %q", err.Error())
+		}
+		return s.validate(content.Schema, value, "body")
+	}
+	return fmt.Errorf("undocumented content type %q", mediaType)
+}
+
+func newTestRegistry(t *testing.T) *database.DatasetRegistry {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "db.sq3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := database.CreateTables(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.InsertStandardContent(db); err != nil {
+		t.Fatal(err)
+	}
+	ds, err := dataset.ReadHazo(strings.NewReader(openAPIFixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := database.NewRegistry(db)
+	if err := reg.InsertDataset(ds); err != nil {
+		t.Fatal(err)
+	}
+	return reg
+}
+
+var testConfig = ServerConfig{SessionKey: "key", SessionLifetime: time.Hour}
+
+const testPassword = "test password"
+
+const form = "application/x-www-form-urlencoded"
+
+func TestRoutesAreDocumented(t *testing.T) {
+	s := readSpec(t)
+	reg := newTestRegistry(t)
+	serverRoutes, _ := routes(reg, testConfig, accounts.NewHandler(reg, testConfig.SessionKey))
+	for _, r := range serverRoutes {
+		documented := false
+		for path := range s.Paths {
+			if path == r.pattern || (strings.HasSuffix(r.pattern, "/") && strings.HasPrefix(path, r.pattern)) {
+				documented = true
+			}
+		}
+		if !documented {
+			t.Errorf("Route %q is missing from the OpenAPI document.", r.pattern)
+		}
+	}
+}
+
+// exercised records the operations of the OpenAPI document the tests made
+// requests to, checked by TestMain once they all ran.
+var exercised = struct {
+	sync.Mutex
+	operations map[string]bool
+}{operations: map[string]bool{}}
+
+// allTestsRun tells if no test was left out with -run or -skip.
+func allTestsRun() bool {
+	for _, name := range []string{"test.run", "test.skip"} {
+		if f := flag.Lookup(name); f != nil && f.Value.String() != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// TestMain fails when some operations of the OpenAPI document are not
+// exercised by any test, unless only some tests were run.
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if code == 0 && allTestsRun() {
+		var s spec
+		if err := json.Unmarshal(openAPISpec, &s); err != nil {
+			fmt.Printf("Cannot parse the OpenAPI document: %q.\n", err.Error())
+			os.Exit(1)
+		}
+		var missing []string
+		for template, ops := range s.Paths {
+			for method := range ops {
+				if !exercised.operations[method+" "+template] {
+					missing = append(missing, method+" "+template)
+				}
+			}
+		}
+		sort.Strings(missing)
+		for _, op := range missing {
+			fmt.Printf("Operation %s is not exercised by the tests.\n", op)
+			code = 1
+		}
+	}
+	os.Exit(code)
+}
+
+// apiServer is a server of the test dataset with a registry of its own,
+// whose responses are checked against the OpenAPI document. Its users are
+// an admin of every dataset and a viewer of the test dataset, whose API
+// tokens are given by name, bogus being an invalid token.
+type apiServer struct {
+	t      *testing.T
+	spec   *spec
+	reg    *database.DatasetRegistry
+	runner *jobs.Runner
+	url    string
+	client *http.Client
+	tokens map[string]string
+}
+
+func newAPIServer(t *testing.T) *apiServer {
+	reg := newTestRegistry(t)
+	a := &apiServer{t: t, spec: readSpec(t), reg: reg, tokens: map[string]string{"bogus": "tx_bogus"}}
+	for _, u := range []struct{ name, datasetId, role string }{
+		{"admin", database.AllDatasets, database.RoleAdmin},
+		{"viewer", "ds", database.RoleViewer},
+	} {
+		user, err := reg.CreateUser(u.name, testPassword)
+		if err == nil {
+			err = reg.SetUserRole(user.Id, u.datasetId, u.role)
+		}
+		if err == nil {
+			a.tokens[u.name], _, err = reg.CreateApiToken(user.Id, "test")
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	mux, runner := newServeMux(reg, testConfig)
+	server := httptest.NewServer(mux)
+	t.Cleanup(func() {
+		server.Close()
+		runner.Wait()
+	})
+	a.runner, a.url, a.client = runner, server.URL, server.Client()
+	a.client.CheckRedirect = func(req *http.Request, via []*http.Request) error { return http.ErrUseLastResponse }
+	return a
+}
+
+// apiRequest is a request made by a user, anonymous if empty, and the
+// status expected.
+type apiRequest struct {
+	method, path, contentType, body string
+	status                          int
+	user                            string
+}
+
+// do makes a request, checking its status and that its response is
+// documented. The jobs started by the request are run before returning.
+func (a *apiServer) do(req apiRequest) (*http.Response, []byte) {
+	a.t.Helper()
+	httpReq, _ := http.NewRequest(req.method, a.url+req.path, strings.NewReader(req.body))
+	// Requests come from the pages of the server, as forms posted by a
+	// browser tell.
+	httpReq.Header.Set("Origin", a.url)
+	if req.contentType != "" {
+		httpReq.Header.Set("Content-Type", req.contentType)
+	}
+	if req.user != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+a.tokens[req.user])
+	}
+	res, err := a.client.Do(httpReq)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	a.runner.Wait()
+	if res.StatusCode != req.status {
+		a.t.Errorf("%s %s: expected status %d, got %d: %s", req.method, req.path, req.status, res.StatusCode, body)
+		return res, body
+	}
+	template, ok := a.spec.findPath(strings.SplitN(req.path, "?", 2)[0])
+	if !ok {
+		a.t.Errorf("%s %s: path not documented", req.method, req.path)
+		return res, body
+	}
+	op, ok := a.spec.Paths[template][strings.ToLower(req.method)]
+	if !ok {
+		if res.StatusCode != http.StatusMethodNotAllowed {
+			a.t.Errorf("%s %s: method not documented", req.method, req.path)
+		}
+		return res, body
+	}
+	exercised.Lock()
+	exercised.operations[strings.ToLower(req.method)+" "+template] = true
+	exercised.Unlock()
+	if err := a.spec.checkResponse(op, res, body); err != nil {
+		a.t.Errorf("%s %s: %s", req.method, req.path, err.Error())
+	}
+	return res, body
+}
+
+func (a *apiServer) check(requests ...apiRequest) {
+	a.t.Helper()
+	for _, req := range requests {
+		a.do(req)
+	}
+}
+
+// checkJobs verifies that no job started by the requests failed.
+func (a *apiServer) checkJobs() {
+	a.t.Helper()
+	list, err := a.reg.GetJobs(100)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	for _, job := range list {
+		if job.Status == database.JobFailed {
+			a.t.Errorf("Job %d %s failed: %q.", job.Id, job.Kind, job.Error)
+		}
+	}
+}
+
+func TestPagesMatchOpenAPI(t *testing.T) {
+	a := newAPIServer(t)
+	shareToken := identification.EncodeShareToken(&identification.Session{
+		Rank:    "species",
+		Answers: []identification.Answer{{CharacterId: "d1", StateIds: []string{"s1"}}},
+	})
+	a.check(
+		apiRequest{"GET", "/openapi.json", "", "", 200, ""},
+		apiRequest{"GET", "/static/style.css", "", "", 200, ""},
+		apiRequest{"GET", "/static/missing.css", "", "", 404, ""},
+		apiRequest{"GET", "/img", "", "", 400, ""},
+		apiRequest{"GET", "/img?src=http://example.org/missing.png", "", "", 404, ""},
+		apiRequest{"GET", "/favicon.ico", "", "", 200, ""},
+		apiRequest{"GET", "/identify", "", "", 200, ""},
+		apiRequest{"GET", "/identify?char=d1", "", "", 200, ""},
+		apiRequest{"GET", "/identify?in=missing", "", "", 200, ""},
+		apiRequest{"POST", "/identify?char=d1", form, "selected-character=d1&selected-state=s1", 200, ""},
+		apiRequest{"POST", "/identify", form, "rank=bogus", 400, ""},
+		apiRequest{"GET", "/identify?s=" + shareToken, "", "", 200, ""},
+		apiRequest{"GET", "/identify?s=AgA", "", "", 400, ""},
+		apiRequest{"POST", "/identify?s=" + shareToken, form, "", 303, ""},
+		apiRequest{"POST", "/identify?s=AgA", form, "", 400, ""},
+		apiRequest{"GET", "/identify/qr?s=" + shareToken, "", "", 200, ""},
+		apiRequest{"GET", "/identify/qr?s=%21", "", "", 400, ""},
+		apiRequest{"GET", "/names?q=quercus", "", "", 200, ""},
+		apiRequest{"GET", "/taxon/t1", "", "", 200, ""},
+		apiRequest{"GET", "/taxon/missing", "", "", 404, ""},
+		apiRequest{"GET", "/glossary", "", "", 200, ""},
+		apiRequest{"GET", "/search?q=leaf&kind=character", "", "", 200, ""},
+		apiRequest{"GET", "/search?q=quercus", "", "", 200, "admin"},
+		apiRequest{"GET", "/search/suggest?q=quer", "", "", 200, ""},
+		apiRequest{"GET", "/characters", "", "", 200, ""},
+		apiRequest{"GET", "/character/d1", "", "", 200, ""},
+		apiRequest{"GET", "/character/missing", "", "", 404, ""},
+		apiRequest{"GET", "/lang?code=FR", "", "", 303, ""},
+		apiRequest{"GET", "/lang?code=XX", "", "", 400, ""},
+		apiRequest{"GET", "/sessions", "", "", 200, ""},
+		apiRequest{"POST", "/sessions", form, "action=new", 303, ""},
+		apiRequest{"POST", "/sessions", form, "code=nope", 404, ""},
+		apiRequest{"GET", "/report", "", "", 200, ""},
+		apiRequest{"GET", "/report?format=pdf", "", "", 200, ""},
+		apiRequest{"GET", "/report?format=doc", "", "", 400, ""},
+	)
+}
+
+func TestSessionAPIMatchesOpenAPI(t *testing.T) {
+	a := newAPIServer(t)
+	res, body := a.do(apiRequest{"POST", "/api/sessions", "application/json", `{"rank": "species"}`, 201, ""})
+	var session struct{ Id, Code string }
+	if err := json.Unmarshal(body, &session); err != nil || res.StatusCode != http.StatusCreated {
+		t.Fatalf("Cannot create a session: %s.", body)
+	}
+	at := "/api/sessions/" + session.Id
+	a.check(
+		apiRequest{"PUT", "/api/sessions", "", "", 405, ""},
+		apiRequest{"GET", "/api/sessions", "", "", 400, ""},
+		apiRequest{"GET", "/api/sessions?code=" + session.Code, "", "", 200, ""},
+		apiRequest{"GET", "/api/sessions?code=nope", "", "", 404, ""},
+		apiRequest{"POST", "/sessions", form, "code=" + session.Code, 303, ""},
+		apiRequest{"GET", at, "", "", 200, ""},
+		apiRequest{"GET", "/api/sessions/missing", "", "", 404, ""},
+		apiRequest{"POST", at + "/rank", "application/json", `{"rank": "bogus"}`, 400, ""},
+		apiRequest{"POST", at + "/rank", "application/json", `{"rank": ""}`, 200, ""},
+		apiRequest{"GET", at + "/characters?limit=5", "", "", 200, ""},
+		apiRequest{"GET", at + "/characters?limit=-1", "", "", 400, ""},
+		apiRequest{"POST", at + "/answers", "application/json", `{"character": "d1", "states": ["s1"]}`, 200, ""},
+		apiRequest{"POST", at + "/answers", "application/json", `{"character": "d1", "states": ["s2"]}`, 422, ""},
+		apiRequest{"POST", at + "/answers", "application/json", `{`, 400, ""},
+		apiRequest{"GET", at + "/answers", "", "", 405, ""},
+		apiRequest{"GET", at + "/candidates", "", "", 200, ""},
+		apiRequest{"GET", at + "/explanations/t2", "", "", 200, ""},
+		apiRequest{"GET", at + "/explanations/missing", "", "", 404, ""},
+		apiRequest{"POST", at + "/undo", "", "", 200, ""},
+		apiRequest{"POST", at + "/reset", "", "", 200, ""},
+	)
+}
+
+func TestAccountsMatchOpenAPI(t *testing.T) {
+	a := newAPIServer(t)
+	a.check(
+		apiRequest{"GET", "/login?next=/admin", "", "", 200, ""},
+		apiRequest{"POST", "/login", form, "name=admin&password=wrong+password", 401, ""},
+		apiRequest{"POST", "/login", form, "name=admin&password=" + testPassword + "&next=/admin", 303, ""},
+		apiRequest{"GET", "/logout", "", "", 405, ""},
+		apiRequest{"POST", "/logout", "", "", 303, ""},
+		apiRequest{"GET", "/account", "", "", 303, ""},
+		apiRequest{"GET", "/account", "", "", 401, "bogus"},
+		apiRequest{"POST", "/account", form, "action=create-token", 401, ""},
+		apiRequest{"GET", "/account", "", "", 200, "viewer"},
+		apiRequest{"POST", "/account", form, "action=create-token&name=script", 200, "viewer"},
+		apiRequest{"POST", "/account", form, "action=delete-token&token=999", 303, "viewer"},
+		apiRequest{"POST", "/account", form, "action=password&current=wrong&password=new+password", 400, "viewer"},
+	)
+}
+
+func TestAdminEditingMatchesOpenAPI(t *testing.T) {
+	a := newAPIServer(t)
+	a.check(
+		apiRequest{"GET", "/admin", "", "", 303, ""},
+		apiRequest{"GET", "/admin", "", "", 401, "bogus"},
+		apiRequest{"GET", "/admin", "", "", 403, "viewer"},
+		apiRequest{"POST", "/admin", form, "action=add-character&name=Bark", 401, ""},
+		apiRequest{"POST", "/admin", form, "action=add-character&name=Bark", 403, "viewer"},
+		apiRequest{"GET", "/admin", "", "", 200, "admin"},
+		apiRequest{"POST", "/admin", form, "action=add-taxon&parent=g1&id=t3&name=Quercus+petraea&rank=species", 303, "admin"},
+		apiRequest{"POST", "/admin", form, "action=add-taxon&parent=missing&name=Quercus", 400, "admin"},
+		apiRequest{"POST", "/admin", form, "action=add-character&name=Bark", 303, "admin"},
+		apiRequest{"GET", "/admin/taxon/t1", "", "", 200, "admin"},
+		apiRequest{"GET", "/admin/taxon/missing", "", "", 404, "admin"},
+		apiRequest{"POST", "/admin/taxon/t1", form, "", 303, "admin"},
+		apiRequest{"POST", "/admin/taxon/t3", form, "action=move&parent=&position=1", 303, "admin"},
+		apiRequest{"POST", "/admin/taxon/g1", form, "action=move&parent=t1", 400, "admin"},
+		apiRequest{"POST", "/admin/taxon/missing", form, "", 404, "admin"},
+		apiRequest{"POST", "/admin/taxon/t3", form, "action=delete", 303, "admin"},
+		apiRequest{"GET", "/admin/character/d1", "", "", 200, "admin"},
+		apiRequest{"GET", "/admin/character/missing", "", "", 404, "admin"},
+		apiRequest{"POST", "/admin/character/d1", form, "action=add-state&id=s3&name=toothed", 303, "admin"},
+		apiRequest{"POST", "/admin/character/d1", form, "action=move-state&state=s3&position=1", 303, "admin"},
+		apiRequest{"POST", "/admin/character/d1", form, "action=add-state&id=s3&name=again", 400, "admin"},
+		apiRequest{"POST", "/admin/character/missing", form, "", 404, "admin"},
+		apiRequest{"GET", "/admin/state/s3", "", "", 200, "admin"},
+		apiRequest{"GET", "/admin/state/missing", "", "", 404, "admin"},
+		apiRequest{"POST", "/admin/state/s3", form, "name=toothed&name.FR=dent%C3%A9e&color=%23aa0000", 303, "admin"},
+		apiRequest{"POST", "/admin/state/s3", form, "action=add-picture&url=", 400, "admin"},
+		apiRequest{"POST", "/admin/state/missing", form, "", 404, "admin"},
+		apiRequest{"GET", "/admin/matrix", "", "", 200, "admin"},
+		apiRequest{"GET", "/admin/matrix?char=missing", "", "", 404, "admin"},
+		apiRequest{"POST", "/admin/matrix?char=d1", form, "t.t1=s1&was.t1=s1&t.t2=s3", 303, "admin"},
+		apiRequest{"POST", "/admin/matrix?char=missing", form, "", 404, "admin"},
+		apiRequest{"POST", "/admin/state/s3", form, "action=delete", 303, "admin"},
+	)
+	a.checkJobs()
+}
+
+func TestImportsMatchOpenAPI(t *testing.T) {
+	a := newAPIServer(t)
+	a.check(
+		apiRequest{"GET", "/admin/import", "", "", 303, ""},
+		apiRequest{"GET", "/admin/import", "", "", 403, "viewer"},
+		apiRequest{"GET", "/admin/import", "", "", 200, "admin"},
+		apiRequest{"POST", "/admin/import?filename=ds.json", "application/json", `{`, 422, "admin"},
+		apiRequest{"POST", "/admin/import?format=doc", "application/json", openAPIFixture, 400, "admin"},
+	)
+	res, _ := a.do(apiRequest{"POST", "/admin/import?filename=ds.json", "application/json", openAPIFixture, 303, "admin"})
+	at := res.Header.Get("Location")
+	a.check(
+		apiRequest{"GET", at, "", "", 200, "admin"},
+		apiRequest{"GET", "/admin/import/999", "", "", 404, "admin"},
+		apiRequest{"POST", at, form, "action=bogus", 400, "admin"},
+		apiRequest{"POST", at, form, "action=cancel", 303, "admin"},
+		apiRequest{"POST", at, form, "action=start", 409, "admin"},
+		apiRequest{"POST", "/admin/import/999", form, "action=start", 404, "admin"},
+		apiRequest{"GET", "/api/datasets", "", "", 405, "admin"},
+		apiRequest{"POST", "/api/datasets", "application/json", openAPIFixture, 401, "bogus"},
+		apiRequest{"POST", "/api/datasets", "application/json", openAPIFixture, 403, "viewer"},
+		apiRequest{"POST", "/api/datasets?format=doc", "application/json", openAPIFixture, 400, "admin"},
+		apiRequest{"POST", "/api/datasets", "application/json", `{"taxons": [{"id": "t1", "children": ["missing"]}]}`, 422, "admin"},
+		apiRequest{"POST", "/api/datasets?dryRun=true", "application/json", openAPIFixture, 200, "admin"},
+	)
+	res, _ = a.do(apiRequest{"POST", "/api/datasets?filename=ds.json", "application/json", openAPIFixture, 202, "admin"})
+	at = res.Header.Get("Location")
+	a.check(
+		apiRequest{"GET", at, "", "", 200, "admin"},
+		apiRequest{"GET", "/api/datasets/imports/999", "", "", 404, "admin"},
+		apiRequest{"POST", at, "", "", 405, "admin"},
+	)
+	a.checkJobs()
+}
+
+func TestJobsMatchOpenAPI(t *testing.T) {
+	a := newAPIServer(t)
+	a.check(
+		apiRequest{"GET", "/admin/jobs", "", "", 303, ""},
+		apiRequest{"GET", "/admin/jobs", "", "", 403, "viewer"},
+		apiRequest{"GET", "/admin/jobs", "", "", 200, "admin"},
+		apiRequest{"POST", "/admin/jobs", form, "action=start&kind=reindex", 303, "admin"},
+		apiRequest{"POST", "/admin/jobs", form, "action=start&kind=import", 400, "admin"},
+		apiRequest{"POST", "/admin/jobs", form, "action=retry&job=999", 404, "admin"},
+		apiRequest{"GET", "/api/jobs", "", "", 200, "admin"},
+		apiRequest{"GET", "/api/jobs", "", "", 403, "viewer"},
+		apiRequest{"GET", "/api/jobs", "", "", 401, "bogus"},
+		apiRequest{"POST", "/api/jobs", "application/json", `{"kind": "import"}`, 400, "admin"},
+		apiRequest{"POST", "/api/jobs", "application/json", `{`, 400, "admin"},
+		apiRequest{"GET", "/admin/key", "", "", 303, ""},
+		apiRequest{"GET", "/admin/key", "", "", 403, "viewer"},
+		apiRequest{"GET", "/admin/key", "", "", 404, "admin"},
+		apiRequest{"POST", "/admin/jobs", form, "action=start&kind=key", 303, "admin"},
+		apiRequest{"GET", "/admin/key", "", "", 200, "admin"},
+	)
+	res, _ := a.do(apiRequest{"POST", "/api/jobs", "application/json", `{"kind": "reindex"}`, 202, "admin"})
+	at := res.Header.Get("Location")
+	// The job is done by then, so it can be neither cancelled nor retried.
+	a.check(
+		apiRequest{"GET", at, "", "", 200, "admin"},
+		apiRequest{"GET", "/api/jobs/999", "", "", 404, "admin"},
+		apiRequest{"POST", at, "", "", 405, "admin"},
+		apiRequest{"POST", at + "/cancel", "", "", 409, "admin"},
+		apiRequest{"POST", "/api/jobs/999/cancel", "", "", 404, "admin"},
+		apiRequest{"POST", at + "/retry", "", "", 409, "admin"},
+		apiRequest{"GET", at + "/retry", "", "", 405, "admin"},
+	)
+	a.checkJobs()
+}
+
+func TestUsersMatchOpenAPI(t *testing.T) {
+	a := newAPIServer(t)
+	_, body := a.do(apiRequest{"POST", "/api/sessions", "application/json", `{}`, 201, ""})
+	var session struct{ Id string }
+	json.Unmarshal(body, &session)
+	a.check(
+		apiRequest{"GET", "/admin/users", "", "", 303, ""},
+		apiRequest{"GET", "/admin/users", "", "", 401, "bogus"},
+		apiRequest{"GET", "/admin/users", "", "", 403, "viewer"},
+		apiRequest{"GET", "/admin/users", "", "", 200, "admin"},
+		apiRequest{"POST", "/admin/users", form, "action=create&name=editor&password=editor+password&role=editor&dataset=ds", 303, "admin"},
+		apiRequest{"POST", "/admin/users", form, "action=create&name=editor&password=editor+password", 400, "admin"},
+		apiRequest{"POST", "/admin/users", form, "action=delete&user=1", 400, "admin"},
+		// A private dataset is only open to the users with a role on it.
+		apiRequest{"POST", "/admin/users", form, "action=private&private=1", 303, "admin"},
+		apiRequest{"GET", "/identify", "", "", 303, ""},
+		apiRequest{"GET", "/api/sessions/" + session.Id, "", "", 401, ""},
+		apiRequest{"GET", "/api/sessions/" + session.Id, "", "", 401, "bogus"},
+		apiRequest{"GET", "/api/sessions/" + session.Id, "", "", 200, "viewer"},
+		apiRequest{"POST", "/admin/users", form, "action=private", 303, "admin"},
+		apiRequest{"GET", "/identify", "", "", 200, ""},
+	)
+}
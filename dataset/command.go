@@ -0,0 +1,645 @@
+package dataset
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	OpBatch            = "batch"
+	OpRename           = "rename"
+	OpSetTranslation   = "setTranslation"
+	OpSetDescription   = "setDescription"
+	OpSetAuthor        = "setAuthor"
+	OpSetRank          = "setRank"
+	OpSetSynonyms      = "setSynonyms"
+	OpSetExtraInfo     = "setExtraInfo"
+	OpSetColor         = "setColor"
+	OpAddPicture       = "addPicture"
+	OpUpdatePicture    = "updatePicture"
+	OpRemovePicture    = "removePicture"
+	OpInsertTaxons     = "insertTaxons"
+	OpDeleteTaxon      = "deleteTaxon"
+	OpMoveTaxon        = "moveTaxon"
+	OpInsertCharacters = "insertCharacters"
+	OpDeleteCharacter  = "deleteCharacter"
+	OpMoveCharacter    = "moveCharacter"
+	OpAddState         = "addState"
+	OpRemoveState      = "removeState"
+	OpMoveState        = "moveState"
+	OpSetTaxonState    = "setTaxonState"
+	OpUnsetTaxonState  = "unsetTaxonState"
+	OpSetTaxonStates   = "setTaxonStates"
+	OpSetDependencies  = "setDependencies"
+	OpAddBook          = "addBook"
+	OpUpdateBook       = "updateBook"
+	OpMoveBook         = "moveBook"
+	OpRemoveBook       = "removeBook"
+	OpSetReferences    = "setReferences"
+)
+
+// TaxonRecord is a self-contained copy of a taxon, used by commands to
+// insert taxons back into a dataset.
+type TaxonRecord struct {
+	Id          string                 `json:"id"`
+	ParentId    string                 `json:"parentId,omitempty"`
+	Name        MultilangText          `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Pictures    []Picture              `json:"pictures,omitempty"`
+	Author      string                 `json:"author,omitempty"`
+	Rank        string                 `json:"rank,omitempty"`
+	Synonyms    []Synonym              `json:"synonyms,omitempty"`
+	StateIds    []string               `json:"stateIds,omitempty"`
+	References  []BookReference        `json:"references,omitempty"`
+	ExtraInfo   map[string]interface{} `json:"extraInfo,omitempty"`
+}
+
+// CharacterRecord is a self-contained copy of a character and its states,
+// used by commands to insert characters back into a dataset.
+type CharacterRecord struct {
+	Id                   string        `json:"id"`
+	ParentId             string        `json:"parentId,omitempty"`
+	Name                 MultilangText `json:"name"`
+	Description          string        `json:"description,omitempty"`
+	Pictures             []Picture     `json:"pictures,omitempty"`
+	States               []State       `json:"states,omitempty"`
+	InherentStateId      string        `json:"inherentStateId,omitempty"`
+	RequiredStateIds     []string      `json:"requiredStateIds,omitempty"`
+	InapplicableStateIds []string      `json:"inapplicableStateIds,omitempty"`
+}
+
+// Command describes a single mutation of a Dataset. Only the fields relevant
+// to its Op are used, so that commands can be stored as plain JSON.
+type Command struct {
+	Op                   string             `json:"op"`
+	Id                   string             `json:"id,omitempty"`
+	ParentId             string             `json:"parentId,omitempty"`
+	Position             int                `json:"position"`
+	Lang                 string             `json:"lang,omitempty"`
+	Key                  string             `json:"key,omitempty"`
+	Text                 string             `json:"text,omitempty"`
+	Value                interface{}        `json:"value,omitempty"`
+	Picture              *Picture           `json:"picture,omitempty"`
+	State                *State             `json:"state,omitempty"`
+	StateId              string             `json:"stateId,omitempty"`
+	StateIds             []string           `json:"stateIds,omitempty"`
+	InherentStateId      string             `json:"inherentStateId,omitempty"`
+	RequiredStateIds     []string           `json:"requiredStateIds,omitempty"`
+	InapplicableStateIds []string           `json:"inapplicableStateIds,omitempty"`
+	Synonyms             []Synonym          `json:"synonyms,omitempty"`
+	Book                 *Book              `json:"book,omitempty"`
+	References           []BookReference    `json:"references,omitempty"`
+	Taxons               []*TaxonRecord     `json:"taxons,omitempty"`
+	Characters           []*CharacterRecord `json:"characters,omitempty"`
+	Commands             []*Command         `json:"commands,omitempty"`
+}
+
+func copyMultilangText(text MultilangText) MultilangText {
+	names := make(map[string]string, len(text.NamesByLangRef))
+	for lang, name := range text.NamesByLangRef {
+		names[lang] = name
+	}
+	return MultilangText{Scientific: text.Scientific, NamesByLangRef: names}
+}
+
+func copyPictures(pics []Picture) []Picture {
+	if len(pics) == 0 {
+		return nil
+	}
+	return append([]Picture{}, pics...)
+}
+
+func copyState(state State) State {
+	state.Name = copyMultilangText(state.Name)
+	state.Pictures = copyPictures(state.Pictures)
+	return state
+}
+
+func stateIdsOf(states []*State) []string {
+	if len(states) == 0 {
+		return nil
+	}
+	ids := make([]string, len(states))
+	for i, state := range states {
+		ids[i] = state.Id
+	}
+	return ids
+}
+
+func (ds *Dataset) taxonRecords(id string) []*TaxonRecord {
+	parent, index := findParent(ds.TaxonsHierarchy, id)
+	if parent == nil {
+		return nil
+	}
+	records := []*TaxonRecord{}
+	var walk func(h *Hierarchy, parentId string)
+	walk = func(h *Hierarchy, parentId string) {
+		record := &TaxonRecord{
+			Id:          h.Id,
+			ParentId:    parentId,
+			Name:        copyMultilangText(h.Name),
+			Description: h.Description,
+			Pictures:    copyPictures(h.Pictures),
+		}
+		if taxon, ok := ds.TaxonsById[h.Id]; ok {
+			record.Author = taxon.Author
+			record.Rank = taxon.Rank
+			record.Synonyms = copySynonyms(taxon.Synonyms)
+			record.StateIds = stateIdsOf(taxon.States)
+			if len(taxon.References) > 0 {
+				record.References = append([]BookReference{}, taxon.References...)
+			}
+			if len(taxon.ExtraInfo) > 0 {
+				record.ExtraInfo = make(map[string]interface{}, len(taxon.ExtraInfo))
+				for k, v := range taxon.ExtraInfo {
+					record.ExtraInfo[k] = v
+				}
+			}
+		}
+		records = append(records, record)
+		for _, child := range h.Children {
+			walk(child, h.Id)
+		}
+	}
+	walk(parent.Children[index], "")
+	return records
+}
+
+func (ds *Dataset) characterRecords(id string) []*CharacterRecord {
+	parent, index := findParent(ds.CharactersHierarchy, id)
+	if parent == nil {
+		return nil
+	}
+	records := []*CharacterRecord{}
+	var walk func(h *Hierarchy, parentId string)
+	walk = func(h *Hierarchy, parentId string) {
+		record := &CharacterRecord{
+			Id:          h.Id,
+			ParentId:    parentId,
+			Name:        copyMultilangText(h.Name),
+			Description: h.Description,
+			Pictures:    copyPictures(h.Pictures),
+		}
+		if ch, ok := ds.CharactersById[h.Id]; ok {
+			for _, state := range ch.States {
+				record.States = append(record.States, copyState(state))
+			}
+			if ch.InherentState != nil {
+				record.InherentStateId = ch.InherentState.Id
+			}
+			record.RequiredStateIds = stateIdsOf(ch.RequiredStates)
+			record.InapplicableStateIds = stateIdsOf(ch.InapplicableStates)
+		}
+		records = append(records, record)
+		for _, child := range h.Children {
+			walk(child, h.Id)
+		}
+	}
+	walk(parent.Children[index], "")
+	return records
+}
+
+func (ds *Dataset) insertTaxonRecords(parentId string, position int, records []*TaxonRecord) error {
+	if len(records) == 0 {
+		return fmt.Errorf("cannot insert an empty list of taxons")
+	}
+	taxons := make(map[string]*Taxon, len(records))
+	for i, record := range records {
+		if _, ok := taxons[record.Id]; ok {
+			return fmt.Errorf("cannot insert taxons: duplicate id %q", record.Id)
+		}
+		states, err := ds.stateRefs(record.StateIds)
+		if err != nil {
+			return fmt.Errorf("cannot insert taxon %q: %s", record.Id, err.Error())
+		}
+		taxon := NewTaxon(&Hierarchy{
+			Id:          record.Id,
+			Name:        copyMultilangText(record.Name),
+			Description: record.Description,
+			Pictures:    copyPictures(record.Pictures),
+		})
+		taxon.Author = record.Author
+		taxon.Rank = record.Rank
+		taxon.Synonyms = copySynonyms(record.Synonyms)
+		taxon.States = states
+		taxon.References = append([]BookReference{}, record.References...)
+		for k, v := range record.ExtraInfo {
+			taxon.ExtraInfo[k] = v
+		}
+		if i > 0 {
+			parent, ok := taxons[record.ParentId]
+			if !ok {
+				return fmt.Errorf("cannot insert taxon %q: parent %q must be listed before it", record.Id, record.ParentId)
+			}
+			parent.Children = append(parent.Children, taxon.Hierarchy)
+		}
+		taxons[record.Id] = taxon
+	}
+	if err := ds.InsertTaxon(taxons[records[0].Id], parentId, position); err != nil {
+		return err
+	}
+	for id, taxon := range taxons {
+		ds.TaxonsById[id] = taxon
+	}
+	return nil
+}
+
+func (ds *Dataset) insertCharacterRecords(parentId string, position int, records []*CharacterRecord) error {
+	if len(records) == 0 {
+		return fmt.Errorf("cannot insert an empty list of characters")
+	}
+	characters := make(map[string]*Character, len(records))
+	stateIds := map[string]bool{}
+	for i, record := range records {
+		if _, ok := characters[record.Id]; ok {
+			return fmt.Errorf("cannot insert characters: duplicate id %q", record.Id)
+		}
+		ch := NewCharacter(&Hierarchy{
+			Id:          record.Id,
+			Name:        copyMultilangText(record.Name),
+			Description: record.Description,
+			Pictures:    copyPictures(record.Pictures),
+		})
+		for _, state := range record.States {
+			if stateIds[state.Id] || ds.hasItem(state.Id) {
+				return fmt.Errorf("cannot insert character %q: state id %q already in use", record.Id, state.Id)
+			}
+			stateIds[state.Id] = true
+			ch.States = append(ch.States, copyState(state))
+		}
+		if i > 0 {
+			parent, ok := characters[record.ParentId]
+			if !ok {
+				return fmt.Errorf("cannot insert character %q: parent %q must be listed before it", record.Id, record.ParentId)
+			}
+			parent.Children = append(parent.Children, ch.Hierarchy)
+		}
+		characters[record.Id] = ch
+	}
+	if err := ds.InsertCharacter(characters[records[0].Id], parentId, position); err != nil {
+		return err
+	}
+	for id, ch := range characters {
+		ds.CharactersById[id] = ch
+	}
+	for _, record := range records {
+		err := ds.SetCharacterDependencies(record.Id, record.RequiredStateIds, record.InapplicableStateIds, record.InherentStateId)
+		if err != nil {
+			ds.DeleteCharacter(records[0].Id)
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreStateRefs returns the commands putting back every reference to the
+// given states from taxons and character dependencies.
+func (ds *Dataset) restoreStateRefs(removed map[string]bool) []*Command {
+	hasRemoved := func(states []*State) bool {
+		for _, state := range states {
+			if removed[state.Id] {
+				return true
+			}
+		}
+		return false
+	}
+	commands := []*Command{}
+	taxonIds := make([]string, 0)
+	for id, taxon := range ds.TaxonsById {
+		if hasRemoved(taxon.States) {
+			taxonIds = append(taxonIds, id)
+		}
+	}
+	sort.Strings(taxonIds)
+	for _, id := range taxonIds {
+		commands = append(commands, &Command{Op: OpSetTaxonStates, Id: id, StateIds: stateIdsOf(ds.TaxonsById[id].States)})
+	}
+	characterIds := make([]string, 0)
+	for id, ch := range ds.CharactersById {
+		if hasRemoved(ch.RequiredStates) || hasRemoved(ch.InapplicableStates) || (ch.InherentState != nil && removed[ch.InherentState.Id]) {
+			characterIds = append(characterIds, id)
+		}
+	}
+	sort.Strings(characterIds)
+	for _, id := range characterIds {
+		commands = append(commands, ds.dependenciesCommand(ds.CharactersById[id]))
+	}
+	return commands
+}
+
+func (ds *Dataset) dependenciesCommand(ch *Character) *Command {
+	cmd := &Command{
+		Op:                   OpSetDependencies,
+		Id:                   ch.Id,
+		RequiredStateIds:     stateIdsOf(ch.RequiredStates),
+		InapplicableStateIds: stateIdsOf(ch.InapplicableStates),
+	}
+	if ch.InherentState != nil {
+		cmd.InherentStateId = ch.InherentState.Id
+	}
+	return cmd
+}
+
+func undoable(undo *Command, err error) (*Command, error) {
+	if err != nil {
+		return nil, err
+	}
+	return undo, nil
+}
+
+// Apply performs the command on the dataset and returns the command that
+// reverts it. Identifiers generated while applying the command, for new
+// pictures or states, are written back into cmd so that it can be replayed
+// identically on another copy of the dataset.
+func (ds *Dataset) Apply(cmd *Command) (*Command, error) {
+	switch cmd.Op {
+	case OpBatch:
+		inverses := make([]*Command, 0, len(cmd.Commands))
+		for _, sub := range cmd.Commands {
+			inverse, err := ds.Apply(sub)
+			if err != nil {
+				for i := len(inverses) - 1; i >= 0; i-- {
+					ds.Apply(inverses[i])
+				}
+				return nil, err
+			}
+			inverses = append(inverses, inverse)
+		}
+		undo := &Command{Op: OpBatch, Commands: make([]*Command, len(inverses))}
+		for i, inverse := range inverses {
+			undo.Commands[len(inverses)-1-i] = inverse
+		}
+		return undo, nil
+	case OpRename:
+		name, err := ds.nameOf(cmd.Id)
+		if err != nil {
+			return nil, err
+		}
+		undo := &Command{Op: OpRename, Id: cmd.Id, Text: name.Scientific}
+		name.Scientific = cmd.Text
+		return undo, nil
+	case OpSetTranslation:
+		name, err := ds.nameOf(cmd.Id)
+		if err != nil {
+			return nil, err
+		}
+		undo := &Command{Op: OpSetTranslation, Id: cmd.Id, Lang: cmd.Lang, Text: name.NamesByLangRef[cmd.Lang]}
+		return undoable(undo, ds.SetTranslation(cmd.Id, cmd.Lang, cmd.Text))
+	case OpSetDescription:
+		var old string
+		if taxon, ok := ds.TaxonsById[cmd.Id]; ok {
+			old = taxon.Description
+		} else if ch, ok := ds.CharactersById[cmd.Id]; ok {
+			old = ch.Description
+		} else if _, state := ds.FindState(cmd.Id); state != nil {
+			old = state.Description
+		}
+		if err := ds.SetDescription(cmd.Id, cmd.Text); err != nil {
+			return nil, err
+		}
+		return &Command{Op: OpSetDescription, Id: cmd.Id, Text: old}, nil
+	case OpSetAuthor:
+		taxon, ok := ds.TaxonsById[cmd.Id]
+		if !ok {
+			return nil, ds.SetAuthor(cmd.Id, cmd.Text)
+		}
+		undo := &Command{Op: OpSetAuthor, Id: cmd.Id, Text: taxon.Author}
+		return undoable(undo, ds.SetAuthor(cmd.Id, cmd.Text))
+	case OpSetRank:
+		taxon, ok := ds.TaxonsById[cmd.Id]
+		if !ok {
+			return nil, ds.SetRank(cmd.Id, cmd.Text)
+		}
+		undo := &Command{Op: OpSetRank, Id: cmd.Id, Text: taxon.Rank}
+		return undoable(undo, ds.SetRank(cmd.Id, cmd.Text))
+	case OpSetSynonyms:
+		taxon, ok := ds.TaxonsById[cmd.Id]
+		if !ok {
+			return nil, ds.SetSynonyms(cmd.Id, cmd.Synonyms)
+		}
+		undo := &Command{Op: OpSetSynonyms, Id: cmd.Id, Synonyms: copySynonyms(taxon.Synonyms)}
+		return undoable(undo, ds.SetSynonyms(cmd.Id, cmd.Synonyms))
+	case OpSetExtraInfo:
+		taxon, ok := ds.TaxonsById[cmd.Id]
+		if !ok {
+			return nil, ds.SetExtraInfo(cmd.Id, cmd.Key, cmd.Value)
+		}
+		undo := &Command{Op: OpSetExtraInfo, Id: cmd.Id, Key: cmd.Key, Value: taxon.ExtraInfo[cmd.Key]}
+		return undoable(undo, ds.SetExtraInfo(cmd.Id, cmd.Key, cmd.Value))
+	case OpSetColor:
+		_, state := ds.FindState(cmd.Id)
+		if state == nil {
+			return nil, ds.SetStateColor(cmd.Id, cmd.Text)
+		}
+		undo := &Command{Op: OpSetColor, Id: cmd.Id, Text: state.Color}
+		return undoable(undo, ds.SetStateColor(cmd.Id, cmd.Text))
+	case OpAddBook:
+		if cmd.Book == nil {
+			return nil, fmt.Errorf("command %q requires a book", cmd.Op)
+		}
+		bookId, err := ds.AddBook(*cmd.Book, cmd.Position)
+		if err != nil {
+			return nil, err
+		}
+		cmd.Book.Id = bookId
+		return &Command{Op: OpRemoveBook, Id: bookId}, nil
+	case OpUpdateBook:
+		if cmd.Book == nil {
+			return nil, fmt.Errorf("command %q requires a book", cmd.Op)
+		}
+		old := ds.FindBook(cmd.Book.Id)
+		if old == nil {
+			return nil, ds.UpdateBook(*cmd.Book)
+		}
+		undo := &Command{Op: OpUpdateBook, Book: &Book{}}
+		*undo.Book = copyBook(*old)
+		return undoable(undo, ds.UpdateBook(*cmd.Book))
+	case OpMoveBook:
+		index := ds.bookIndex(cmd.Id)
+		undo := &Command{Op: OpMoveBook, Id: cmd.Id, Position: index}
+		return undoable(undo, ds.MoveBook(cmd.Id, cmd.Position))
+	case OpRemoveBook:
+		index := ds.bookIndex(cmd.Id)
+		if index < 0 {
+			return nil, ds.RemoveBook(cmd.Id)
+		}
+		book := copyBook(ds.Books[index])
+		undo := &Command{Op: OpBatch, Commands: []*Command{{Op: OpAddBook, Position: index, Book: &book}}}
+		for _, id := range sortedTaxonIds(ds) {
+			for _, ref := range ds.TaxonsById[id].References {
+				if ref.BookId == cmd.Id {
+					undo.Commands = append(undo.Commands, &Command{Op: OpSetReferences, Id: id, References: copyReferences(ds.TaxonsById[id].References)})
+					break
+				}
+			}
+		}
+		return undoable(undo, ds.RemoveBook(cmd.Id))
+	case OpSetReferences:
+		taxon, ok := ds.TaxonsById[cmd.Id]
+		if !ok {
+			return nil, ds.SetReferences(cmd.Id, cmd.References)
+		}
+		undo := &Command{Op: OpSetReferences, Id: cmd.Id, References: copyReferences(taxon.References)}
+		return undoable(undo, ds.SetReferences(cmd.Id, cmd.References))
+	case OpAddPicture:
+		if cmd.Picture == nil {
+			return nil, fmt.Errorf("command %q requires a picture", cmd.Op)
+		}
+		picId, err := ds.AddPicture(cmd.Id, *cmd.Picture, cmd.Position)
+		if err != nil {
+			return nil, err
+		}
+		cmd.Picture.Id = picId
+		return &Command{Op: OpRemovePicture, Id: cmd.Id, Picture: &Picture{Id: picId}}, nil
+	case OpUpdatePicture, OpRemovePicture:
+		if cmd.Picture == nil {
+			return nil, fmt.Errorf("command %q requires a picture", cmd.Op)
+		}
+		pics, err := ds.picturesOf(cmd.Id)
+		if err != nil {
+			return nil, err
+		}
+		for i, pic := range *pics {
+			if pic.Id != cmd.Picture.Id {
+				continue
+			}
+			old := pic
+			if cmd.Op == OpUpdatePicture {
+				(*pics)[i] = *cmd.Picture
+				return &Command{Op: OpUpdatePicture, Id: cmd.Id, Picture: &old}, nil
+			}
+			*pics = append((*pics)[:i], (*pics)[i+1:]...)
+			return &Command{Op: OpAddPicture, Id: cmd.Id, Position: i, Picture: &old}, nil
+		}
+		return nil, fmt.Errorf("item %q has no picture with id %q", cmd.Id, cmd.Picture.Id)
+	case OpInsertTaxons:
+		if err := ds.insertTaxonRecords(cmd.ParentId, cmd.Position, cmd.Taxons); err != nil {
+			return nil, err
+		}
+		return &Command{Op: OpDeleteTaxon, Id: cmd.Taxons[0].Id}, nil
+	case OpDeleteTaxon:
+		parent, index := findParent(ds.TaxonsHierarchy, cmd.Id)
+		if parent == nil {
+			return nil, ds.DeleteTaxon(cmd.Id)
+		}
+		undo := &Command{Op: OpInsertTaxons, ParentId: ds.ParentTaxonId(cmd.Id), Position: index, Taxons: ds.taxonRecords(cmd.Id)}
+		return undoable(undo, ds.DeleteTaxon(cmd.Id))
+	case OpMoveTaxon:
+		parent, index := findParent(ds.TaxonsHierarchy, cmd.Id)
+		if parent == nil {
+			return nil, ds.MoveTaxon(cmd.Id, cmd.ParentId, cmd.Position)
+		}
+		undo := &Command{Op: OpMoveTaxon, Id: cmd.Id, ParentId: ds.ParentTaxonId(cmd.Id), Position: index}
+		return undoable(undo, ds.MoveTaxon(cmd.Id, cmd.ParentId, cmd.Position))
+	case OpInsertCharacters:
+		if err := ds.insertCharacterRecords(cmd.ParentId, cmd.Position, cmd.Characters); err != nil {
+			return nil, err
+		}
+		return &Command{Op: OpDeleteCharacter, Id: cmd.Characters[0].Id}, nil
+	case OpDeleteCharacter:
+		parent, index := findParent(ds.CharactersHierarchy, cmd.Id)
+		if parent == nil {
+			return nil, ds.DeleteCharacter(cmd.Id)
+		}
+		records := ds.characterRecords(cmd.Id)
+		removed := map[string]bool{}
+		for _, record := range records {
+			for _, state := range record.States {
+				removed[state.Id] = true
+			}
+		}
+		undo := &Command{Op: OpBatch, Commands: []*Command{
+			{Op: OpInsertCharacters, ParentId: ds.ParentCharacterId(cmd.Id), Position: index, Characters: records},
+		}}
+		for _, restore := range ds.restoreStateRefs(removed) {
+			if restore.Op == OpSetDependencies && isInSubtree(parent.Children[index], restore.Id) {
+				continue
+			}
+			undo.Commands = append(undo.Commands, restore)
+		}
+		return undoable(undo, ds.DeleteCharacter(cmd.Id))
+	case OpMoveCharacter:
+		parent, index := findParent(ds.CharactersHierarchy, cmd.Id)
+		if parent == nil {
+			return nil, ds.MoveCharacter(cmd.Id, cmd.ParentId, cmd.Position)
+		}
+		undo := &Command{Op: OpMoveCharacter, Id: cmd.Id, ParentId: ds.ParentCharacterId(cmd.Id), Position: index}
+		return undoable(undo, ds.MoveCharacter(cmd.Id, cmd.ParentId, cmd.Position))
+	case OpAddState:
+		if cmd.State == nil {
+			return nil, fmt.Errorf("command %q requires a state", cmd.Op)
+		}
+		stateId, err := ds.AddState(cmd.Id, copyState(*cmd.State), cmd.Position)
+		if err != nil {
+			return nil, err
+		}
+		cmd.State.Id = stateId
+		return &Command{Op: OpRemoveState, Id: stateId}, nil
+	case OpRemoveState:
+		ch, state := ds.FindState(cmd.Id)
+		if state == nil {
+			return nil, ds.RemoveState(cmd.Id)
+		}
+		old := copyState(*state)
+		undo := &Command{Op: OpBatch, Commands: []*Command{
+			{Op: OpAddState, Id: ch.Id, Position: indexOfState(ch, cmd.Id), State: &old},
+		}}
+		undo.Commands = append(undo.Commands, ds.restoreStateRefs(map[string]bool{cmd.Id: true})...)
+		return undoable(undo, ds.RemoveState(cmd.Id))
+	case OpMoveState:
+		ch, state := ds.FindState(cmd.Id)
+		if state == nil {
+			return nil, ds.MoveState(cmd.Id, cmd.Position)
+		}
+		undo := &Command{Op: OpMoveState, Id: cmd.Id, Position: indexOfState(ch, cmd.Id)}
+		return undoable(undo, ds.MoveState(cmd.Id, cmd.Position))
+	case OpSetTaxonState, OpUnsetTaxonState, OpSetTaxonStates:
+		taxon, ok := ds.TaxonsById[cmd.Id]
+		if !ok {
+			return nil, fmt.Errorf("cannot change states: no taxon with id %q", cmd.Id)
+		}
+		undo := &Command{Op: OpSetTaxonStates, Id: cmd.Id, StateIds: stateIdsOf(taxon.States)}
+		var err error
+		switch cmd.Op {
+		case OpSetTaxonState:
+			err = ds.SetTaxonState(cmd.Id, cmd.StateId)
+		case OpUnsetTaxonState:
+			err = ds.UnsetTaxonState(cmd.Id, cmd.StateId)
+		default:
+			err = ds.SetTaxonStates(cmd.Id, cmd.StateIds)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return undo, nil
+	case OpSetDependencies:
+		ch, ok := ds.CharactersById[cmd.Id]
+		if !ok {
+			return nil, fmt.Errorf("cannot set dependencies: no character with id %q", cmd.Id)
+		}
+		undo := ds.dependenciesCommand(ch)
+		if err := ds.SetCharacterDependencies(cmd.Id, cmd.RequiredStateIds, cmd.InapplicableStateIds, cmd.InherentStateId); err != nil {
+			return nil, err
+		}
+		return undo, nil
+	}
+	return nil, fmt.Errorf("unknown command %q", cmd.Op)
+}
+
+func indexOfState(ch *Character, stateId string) int {
+	for i, state := range ch.States {
+		if state.Id == stateId {
+			return i
+		}
+	}
+	return -1
+}
+
+func sortedTaxonIds(ds *Dataset) []string {
+	ids := make([]string, 0, len(ds.TaxonsById))
+	for id := range ds.TaxonsById {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
@@ -0,0 +1,141 @@
+package dataset
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+type cslName struct {
+	Family  string `json:"family,omitempty"`
+	Given   string `json:"given,omitempty"`
+	Literal string `json:"literal,omitempty"`
+}
+
+type cslDate struct {
+	DateParts [][]interface{} `json:"date-parts,omitempty"`
+}
+
+type cslItem struct {
+	Id             string    `json:"id"`
+	Type           string    `json:"type"`
+	Title          string    `json:"title,omitempty"`
+	Author         []cslName `json:"author,omitempty"`
+	Issued         *cslDate  `json:"issued,omitempty"`
+	ContainerTitle string    `json:"container-title,omitempty"`
+	Volume         string    `json:"volume,omitempty"`
+	Issue          string    `json:"issue,omitempty"`
+	Page           string    `json:"page,omitempty"`
+	Publisher      string    `json:"publisher,omitempty"`
+	Doi            string    `json:"DOI,omitempty"`
+	Url            string    `json:"URL,omitempty"`
+}
+
+var cslTypesByKind = map[string]string{
+	"":              "book",
+	BookKindBook:    "book",
+	BookKindArticle: "article-journal",
+}
+
+// WriteCSLJSON writes the books as a CSL-JSON array.
+func WriteCSLJSON(w io.Writer, books []Book) error {
+	items := make([]cslItem, len(books))
+	for i, book := range books {
+		cslType, ok := cslTypesByKind[book.Kind]
+		if !ok {
+			cslType = book.Kind
+		}
+		item := cslItem{
+			Id:             book.Id,
+			Type:           cslType,
+			Title:          book.Title,
+			ContainerTitle: book.Journal,
+			Volume:         book.Volume,
+			Issue:          book.Issue,
+			Page:           book.Pages,
+			Publisher:      book.Publisher,
+			Doi:            book.Doi,
+			Url:            book.Url,
+		}
+		for _, author := range book.Authors {
+			if parts := strings.SplitN(author, ",", 2); len(parts) == 2 {
+				item.Author = append(item.Author, cslName{Family: strings.TrimSpace(parts[0]), Given: strings.TrimSpace(parts[1])})
+			} else {
+				item.Author = append(item.Author, cslName{Literal: author})
+			}
+		}
+		if book.Year != 0 {
+			item.Issued = &cslDate{DateParts: [][]interface{}{{book.Year}}}
+		}
+		items[i] = item
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(items)
+}
+
+func cslYear(date *cslDate) int {
+	if date == nil || len(date.DateParts) == 0 || len(date.DateParts[0]) == 0 {
+		return 0
+	}
+	switch year := date.DateParts[0][0].(type) {
+	case float64:
+		return int(year)
+	case string:
+		return fromDigits(year)
+	}
+	return 0
+}
+
+func fromDigits(s string) int {
+	n := 0
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			break
+		}
+		n = n*10 + int(r-'0')
+	}
+	return n
+}
+
+// ReadCSLJSON reads a CSL-JSON array as books.
+func ReadCSLJSON(r io.Reader) ([]Book, error) {
+	items := []cslItem{}
+	if err := json.NewDecoder(r).Decode(&items); err != nil {
+		return nil, err
+	}
+	books := make([]Book, len(items))
+	for i, item := range items {
+		kind := item.Type
+		for k, cslType := range cslTypesByKind {
+			if k != "" && cslType == item.Type {
+				kind = k
+			}
+		}
+		book := Book{
+			Id:        item.Id,
+			Kind:      kind,
+			Title:     item.Title,
+			Year:      cslYear(item.Issued),
+			Journal:   item.ContainerTitle,
+			Volume:    item.Volume,
+			Issue:     item.Issue,
+			Pages:     item.Page,
+			Publisher: item.Publisher,
+			Doi:       item.Doi,
+			Url:       item.Url,
+		}
+		for _, name := range item.Author {
+			switch {
+			case name.Literal != "":
+				book.Authors = append(book.Authors, name.Literal)
+			case name.Given != "":
+				book.Authors = append(book.Authors, name.Family+", "+name.Given)
+			default:
+				book.Authors = append(book.Authors, name.Family)
+			}
+		}
+		books[i] = book
+	}
+	return books, nil
+}
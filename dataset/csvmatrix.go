@@ -0,0 +1,142 @@
+package dataset
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// csvColumns are the headers of the columns describing taxons rather than
+// characters in a CSV matrix.
+var csvColumns = map[string]bool{"author": true, "rank": true, "parent": true}
+
+// splitCSVStates returns the state names of a matrix cell, separated by
+// semicolons or vertical bars.
+func splitCSVStates(cell string) []string {
+	var names []string
+	for _, name := range strings.FieldsFunc(cell, func(r rune) bool { return r == ';' || r == '|' }) {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// ReadCSVMatrix reads a matrix whose first row names the characters and
+// whose following rows give a taxon name then its states, as comma or tab
+// separated values. Columns headed author, rank and parent describe the
+// taxons instead, parents being given by name. Characters have the states
+// found in their column, and items get generated ids.
+func ReadCSVMatrix(r io.Reader) (*Dataset, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimPrefix(data, []byte("\ufeff"))
+	reader := csv.NewReader(bytes.NewReader(data))
+	firstLine := data
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		firstLine = data[:i]
+	}
+	if bytes.Count(firstLine, []byte("\t")) > bytes.Count(firstLine, []byte(",")) {
+		reader.Comma = '\t'
+	}
+	reader.FieldsPerRecord = -1
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	ds := New("")
+	if len(rows) == 0 {
+		return ds, nil
+	}
+	header := rows[0]
+	columns := make([]*Character, len(header))
+	taxonColumns := map[string]int{}
+	statesByName := map[string]map[string]*State{}
+	stateCount := 0
+	for i := 1; i < len(header); i++ {
+		name := strings.TrimSpace(header[i])
+		if key := strings.ToLower(name); csvColumns[key] {
+			taxonColumns[key] = i
+			continue
+		}
+		ch := NewCharacter(&Hierarchy{Id: fmt.Sprintf("c%d", len(ds.CharactersById)+1), Name: *NewMultilangText(name)})
+		ds.AddCharacterBelow(ch, ds.CharactersHierarchy)
+		columns[i] = ch
+		statesByName[ch.Id] = map[string]*State{}
+	}
+	cell := func(row []string, column string) string {
+		if i, ok := taxonColumns[column]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+	taxonsByName := map[string]*Taxon{}
+	parentNames := map[*Taxon]string{}
+	var taxons []*Taxon
+	for _, row := range rows[1:] {
+		if len(row) == 0 || strings.TrimSpace(row[0]) == "" {
+			continue
+		}
+		name := strings.TrimSpace(row[0])
+		taxon := NewTaxon(&Hierarchy{Id: fmt.Sprintf("t%d", len(taxons)+1), Name: *NewMultilangText(name)})
+		taxon.Author = cell(row, "author")
+		if rank, err := ParseRank(cell(row, "rank")); err == nil {
+			taxon.Rank = rank
+		} else {
+			ds.noteProblem(taxon.Id, "has unknown rank %q", cell(row, "rank"))
+		}
+		if _, ok := taxonsByName[name]; ok {
+			ds.noteProblem(taxon.Id, "has the name %q of another taxon", name)
+		} else {
+			taxonsByName[name] = taxon
+		}
+		parentNames[taxon] = cell(row, "parent")
+		taxons = append(taxons, taxon)
+		for i := 1; i < len(row) && i < len(columns); i++ {
+			ch := columns[i]
+			if ch == nil {
+				continue
+			}
+			for _, stateName := range splitCSVStates(row[i]) {
+				state, ok := statesByName[ch.Id][stateName]
+				if !ok {
+					stateCount++
+					state = &State{Id: fmt.Sprintf("s%d", stateCount), Name: *NewMultilangText(stateName)}
+					ch.States = append(ch.States, *state)
+					statesByName[ch.Id][stateName] = state
+				}
+				taxon.States = append(taxon.States, state)
+			}
+		}
+	}
+	var place func(taxon *Taxon, ancestors map[*Taxon]bool)
+	place = func(taxon *Taxon, ancestors map[*Taxon]bool) {
+		if _, ok := ds.TaxonsById[taxon.Id]; ok {
+			return
+		}
+		parent := ds.TaxonsHierarchy
+		if parentName := parentNames[taxon]; parentName != "" {
+			parentTaxon, ok := taxonsByName[parentName]
+			switch {
+			case !ok:
+				ds.noteProblem(taxon.Id, "has unknown parent %q", parentName)
+			case ancestors[parentTaxon] || parentTaxon == taxon:
+				ds.noteProblem(taxon.Id, "is its own ancestor")
+			default:
+				ancestors[taxon] = true
+				place(parentTaxon, ancestors)
+				parent = parentTaxon.Hierarchy
+			}
+		}
+		ds.AddTaxonBelow(taxon, parent)
+	}
+	for _, taxon := range taxons {
+		place(taxon, map[*Taxon]bool{})
+	}
+	return ds, nil
+}
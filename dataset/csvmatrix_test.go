@@ -0,0 +1,49 @@
+package dataset
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadCSVMatrix(t *testing.T) {
+	ds, err := ReadCSVMatrix(strings.NewReader("taxon,Rank,parent,Leaf shape,Habit\n" +
+		"Quercus robur,species,Quercus,lobed,tree\n" +
+		"Quercus,genus,,,tree\n" +
+		"Quercus ilex,species,Quercus,entire; lobed,tree|shrub\n" +
+		"Fagus,genre,Fagaceae,entire,\n"))
+	if err != nil {
+		t.Logf("Unexpected error: %q.", err.Error())
+		t.FailNow()
+	}
+	if len(ds.TaxonsById) != 4 || len(ds.CharactersById) != 2 {
+		t.Logf("Expected 4 taxons and 2 characters, got %d and %d.", len(ds.TaxonsById), len(ds.CharactersById))
+		t.FailNow()
+	}
+	if parentId := ds.ParentTaxonId("t1"); parentId != "t2" {
+		t.Logf("Expected t1 below t2, got %q.", parentId)
+		t.Fail()
+	}
+	if ds.TaxonsById["t1"].Rank != RankSpecies {
+		t.Logf("Expected t1 to be a species.")
+		t.Fail()
+	}
+	shape := ds.CharactersById["c1"]
+	if len(shape.States) != 2 || shape.States[0].Name.Scientific != "lobed" || shape.States[1].Name.Scientific != "entire" {
+		t.Logf("Wrong states for c1: %v.", shape.States)
+		t.Fail()
+	}
+	if ilex := ds.TaxonsById["t3"]; len(ilex.States) != 4 {
+		t.Logf("Expected t3 to have 4 states, got %v.", ilex.States)
+		t.Fail()
+	}
+	errs := ds.ValidateReferences()
+	if len(errs) != 2 || errs[0].ItemId != "t4" || errs[1].ItemId != "t4" {
+		t.Logf("Expected the rank and the parent of t4 to be reported, got %v.", errs)
+		t.Fail()
+	}
+	tabs, err := ReadCSVMatrix(strings.NewReader("taxon\tcolor\na\tred, dark\n"))
+	if err != nil || len(tabs.CharactersById["c1"].States) != 1 {
+		t.Logf("Expected tab separated values to be read.")
+		t.Fail()
+	}
+}
@@ -0,0 +1,394 @@
+package database
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Roles of users on a dataset, each one allowing what the previous ones do.
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+)
+
+// Roles lists the roles from the least to the most privileged.
+var Roles = []string{RoleViewer, RoleEditor, RoleAdmin}
+
+// AllDatasets stands for every dataset in the roles of users.
+const AllDatasets = "*"
+
+// ErrInvalidAccount is wrapped by the errors of account changes that are
+// not allowed, such as names already in use or passwords too short.
+var ErrInvalidAccount = errors.New("invalid account")
+
+const (
+	minPasswordLength = 8
+	maxPasswordLength = 72
+	maxUserNameLength = 128
+	apiTokenPrefix    = "tx_"
+)
+
+// IsRole tells if a role is one of Roles.
+func IsRole(role string) bool {
+	return roleLevel(role) > 0
+}
+
+func roleLevel(role string) int {
+	for i, r := range Roles {
+		if r == role {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// RoleAllows tells if a role gives the rights of the required one, the
+// empty role giving none.
+func RoleAllows(role, required string) bool {
+	return roleLevel(role) >= roleLevel(required)
+}
+
+// User is an account of the server, with its roles by dataset id, the role
+// on AllDatasets applying to every dataset.
+type User struct {
+	Id      int64
+	Name    string
+	Created time.Time
+	Roles   map[string]string
+	stamp   string
+}
+
+// RoleOn returns the most privileged role of a user on a dataset, empty if
+// the user has none.
+func (u *User) RoleOn(datasetId string) string {
+	role := u.Roles[AllDatasets]
+	if r, ok := u.Roles[datasetId]; ok && roleLevel(r) > roleLevel(role) {
+		role = r
+	}
+	return role
+}
+
+// Stamp changes with the password of the user, so that what it signs is
+// revoked when the password is changed.
+func (u *User) Stamp() string {
+	return u.stamp
+}
+
+// ApiToken is a token letting scripts act on behalf of a user. Only a hash
+// of the token is stored, it is shown once when it is created.
+type ApiToken struct {
+	Id      int64
+	Name    string
+	Created time.Time
+}
+
+func checkUserName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxUserNameLength || strings.ContainsAny(name, ":\r\n") {
+		return "", fmt.Errorf("%w: user names must have between 1 and %d characters and no colons", ErrInvalidAccount, maxUserNameLength)
+	}
+	return name, nil
+}
+
+func hashPassword(password string) (string, error) {
+	// bcrypt ignores what follows the first 72 bytes.
+	if len(password) < minPasswordLength || len(password) > maxPasswordLength {
+		return "", fmt.Errorf("%w: passwords must have between %d and %d characters", ErrInvalidAccount, minPasswordLength, maxPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+func passwordStamp(hash string) string {
+	sum := sha256.Sum256([]byte(hash))
+	return hex.EncodeToString(sum[:8])
+}
+
+func hashApiToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateUser stores a new user without any role.
+func (reg *DatasetRegistry) CreateUser(name, password string) (*User, error) {
+	name, err := checkUserName(name)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	op := NewDatabaseOperation(reg.db)
+	defer op.Close()
+	if len(queryStrings(op, `SELECT name FROM Users WHERE name = ?`, name)) > 0 {
+		return nil, op.fail(fmt.Errorf("%w: the name %q is already used", ErrInvalidAccount, name))
+	}
+	res := op.TryExec(op.TryPrepare(`INSERT INTO Users (name, hash, created) VALUES (?, ?, ?)`), name, hash, time.Now().Unix())
+	if op.HasFailed() {
+		return nil, op.Error()
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, op.fail(err)
+	}
+	return &User{Id: id, Name: name, Created: time.Now().Truncate(time.Second), Roles: map[string]string{}, stamp: passwordStamp(hash)}, nil
+}
+
+func (reg *DatasetRegistry) getUser(cond string, arg interface{}) (*User, string, error) {
+	user := &User{Roles: map[string]string{}}
+	var hash string
+	var created int64
+	err := reg.db.QueryRow(`SELECT id, name, hash, created FROM Users WHERE `+cond, arg).
+		Scan(&user.Id, &user.Name, &hash, &created)
+	if err == sql.ErrNoRows {
+		return nil, "", nil
+	} else if err != nil {
+		return nil, "", err
+	}
+	user.Created, user.stamp = time.Unix(created, 0), passwordStamp(hash)
+	rows, err := reg.db.Query(`SELECT dataset, role FROM UserRoles WHERE user = ?`, user.Id)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var datasetId, role string
+		if err := rows.Scan(&datasetId, &role); err != nil {
+			return nil, "", err
+		}
+		user.Roles[datasetId] = role
+	}
+	return user, hash, rows.Err()
+}
+
+// GetUser returns the user with this id, nil if there is none.
+func (reg *DatasetRegistry) GetUser(id int64) (*User, error) {
+	user, _, err := reg.getUser(`id = ?`, id)
+	return user, err
+}
+
+// GetUserByName returns the user with this name, nil if there is none.
+func (reg *DatasetRegistry) GetUserByName(name string) (*User, error) {
+	user, _, err := reg.getUser(`name = ?`, strings.TrimSpace(name))
+	return user, err
+}
+
+// GetUsers returns every user sorted by name.
+func (reg *DatasetRegistry) GetUsers() ([]*User, error) {
+	rows, err := reg.db.Query(`SELECT id FROM Users ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	users := []*User{}
+	for _, id := range ids {
+		user, err := reg.GetUser(id)
+		if err != nil {
+			return nil, err
+		}
+		if user != nil {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+// unknownUserHash is compared to the passwords given for unknown users, so
+// that they take as long to refuse as wrong passwords.
+var (
+	unknownUserHash     []byte
+	unknownUserHashOnce sync.Once
+)
+
+// Authenticate returns the user with this name and password, nil if there
+// is none.
+func (reg *DatasetRegistry) Authenticate(name, password string) (*User, error) {
+	user, hash, err := reg.getUser(`name = ?`, strings.TrimSpace(name))
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		unknownUserHashOnce.Do(func() {
+			unknownUserHash, _ = bcrypt.GenerateFromPassword([]byte("unknown user"), bcrypt.DefaultCost)
+		})
+		bcrypt.CompareHashAndPassword(unknownUserHash, []byte(password))
+		return nil, nil
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return nil, nil
+	}
+	return user, nil
+}
+
+// SetUserPassword changes the password of a user.
+func (reg *DatasetRegistry) SetUserPassword(id int64, password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	res, err := reg.db.Exec(`UPDATE Users SET hash = ? WHERE id = ?`, hash, id)
+	if err != nil {
+		return err
+	}
+	if count, err := res.RowsAffected(); err != nil || count == 0 {
+		return userNotFound(id, err)
+	}
+	return nil
+}
+
+func userNotFound(id int64, err error) error {
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: there is no user %d", ErrInvalidAccount, id)
+}
+
+// SetUserRole gives a role on a dataset to a user, or removes it when the
+// role is empty.
+func (reg *DatasetRegistry) SetUserRole(id int64, datasetId string, role string) error {
+	if role != "" && !IsRole(role) {
+		return fmt.Errorf("%w: unknown role %q", ErrInvalidAccount, role)
+	}
+	op := NewDatabaseOperation(reg.db)
+	defer op.Close()
+	if queryInt(op, `SELECT COUNT(*) FROM Users WHERE id = ?`, id) == 0 {
+		return op.fail(userNotFound(id, op.Error()))
+	}
+	if role == "" {
+		op.TryExec(op.TryPrepare(`DELETE FROM UserRoles WHERE user = ? AND dataset = ?`), id, datasetId)
+	} else {
+		op.TryExec(op.TryPrepare(`INSERT OR REPLACE INTO UserRoles (user, dataset, role) VALUES (?, ?, ?)`), id, datasetId, role)
+	}
+	return op.Error()
+}
+
+// DeleteUser removes a user with its roles and API tokens.
+func (reg *DatasetRegistry) DeleteUser(id int64) error {
+	op := NewDatabaseOperation(reg.db)
+	defer op.Close()
+	op.TryExec(op.TryPrepare(`DELETE FROM ApiTokens WHERE user = ?`), id)
+	op.TryExec(op.TryPrepare(`DELETE FROM UserRoles WHERE user = ?`), id)
+	res := op.TryExec(op.TryPrepare(`DELETE FROM Users WHERE id = ?`), id)
+	if op.HasFailed() {
+		return op.Error()
+	}
+	if count, err := res.RowsAffected(); err != nil || count == 0 {
+		return op.fail(userNotFound(id, err))
+	}
+	return nil
+}
+
+// CreateApiToken stores a new API token for a user, returning the token
+// itself, which cannot be retrieved afterwards.
+func (reg *DatasetRegistry) CreateApiToken(userId int64, name string) (string, *ApiToken, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	token := apiTokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	now := time.Now().Truncate(time.Second)
+	op := NewDatabaseOperation(reg.db)
+	defer op.Close()
+	if queryInt(op, `SELECT COUNT(*) FROM Users WHERE id = ?`, userId) == 0 {
+		return "", nil, op.fail(userNotFound(userId, op.Error()))
+	}
+	res := op.TryExec(op.TryPrepare(`INSERT INTO ApiTokens (user, name, hash, created) VALUES (?, ?, ?, ?)`),
+		userId, strings.TrimSpace(name), hashApiToken(token), now.Unix())
+	if op.HasFailed() {
+		return "", nil, op.Error()
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return "", nil, op.fail(err)
+	}
+	return token, &ApiToken{Id: id, Name: strings.TrimSpace(name), Created: now}, nil
+}
+
+// GetUserApiTokens returns the API tokens of a user, the last created first.
+func (reg *DatasetRegistry) GetUserApiTokens(userId int64) ([]ApiToken, error) {
+	rows, err := reg.db.Query(`SELECT id, name, created FROM ApiTokens WHERE user = ? ORDER BY created DESC, id DESC`, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tokens := []ApiToken{}
+	for rows.Next() {
+		var token ApiToken
+		var created int64
+		if err := rows.Scan(&token.Id, &token.Name, &created); err != nil {
+			return nil, err
+		}
+		token.Created = time.Unix(created, 0)
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+// DeleteApiToken revokes an API token of a user, returning false if the
+// user has no such token.
+func (reg *DatasetRegistry) DeleteApiToken(userId int64, tokenId int64) (bool, error) {
+	res, err := reg.db.Exec(`DELETE FROM ApiTokens WHERE id = ? AND user = ?`, tokenId, userId)
+	if err != nil {
+		return false, err
+	}
+	count, err := res.RowsAffected()
+	return count > 0, err
+}
+
+// AuthenticateToken returns the user of an API token, nil if the token is
+// unknown.
+func (reg *DatasetRegistry) AuthenticateToken(token string) (*User, error) {
+	if !strings.HasPrefix(token, apiTokenPrefix) {
+		return nil, nil
+	}
+	var userId int64
+	err := reg.db.QueryRow(`SELECT user FROM ApiTokens WHERE hash = ?`, hashApiToken(token)).Scan(&userId)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return reg.GetUser(userId)
+}
+
+// SetDatasetPrivate sets whether a dataset is only shown to the users with
+// a role on it.
+func (reg *DatasetRegistry) SetDatasetPrivate(datasetId string, private bool) error {
+	_, err := reg.db.Exec(`INSERT OR REPLACE INTO DatasetAccess (dataset, private) VALUES (?, ?)`, datasetId, private)
+	return err
+}
+
+// IsDatasetPrivate tells if a dataset is only shown to the users with a
+// role on it, datasets being public unless made private.
+func (reg *DatasetRegistry) IsDatasetPrivate(datasetId string) (bool, error) {
+	var private bool
+	err := reg.db.QueryRow(`SELECT private FROM DatasetAccess WHERE dataset = ?`, datasetId).Scan(&private)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return private, err
+}
@@ -0,0 +1,768 @@
+package database
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"nicolas.galipot.net/taxonomia/dataset"
+)
+
+// ErrInvalidEdit is wrapped by the errors of edits that the dataset does not
+// allow, such as unknown parents or ids already in use.
+var ErrInvalidEdit = errors.New("invalid edit")
+
+// Roots of the hierarchies, as created by dataset.New.
+const (
+	taxonsRootId     = "t0"
+	charactersRootId = "c0"
+)
+
+// queryStrings returns the first column of the rows of a query.
+func queryStrings(op *DatabaseOperation, query string, args ...interface{}) []string {
+	rows := op.TryQuery(op.TryPrepare(query), args...)
+	if op.HasFailed() {
+		return nil
+	}
+	defer rows.Close()
+	values := []string{}
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			op.fail(err)
+			return nil
+		}
+		values = append(values, value)
+	}
+	if err := rows.Err(); err != nil {
+		op.fail(err)
+	}
+	return values
+}
+
+func queryInt(op *DatabaseOperation, query string, args ...interface{}) int {
+	rows := op.TryQuery(op.TryPrepare(query), args...)
+	if op.HasFailed() {
+		return 0
+	}
+	defer rows.Close()
+	var n int
+	if rows.Next() {
+		if err := rows.Scan(&n); err != nil {
+			op.fail(err)
+		}
+	}
+	return n
+}
+
+func itemExists(op *DatabaseOperation, id string) bool {
+	return len(queryStrings(op, `SELECT id FROM Items WHERE id = ?`, id)) > 0
+}
+
+// isItemOf tells if an item other than the root is in a table of taxons or
+// characters.
+func isItemOf(op *DatabaseOperation, table string, rootId string, id string) bool {
+	return id != rootId && len(queryStrings(op, `SELECT item FROM `+table+` WHERE item = ?`, id)) > 0
+}
+
+// newItemId returns the first id made of a prefix and a number above the
+// count of items in a table that is not in use.
+func newItemId(op *DatabaseOperation, prefix string, table string) string {
+	for n := queryInt(op, `SELECT COUNT(*) FROM `+table) + 1; !op.HasFailed(); n++ {
+		if id := fmt.Sprintf("%s%d", prefix, n); !itemExists(op, id) {
+			return id
+		}
+	}
+	return ""
+}
+
+// ensureRoot creates the root of a hierarchy when no dataset was imported.
+func ensureRoot(op *DatabaseOperation, id string, name string, insertKind string) {
+	if itemExists(op, id) {
+		return
+	}
+	op.TryExec(op.TryPrepare(QUERY_INSERT_ITEM), id, 0, name, "")
+	op.TryExec(op.TryPrepare(QUERY_INSERT_HIERARCHIES), id, "", id, id)
+	op.TryExec(op.TryPrepare(insertKind), id)
+}
+
+func checkName(name dataset.MultilangText) error {
+	if strings.TrimSpace(name.Scientific) == "" {
+		return fmt.Errorf("%w: a name is required", ErrInvalidEdit)
+	}
+	return nil
+}
+
+func checkRank(rank string) error {
+	if rank != "" && dataset.RankLevel(rank) < 0 {
+		return fmt.Errorf("%w: unknown rank %q", ErrInvalidEdit, rank)
+	}
+	return nil
+}
+
+func insertItemNames(op *DatabaseOperation, id string, name dataset.MultilangText) {
+	insertName := op.TryPrepare(QUERY_INSERT_NAMES)
+	for lang, text := range name.NamesByLangRef {
+		if text = strings.TrimSpace(text); text != "" {
+			op.TryExec(insertName, id, lang, text)
+		}
+	}
+}
+
+func insertItemPictures(op *DatabaseOperation, id string, pictures []dataset.Picture) {
+	insertPicture := op.TryPrepare(`INSERT INTO ItemPictures (id, item, url, label) VALUES (?,?,?,?)`)
+	picId := queryInt(op, `SELECT IFNULL(MAX(id), 0) FROM ItemPictures`)
+	for _, pic := range pictures {
+		picId++
+		op.TryExec(insertPicture, picId, id, pic.Source, pic.Legend)
+	}
+}
+
+// deleteItems removes items with their names, pictures and place in the
+// hierarchies.
+func deleteItems(op *DatabaseOperation, ids []string) {
+	in := inLen(len(ids))
+	args := strSliceToInterface(ids)
+	for _, table := range []string{"ItemNames", "ItemPictures"} {
+		op.TryExec(op.TryPrepare(`DELETE FROM `+table+` WHERE item IN (`+in+`)`), args...)
+	}
+	op.TryExec(op.TryPrepare(`DELETE FROM Hierarchies WHERE descendant IN (`+in+`)`), args...)
+	op.TryExec(op.TryPrepare(`DELETE FROM Items WHERE id IN (`+in+`)`), args...)
+}
+
+// placeInHierarchy puts an item at a position among its siblings, last when
+// the position is out of range, then numbers the items below the root in
+// depth-first order like the import does.
+func placeInHierarchy(op *DatabaseOperation, rootId string, id string, position int) {
+	rows := op.TryQuery(op.TryPrepare(`SELECT Parent.ancestor, Parent.descendant, Items.ord
+		FROM Hierarchies Parent
+		INNER JOIN Hierarchies Root ON Root.descendant = Parent.descendant
+		INNER JOIN Items ON Items.id = Parent.descendant
+		WHERE Root.ancestor = ? AND Parent.length = 1
+		ORDER BY Items.ord ASC`), rootId)
+	if op.HasFailed() {
+		return
+	}
+	children := map[string][]string{}
+	ords := map[string]int{}
+	var parentId string
+	for rows.Next() {
+		var parent, child string
+		var ord int
+		if err := rows.Scan(&parent, &child, &ord); err != nil {
+			rows.Close()
+			op.fail(err)
+			return
+		}
+		ords[child] = ord
+		if child == id {
+			parentId = parent
+		} else {
+			children[parent] = append(children[parent], child)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		op.fail(err)
+	}
+	rows.Close()
+	siblings := children[parentId]
+	if position < 0 || position > len(siblings) {
+		position = len(siblings)
+	}
+	siblings = append(siblings, "")
+	copy(siblings[position+1:], siblings[position:])
+	siblings[position] = id
+	children[parentId] = siblings
+	updateOrd := op.TryPrepare(`UPDATE Items SET ord = ? WHERE id = ?`)
+	ord := 0
+	var number func(parentId string)
+	number = func(parentId string) {
+		for _, child := range children[parentId] {
+			ord++
+			if ords[child] != ord {
+				op.TryExec(updateOrd, ord, child)
+			}
+			number(child)
+		}
+	}
+	number(rootId)
+}
+
+// moveInHierarchy puts an item and its descendants below a new parent.
+func moveInHierarchy(op *DatabaseOperation, id string, parentId string) {
+	op.TryExec(op.TryPrepare(`DELETE FROM Hierarchies
+		WHERE descendant IN (SELECT descendant FROM Hierarchies WHERE ancestor = ?)
+		AND ancestor NOT IN (SELECT descendant FROM Hierarchies WHERE ancestor = ?)`), id, id)
+	op.TryExec(op.TryPrepare(`INSERT INTO Hierarchies (ancestor, descendant, length)
+		SELECT Above.ancestor, Below.descendant, Above.length + Below.length + 1
+		FROM Hierarchies Above, Hierarchies Below
+		WHERE Above.descendant = ? AND Below.ancestor = ?`), parentId, id)
+}
+
+// Apply performs a command of the dataset edit API on the dataset of the
+// registry, as dataset.Dataset.Apply does on a dataset in memory. The
+// command, with all those of a batch, runs in one transaction and gives the
+// dataset a new version. Ids generated for new items and pictures are
+// written back into cmd.
+func (reg *DatasetRegistry) Apply(cmd *dataset.Command) error {
+	if cmd.Op == dataset.OpBatch && len(cmd.Commands) == 0 {
+		return nil
+	}
+	fields, err := reg.getExtraFieldsById()
+	if err != nil {
+		return err
+	}
+	e := &editor{op: NewDatabaseOperation(reg.db), fullText: reg.hasFullTextIndex(), fields: fields}
+	defer e.op.Close()
+	if err := e.apply(cmd); err != nil {
+		return e.op.fail(err)
+	}
+	updateDatasetVersion(e.op, cmd)
+	return e.op.Error()
+}
+
+// updateDatasetVersion gives the dataset a new version after an edit, made
+// from the version before and the command.
+func updateDatasetVersion(op *DatabaseOperation, cmd *dataset.Command) {
+	if op.HasFailed() {
+		return
+	}
+	encoded, err := json.Marshal(cmd)
+	if err != nil {
+		op.fail(err)
+		return
+	}
+	var id, version string
+	err = op.tx.QueryRow(`SELECT id, version FROM Datasets ORDER BY imported DESC LIMIT 1`).Scan(&id, &version)
+	if err == sql.ErrNoRows {
+		return
+	} else if err != nil {
+		op.fail(err)
+		return
+	}
+	sum := sha256.Sum256(append([]byte(version), encoded...))
+	op.TryExec(op.TryPrepare(`UPDATE Datasets SET version = ? WHERE id = ?`), hex.EncodeToString(sum[:])[:16], id)
+}
+
+// editor applies commands in the transaction of an operation.
+type editor struct {
+	op       *DatabaseOperation
+	fullText bool
+	fields   map[string]*dataset.ExtraField
+}
+
+func (e *editor) apply(cmd *dataset.Command) error {
+	op := e.op
+	switch cmd.Op {
+	case dataset.OpBatch:
+		for _, sub := range cmd.Commands {
+			if err := e.apply(sub); err != nil {
+				return err
+			}
+		}
+	case dataset.OpRename:
+		if err := checkName(dataset.MultilangText{Scientific: cmd.Text}); err != nil {
+			return err
+		}
+		if err := e.requireItem(cmd.Id); err != nil {
+			return err
+		}
+		op.TryExec(op.TryPrepare(`UPDATE Items SET name = ? WHERE id = ?`), cmd.Text, cmd.Id)
+		reindexItems(op, e.fullText, cmd.Id)
+	case dataset.OpSetTranslation:
+		if err := e.requireItem(cmd.Id); err != nil {
+			return err
+		}
+		op.TryExec(op.TryPrepare(`DELETE FROM ItemNames WHERE item = ? AND lang = ?`), cmd.Id, cmd.Lang)
+		insertItemNames(op, cmd.Id, dataset.MultilangText{NamesByLangRef: map[string]string{cmd.Lang: cmd.Text}})
+		reindexItems(op, e.fullText, cmd.Id)
+	case dataset.OpSetDescription:
+		if err := e.requireItem(cmd.Id); err != nil {
+			return err
+		}
+		op.TryExec(op.TryPrepare(`UPDATE Items SET description = ? WHERE id = ?`), cmd.Text, cmd.Id)
+		reindexItems(op, e.fullText, cmd.Id)
+	case dataset.OpSetAuthor:
+		if err := e.requireTaxon(cmd.Id); err != nil {
+			return err
+		}
+		op.TryExec(op.TryPrepare(`UPDATE Taxons SET author = ? WHERE item = ?`), cmd.Text, cmd.Id)
+	case dataset.OpSetRank:
+		if err := checkRank(cmd.Text); err != nil {
+			return err
+		}
+		if err := e.requireTaxon(cmd.Id); err != nil {
+			return err
+		}
+		op.TryExec(op.TryPrepare(`UPDATE Taxons SET rank = ? WHERE item = ?`), cmd.Text, cmd.Id)
+	case dataset.OpSetExtraInfo:
+		if err := e.requireTaxon(cmd.Id); err != nil {
+			return err
+		}
+		return e.setExtraValue(cmd.Id, cmd.Key, cmd.Value)
+	case dataset.OpSetColor:
+		if err := e.requireState(cmd.Id); err != nil {
+			return err
+		}
+		op.TryExec(op.TryPrepare(`UPDATE States SET color = ? WHERE item = ?`), cmd.Text, cmd.Id)
+	case dataset.OpAddPicture, dataset.OpUpdatePicture, dataset.OpRemovePicture:
+		return e.editPicture(cmd)
+	case dataset.OpInsertTaxons:
+		return e.insertTaxons(cmd.ParentId, cmd.Position, cmd.Taxons)
+	case dataset.OpDeleteTaxon:
+		return e.deleteTaxon(cmd.Id)
+	case dataset.OpMoveTaxon:
+		return e.moveItem("Taxons", taxonsRootId, cmd.Id, cmd.ParentId, cmd.Position)
+	case dataset.OpInsertCharacters:
+		return e.insertCharacters(cmd.ParentId, cmd.Position, cmd.Characters)
+	case dataset.OpDeleteCharacter:
+		return e.deleteCharacter(cmd.Id)
+	case dataset.OpMoveCharacter:
+		return e.moveItem("Characters", charactersRootId, cmd.Id, cmd.ParentId, cmd.Position)
+	case dataset.OpAddState:
+		if cmd.State == nil {
+			return fmt.Errorf("%w: command %q requires a state", ErrInvalidEdit, cmd.Op)
+		}
+		if err := e.requireCharacter(cmd.Id); err != nil {
+			return err
+		}
+		if err := insertState(op, cmd.Id, cmd.State, cmd.Position); err != nil {
+			return err
+		}
+		reindexItems(op, e.fullText, cmd.State.Id)
+	case dataset.OpRemoveState:
+		if err := e.requireState(cmd.Id); err != nil {
+			return err
+		}
+		unindexItems(op, e.fullText, []string{cmd.Id})
+		deleteStates(op, []string{cmd.Id})
+	case dataset.OpMoveState:
+		if err := e.requireState(cmd.Id); err != nil {
+			return err
+		}
+		placeState(op, stateCharacter(op, cmd.Id), cmd.Id, cmd.Position)
+	case dataset.OpSetTaxonState, dataset.OpUnsetTaxonState, dataset.OpSetTaxonStates:
+		return e.codeStates(cmd)
+	default:
+		return fmt.Errorf("%w: command %q cannot be applied to the database", ErrInvalidEdit, cmd.Op)
+	}
+	return op.Error()
+}
+
+// requireItem checks that a taxon, character or state has an id.
+func (e *editor) requireItem(id string) error {
+	if id == taxonsRootId || id == charactersRootId || !itemExists(e.op, id) {
+		return fmt.Errorf("%w: no item with id %q", ErrInvalidEdit, id)
+	}
+	return e.op.Error()
+}
+
+func (e *editor) requireTaxon(id string) error {
+	if !isItemOf(e.op, "Taxons", taxonsRootId, id) {
+		return fmt.Errorf("%w: no taxon with id %q", ErrInvalidEdit, id)
+	}
+	return e.op.Error()
+}
+
+func (e *editor) requireCharacter(id string) error {
+	if !isItemOf(e.op, "Characters", charactersRootId, id) {
+		return fmt.Errorf("%w: no character with id %q", ErrInvalidEdit, id)
+	}
+	return e.op.Error()
+}
+
+func (e *editor) requireState(id string) error {
+	if stateCharacter(e.op, id) == "" {
+		return fmt.Errorf("%w: no state with id %q", ErrInvalidEdit, id)
+	}
+	return e.op.Error()
+}
+
+// setExtraValue checks a value against the type of its field and stores
+// it, an empty value removing it.
+func (e *editor) setExtraValue(taxonId string, fieldId string, value interface{}) error {
+	field, ok := e.fields[fieldId]
+	if !ok {
+		return fmt.Errorf("%w: no extra field with id %q", ErrInvalidEdit, fieldId)
+	}
+	if text, ok := value.(string); value == nil || (ok && strings.TrimSpace(text) == "") {
+		e.op.TryExec(e.op.TryPrepare(`DELETE FROM TaxonExtraValues WHERE taxon = ? AND field = ?`), taxonId, fieldId)
+		return e.op.Error()
+	}
+	normalized, err := field.Normalize(value)
+	if err != nil {
+		return &dataset.ExtraValueError{TaxonId: taxonId, FieldId: fieldId, Err: err}
+	}
+	e.op.TryExec(e.op.TryPrepare(`INSERT OR REPLACE INTO TaxonExtraValues (taxon, field, value, number) VALUES (?,?,?,?)`),
+		taxonId, fieldId, dataset.FormatExtraValue(normalized), numberOrNull(normalized))
+	return e.op.Error()
+}
+
+// insertTaxons adds taxons below a parent, the root when parentId is
+// empty, at a position among its siblings. As in dataset.Command, the
+// taxons after the first are placed last below their parent, which must be
+// listed before them. Taxons are given new ids when they have none.
+func (e *editor) insertTaxons(parentId string, position int, records []*dataset.TaxonRecord) error {
+	if len(records) == 0 {
+		return fmt.Errorf("%w: no taxon to insert", ErrInvalidEdit)
+	}
+	ensureRoot(e.op, taxonsRootId, "Taxons", `INSERT INTO Taxons (item, author) VALUES (?, '')`)
+	inserted := map[string]bool{}
+	for i, record := range records {
+		if i > 0 {
+			if !inserted[record.ParentId] {
+				return fmt.Errorf("%w: parent %q of taxon %q must be listed before it", ErrInvalidEdit, record.ParentId, record.Id)
+			}
+			parentId, position = record.ParentId, -1
+		}
+		if err := e.insertTaxon(record, parentId, position); err != nil {
+			return err
+		}
+		inserted[record.Id] = true
+	}
+	return e.op.Error()
+}
+
+func (e *editor) insertTaxon(record *dataset.TaxonRecord, parentId string, position int) error {
+	op := e.op
+	if err := checkName(record.Name); err != nil {
+		return err
+	}
+	if err := checkRank(record.Rank); err != nil {
+		return err
+	}
+	if len(record.Synonyms) > 0 || len(record.References) > 0 {
+		return fmt.Errorf("%w: synonyms and references of taxon %q cannot be inserted in the database", ErrInvalidEdit, record.Id)
+	}
+	if parentId == "" {
+		parentId = taxonsRootId
+	} else if err := e.requireTaxon(parentId); err != nil {
+		return err
+	}
+	if record.Id == "" {
+		record.Id = newItemId(op, "t", "Taxons")
+	} else if itemExists(op, record.Id) {
+		return fmt.Errorf("%w: id %q already in use", ErrInvalidEdit, record.Id)
+	}
+	op.TryExec(op.TryPrepare(QUERY_INSERT_ITEM), record.Id, 0, record.Name.Scientific, record.Description)
+	insertItemNames(op, record.Id, record.Name)
+	insertItemPictures(op, record.Id, record.Pictures)
+	op.TryExec(op.TryPrepare(QUERY_INSERT_HIERARCHIES), record.Id, parentId, record.Id, record.Id)
+	op.TryExec(op.TryPrepare(`INSERT INTO Taxons (item, author, rank) VALUES (?,?,?)`), record.Id, record.Author, record.Rank)
+	placeInHierarchy(op, taxonsRootId, record.Id, position)
+	for _, stateId := range record.StateIds {
+		if err := e.requireState(stateId); err != nil {
+			return err
+		}
+		op.TryExec(op.TryPrepare(`INSERT OR IGNORE INTO TaxonStates (taxon, state) VALUES (?,?)`), record.Id, stateId)
+	}
+	for key, value := range record.ExtraInfo {
+		if err := e.setExtraValue(record.Id, key, value); err != nil {
+			return err
+		}
+	}
+	reindexItems(op, e.fullText, record.Id)
+	return op.Error()
+}
+
+func (e *editor) moveItem(table string, rootId string, id string, parentId string, position int) error {
+	op := e.op
+	if !isItemOf(op, table, rootId, id) {
+		return fmt.Errorf("%w: cannot move %q, not found", ErrInvalidEdit, id)
+	}
+	if parentId == "" {
+		parentId = rootId
+	} else if !isItemOf(op, table, rootId, parentId) {
+		return fmt.Errorf("%w: cannot move %q below %q, not found", ErrInvalidEdit, id, parentId)
+	}
+	if len(queryStrings(op, `SELECT ancestor FROM Hierarchies WHERE ancestor = ? AND descendant = ?`, id, parentId)) > 0 {
+		return fmt.Errorf("%w: cannot move %q below itself", ErrInvalidEdit, id)
+	}
+	moveInHierarchy(op, id, parentId)
+	placeInHierarchy(op, rootId, id, position)
+	return op.Error()
+}
+
+// deleteTaxon removes a taxon with its descendants, their coded states,
+// synonyms, references and extra values.
+func (e *editor) deleteTaxon(id string) error {
+	op := e.op
+	if err := e.requireTaxon(id); err != nil {
+		return err
+	}
+	ids := queryStrings(op, `SELECT descendant FROM Hierarchies WHERE ancestor = ?`, id)
+	unindexItems(op, e.fullText, ids)
+	for _, query := range []string{
+		`DELETE FROM TaxonStates WHERE taxon IN (%s)`,
+		`DELETE FROM TaxonSynonyms WHERE taxon IN (%s)`,
+		`DELETE FROM TaxonReferences WHERE taxon IN (%s)`,
+		`DELETE FROM TaxonExtraValues WHERE taxon IN (%s)`,
+		`DELETE FROM Taxons WHERE item IN (%s)`,
+	} {
+		op.TryExec(op.TryPrepare(fmt.Sprintf(query, inLen(len(ids)))), strSliceToInterface(ids)...)
+	}
+	deleteItems(op, ids)
+	return op.Error()
+}
+
+// insertCharacters adds characters with their states below a parent, the
+// root when parentId is empty, like insertTaxons. Characters and states are
+// given new ids when they have none.
+func (e *editor) insertCharacters(parentId string, position int, records []*dataset.CharacterRecord) error {
+	if len(records) == 0 {
+		return fmt.Errorf("%w: no character to insert", ErrInvalidEdit)
+	}
+	ensureRoot(e.op, charactersRootId, "Characters", `INSERT INTO Characters (item) VALUES (?)`)
+	inserted := map[string]bool{}
+	for i, record := range records {
+		if i > 0 {
+			if !inserted[record.ParentId] {
+				return fmt.Errorf("%w: parent %q of character %q must be listed before it", ErrInvalidEdit, record.ParentId, record.Id)
+			}
+			parentId, position = record.ParentId, -1
+		}
+		if err := e.insertCharacter(record, parentId, position); err != nil {
+			return err
+		}
+		inserted[record.Id] = true
+	}
+	return e.op.Error()
+}
+
+func (e *editor) insertCharacter(record *dataset.CharacterRecord, parentId string, position int) error {
+	op := e.op
+	if err := checkName(record.Name); err != nil {
+		return err
+	}
+	if record.InherentStateId != "" || len(record.RequiredStateIds) > 0 || len(record.InapplicableStateIds) > 0 {
+		return fmt.Errorf("%w: dependencies of character %q cannot be inserted in the database", ErrInvalidEdit, record.Id)
+	}
+	if parentId == "" {
+		parentId = charactersRootId
+	} else if err := e.requireCharacter(parentId); err != nil {
+		return err
+	}
+	if record.Id == "" {
+		record.Id = newItemId(op, "c", "Characters")
+	} else if itemExists(op, record.Id) {
+		return fmt.Errorf("%w: id %q already in use", ErrInvalidEdit, record.Id)
+	}
+	op.TryExec(op.TryPrepare(QUERY_INSERT_ITEM), record.Id, 0, record.Name.Scientific, record.Description)
+	insertItemNames(op, record.Id, record.Name)
+	insertItemPictures(op, record.Id, record.Pictures)
+	op.TryExec(op.TryPrepare(QUERY_INSERT_HIERARCHIES), record.Id, parentId, record.Id, record.Id)
+	op.TryExec(op.TryPrepare(`INSERT INTO Characters (item) VALUES (?)`), record.Id)
+	placeInHierarchy(op, charactersRootId, record.Id, position)
+	ids := []string{record.Id}
+	for i := range record.States {
+		if err := insertState(op, record.Id, &record.States[i], i); err != nil {
+			return err
+		}
+		ids = append(ids, record.States[i].Id)
+	}
+	reindexItems(op, e.fullText, ids...)
+	return op.Error()
+}
+
+// deleteStates removes states and the codings and dependencies using them.
+func deleteStates(op *DatabaseOperation, ids []string) {
+	for _, query := range []string{
+		`DELETE FROM TaxonStates WHERE state IN (%s)`,
+		`DELETE FROM CharacterRequiredStates WHERE state IN (%s)`,
+		`DELETE FROM CharacterInapplicableStates WHERE state IN (%s)`,
+		`DELETE FROM States WHERE item IN (%s)`,
+	} {
+		op.TryExec(op.TryPrepare(fmt.Sprintf(query, inLen(len(ids)))), strSliceToInterface(ids)...)
+	}
+	deleteItems(op, ids)
+}
+
+// deleteCharacter removes a character with its descendants, their states
+// and the codings and dependencies using them.
+func (e *editor) deleteCharacter(id string) error {
+	op := e.op
+	if err := e.requireCharacter(id); err != nil {
+		return err
+	}
+	ids := queryStrings(op, `SELECT descendant FROM Hierarchies WHERE ancestor = ?`, id)
+	stateIds := queryStrings(op, `SELECT item FROM States WHERE character IN (`+inLen(len(ids))+`)`, strSliceToInterface(ids)...)
+	unindexItems(op, e.fullText, append(append([]string{}, ids...), stateIds...))
+	deleteStates(op, stateIds)
+	for _, query := range []string{
+		`DELETE FROM CharacterRequiredStates WHERE character IN (%s)`,
+		`DELETE FROM CharacterInapplicableStates WHERE character IN (%s)`,
+		`DELETE FROM Characters WHERE item IN (%s)`,
+	} {
+		op.TryExec(op.TryPrepare(fmt.Sprintf(query, inLen(len(ids)))), strSliceToInterface(ids)...)
+	}
+	deleteItems(op, ids)
+	return op.Error()
+}
+
+func insertState(op *DatabaseOperation, characterId string, state *dataset.State, position int) error {
+	if err := checkName(state.Name); err != nil {
+		return err
+	}
+	if state.Id == "" {
+		state.Id = newItemId(op, "s", "States")
+	} else if itemExists(op, state.Id) {
+		return fmt.Errorf("%w: id %q already in use", ErrInvalidEdit, state.Id)
+	}
+	op.TryExec(op.TryPrepare(QUERY_INSERT_ITEM), state.Id, 0, state.Name.Scientific, state.Description)
+	insertItemNames(op, state.Id, state.Name)
+	insertItemPictures(op, state.Id, state.Pictures)
+	op.TryExec(op.TryPrepare(`INSERT INTO States (item, character, color) VALUES (?,?,?)`), state.Id, characterId, state.Color)
+	placeState(op, characterId, state.Id, position)
+	return op.Error()
+}
+
+// placeState puts a state at a position among the states of its character,
+// last when the position is out of range.
+func placeState(op *DatabaseOperation, characterId string, id string, position int) {
+	var states []string
+	for _, stateId := range queryStrings(op, `SELECT States.item FROM States
+		INNER JOIN Items ON Items.id = States.item
+		WHERE States.character = ?
+		ORDER BY Items.ord ASC`, characterId) {
+		if stateId != id {
+			states = append(states, stateId)
+		}
+	}
+	if position < 0 || position > len(states) {
+		position = len(states)
+	}
+	states = append(states, "")
+	copy(states[position+1:], states[position:])
+	states[position] = id
+	updateOrd := op.TryPrepare(`UPDATE Items SET ord = ? WHERE id = ?`)
+	for i, stateId := range states {
+		op.TryExec(updateOrd, i, stateId)
+	}
+}
+
+func stateCharacter(op *DatabaseOperation, id string) string {
+	if ids := queryStrings(op, `SELECT character FROM States WHERE item = ?`, id); len(ids) > 0 {
+		return ids[0]
+	}
+	return ""
+}
+
+// GetState returns a state with the character it belongs to, nils if there
+// is no state with this id.
+func (reg *DatasetRegistry) GetState(id string) (*dataset.State, *dataset.Character, error) {
+	var characterId string
+	err := reg.db.QueryRow(`SELECT character FROM States WHERE item = ?`, id).Scan(&characterId)
+	if err == sql.ErrNoRows {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	character, err := reg.GetCharacter(characterId)
+	if err != nil || character == nil {
+		return nil, nil, err
+	}
+	for i := range character.States {
+		if character.States[i].Id == id {
+			return &character.States[i], character, nil
+		}
+	}
+	return nil, nil, nil
+}
+
+func checkPicture(pic dataset.Picture) error {
+	if strings.TrimSpace(pic.Source) == "" {
+		return fmt.Errorf("%w: a picture needs an address", ErrInvalidEdit)
+	}
+	return nil
+}
+
+// editPicture adds a picture to a taxon, character or state, always last,
+// or updates or removes one of its pictures.
+func (e *editor) editPicture(cmd *dataset.Command) error {
+	op := e.op
+	pic := cmd.Picture
+	if pic == nil {
+		return fmt.Errorf("%w: command %q requires a picture", ErrInvalidEdit, cmd.Op)
+	}
+	if cmd.Op != dataset.OpRemovePicture {
+		if err := checkPicture(*pic); err != nil {
+			return err
+		}
+	}
+	if cmd.Op == dataset.OpAddPicture {
+		if err := e.requireItem(cmd.Id); err != nil {
+			return err
+		}
+		insertItemPictures(op, cmd.Id, []dataset.Picture{*pic})
+		pic.Id = fmt.Sprint(queryInt(op, `SELECT MAX(id) FROM ItemPictures`))
+		return op.Error()
+	}
+	var res sql.Result
+	if cmd.Op == dataset.OpUpdatePicture {
+		res = op.TryExec(op.TryPrepare(`UPDATE ItemPictures SET url = ?, label = ? WHERE item = ? AND id = ?`), pic.Source, pic.Legend, cmd.Id, pic.Id)
+	} else {
+		res = op.TryExec(op.TryPrepare(`DELETE FROM ItemPictures WHERE item = ? AND id = ?`), cmd.Id, pic.Id)
+	}
+	if res != nil {
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return fmt.Errorf("%w: item %q has no picture with id %q", ErrInvalidEdit, cmd.Id, pic.Id)
+		}
+	}
+	return op.Error()
+}
+
+// codeStates sets or unsets a state of a taxon, or replaces all of its
+// states, leaving the other codings as they are.
+func (e *editor) codeStates(cmd *dataset.Command) error {
+	op := e.op
+	if err := e.requireTaxon(cmd.Id); err != nil {
+		return err
+	}
+	stateIds := cmd.StateIds
+	if cmd.Op != dataset.OpSetTaxonStates {
+		stateIds = []string{cmd.StateId}
+	}
+	for _, stateId := range stateIds {
+		if err := e.requireState(stateId); err != nil {
+			return err
+		}
+	}
+	switch cmd.Op {
+	case dataset.OpSetTaxonState:
+		op.TryExec(op.TryPrepare(`INSERT OR IGNORE INTO TaxonStates (taxon, state) VALUES (?,?)`), cmd.Id, cmd.StateId)
+	case dataset.OpUnsetTaxonState:
+		op.TryExec(op.TryPrepare(`DELETE FROM TaxonStates WHERE taxon = ? AND state = ?`), cmd.Id, cmd.StateId)
+	default:
+		op.TryExec(op.TryPrepare(`DELETE FROM TaxonStates WHERE taxon = ?`), cmd.Id)
+		setState := op.TryPrepare(`INSERT OR IGNORE INTO TaxonStates (taxon, state) VALUES (?,?)`)
+		for _, stateId := range stateIds {
+			op.TryExec(setState, cmd.Id, stateId)
+		}
+	}
+	return op.Error()
+}
+
+// GetCodedStates returns the states each taxon is coded with.
+func (reg *DatasetRegistry) GetCodedStates() (map[string]map[string]bool, error) {
+	rows, err := reg.db.Query(`SELECT taxon, state FROM TaxonStates`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	coded := map[string]map[string]bool{}
+	for rows.Next() {
+		var taxonId, stateId string
+		if err := rows.Scan(&taxonId, &stateId); err != nil {
+			return nil, err
+		}
+		if coded[taxonId] == nil {
+			coded[taxonId] = map[string]bool{}
+		}
+		coded[taxonId][stateId] = true
+	}
+	return coded, rows.Err()
+}
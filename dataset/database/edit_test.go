@@ -0,0 +1,95 @@
+package database
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"nicolas.galipot.net/taxonomia/dataset"
+)
+
+func datasetVersion(t *testing.T, reg *DatasetRegistry) string {
+	info, err := reg.GetDatasetInfo()
+	if err != nil || info == nil {
+		t.Fatalf("Expected the dataset info, got %+v and %v.", info, err)
+	}
+	return info.Version
+}
+
+func TestApplyChangesTheVersion(t *testing.T) {
+	reg := newDatasetTestRegistry(t, registryFixture)
+	imported := datasetVersion(t, reg)
+	if err := reg.Apply(&dataset.Command{Op: dataset.OpBatch}); err != nil {
+		t.Fatal(err)
+	}
+	if version := datasetVersion(t, reg); version != imported {
+		t.Errorf("Expected an empty batch to keep the version, got %q.", version)
+	}
+	if err := reg.Apply(&dataset.Command{Op: dataset.OpRename, Id: "t1", Text: "Quercus pedunculata"}); err != nil {
+		t.Fatal(err)
+	}
+	edited := datasetVersion(t, reg)
+	if edited == imported || len(edited) != len(imported) {
+		t.Errorf("Expected a new version after %q, got %q.", imported, edited)
+	}
+	if taxon, err := reg.GetTaxon("t1"); err != nil || taxon.Name.Scientific != "Quercus pedunculata" {
+		t.Errorf("Expected t1 to be renamed, got %+v and %v.", taxon, err)
+	}
+	// Refused edits leave the version as it is.
+	if err := reg.Apply(&dataset.Command{Op: dataset.OpRename, Id: "t9", Text: "Quercus"}); !errors.Is(err, ErrInvalidEdit) {
+		t.Errorf("Expected renaming an unknown taxon to be refused, got %v.", err)
+	}
+	if version := datasetVersion(t, reg); version != edited {
+		t.Errorf("Expected the version to stay %q, got %q.", edited, version)
+	}
+}
+
+func TestApplyBatchIsAllOrNothing(t *testing.T) {
+	reg := newDatasetTestRegistry(t, strings.Replace(registryFixture, `"id": "ds",`,
+		`"id": "ds", "extraFields": [ { "id": "height", "label": "Height", "type": "number" } ],`, 1))
+	batch := &dataset.Command{Op: dataset.OpBatch, Commands: []*dataset.Command{
+		{Op: dataset.OpRename, Id: "t1", Text: "Quercus pedunculata"},
+		{Op: dataset.OpSetExtraInfo, Id: "t1", Key: "height", Value: "tall"},
+	}}
+	var valueErr *dataset.ExtraValueError
+	if err := reg.Apply(batch); !errors.As(err, &valueErr) {
+		t.Fatalf("Expected an invalid value, got %v.", err)
+	}
+	if taxon, err := reg.GetTaxon("t1"); err != nil || taxon.Name.Scientific != "Quercus robur" {
+		t.Errorf("Expected t1 to keep its name, got %+v and %v.", taxon, err)
+	}
+	batch.Commands[1].Value = "40"
+	if err := reg.Apply(batch); err != nil {
+		t.Fatal(err)
+	}
+	if values, err := reg.GetTaxonExtraValues("t1"); err != nil || values["height"] != 40.0 {
+		t.Errorf("Expected t1 to be 40 high, got %v and %v.", values, err)
+	}
+}
+
+func TestApplyWritesBackIds(t *testing.T) {
+	reg := newDatasetTestRegistry(t, registryFixture)
+	insert := &dataset.Command{Op: dataset.OpInsertTaxons, ParentId: "g1", Position: 0, Taxons: []*dataset.TaxonRecord{
+		{Name: dataset.MultilangText{Scientific: "Quercus suber"}, StateIds: []string{"s2"}},
+	}}
+	if err := reg.Apply(insert); err != nil {
+		t.Fatal(err)
+	}
+	id := insert.Taxons[0].Id
+	if children, err := reg.GetTaxonChildren("g1"); err != nil || taxonIds(children) != id+",t2,t1" {
+		t.Errorf("Expected %s first below g1, got %q and %v.", id, taxonIds(children), err)
+	}
+	if taxons, err := reg.GetTaxonsHavingStates([]string{"s2"}); err != nil || !strings.Contains(taxonIds(taxons), id) {
+		t.Errorf("Expected %s to be entire, got %q and %v.", id, taxonIds(taxons), err)
+	}
+	addState := &dataset.Command{Op: dataset.OpAddState, Id: "c2", Position: -1, State: &dataset.State{Name: dataset.MultilangText{Scientific: "fissured"}}}
+	if err := reg.Apply(addState); err != nil {
+		t.Fatal(err)
+	}
+	if state, character, err := reg.GetState(addState.State.Id); err != nil || state == nil || character.Id != "c2" {
+		t.Errorf("Expected the new state of c2, got %+v and %v.", state, err)
+	}
+	if err := reg.Apply(&dataset.Command{Op: dataset.OpAddBook, Book: &dataset.Book{Title: "Flora"}}); !errors.Is(err, ErrInvalidEdit) {
+		t.Errorf("Expected books to be refused, got %v.", err)
+	}
+}
@@ -0,0 +1,69 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+
+	"nicolas.galipot.net/taxonomia/dataset"
+)
+
+// ExtraFilter restricts a search to the taxons whose value for an extra
+// field compares to Value with Op, one of =, ~ (contains), <, <=, > and >=.
+type ExtraFilter struct {
+	Field string
+	Op    string
+	Value string
+}
+
+var extraFilterOps = []string{"<=", ">=", "=", "~", "<", ">"}
+
+// ParseExtraFilter reads a filter written like "height>=2" or "color=red".
+func ParseExtraFilter(s string) (ExtraFilter, error) {
+	for i := range s {
+		for _, op := range extraFilterOps {
+			if strings.HasPrefix(s[i:], op) {
+				filter := ExtraFilter{
+					Field: strings.TrimSpace(s[:i]),
+					Op:    op,
+					Value: strings.TrimSpace(s[i+len(op):]),
+				}
+				if filter.Field == "" {
+					return filter, fmt.Errorf("filter %q has no field", s)
+				}
+				return filter, nil
+			}
+		}
+	}
+	return ExtraFilter{}, fmt.Errorf("filter %q has no operator among %s", s, strings.Join(extraFilterOps, " "))
+}
+
+func (f ExtraFilter) String() string {
+	return f.Field + f.Op + f.Value
+}
+
+// sql returns the condition on a TaxonExtraValues row matching the filter,
+// with its arguments. Numbers are compared as numbers, other values as text,
+// which orders dates.
+func (f ExtraFilter) sql(field *dataset.ExtraField) (string, []interface{}, error) {
+	if field == nil {
+		return "", nil, fmt.Errorf("no extra field with id %q", f.Field)
+	}
+	if f.Op == "~" {
+		return `value LIKE ? ESCAPE '\'`, []interface{}{likePattern(f.Value)}, nil
+	}
+	valid := false
+	for _, op := range extraFilterOps {
+		valid = valid || op == f.Op
+	}
+	if !valid {
+		return "", nil, fmt.Errorf("unknown filter operator %q", f.Op)
+	}
+	value, err := field.Normalize(f.Value)
+	if err != nil {
+		return "", nil, fmt.Errorf("cannot filter on %s: %s", f.Field, err.Error())
+	}
+	if n, ok := value.(float64); ok {
+		return "number " + f.Op + " ?", []interface{}{n}, nil
+	}
+	return "value " + f.Op + " ?", []interface{}{value}, nil
+}
@@ -0,0 +1,79 @@
+package database
+
+import (
+	"nicolas.galipot.net/taxonomia/dataset"
+)
+
+// candidatesQuery returns the query selecting the taxons having all the
+// given states, as GetTaxonsHavingStates does, every coded taxon when there
+// are no states.
+func candidatesQuery(states []string) (string, []interface{}) {
+	if len(states) == 0 {
+		return `SELECT DISTINCT TaxonStates.taxon FROM TaxonStates`, nil
+	}
+	args := strSliceToInterface(states)
+	return `SELECT TaxonStates.taxon FROM TaxonStates
+		WHERE TaxonStates.state IN (` + inLen(len(states)) + `)
+		GROUP BY TaxonStates.taxon
+		HAVING COUNT(DISTINCT TaxonStates.state) = (
+			SELECT COUNT(DISTINCT States.item) FROM States WHERE States.item IN (` + inLen(len(states)) + `)
+		)`, append(args, args...)
+}
+
+// GetCandidateTaxons returns the taxons having all the given states. When a
+// rank is given the taxons are aggregated to their closest ancestor, or
+// themselves, of this rank.
+func (reg *DatasetRegistry) GetCandidateTaxons(states []string, rank string) ([]*dataset.Taxon, error) {
+	candidates, args := candidatesQuery(states)
+	if rank == "" {
+		return reg.getRelatedTaxons(`WITH Found AS (`+candidates+`)
+			SELECT Taxon.id, Taxon.name, Taxons.author, Taxons.rank
+			FROM Found
+			INNER JOIN Items Taxon ON Taxon.id = Found.taxon
+			INNER JOIN Taxons ON Taxons.item = Taxon.id
+			ORDER BY Taxon.ord ASC`, args...)
+	}
+	return reg.getRelatedTaxons(`WITH Found AS (`+candidates+`)
+		SELECT Taxon.id, Taxon.name, Taxons.author, Taxons.rank
+		FROM Found
+		INNER JOIN Hierarchies ON Hierarchies.descendant = Found.taxon
+		INNER JOIN Taxons ON Taxons.item = Hierarchies.ancestor AND Taxons.rank = ?
+		INNER JOIN Items Taxon ON Taxon.id = Taxons.item
+		GROUP BY Taxon.id
+		ORDER BY Taxon.ord ASC`, append(args, rank)...)
+}
+
+// GetCandidateStateCounts returns how many of the taxons matching the given
+// states have each state of the other characters, by character and state id,
+// and the number of matching taxons.
+func (reg *DatasetRegistry) GetCandidateStateCounts(states []string, excludedCharacterIds []string) (map[string]map[string]int, int, error) {
+	candidates, args := candidatesQuery(states)
+	var total int
+	if err := reg.db.QueryRow(`WITH Found AS (`+candidates+`) SELECT COUNT(*) FROM Found`, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := reg.db.Query(`WITH Found AS (`+candidates+`)
+		SELECT States.character, TaxonStates.state, COUNT(*)
+		FROM Found
+		INNER JOIN TaxonStates ON TaxonStates.taxon = Found.taxon
+		INNER JOIN States ON States.item = TaxonStates.state
+		WHERE States.character NOT IN (`+inLen(len(excludedCharacterIds))+`)
+		GROUP BY States.character, TaxonStates.state`, append(args, strSliceToInterface(excludedCharacterIds)...)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	counts := map[string]map[string]int{}
+	for rows.Next() {
+		var characterId, stateId string
+		var count int
+		if err := rows.Scan(&characterId, &stateId, &count); err != nil {
+			return nil, 0, err
+		}
+		if counts[characterId] == nil {
+			counts[characterId] = map[string]int{}
+		}
+		counts[characterId][stateId] = count
+	}
+	return counts, total, rows.Err()
+}
@@ -0,0 +1,58 @@
+package database
+
+import (
+	"testing"
+)
+
+func TestGetCandidateTaxons(t *testing.T) {
+	reg := newDatasetTestRegistry(t, registryFixture)
+	cases := []struct {
+		states []string
+		rank   string
+		want   string
+	}{
+		{nil, "", "t2,t1"},
+		{[]string{"s2"}, "", "t2,t1"},
+		// A taxon must have every state, even of the same character.
+		{[]string{"s1", "s2"}, "", "t1"},
+		{[]string{"s2", "s3"}, "", "t1"},
+		{[]string{"s2", "s2"}, "", "t2,t1"},
+		{[]string{"s4", "s2"}, "genus", "g1"},
+		{[]string{"s1"}, "family", "f1"},
+		{[]string{"s1", "s3", "s4", "s2"}, "", "t1"},
+		{[]string{"s3", "s4", "s1"}, "species", ""},
+	}
+	for _, c := range cases {
+		taxons, err := reg.GetCandidateTaxons(c.states, c.rank)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := taxonIds(taxons); got != c.want {
+			t.Errorf("GetCandidateTaxons(%q, %q): expected %q, got %q.", c.states, c.rank, c.want, got)
+		}
+	}
+}
+
+func TestGetCandidateStateCounts(t *testing.T) {
+	reg := newDatasetTestRegistry(t, registryFixture)
+	counts, total, err := reg.GetCandidateStateCounts([]string{"s2"}, []string{"c2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2 {
+		t.Errorf("Expected 2 candidates, got %d.", total)
+	}
+	if counts["c1"]["s1"] != 1 || counts["c1"]["s2"] != 2 || counts["c3"]["s4"] != 1 {
+		t.Errorf("Expected the states of the candidates to be counted, got %v.", counts)
+	}
+	if _, ok := counts["c2"]; ok {
+		t.Errorf("Expected the excluded character to be left out, got %v.", counts["c2"])
+	}
+	counts, total, err = reg.GetCandidateStateCounts([]string{"s1", "s2"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 1 || counts["c1"]["s2"] != 1 || counts["c2"]["s3"] != 1 {
+		t.Errorf("Expected only t1 to be counted, got %d and %v.", total, counts)
+	}
+}
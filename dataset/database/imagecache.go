@@ -0,0 +1,271 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheOptions tune how CacheImages downloads pictures, zero values being
+// replaced by the defaults.
+type CacheOptions struct {
+	// Workers is how many pictures are downloaded at once, 4 by default.
+	Workers int
+	// Timeout limits each request, 30 seconds by default.
+	Timeout time.Duration
+	// Retries is how many times a failed download is tried again, the
+	// server being unavailable or the connection lost. 2 by default, -1
+	// for none.
+	Retries int
+	// RetryDelay is the wait before the first retry, doubling at each
+	// retry, 1 second by default.
+	RetryDelay time.Duration
+	// BatchSize is how many pictures are committed at once, 20 by default.
+	BatchSize int
+	// MaxSize is the size of the largest picture cached, 20 MB by default.
+	MaxSize int64
+	// Refresh downloads again the pictures already cached.
+	Refresh bool
+	// Client makes the requests, http.DefaultClient by default.
+	Client *http.Client
+	// Progress is told how many of the pictures to download are done.
+	Progress func(done int, total int)
+}
+
+func (opts *CacheOptions) setDefaults() {
+	if opts.Workers < 1 {
+		opts.Workers = 4
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 30 * time.Second
+	}
+	if opts.Retries == 0 {
+		opts.Retries = 2
+	} else if opts.Retries < 0 {
+		opts.Retries = 0
+	}
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = time.Second
+	}
+	if opts.BatchSize < 1 {
+		opts.BatchSize = 20
+	}
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = 20 << 20
+	}
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	if opts.Progress == nil {
+		opts.Progress = func(int, int) {}
+	}
+}
+
+// CacheFailure is a picture that could not be cached.
+type CacheFailure struct {
+	URL string
+	Err error
+}
+
+// CacheReport tells what CacheImages did with the pictures of the dataset:
+// Skipped were already cached, Cached were downloaded, and Failures could
+// not be.
+type CacheReport struct {
+	Total    int
+	Skipped  int
+	Cached   int
+	Failures []CacheFailure
+}
+
+var (
+	errNotAnImage = errors.New("not an image")
+	errTooLarge   = errors.New("picture too large")
+)
+
+// downloadError is a failed download, which may succeed when retried.
+type downloadError struct {
+	err       error
+	retryable bool
+}
+
+func (e *downloadError) Error() string {
+	return e.err.Error()
+}
+
+func (e *downloadError) Unwrap() error {
+	return e.err
+}
+
+// isImage tells if downloaded content is a picture, from its first bytes or
+// else from its content type for SVG files, which are not sniffed.
+func isImage(data []byte, contentType string) bool {
+	return strings.HasPrefix(http.DetectContentType(data), "image/") ||
+		strings.HasPrefix(contentType, "image/svg+xml")
+}
+
+// downloadImage requests a picture once, with the timeout of opts.
+func downloadImage(ctx context.Context, url string, opts *CacheOptions) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, &downloadError{err: err}
+	}
+	res, err := opts.Client.Do(req)
+	if err != nil {
+		return nil, &downloadError{err: err, retryable: true}
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		retryable := res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests
+		return nil, &downloadError{err: fmt.Errorf("HTTP status %s", res.Status), retryable: retryable}
+	}
+	data, err := io.ReadAll(io.LimitReader(res.Body, opts.MaxSize+1))
+	if err != nil {
+		return nil, &downloadError{err: err, retryable: true}
+	}
+	if int64(len(data)) > opts.MaxSize {
+		return nil, &downloadError{err: errTooLarge}
+	}
+	if !isImage(data, res.Header.Get("Content-Type")) {
+		return nil, &downloadError{err: errNotAnImage}
+	}
+	return data, nil
+}
+
+// fetchImage downloads a picture, retrying with a growing delay while it
+// may succeed.
+func fetchImage(ctx context.Context, url string, opts *CacheOptions) ([]byte, error) {
+	delay := opts.RetryDelay
+	for retry := 0; ; retry++ {
+		data, err := downloadImage(ctx, url, opts)
+		var downloadErr *downloadError
+		if err == nil || retry == opts.Retries || !errors.As(err, &downloadErr) || !downloadErr.retryable {
+			return data, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+type cachedPicture struct {
+	url  string
+	data []byte
+	err  error
+}
+
+// picturesToCache returns the URLs of the pictures of the dataset, and
+// those not cached yet unless all are refreshed.
+func (reg *DatasetRegistry) picturesToCache(refresh bool) (all []string, missing []string, err error) {
+	rows, err := reg.db.Query(`SELECT DISTINCT Pic.url, Cache.src IS NOT NULL
+		FROM ItemPictures Pic LEFT JOIN PictureCache Cache ON Cache.src = Pic.url
+		WHERE Pic.url <> '' ORDER BY Pic.url`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var url string
+		var cached bool
+		if err := rows.Scan(&url, &cached); err != nil {
+			return nil, nil, err
+		}
+		all = append(all, url)
+		if refresh || !cached {
+			missing = append(missing, url)
+		}
+	}
+	return all, missing, rows.Err()
+}
+
+// storePictures commits downloaded pictures to the cache.
+func (reg *DatasetRegistry) storePictures(pictures []cachedPicture) error {
+	op := NewDatabaseOperation(reg.db)
+	defer op.Close()
+	insertCache := op.TryPrepare(`INSERT OR REPLACE INTO PictureCache (src, data) VALUES (?,?)`)
+	for _, pic := range pictures {
+		op.TryExec(insertCache, pic.url, pic.data)
+	}
+	return op.Error()
+}
+
+// CacheImages downloads the pictures of the dataset into the cache served
+// by CachedImageHandler. Pictures already cached are skipped, so that a
+// run stopped by ctx or by failed downloads is resumed by the next one.
+// Downloaded pictures are committed in batches as they arrive, and those
+// that fail are listed in the report rather than stopping the others.
+func (reg *DatasetRegistry) CacheImages(ctx context.Context, opts CacheOptions) (*CacheReport, error) {
+	opts.setDefaults()
+	all, missing, err := reg.picturesToCache(opts.Refresh)
+	if err != nil {
+		return nil, err
+	}
+	report := &CacheReport{Total: len(all), Skipped: len(all) - len(missing), Failures: []CacheFailure{}}
+	opts.Progress(0, len(missing))
+	urls := make(chan string)
+	results := make(chan cachedPicture)
+	go func() {
+		defer close(urls)
+		for _, url := range missing {
+			select {
+			case urls <- url:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for url := range urls {
+				data, err := fetchImage(ctx, url, &opts)
+				results <- cachedPicture{url: url, data: data, err: err}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	var batch []cachedPicture
+	var storeErr error
+	flush := func() {
+		if len(batch) > 0 && storeErr == nil {
+			if storeErr = reg.storePictures(batch); storeErr == nil {
+				report.Cached += len(batch)
+			}
+		}
+		batch = batch[:0]
+	}
+	done := 0
+	for pic := range results {
+		done++
+		if pic.err != nil {
+			if ctx.Err() == nil {
+				report.Failures = append(report.Failures, CacheFailure{URL: pic.url, Err: pic.err})
+			}
+		} else {
+			batch = append(batch, pic)
+			if len(batch) >= opts.BatchSize {
+				flush()
+			}
+		}
+		opts.Progress(done, len(missing))
+	}
+	flush()
+	if storeErr != nil {
+		return report, storeErr
+	}
+	return report, ctx.Err()
+}
@@ -0,0 +1,157 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// pngHeader is enough of a PNG file to be sniffed as one.
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func newImageTestRegistry(t *testing.T, urls ...string) *DatasetRegistry {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "db.sq3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := CreateTables(db); err != nil {
+		t.Fatal(err)
+	}
+	for i, url := range urls {
+		_, err := db.Exec(`INSERT INTO ItemPictures (id, item, url, label) VALUES (?, ?, ?, '')`, i, "t1", url)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewRegistry(db)
+}
+
+type pictureServer struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	requests map[string]int
+}
+
+func newPictureServer(t *testing.T) *pictureServer {
+	s := &pictureServer{requests: map[string]int{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mutex.Lock()
+		s.requests[r.URL.Path]++
+		count := s.requests[r.URL.Path]
+		s.mutex.Unlock()
+		switch r.URL.Path {
+		case "/leaf.png":
+			w.Write(pngHeader)
+		case "/flower.svg":
+			w.Header().Set("Content-Type", "image/svg+xml")
+			w.Write([]byte(`<svg xmlns="http://www.w3.org/2000/svg"/>`))
+		case "/flaky.png":
+			if count == 1 {
+				http.Error(w, "try later", http.StatusServiceUnavailable)
+				return
+			}
+			w.Write(pngHeader)
+		case "/page.png":
+			w.Write([]byte("<html><body>Not found</body></html>"))
+		case "/slow.png":
+			time.Sleep(200 * time.Millisecond)
+			w.Write(pngHeader)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *pictureServer) requestCount(path string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.requests[path]
+}
+
+func TestCacheImages(t *testing.T) {
+	server := newPictureServer(t)
+	paths := []string{"/leaf.png", "/flower.svg", "/flaky.png", "/missing.png", "/page.png", "/slow.png"}
+	var urls []string
+	for _, path := range paths {
+		urls = append(urls, server.URL+path)
+	}
+	// A picture shown for several items is downloaded once.
+	reg := newImageTestRegistry(t, append(urls, server.URL+"/leaf.png")...)
+	var progress []int
+	opts := CacheOptions{
+		Workers:    3,
+		Timeout:    50 * time.Millisecond,
+		Retries:    1,
+		RetryDelay: time.Millisecond,
+		BatchSize:  2,
+		Client:     server.Client(),
+		Progress:   func(done int, total int) { progress = append(progress, done) },
+	}
+	report, err := reg.CacheImages(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Total != 6 || report.Skipped != 0 || report.Cached != 3 || len(report.Failures) != 3 {
+		t.Errorf("Expected 3 of 6 pictures cached and 3 failures, got %+v.", report)
+	}
+	failed := map[string]error{}
+	for _, failure := range report.Failures {
+		failed[failure.URL] = failure.Err
+	}
+	if err := failed[server.URL+"/page.png"]; !errors.Is(err, errNotAnImage) {
+		t.Errorf("Expected an HTML page not to be cached as a picture, got %v.", err)
+	}
+	for _, path := range []string{"/missing.png", "/slow.png"} {
+		if failed[server.URL+path] == nil {
+			t.Errorf("Expected %s to fail.", path)
+		}
+	}
+	if count := server.requestCount("/missing.png"); count != 1 {
+		t.Errorf("Expected a missing picture to be requested once, got %d requests.", count)
+	}
+	if count := server.requestCount("/slow.png"); count != 2 {
+		t.Errorf("Expected a timed out picture to be retried once, got %d requests.", count)
+	}
+	if len(progress) != 7 || progress[6] != 6 {
+		t.Errorf("Expected progress from 0 to 6, got %v.", progress)
+	}
+	for _, path := range []string{"/leaf.png", "/flower.svg", "/flaky.png"} {
+		if _, ok := reg.GetCachedImage(server.URL + path); !ok {
+			t.Errorf("Expected %s to be cached.", path)
+		}
+	}
+	if _, ok := reg.GetCachedImage(server.URL + "/missing.png"); ok {
+		t.Error("Expected the 404 page not to be cached.")
+	}
+
+	opts.Progress = nil
+	report, err = reg.CacheImages(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Skipped != 3 || report.Cached != 0 || len(report.Failures) != 3 {
+		t.Errorf("Expected the cached pictures to be skipped, got %+v.", report)
+	}
+	if count := server.requestCount("/leaf.png"); count != 1 {
+		t.Errorf("Expected a cached picture not to be requested again, got %d requests.", count)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	opts.Refresh = true
+	report, err = reg.CacheImages(ctx, opts)
+	if err != context.Canceled || report.Cached != 0 || len(report.Failures) != 0 {
+		t.Errorf("Expected a cancelled run to stop, got %v and %+v.", err, report)
+	}
+}
@@ -0,0 +1,264 @@
+package database
+
+import (
+	"database/sql"
+	"sort"
+	"time"
+
+	"nicolas.galipot.net/taxonomia/dataset"
+)
+
+// Statuses of dataset imports.
+const (
+	ImportPending = "pending"
+	ImportRunning = "running"
+	ImportDone    = "done"
+	ImportFailed  = "failed"
+)
+
+// DatasetImport is a dataset file uploaded to replace the dataset of the
+// server, waiting to be started or imported already. Once started, it is
+// imported by the job JobId, which gives its status, Step, Done and Total.
+type DatasetImport struct {
+	Id        int64     `json:"id"`
+	DatasetId string    `json:"dataset"`
+	Format    string    `json:"format"`
+	Filename  string    `json:"filename"`
+	UserId    int64     `json:"-"`
+	JobId     int64     `json:"job,omitempty"`
+	Status    string    `json:"status"`
+	Step      string    `json:"step,omitempty"`
+	Done      int       `json:"done"`
+	Total     int       `json:"total"`
+	Error     string    `json:"error,omitempty"`
+	Created   time.Time `json:"created"`
+	Updated   time.Time `json:"updated"`
+}
+
+// Finished tells if the import ended, whether it succeeded or not.
+func (imp *DatasetImport) Finished() bool {
+	return imp.Status == ImportDone || imp.Status == ImportFailed
+}
+
+// selectDatasetImports selects imports with the status of their job, a
+// queued or retried job still importing.
+const selectDatasetImports = `SELECT Imp.id, Imp.dataset, Imp.format, Imp.filename, Imp.user, Imp.job,
+		CASE WHEN Job.id IS NULL THEN Imp.status
+			WHEN Job.status IN ('` + JobPending + `', '` + JobRunning + `') THEN '` + ImportRunning + `'
+			WHEN Job.status = '` + JobDone + `' THEN '` + ImportDone + `'
+			ELSE '` + ImportFailed + `' END,
+		IFNULL(Job.step, ''), IFNULL(Job.done, 0), IFNULL(Job.total, 0), IFNULL(Job.error, Imp.error),
+		Imp.created, MAX(Imp.updated, IFNULL(Job.updated, 0))
+	FROM DatasetImports Imp LEFT JOIN Jobs Job ON Job.id = Imp.job `
+
+func scanDatasetImport(scan func(dest ...interface{}) error) (*DatasetImport, error) {
+	imp := &DatasetImport{}
+	var created, updated int64
+	err := scan(&imp.Id, &imp.DatasetId, &imp.Format, &imp.Filename, &imp.UserId, &imp.JobId, &imp.Status, &imp.Step,
+		&imp.Done, &imp.Total, &imp.Error, &created, &updated)
+	if err != nil {
+		return nil, err
+	}
+	imp.Created, imp.Updated = time.Unix(created, 0), time.Unix(updated, 0)
+	return imp, nil
+}
+
+// CreateDatasetImport stores a pending import with the file to import,
+// setting its id and dates.
+func (reg *DatasetRegistry) CreateDatasetImport(imp *DatasetImport, data []byte) error {
+	now := time.Now().Truncate(time.Second)
+	res, err := reg.db.Exec(`INSERT INTO DatasetImports (dataset, format, filename, user, status, created, updated, data)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, imp.DatasetId, imp.Format, imp.Filename, imp.UserId, ImportPending,
+		now.Unix(), now.Unix(), data)
+	if err != nil {
+		return err
+	}
+	if imp.Id, err = res.LastInsertId(); err != nil {
+		return err
+	}
+	imp.Status, imp.Created, imp.Updated = ImportPending, now, now
+	return nil
+}
+
+// GetDatasetImport returns the import with this id, nil if there is none.
+func (reg *DatasetRegistry) GetDatasetImport(id int64) (*DatasetImport, error) {
+	imp, err := scanDatasetImport(reg.db.QueryRow(selectDatasetImports+`WHERE Imp.id = ?`, id).Scan)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return imp, err
+}
+
+// GetDatasetImports returns the last imports, the most recent first.
+func (reg *DatasetRegistry) GetDatasetImports(limit int) ([]*DatasetImport, error) {
+	rows, err := reg.db.Query(selectDatasetImports+`ORDER BY Imp.id DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	imports := []*DatasetImport{}
+	for rows.Next() {
+		imp, err := scanDatasetImport(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		imports = append(imports, imp)
+	}
+	return imports, rows.Err()
+}
+
+// GetDatasetImportData returns the file of an import that was not imported
+// yet, nil otherwise.
+func (reg *DatasetRegistry) GetDatasetImportData(id int64) ([]byte, error) {
+	var data []byte
+	err := reg.db.QueryRow(`SELECT data FROM DatasetImports WHERE id = ?`, id).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return data, err
+}
+
+// StartDatasetImport marks a pending import as imported by a job,
+// returning false if it was not pending anymore.
+func (reg *DatasetRegistry) StartDatasetImport(id int64, jobId int64) (bool, error) {
+	res, err := reg.db.Exec(`UPDATE DatasetImports SET status = ?, job = ?, updated = ? WHERE id = ? AND status = ?`,
+		ImportRunning, jobId, time.Now().Unix(), id, ImportPending)
+	if err != nil {
+		return false, err
+	}
+	count, err := res.RowsAffected()
+	return count > 0, err
+}
+
+// CancelDatasetImport marks a pending import as failed, returning false if
+// it was not pending anymore.
+func (reg *DatasetRegistry) CancelDatasetImport(id int64) (bool, error) {
+	res, err := reg.db.Exec(`UPDATE DatasetImports SET status = ?, error = ?, updated = ?, data = ? WHERE id = ? AND status = ?`,
+		ImportFailed, "cancelled", time.Now().Unix(), []byte{}, id, ImportPending)
+	if err != nil {
+		return false, err
+	}
+	count, err := res.RowsAffected()
+	return count > 0, err
+}
+
+// ClearDatasetImportData forgets the file of an import once imported, the
+// file of a failed import being kept for its job to be retried.
+func (reg *DatasetRegistry) ClearDatasetImportData(id int64) error {
+	_, err := reg.db.Exec(`UPDATE DatasetImports SET data = ? WHERE id = ?`, []byte{}, id)
+	return err
+}
+
+// ItemChange is an item added, removed or renamed by an import, OldName
+// being its name before a renaming.
+type ItemChange struct {
+	Id      string `json:"id"`
+	Name    string `json:"name"`
+	OldName string `json:"oldName,omitempty"`
+}
+
+// KindPreview tells how an import changes the items of a kind: taxon,
+// character or state.
+type KindPreview struct {
+	Kind    string       `json:"kind"`
+	Before  int          `json:"before"`
+	After   int          `json:"after"`
+	Added   []ItemChange `json:"added"`
+	Removed []ItemChange `json:"removed"`
+	Renamed []ItemChange `json:"renamed"`
+}
+
+// ImportPreview tells what importing a dataset changes, items being matched
+// by id.
+type ImportPreview struct {
+	Kinds []*KindPreview `json:"kinds"`
+}
+
+// Changes tells if the import adds, removes or renames any item.
+func (p *ImportPreview) Changes() bool {
+	for _, kind := range p.Kinds {
+		if len(kind.Added)+len(kind.Removed)+len(kind.Renamed) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func previewKind(kind string, oldNames map[string]string, newNames map[string]string) *KindPreview {
+	preview := &KindPreview{
+		Kind:    kind,
+		Before:  len(oldNames),
+		After:   len(newNames),
+		Added:   []ItemChange{},
+		Removed: []ItemChange{},
+		Renamed: []ItemChange{},
+	}
+	for id, name := range newNames {
+		if oldName, ok := oldNames[id]; !ok {
+			preview.Added = append(preview.Added, ItemChange{Id: id, Name: name})
+		} else if oldName != name {
+			preview.Renamed = append(preview.Renamed, ItemChange{Id: id, Name: name, OldName: oldName})
+		}
+	}
+	for id, name := range oldNames {
+		if _, ok := newNames[id]; !ok {
+			preview.Removed = append(preview.Removed, ItemChange{Id: id, Name: name})
+		}
+	}
+	for _, changes := range [][]ItemChange{preview.Added, preview.Removed, preview.Renamed} {
+		sort.Slice(changes, func(i, j int) bool { return changes[i].Id < changes[j].Id })
+	}
+	return preview
+}
+
+func (reg *DatasetRegistry) storedNames(table string, excludedId string) (map[string]string, error) {
+	rows, err := reg.db.Query(`SELECT Items.id, Items.name FROM `+table+` INNER JOIN Items ON Items.id = `+table+`.item
+		WHERE Items.id <> ?`, excludedId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	names := map[string]string{}
+	for rows.Next() {
+		var id, name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		names[id] = name
+	}
+	return names, rows.Err()
+}
+
+// PreviewDataset compares the taxons, characters and states of a dataset
+// with those stored, before it replaces them.
+func (reg *DatasetRegistry) PreviewDataset(ds *dataset.Dataset) (*ImportPreview, error) {
+	oldTaxons, err := reg.storedNames("Taxons", ds.TaxonsHierarchy.Id)
+	if err != nil {
+		return nil, err
+	}
+	oldCharacters, err := reg.storedNames("Characters", ds.CharactersHierarchy.Id)
+	if err != nil {
+		return nil, err
+	}
+	oldStates, err := reg.storedNames("States", "")
+	if err != nil {
+		return nil, err
+	}
+	newTaxons := make(map[string]string, len(ds.TaxonsById))
+	for id, taxon := range ds.TaxonsById {
+		newTaxons[id] = taxon.Name.Scientific
+	}
+	newCharacters := make(map[string]string, len(ds.CharactersById))
+	newStates := map[string]string{}
+	for id, ch := range ds.CharactersById {
+		newCharacters[id] = ch.Name.Scientific
+		for _, state := range ch.States {
+			newStates[state.Id] = state.Name.Scientific
+		}
+	}
+	return &ImportPreview{Kinds: []*KindPreview{
+		previewKind("taxon", oldTaxons, newTaxons),
+		previewKind("character", oldCharacters, newCharacters),
+		previewKind("state", oldStates, newStates),
+	}}, nil
+}
@@ -0,0 +1,185 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Statuses of jobs.
+const (
+	JobPending   = "pending"
+	JobRunning   = "running"
+	JobDone      = "done"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// Job is a long operation run in the background by the server, such as an
+// import. Params tell its kind what to work on. A pending job waits for a
+// worker until RunAfter, a failed attempt being retried later as long as
+// Attempts is below MaxAttempts.
+type Job struct {
+	Id          int64     `json:"id"`
+	Kind        string    `json:"kind"`
+	Params      string    `json:"params,omitempty"`
+	UserId      int64     `json:"-"`
+	Status      string    `json:"status"`
+	Step        string    `json:"step,omitempty"`
+	Done        int       `json:"done"`
+	Total       int       `json:"total"`
+	Error       string    `json:"error,omitempty"`
+	Attempts    int       `json:"attempts"`
+	MaxAttempts int       `json:"maxAttempts"`
+	RunAfter    time.Time `json:"runAfter"`
+	Created     time.Time `json:"created"`
+	Updated     time.Time `json:"updated"`
+}
+
+// Finished tells if the job ended, whether it succeeded or not.
+func (job *Job) Finished() bool {
+	return job.Status == JobDone || job.Status == JobFailed || job.Status == JobCancelled
+}
+
+const selectJobs = `SELECT id, kind, params, user, status, step, done, total, error, attempts, max_attempts,
+	run_after, created, updated FROM Jobs `
+
+func scanJob(scan func(dest ...interface{}) error) (*Job, error) {
+	job := &Job{}
+	var runAfter, created, updated int64
+	err := scan(&job.Id, &job.Kind, &job.Params, &job.UserId, &job.Status, &job.Step, &job.Done, &job.Total,
+		&job.Error, &job.Attempts, &job.MaxAttempts, &runAfter, &created, &updated)
+	if err != nil {
+		return nil, err
+	}
+	job.RunAfter, job.Created, job.Updated = time.Unix(runAfter, 0), time.Unix(created, 0), time.Unix(updated, 0)
+	return job, nil
+}
+
+func queryJobs(rows *sql.Rows, err error) ([]*Job, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	jobs := []*Job{}
+	for rows.Next() {
+		job, err := scanJob(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// CreateJob stores a pending job to run at once, setting its id and dates.
+func (reg *DatasetRegistry) CreateJob(job *Job) error {
+	now := time.Now().Truncate(time.Second)
+	if job.MaxAttempts < 1 {
+		job.MaxAttempts = 1
+	}
+	res, err := reg.db.Exec(`INSERT INTO Jobs (kind, params, user, status, max_attempts, run_after, created, updated)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, job.Kind, job.Params, job.UserId, JobPending, job.MaxAttempts,
+		now.Unix(), now.Unix(), now.Unix())
+	if err != nil {
+		return err
+	}
+	if job.Id, err = res.LastInsertId(); err != nil {
+		return err
+	}
+	job.Status, job.RunAfter, job.Created, job.Updated = JobPending, now, now, now
+	return nil
+}
+
+// GetJob returns the job with this id, nil if there is none.
+func (reg *DatasetRegistry) GetJob(id int64) (*Job, error) {
+	job, err := scanJob(reg.db.QueryRow(selectJobs+`WHERE id = ?`, id).Scan)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return job, err
+}
+
+// GetJobs returns the last jobs, the most recent first.
+func (reg *DatasetRegistry) GetJobs(limit int) ([]*Job, error) {
+	return queryJobs(reg.db.Query(selectJobs+`ORDER BY id DESC LIMIT ?`, limit))
+}
+
+// HasActiveJobs tells if a job of a kind is pending or running.
+func (reg *DatasetRegistry) HasActiveJobs(kind string) (bool, error) {
+	var count int
+	err := reg.db.QueryRow(`SELECT COUNT(*) FROM Jobs WHERE kind = ? AND status IN (?, ?)`,
+		kind, JobPending, JobRunning).Scan(&count)
+	return count > 0, err
+}
+
+// NextPendingJob returns the pending job to run first, leaving out some
+// kinds, nil if there is none. It may have to wait until its RunAfter.
+func (reg *DatasetRegistry) NextPendingJob(excludedKinds []string) (*Job, error) {
+	args := append([]interface{}{JobPending}, strSliceToInterface(excludedKinds)...)
+	job, err := scanJob(reg.db.QueryRow(selectJobs+`WHERE status = ? AND kind NOT IN (`+inLen(len(excludedKinds))+`)
+		ORDER BY run_after ASC, id ASC LIMIT 1`, args...).Scan)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return job, err
+}
+
+// StartJob marks a pending job as running, counting an attempt, and
+// returns false if it was not pending anymore.
+func (reg *DatasetRegistry) StartJob(id int64) (bool, error) {
+	res, err := reg.db.Exec(`UPDATE Jobs SET status = ?, attempts = attempts + 1, updated = ? WHERE id = ? AND status = ?`,
+		JobRunning, time.Now().Unix(), id, JobPending)
+	if err != nil {
+		return false, err
+	}
+	count, err := res.RowsAffected()
+	return count > 0, err
+}
+
+// FinishJob stores how far an attempt at a job went and its new status,
+// pending again when it is retried.
+func (reg *DatasetRegistry) FinishJob(job *Job) error {
+	job.Updated = time.Now().Truncate(time.Second)
+	_, err := reg.db.Exec(`UPDATE Jobs SET status = ?, step = ?, done = ?, total = ?, error = ?, run_after = ?, updated = ?
+		WHERE id = ?`, job.Status, job.Step, job.Done, job.Total, job.Error, job.RunAfter.Unix(), job.Updated.Unix(), job.Id)
+	return err
+}
+
+// CancelJob marks a pending job as cancelled, returning false if it was not
+// pending anymore.
+func (reg *DatasetRegistry) CancelJob(id int64) (bool, error) {
+	res, err := reg.db.Exec(`UPDATE Jobs SET status = ?, error = ?, updated = ? WHERE id = ? AND status = ?`,
+		JobCancelled, "cancelled", time.Now().Unix(), id, JobPending)
+	if err != nil {
+		return false, err
+	}
+	count, err := res.RowsAffected()
+	return count > 0, err
+}
+
+// RetryJob makes a failed or cancelled job pending again with all its
+// attempts, returning false if it was neither.
+func (reg *DatasetRegistry) RetryJob(id int64) (bool, error) {
+	now := time.Now().Unix()
+	res, err := reg.db.Exec(`UPDATE Jobs SET status = ?, step = '', done = 0, total = 0, error = '', attempts = 0,
+		run_after = ?, updated = ? WHERE id = ? AND status IN (?, ?)`, JobPending, now, now, id, JobFailed, JobCancelled)
+	if err != nil {
+		return false, err
+	}
+	count, err := res.RowsAffected()
+	return count > 0, err
+}
+
+// RequeueInterruptedJobs makes the jobs left running when the server
+// stopped pending again, or failed if they have no attempts left.
+func (reg *DatasetRegistry) RequeueInterruptedJobs() error {
+	const interrupted = "interrupted by a restart of the server"
+	now := time.Now().Unix()
+	op := NewDatabaseOperation(reg.db)
+	defer op.Close()
+	op.TryExec(op.TryPrepare(`UPDATE Jobs SET status = ?, error = ?, updated = ? WHERE status = ? AND attempts >= max_attempts`),
+		JobFailed, interrupted, now, JobRunning)
+	op.TryExec(op.TryPrepare(`UPDATE Jobs SET status = ?, error = ?, run_after = ?, updated = ? WHERE status = ?`),
+		JobPending, interrupted, now, now, JobRunning)
+	return op.Error()
+}
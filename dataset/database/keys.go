@@ -0,0 +1,39 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// IdentificationKey is a single-access key of the dataset, generated by a
+// job from the dataset at a version.
+type IdentificationKey struct {
+	JobId   int64
+	Version string
+	Created time.Time
+	Content string
+}
+
+// SaveIdentificationKey stores the key generated by a job.
+func (reg *DatasetRegistry) SaveIdentificationKey(key *IdentificationKey) error {
+	key.Created = time.Now().Truncate(time.Second)
+	_, err := reg.db.Exec(`INSERT OR REPLACE INTO IdentificationKeys (job, version, created, content) VALUES (?, ?, ?, ?)`,
+		key.JobId, key.Version, key.Created.Unix(), key.Content)
+	return err
+}
+
+// GetLastIdentificationKey returns the key generated last, nil if there is
+// none.
+func (reg *DatasetRegistry) GetLastIdentificationKey() (*IdentificationKey, error) {
+	key := &IdentificationKey{}
+	var created int64
+	err := reg.db.QueryRow(`SELECT job, version, created, content FROM IdentificationKeys ORDER BY created DESC, job DESC LIMIT 1`).
+		Scan(&key.JobId, &key.Version, &created, &key.Content)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	key.Created = time.Unix(created, 0)
+	return key, nil
+}
@@ -0,0 +1,104 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// migrations upgrade the tables of a database created by an earlier
+// version to those of CreateTables, PRAGMA user_version counting the ones
+// already applied. New migrations are appended, never reordered. Tables
+// missing altogether are created by CreateTables itself, and each
+// migration checks the columns it changes, so that it also leaves alone
+// databases created with these columns already.
+var migrations = []func(op *DatabaseOperation){
+	// Taxonomic ranks.
+	func(op *DatabaseOperation) {
+		op.addColumn("Taxons", "rank", "VARCHAR(32) NOT NULL DEFAULT ''")
+	},
+	// State colors as CSS colors rather than numbers.
+	func(op *DatabaseOperation) {
+		if !strings.HasPrefix(strings.ToUpper(op.columnType("States", "color")), "INT") {
+			return
+		}
+		op.exec(`CREATE TABLE StatesWithColors (
+			item TEXT NOT NULL,
+			character INT NOT NULL,
+			color VARCHAR(16) NOT NULL DEFAULT '',
+			PRIMARY KEY(item),
+			FOREIGN KEY(item) REFERENCES Items(id),
+			FOREIGN KEY(character) REFERENCES Characters(id)
+		);`)
+		op.exec(`INSERT INTO StatesWithColors (item, character, color)
+			SELECT item, character, CASE WHEN color = 0 THEN '' ELSE CAST(color AS TEXT) END FROM States`)
+		op.exec(`DROP TABLE States`)
+		op.exec(`ALTER TABLE StatesWithColors RENAME TO States`)
+	},
+}
+
+// columnType returns the declared type of a column of a table, or "" if
+// the table has no such column.
+func (op *DatabaseOperation) columnType(table string, column string) string {
+	if op.err != nil {
+		return ""
+	}
+	rows, err := op.tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		op.fail(err)
+		return ""
+	}
+	defer rows.Close()
+	columnType := ""
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, declType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &declType, &notNull, &defaultValue, &pk); err != nil {
+			op.fail(err)
+			return ""
+		}
+		if strings.EqualFold(name, column) {
+			columnType = declType
+		}
+	}
+	if err := rows.Err(); err != nil {
+		op.fail(err)
+	}
+	return columnType
+}
+
+// exec runs a statement of a migration.
+func (op *DatabaseOperation) exec(query string) {
+	if op.err == nil {
+		_, err := op.tx.Exec(query)
+		op.fail(err)
+	}
+}
+
+// addColumn adds a column to a table unless it already has it.
+func (op *DatabaseOperation) addColumn(table string, column string, definition string) {
+	if op.columnType(table, column) != "" || op.err != nil {
+		return
+	}
+	op.exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+}
+
+// migrate applies the migrations the database has not been through yet,
+// in a single transaction.
+func migrate(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+	if version >= len(migrations) {
+		return nil
+	}
+	op := NewDatabaseOperation(db)
+	defer op.Close()
+	for _, migration := range migrations[version:] {
+		migration(op)
+	}
+	op.exec(fmt.Sprintf("PRAGMA user_version = %d", len(migrations)))
+	return op.Error()
+}
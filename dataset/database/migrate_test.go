@@ -0,0 +1,105 @@
+package database
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// baselineTables are some tables as created by the first version, before
+// any migration.
+var baselineTables = []string{
+	`CREATE TABLE Items (
+		id TEXT NOT NULL,
+		ord INTEGER NOT NULL,
+		name VARCHAR(512) NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		PRIMARY KEY (id)
+	);`,
+	`CREATE TABLE Taxons (
+		item TEXT NOT NULL,
+		author VARCHAR(512) NOT NULL,
+		PRIMARY KEY(item)
+	);`,
+	`CREATE TABLE States (
+		item TEXT NOT NULL,
+		character INT NOT NULL,
+		color INT NOT NULL DEFAULT 0,
+		PRIMARY KEY(item),
+		FOREIGN KEY(item) REFERENCES Items(id),
+		FOREIGN KEY(character) REFERENCES Characters(id)
+	);`,
+	`INSERT INTO Items (id, ord, name) VALUES ('t1', 0, 'Quercus');`,
+	`INSERT INTO States (item, character) VALUES ('s1', 'c1');`,
+	`INSERT INTO Taxons (item, author) VALUES ('t1', 'L.');`,
+}
+
+func TestCreateTablesUpgradesDatabase(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "db.sq3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, query := range baselineTables {
+		if _, err := db.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Running it again on an upgraded database must change nothing.
+	for i := 0; i < 2; i++ {
+		if err := CreateTables(db); err != nil {
+			t.Fatalf("Expected an existing database to be upgraded, got %v.", err)
+		}
+	}
+	var author, rank string
+	if err := db.QueryRow(`SELECT author, rank FROM Taxons WHERE item = 't1'`).Scan(&author, &rank); err != nil {
+		t.Fatal(err)
+	}
+	if author != "L." || rank != "" {
+		t.Errorf("Expected the taxon to be kept without a rank, got %q and %q.", author, rank)
+	}
+	var color string
+	if err := db.QueryRow(`SELECT color FROM States WHERE item = 's1'`).Scan(&color); err != nil {
+		t.Fatal(err)
+	}
+	if color != "" {
+		t.Errorf("Expected states without a color, got %q.", color)
+	}
+	if _, err := db.Exec(`INSERT INTO States (item, character, color) VALUES ('s2', 'c1', '#ff0000')`); err != nil {
+		t.Errorf("Expected colors to be strings, got %v.", err)
+	}
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version != len(migrations) {
+		t.Errorf("Expected the database at version %d, got %d.", len(migrations), version)
+	}
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM TaxonStates`).Scan(&count); err != nil {
+		t.Errorf("Expected the missing tables to be created, got %v.", err)
+	}
+}
+
+func TestCreateTablesOnNewDatabase(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "db.sq3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := CreateTables(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := InsertStandardContent(db); err != nil {
+		t.Fatal(err)
+	}
+	// A database initialized twice is left as it is.
+	if err := CreateTables(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := InsertStandardContent(db); err != nil {
+		t.Errorf("Expected the standard content to be inserted again, got %v.", err)
+	}
+}
@@ -52,3 +52,12 @@ func (op *DatabaseOperation) TryQuery(stmt *sql.Stmt, args ...interface{}) (rows
 	rows, op.err = stmt.Query(args...)
 	return
 }
+
+// fail makes the operation fail with an error, unless it already failed,
+// and returns the error of the operation.
+func (op *DatabaseOperation) fail(err error) error {
+	if op.err == nil {
+		op.err = err
+	}
+	return op.err
+}
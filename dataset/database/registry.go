@@ -1,32 +1,34 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"io/ioutil"
-	"log"
-	"net/http"
 	"strings"
+	"time"
 
 	"nicolas.galipot.net/taxonomia/dataset"
 )
 
+// CreateTables creates the tables missing from the database, then upgrades
+// those created by an earlier version, so that it can be run again on an
+// existing database.
 func CreateTables(db *sql.DB) (err error) {
-	sqlTables := []string{"Items", "PictureCache", "ItemPictures", "Languages", "ItemNames", "Hierarchies", "Characters", "States", "Taxons", "TaxonStates", "CharacterRequiredStates"}
+	sqlTables := []string{"Items", "PictureCache", "ItemPictures", "Languages", "ItemNames", "Hierarchies", "Characters", "States", "Taxons", "TaxonSynonyms", "TaxonStates", "CharacterRequiredStates", "CharacterInapplicableStates", "Books", "BookAuthors", "TaxonReferences", "Glossary", "GlossaryTexts", "ExtraFields", "TaxonExtraValues", "SearchEntries", "IdentificationSessions", "IdentificationAnswers", "Datasets", "DatasetAccess", "Users", "UserRoles", "ApiTokens", "DatasetImports", "Jobs", "IdentificationKeys"}
 	sqlCreateTables := []string{
-		`CREATE TABLE Items (
+		`CREATE TABLE IF NOT EXISTS Items (
 			id TEXT NOT NULL,
 			ord INTEGER NOT NULL,
 			name VARCHAR(512) NOT NULL,
 			description TEXT NOT NULL DEFAULT '',
 			PRIMARY KEY (id)
 		);`,
-		`CREATE TABLE PictureCache (
+		`CREATE TABLE IF NOT EXISTS PictureCache (
 			src TEXT NOT NULL,
 			data BLOB NOT NULL,
 			PRIMARY KEY (src)
 		);`,
-		`CREATE TABLE ItemPictures (
+		`CREATE TABLE IF NOT EXISTS ItemPictures (
 			id INT NOT NULL,
 			item TEXT NOT NULL,
 			url VARCHAR(512) NOT NULL,
@@ -34,12 +36,12 @@ func CreateTables(db *sql.DB) (err error) {
 			PRIMARY KEY (id),
 			FOREIGN KEY(item) REFERENCES Items(id)
 		);`,
-		`CREATE TABLE Languages (
+		`CREATE TABLE IF NOT EXISTS Languages (
 			code VARCHAR(2) NOT NULL,
 			label VARCHAR(512) NOT NULL,
 			PRIMARY KEY (code)
 		);`,
-		`CREATE TABLE ItemNames (
+		`CREATE TABLE IF NOT EXISTS ItemNames (
 			item TEXT NOT NULL,
 			lang VARCHAR(2) NOT NULL,
 			text VARCHAR(512) NOT NULL,
@@ -47,7 +49,7 @@ func CreateTables(db *sql.DB) (err error) {
 			FOREIGN KEY(item) REFERENCES Items(id),
 			FOREIGN KEY(lang) REFERENCES Languages(id)
 		);`,
-		`CREATE TABLE Hierarchies (
+		`CREATE TABLE IF NOT EXISTS Hierarchies (
 			ancestor TEXT NOT NULL,
 			descendant TEXT NOT NULL,
 			length INT NOT NULL DEFAULT 0,
@@ -55,44 +57,220 @@ func CreateTables(db *sql.DB) (err error) {
 			FOREIGN KEY(ancestor) REFERENCES Items(id),
 			FOREIGN KEY(descendant) REFERENCES Items(id)
 		);`,
-		`CREATE TABLE Characters (
+		`CREATE TABLE IF NOT EXISTS Characters (
 			item TEXT NOT NULL,
 			PRIMARY KEY(item),
 			FOREIGN KEY(item) REFERENCES Items(id)
 		);`,
-		`CREATE TABLE States (
+		`CREATE TABLE IF NOT EXISTS States (
 			item TEXT NOT NULL,
 			character INT NOT NULL,
-			color INT NOT NULL DEFAULT 0,
+			color VARCHAR(16) NOT NULL DEFAULT '',
 			PRIMARY KEY(item),
 			FOREIGN KEY(item) REFERENCES Items(id),
 			FOREIGN KEY(character) REFERENCES Characters(id)
 		);`,
-		`CREATE TABLE Taxons (
+		`CREATE TABLE IF NOT EXISTS Taxons (
 			item TEXT NOT NULL,
 			author VARCHAR(512) NOT NULL,
+			rank VARCHAR(32) NOT NULL DEFAULT '',
 			PRIMARY KEY(item)
 		);`,
-		`CREATE TABLE TaxonStates (
+		`CREATE TABLE IF NOT EXISTS TaxonSynonyms (
+			taxon TEXT NOT NULL,
+			ord INTEGER NOT NULL,
+			name VARCHAR(512) NOT NULL,
+			author VARCHAR(512) NOT NULL DEFAULT '',
+			year INTEGER NOT NULL DEFAULT 0,
+			status VARCHAR(32) NOT NULL DEFAULT '',
+			PRIMARY KEY(taxon, ord),
+			FOREIGN KEY(taxon) REFERENCES Taxons(item)
+		);`,
+		`CREATE TABLE IF NOT EXISTS TaxonStates (
 			taxon TEXT NOT NULL,
 			state TEXT NOT NULL,
 			PRIMARY KEY(taxon, state)
 		);`,
-		`CREATE TABLE CharacterRequiredStates (
+		`CREATE TABLE IF NOT EXISTS CharacterRequiredStates (
 			character TEXT NOT NULL,
 			state TEXT NOT NULL,
 			PRIMARY KEY(character, state)
 		);`,
+		`CREATE TABLE IF NOT EXISTS CharacterInapplicableStates (
+			character TEXT NOT NULL,
+			state TEXT NOT NULL,
+			PRIMARY KEY(character, state)
+		);`,
+		`CREATE TABLE IF NOT EXISTS Books (
+			id TEXT NOT NULL,
+			ord INTEGER NOT NULL,
+			kind VARCHAR(32) NOT NULL DEFAULT '',
+			title TEXT NOT NULL,
+			year INTEGER NOT NULL DEFAULT 0,
+			journal TEXT NOT NULL DEFAULT '',
+			volume VARCHAR(32) NOT NULL DEFAULT '',
+			issue VARCHAR(32) NOT NULL DEFAULT '',
+			pages VARCHAR(32) NOT NULL DEFAULT '',
+			publisher TEXT NOT NULL DEFAULT '',
+			doi VARCHAR(256) NOT NULL DEFAULT '',
+			url TEXT NOT NULL DEFAULT '',
+			PRIMARY KEY(id)
+		);`,
+		`CREATE TABLE IF NOT EXISTS BookAuthors (
+			book TEXT NOT NULL,
+			ord INTEGER NOT NULL,
+			name VARCHAR(512) NOT NULL,
+			PRIMARY KEY(book, ord),
+			FOREIGN KEY(book) REFERENCES Books(id)
+		);`,
+		`CREATE TABLE IF NOT EXISTS TaxonReferences (
+			taxon TEXT NOT NULL,
+			ord INTEGER NOT NULL,
+			book TEXT NOT NULL,
+			page INTEGER NOT NULL DEFAULT 0,
+			fasc VARCHAR(32) NOT NULL DEFAULT '',
+			detail TEXT NOT NULL DEFAULT '',
+			PRIMARY KEY(taxon, ord),
+			FOREIGN KEY(taxon) REFERENCES Taxons(item),
+			FOREIGN KEY(book) REFERENCES Books(id)
+		);`,
+		`CREATE TABLE IF NOT EXISTS Glossary (
+			id TEXT NOT NULL,
+			ord INTEGER NOT NULL,
+			url TEXT NOT NULL DEFAULT '',
+			PRIMARY KEY(id)
+		);`,
+		`CREATE TABLE IF NOT EXISTS GlossaryTexts (
+			entry TEXT NOT NULL,
+			lang VARCHAR(2) NOT NULL,
+			name VARCHAR(512) NOT NULL DEFAULT '',
+			definition TEXT NOT NULL DEFAULT '',
+			PRIMARY KEY(entry, lang),
+			FOREIGN KEY(entry) REFERENCES Glossary(id),
+			FOREIGN KEY(lang) REFERENCES Languages(code)
+		);`,
+		`CREATE TABLE IF NOT EXISTS ExtraFields (
+			id TEXT NOT NULL,
+			ord INTEGER NOT NULL,
+			std INTEGER NOT NULL DEFAULT 0,
+			label VARCHAR(512) NOT NULL DEFAULT '',
+			icon TEXT NOT NULL DEFAULT '',
+			type VARCHAR(32) NOT NULL DEFAULT 'text',
+			options TEXT NOT NULL DEFAULT '',
+			PRIMARY KEY(id)
+		);`,
+		`CREATE TABLE IF NOT EXISTS TaxonExtraValues (
+			taxon TEXT NOT NULL,
+			field TEXT NOT NULL,
+			value TEXT NOT NULL,
+			number REAL,
+			PRIMARY KEY(taxon, field),
+			FOREIGN KEY(taxon) REFERENCES Taxons(item),
+			FOREIGN KEY(field) REFERENCES ExtraFields(id)
+		);`,
+		`CREATE TABLE IF NOT EXISTS SearchEntries (
+			id INTEGER PRIMARY KEY,
+			item TEXT NOT NULL,
+			kind VARCHAR(16) NOT NULL,
+			lang VARCHAR(2) NOT NULL DEFAULT '',
+			field VARCHAR(16) NOT NULL,
+			text TEXT NOT NULL,
+			FOREIGN KEY(item) REFERENCES Items(id)
+		);`,
+		`CREATE TABLE IF NOT EXISTS IdentificationSessions (
+			id TEXT NOT NULL,
+			code VARCHAR(16) NOT NULL UNIQUE,
+			owner TEXT NOT NULL DEFAULT '',
+			rank VARCHAR(32) NOT NULL DEFAULT '',
+			created INTEGER NOT NULL,
+			updated INTEGER NOT NULL,
+			expires INTEGER NOT NULL,
+			PRIMARY KEY(id)
+		);`,
+		`CREATE TABLE IF NOT EXISTS IdentificationAnswers (
+			session TEXT NOT NULL,
+			ord INTEGER NOT NULL,
+			character TEXT NOT NULL,
+			states TEXT NOT NULL DEFAULT '',
+			PRIMARY KEY(session, ord),
+			FOREIGN KEY(session) REFERENCES IdentificationSessions(id) ON DELETE CASCADE
+		);`,
+		`CREATE TABLE IF NOT EXISTS Datasets (
+			id TEXT NOT NULL,
+			version VARCHAR(64) NOT NULL,
+			imported INTEGER NOT NULL,
+			PRIMARY KEY(id)
+		);`,
+		`CREATE TABLE IF NOT EXISTS DatasetAccess (
+			dataset TEXT NOT NULL,
+			private INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY(dataset)
+		);`,
+		`CREATE TABLE IF NOT EXISTS Users (
+			id INTEGER PRIMARY KEY,
+			name VARCHAR(128) NOT NULL UNIQUE,
+			hash TEXT NOT NULL,
+			created INTEGER NOT NULL
+		);`,
+		`CREATE TABLE IF NOT EXISTS UserRoles (
+			user INTEGER NOT NULL,
+			dataset TEXT NOT NULL,
+			role VARCHAR(16) NOT NULL,
+			PRIMARY KEY(user, dataset),
+			FOREIGN KEY(user) REFERENCES Users(id) ON DELETE CASCADE
+		);`,
+		`CREATE TABLE IF NOT EXISTS ApiTokens (
+			id INTEGER PRIMARY KEY,
+			user INTEGER NOT NULL,
+			name VARCHAR(128) NOT NULL DEFAULT '',
+			hash TEXT NOT NULL UNIQUE,
+			created INTEGER NOT NULL,
+			FOREIGN KEY(user) REFERENCES Users(id) ON DELETE CASCADE
+		);`,
+		`CREATE TABLE IF NOT EXISTS DatasetImports (
+			id INTEGER PRIMARY KEY,
+			dataset TEXT NOT NULL,
+			format VARCHAR(16) NOT NULL,
+			filename TEXT NOT NULL DEFAULT '',
+			user INTEGER NOT NULL,
+			status VARCHAR(16) NOT NULL,
+			error TEXT NOT NULL DEFAULT '',
+			job INTEGER NOT NULL DEFAULT 0,
+			created INTEGER NOT NULL,
+			updated INTEGER NOT NULL,
+			data BLOB NOT NULL
+		);`,
+		`CREATE TABLE IF NOT EXISTS Jobs (
+			id INTEGER PRIMARY KEY,
+			kind VARCHAR(32) NOT NULL,
+			params TEXT NOT NULL DEFAULT '',
+			user INTEGER NOT NULL,
+			status VARCHAR(16) NOT NULL,
+			step VARCHAR(32) NOT NULL DEFAULT '',
+			done INTEGER NOT NULL DEFAULT 0,
+			total INTEGER NOT NULL DEFAULT 0,
+			error TEXT NOT NULL DEFAULT '',
+			attempts INTEGER NOT NULL DEFAULT 0,
+			max_attempts INTEGER NOT NULL DEFAULT 1,
+			run_after INTEGER NOT NULL,
+			created INTEGER NOT NULL,
+			updated INTEGER NOT NULL
+		);`,
+		`CREATE TABLE IF NOT EXISTS IdentificationKeys (
+			job INTEGER NOT NULL,
+			version VARCHAR(64) NOT NULL DEFAULT '',
+			created INTEGER NOT NULL,
+			content TEXT NOT NULL,
+			PRIMARY KEY(job)
+		);`,
 	}
 	for i, createTable := range sqlCreateTables {
-		tableName := sqlTables[i]
-		stmt, err := db.Prepare(createTable)
-		if err != nil {
-			log.Fatalf("Could not create table %s: %q.\n", tableName, err.Error())
+		if _, err := db.Exec(createTable); err != nil {
+			return fmt.Errorf("could not create table %s: %w", sqlTables[i], err)
 		}
-		stmt.Exec()
 	}
-	return
+	createSearchIndex(db)
+	return migrate(db)
 }
 
 var stdLanguages = []dataset.Lang{
@@ -103,8 +281,25 @@ var stdLanguages = []dataset.Lang{
 	{Code: "FR", Label: "French"},
 }
 
+func (reg *DatasetRegistry) GetLanguages() ([]dataset.Lang, error) {
+	rows, err := reg.db.Query(`SELECT code, label FROM Languages ORDER BY code`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	langs := []dataset.Lang{}
+	for rows.Next() {
+		var lang dataset.Lang
+		if err := rows.Scan(&lang.Code, &lang.Label); err != nil {
+			return nil, err
+		}
+		langs = append(langs, lang)
+	}
+	return langs, rows.Err()
+}
+
 func InsertStandardContent(db *sql.DB) error {
-	if insertLang, err := db.Prepare(`INSERT INTO Languages (code, label) VALUES (?,?)`); err == nil {
+	if insertLang, err := db.Prepare(`INSERT OR IGNORE INTO Languages (code, label) VALUES (?,?)`); err == nil {
 		for _, lang := range stdLanguages {
 			_, err = insertLang.Exec(lang.Code, lang.Label)
 			if err != nil {
@@ -153,18 +348,21 @@ func (reg *DatasetRegistry) insertHierarchicalItem(op *DatabaseOperation, insert
 }
 
 type insertCharacterPreparedStatements struct {
-	insertItem           *sql.Stmt
-	insertItemNames      *sql.Stmt
-	insertItemPicture    *sql.Stmt
-	insertHierarchy      *sql.Stmt
-	insertCharacter      *sql.Stmt
-	insertState          *sql.Stmt
-	insertRequiredStates *sql.Stmt
+	insertItem               *sql.Stmt
+	insertItemNames          *sql.Stmt
+	insertItemPicture        *sql.Stmt
+	insertHierarchy          *sql.Stmt
+	insertCharacter          *sql.Stmt
+	insertState              *sql.Stmt
+	insertRequiredStates     *sql.Stmt
+	insertInapplicableStates *sql.Stmt
+	inserted                 func()
 }
 
 func (reg *DatasetRegistry) recursivelyInsertCharacters(ds *dataset.Dataset, op *DatabaseOperation, stmts insertCharacterPreparedStatements, character *dataset.Character, parentHierarchy *dataset.Hierarchy) error {
 	reg.insertHierarchicalItem(op, stmts.insertItem, stmts.insertItemNames, stmts.insertHierarchy, reg.charactersCount, character.Hierarchy, parentHierarchy)
 	reg.charactersCount++
+	stmts.inserted()
 	op.TryExec(stmts.insertCharacter, character.Id)
 	for _, pic := range character.Pictures {
 		reg.picCount++
@@ -175,7 +373,7 @@ func (reg *DatasetRegistry) recursivelyInsertCharacters(ds *dataset.Dataset, op
 		for lang, text := range state.Name.NamesByLangRef {
 			op.TryExec(stmts.insertItemNames, state.Id, lang, text)
 		}
-		op.TryExec(stmts.insertState, state.Id, character.Id)
+		op.TryExec(stmts.insertState, state.Id, character.Id, state.Color)
 		for _, pic := range state.Pictures {
 			reg.picCount++
 			op.TryExec(stmts.insertItemPicture, reg.picCount, state.Id, pic.Source, pic.Legend)
@@ -191,24 +389,27 @@ func (reg *DatasetRegistry) recursivelyInsertCharacters(ds *dataset.Dataset, op
 	for _, state := range character.RequiredStates {
 		op.TryExec(stmts.insertRequiredStates, character.Id, state.Id)
 	}
+	for _, state := range character.InapplicableStates {
+		op.TryExec(stmts.insertInapplicableStates, character.Id, state.Id)
+	}
 	return op.Error()
 }
 
-func (reg *DatasetRegistry) insertCharacters(ds *dataset.Dataset, character *dataset.Character, parent *dataset.Character) error {
+func (reg *DatasetRegistry) insertCharacters(op *DatabaseOperation, ds *dataset.Dataset, character *dataset.Character, parent *dataset.Character, inserted func()) error {
 	var parentHierarchy *dataset.Hierarchy
 	if parent != nil {
 		parentHierarchy = parent.Hierarchy
 	}
-	op := NewDatabaseOperation(reg.db)
-	defer op.Close()
 	stmts := insertCharacterPreparedStatements{
-		insertItem:           op.TryPrepare(QUERY_INSERT_ITEM),
-		insertItemNames:      op.TryPrepare(QUERY_INSERT_NAMES),
-		insertItemPicture:    op.TryPrepare(`INSERT INTO ItemPictures (id,item,url,label) VALUES (?,?,?,?);`),
-		insertHierarchy:      op.TryPrepare(QUERY_INSERT_HIERARCHIES),
-		insertCharacter:      op.TryPrepare(`INSERT INTO Characters (item) VALUES (?);`),
-		insertState:          op.TryPrepare(`INSERT INTO STATES (item,character) VALUES (?,?);`),
-		insertRequiredStates: op.TryPrepare(`INSERT INTO CharacterRequiredStates (character,state) VALUES (?,?);`),
+		insertItem:               op.TryPrepare(QUERY_INSERT_ITEM),
+		insertItemNames:          op.TryPrepare(QUERY_INSERT_NAMES),
+		insertItemPicture:        op.TryPrepare(`INSERT INTO ItemPictures (id,item,url,label) VALUES (?,?,?,?);`),
+		insertHierarchy:          op.TryPrepare(QUERY_INSERT_HIERARCHIES),
+		insertCharacter:          op.TryPrepare(`INSERT INTO Characters (item) VALUES (?);`),
+		insertState:              op.TryPrepare(`INSERT INTO STATES (item,character,color) VALUES (?,?,?);`),
+		insertRequiredStates:     op.TryPrepare(`INSERT INTO CharacterRequiredStates (character,state) VALUES (?,?);`),
+		insertInapplicableStates: op.TryPrepare(`INSERT INTO CharacterInapplicableStates (character,state) VALUES (?,?);`),
+		inserted:                 inserted,
 	}
 	return reg.recursivelyInsertCharacters(ds, op, stmts, character, parentHierarchy)
 }
@@ -219,17 +420,27 @@ type insertTaxonPreparedStatements struct {
 	insertItemPicture *sql.Stmt
 	insertHierarchy   *sql.Stmt
 	insertTaxon       *sql.Stmt
+	insertSynonym     *sql.Stmt
+	insertReference   *sql.Stmt
 	insertTaxonStates *sql.Stmt
+	inserted          func()
 }
 
 func (reg *DatasetRegistry) recursivelyInsertTaxons(ds *dataset.Dataset, op *DatabaseOperation, stmts insertTaxonPreparedStatements, taxon *dataset.Taxon, parentHierarchy *dataset.Hierarchy) error {
 	reg.insertHierarchicalItem(op, stmts.insertItem, stmts.insertItemNames, stmts.insertHierarchy, reg.taxonsCount, taxon.Hierarchy, parentHierarchy)
 	reg.taxonsCount++
-	op.TryExec(stmts.insertTaxon, taxon.Id, taxon.Author)
+	stmts.inserted()
+	op.TryExec(stmts.insertTaxon, taxon.Id, taxon.Author, taxon.Rank)
+	for i, synonym := range taxon.Synonyms {
+		op.TryExec(stmts.insertSynonym, taxon.Id, i, synonym.Name, synonym.Author, synonym.Year, synonym.Status)
+	}
 	for _, pic := range taxon.Pictures {
 		reg.picCount++
 		op.TryExec(stmts.insertItemPicture, reg.picCount, taxon.Id, pic.Source, pic.Legend)
 	}
+	for i, ref := range taxon.References {
+		op.TryExec(stmts.insertReference, taxon.Id, i, ref.BookId, ref.Page, ref.Fasc, ref.Detail)
+	}
 	for _, state := range taxon.States {
 		op.TryExec(stmts.insertTaxonStates, taxon.Id, state.Id)
 	}
@@ -243,32 +454,222 @@ func (reg *DatasetRegistry) recursivelyInsertTaxons(ds *dataset.Dataset, op *Dat
 	return op.Error()
 }
 
-func (reg *DatasetRegistry) insertTaxons(ds *dataset.Dataset, taxon *dataset.Taxon, parent *dataset.Taxon) error {
+func (reg *DatasetRegistry) insertTaxons(op *DatabaseOperation, ds *dataset.Dataset, taxon *dataset.Taxon, parent *dataset.Taxon, inserted func()) error {
 	var parentHierarchy *dataset.Hierarchy
 	if parent != nil {
 		parentHierarchy = parent.Hierarchy
 	}
-	op := NewDatabaseOperation(reg.db)
-	defer op.Close()
 	stmts := insertTaxonPreparedStatements{
 		insertItem:        op.TryPrepare(QUERY_INSERT_ITEM),
 		insertItemNames:   op.TryPrepare(QUERY_INSERT_NAMES),
 		insertItemPicture: op.TryPrepare(`INSERT INTO ItemPictures (id,item,url,label) VALUES (?,?,?,?);`),
 		insertHierarchy:   op.TryPrepare(QUERY_INSERT_HIERARCHIES),
-		insertTaxon:       op.TryPrepare(`INSERT INTO Taxons (item, author) VALUES (?,?);`),
+		insertTaxon:       op.TryPrepare(`INSERT INTO Taxons (item, author, rank) VALUES (?,?,?);`),
+		insertSynonym:     op.TryPrepare(`INSERT INTO TaxonSynonyms (taxon, ord, name, author, year, status) VALUES (?,?,?,?,?,?);`),
+		insertReference:   op.TryPrepare(`INSERT INTO TaxonReferences (taxon, ord, book, page, fasc, detail) VALUES (?,?,?,?,?,?);`),
 		insertTaxonStates: op.TryPrepare(`INSERT INTO TaxonStates (taxon, state) VALUES (?,?);`),
+		inserted:          inserted,
 	}
 	return reg.recursivelyInsertTaxons(ds, op, stmts, taxon, parentHierarchy)
 }
 
-func (reg *DatasetRegistry) InsertDataset(ds *dataset.Dataset) (err error) {
-	if err = reg.insertCharacters(ds, dataset.NewCharacter(ds.CharactersHierarchy), nil); err != nil {
-		log.Fatalf("Cannot insert hierarchy: %q.\n", err.Error())
+func (reg *DatasetRegistry) insertBooks(op *DatabaseOperation, books []dataset.Book) error {
+	insertBook := op.TryPrepare(`INSERT INTO Books (id, ord, kind, title, year, journal, volume, issue, pages, publisher, doi, url)
+		VALUES (?,?,?,?,?,?,?,?,?,?,?,?);`)
+	insertAuthor := op.TryPrepare(`INSERT INTO BookAuthors (book, ord, name) VALUES (?,?,?);`)
+	for i, book := range books {
+		op.TryExec(insertBook, book.Id, i, book.Kind, book.Title, book.Year, book.Journal, book.Volume, book.Issue,
+			book.Pages, book.Publisher, book.Doi, book.Url)
+		for j, author := range book.Authors {
+			op.TryExec(insertAuthor, book.Id, j, author)
+		}
+	}
+	return op.Error()
+}
+
+func (reg *DatasetRegistry) insertGlossary(op *DatabaseOperation, entries []dataset.DictionaryEntry) error {
+	insertEntry := op.TryPrepare(`INSERT INTO Glossary (id, ord, url) VALUES (?,?,?);`)
+	insertText := op.TryPrepare(`INSERT INTO GlossaryTexts (entry, lang, name, definition) VALUES (?,?,?,?);`)
+	for i, entry := range entries {
+		op.TryExec(insertEntry, entry.Id, i, entry.Url)
+		langs := map[string]bool{}
+		for lang := range entry.Name.NamesByLangRef {
+			langs[lang] = true
+		}
+		for lang := range entry.Definition.NamesByLangRef {
+			langs[lang] = true
+		}
+		for lang := range langs {
+			op.TryExec(insertText, entry.Id, lang, entry.Name.NamesByLangRef[lang], entry.Definition.NamesByLangRef[lang])
+		}
+	}
+	return op.Error()
+}
+
+func numberOrNull(value interface{}) interface{} {
+	if n, ok := value.(float64); ok {
+		return n
+	}
+	return nil
+}
+
+// insertExtraFields inserts the extra fields and the values of taxons that
+// match their type, invalid values being left out.
+func (reg *DatasetRegistry) insertExtraFields(op *DatabaseOperation, ds *dataset.Dataset) error {
+	insertField := op.TryPrepare(`INSERT INTO ExtraFields (id, ord, std, label, icon, type, options) VALUES (?,?,?,?,?,?,?);`)
+	insertValue := op.TryPrepare(`INSERT INTO TaxonExtraValues (taxon, field, value, number) VALUES (?,?,?,?);`)
+	fields := ds.AllExtraFields()
+	for i, field := range fields {
+		op.TryExec(insertField, field.Id, i, field.IsStandard, field.Label, field.Icon, field.Type, strings.Join(field.Options, "\n"))
+	}
+	for _, taxon := range ds.TaxonsById {
+		for i := range fields {
+			value, ok := taxon.ExtraInfo[fields[i].Id]
+			if !ok {
+				continue
+			}
+			normalized, err := fields[i].Normalize(value)
+			if err != nil {
+				continue
+			}
+			op.TryExec(insertValue, taxon.Id, fields[i].Id, dataset.FormatExtraValue(normalized), numberOrNull(normalized))
+		}
+	}
+	return op.Error()
+}
+
+// datasetTables are the tables holding the content of the dataset, emptied
+// before a dataset replaces it.
+var datasetTables = []string{"Items", "ItemPictures", "ItemNames", "Hierarchies", "Characters", "States", "Taxons",
+	"TaxonSynonyms", "TaxonStates", "CharacterRequiredStates", "CharacterInapplicableStates", "Books", "BookAuthors",
+	"TaxonReferences", "Glossary", "GlossaryTexts", "ExtraFields", "TaxonExtraValues", "SearchEntries"}
+
+// ImportProgress is told the step an import is at, and how many of the
+// taxons and characters were inserted.
+type ImportProgress func(step string, done int, total int)
+
+// Steps of an import given to ImportProgress.
+const (
+	ImportStepClearing   = "clearing"
+	ImportStepBooks      = "books"
+	ImportStepGlossary   = "glossary"
+	ImportStepCharacters = "characters"
+	ImportStepTaxons     = "taxons"
+	ImportStepFields     = "extra fields"
+	ImportStepIndex      = "index"
+)
+
+// InsertDataset replaces the dataset of the registry, see
+// InsertDatasetWithProgress.
+func (reg *DatasetRegistry) InsertDataset(ds *dataset.Dataset) error {
+	return reg.InsertDatasetWithProgress(context.Background(), ds, nil)
+}
+
+// InsertDatasetWithProgress replaces the dataset of the registry in a single
+// transaction, so that it is left untouched when the import fails or ctx is
+// cancelled. Cached pictures, users and identification sessions are kept.
+func (reg *DatasetRegistry) InsertDatasetWithProgress(ctx context.Context, ds *dataset.Dataset, progress ImportProgress) error {
+	if progress == nil {
+		progress = func(string, int, int) {}
+	}
+	// The roots of the hierarchies are inserted too.
+	total := len(ds.TaxonsById) + len(ds.CharactersById) + 2
+	done := 0
+	step := ImportStepClearing
+	fullText := reg.hasFullTextIndex()
+	op := NewDatabaseOperation(reg.db)
+	defer op.Close()
+	inserted := func() {
+		done++
+		if done%100 == 0 {
+			if err := ctx.Err(); err != nil {
+				op.fail(err)
+			}
+			progress(step, done, total)
+		}
+	}
+	setStep := func(s string) {
+		if err := ctx.Err(); err != nil {
+			op.fail(err)
+		}
+		step = s
+		progress(step, done, total)
+	}
+	setStep(ImportStepClearing)
+	for _, table := range datasetTables {
+		op.TryExec(op.TryPrepare(`DELETE FROM ` + table))
+	}
+	reg.picCount, reg.taxonsCount, reg.charactersCount = 0, 0, 0
+	setStep(ImportStepBooks)
+	if err := reg.insertBooks(op, ds.Books); err != nil {
+		return fmt.Errorf("cannot insert books: %w", err)
+	}
+	setStep(ImportStepGlossary)
+	if err := reg.insertGlossary(op, ds.DictionaryEntry); err != nil {
+		return fmt.Errorf("cannot insert glossary: %w", err)
+	}
+	setStep(ImportStepCharacters)
+	if err := reg.insertCharacters(op, ds, dataset.NewCharacter(ds.CharactersHierarchy), nil, inserted); err != nil {
+		return fmt.Errorf("cannot insert characters: %w", err)
+	}
+	setStep(ImportStepTaxons)
+	if err := reg.insertTaxons(op, ds, dataset.NewTaxon(ds.TaxonsHierarchy), nil, inserted); err != nil {
+		return fmt.Errorf("cannot insert taxons: %w", err)
+	}
+	setStep(ImportStepFields)
+	if err := reg.insertExtraFields(op, ds); err != nil {
+		return fmt.Errorf("cannot insert extra fields: %w", err)
+	}
+	setStep(ImportStepIndex)
+	if err := indexItems(op, fullText, ds.TaxonsHierarchy.Id, ds.CharactersHierarchy.Id); err != nil {
+		return fmt.Errorf("cannot index items: %w", err)
+	}
+	if err := insertDatasetInfo(op, ds); err != nil {
+		return fmt.Errorf("cannot insert dataset version: %w", err)
+	}
+	return nil
+}
+
+// RebuildSearchIndex fills the search entries and the full-text index again
+// from the items of the dataset.
+func (reg *DatasetRegistry) RebuildSearchIndex() error {
+	fullText := reg.hasFullTextIndex()
+	op := NewDatabaseOperation(reg.db)
+	defer op.Close()
+	return indexItems(op, fullText, taxonsRootId, charactersRootId)
+}
+
+// DatasetInfo tells which version of a dataset was imported and when. The
+// version changes with every edit applied to the dataset.
+type DatasetInfo struct {
+	Id       string
+	Version  string
+	Imported time.Time
+}
+
+func insertDatasetInfo(op *DatabaseOperation, ds *dataset.Dataset) error {
+	version, err := ds.Version()
+	if err != nil {
+		return op.fail(err)
 	}
-	if err = reg.insertTaxons(ds, dataset.NewTaxon(ds.TaxonsHierarchy), nil); err != nil {
-		log.Fatalf("Cannot insert hierarchy: %q.\n", err.Error())
+	op.TryExec(op.TryPrepare(`INSERT OR REPLACE INTO Datasets (id, version, imported) VALUES (?, ?, ?)`),
+		ds.Id, version, time.Now().Unix())
+	return op.Error()
+}
+
+// GetDatasetInfo returns the dataset imported last, nil if there is none.
+func (reg *DatasetRegistry) GetDatasetInfo() (*DatasetInfo, error) {
+	info := &DatasetInfo{}
+	var imported int64
+	err := reg.db.QueryRow(`SELECT id, version, imported FROM Datasets ORDER BY imported DESC LIMIT 1`).
+		Scan(&info.Id, &info.Version, &imported)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
 	}
-	return err
+	info.Imported = time.Unix(imported, 0)
+	return info, nil
 }
 
 func inLen(length int) string {
@@ -294,9 +695,10 @@ func (reg *DatasetRegistry) GetTaxonsHavingStates(states []string) ([]*dataset.T
 	op := NewDatabaseOperation(reg.db)
 	defer op.Close()
 	selectTaxons := op.TryPrepare(fmt.Sprintf(
-		`SELECT Taxon.id, Taxon.name
+		`SELECT Taxon.id, Taxon.name, IFNULL(Taxons.rank, '')
 		FROM Items Taxon
 		INNER JOIN TaxonStates ON Taxon.id = TaxonStates.taxon 
+		LEFT JOIN Taxons ON Taxons.item = Taxon.id
 		WHERE TaxonStates.state IN (%s)
 		GROUP BY Taxon.id
 		HAVING Count(TaxonStates.state) = ?`, inLen(len(states))))
@@ -310,18 +712,79 @@ func (reg *DatasetRegistry) GetTaxonsHavingStates(states []string) ([]*dataset.T
 	taxons := []*dataset.Taxon{}
 	for rows.Next() {
 		taxon := dataset.NewTaxon(&dataset.Hierarchy{})
-		rows.Scan(&taxon.Id, &taxon.Name.Scientific)
+		rows.Scan(&taxon.Id, &taxon.Name.Scientific, &taxon.Rank)
 		taxons = append(taxons, taxon)
 	}
 	return taxons, nil
 }
 
+// GetTaxonsHavingStatesAtRank aggregates the taxons having all the given
+// states to their closest ancestor, or themselves, of the given rank.
+func (reg *DatasetRegistry) GetTaxonsHavingStatesAtRank(states []string, rank string) ([]*dataset.Taxon, error) {
+	op := NewDatabaseOperation(reg.db)
+	defer op.Close()
+	selectTaxons := op.TryPrepare(fmt.Sprintf(
+		`WITH Found AS (
+			SELECT TaxonStates.taxon FROM TaxonStates
+			WHERE TaxonStates.state IN (%s)
+			GROUP BY TaxonStates.taxon
+			HAVING Count(TaxonStates.state) = ?
+		)
+		SELECT Taxon.id, Taxon.name, Taxons.rank
+		FROM Found
+		INNER JOIN Hierarchies ON Hierarchies.descendant = Found.taxon
+		INNER JOIN Taxons ON Taxons.item = Hierarchies.ancestor AND Taxons.rank = ?
+		INNER JOIN Items Taxon ON Taxon.id = Taxons.item
+		GROUP BY Taxon.id
+		ORDER BY Taxon.ord ASC`, inLen(len(states))))
+	args := strSliceToInterface(states)
+	args = append(args, len(states), rank)
+	rows := op.TryQuery(selectTaxons, args...)
+	if op.HasFailed() {
+		return nil, op.Error()
+	}
+	defer rows.Close()
+	taxons := []*dataset.Taxon{}
+	for rows.Next() {
+		taxon := dataset.NewTaxon(&dataset.Hierarchy{})
+		rows.Scan(&taxon.Id, &taxon.Name.Scientific, &taxon.Rank)
+		taxons = append(taxons, taxon)
+	}
+	return taxons, nil
+}
+
+// GetTaxonRanks returns the ranks used by the registered taxons, from the
+// highest to the lowest.
+func (reg *DatasetRegistry) GetTaxonRanks() ([]string, error) {
+	op := NewDatabaseOperation(reg.db)
+	defer op.Close()
+	selectRanks := op.TryPrepare(`SELECT DISTINCT rank FROM Taxons WHERE rank <> ''`)
+	rows := op.TryQuery(selectRanks)
+	if op.HasFailed() {
+		return nil, op.Error()
+	}
+	defer rows.Close()
+	used := map[string]bool{}
+	for rows.Next() {
+		var rank string
+		rows.Scan(&rank)
+		used[rank] = true
+	}
+	ranks := []string{}
+	for _, rank := range dataset.Ranks {
+		if used[rank] {
+			ranks = append(ranks, rank)
+		}
+	}
+	return ranks, nil
+}
+
 func (reg *DatasetRegistry) GetAllCharactersExcept(characterIds []string) ([]*dataset.Character, map[string]*dataset.Character, error) {
 	op := NewDatabaseOperation(reg.db)
 	defer op.Close()
 	selectCharacters := op.TryPrepare(fmt.Sprintf(
-		`SELECT Character.id, Character.name, CharName.lang, CharName.text, CharPic.id, CharPic.url, 
-		State.id, State.name, StateName.lang, StateName.text, Hierarchies.ancestor, StatePic.id, StatePic.url
+		`SELECT Character.id, Character.name, Character.description, CharName.lang, CharName.text, CharPic.id, CharPic.url, 
+		State.id, State.name, State.description, StateName.lang, StateName.text, Hierarchies.ancestor, StatePic.id, StatePic.url
 		FROM Items Character
 		INNER JOIN Characters ON Characters.item = Character.id
 		LEFT JOIN ItemNames CharName ON CharName.item = Character.id
@@ -351,23 +814,24 @@ func (reg *DatasetRegistry) GetAllCharactersExcept(characterIds []string) ([]*da
 	for rows.Next() {
 		var charPicId sql.NullInt64
 		var charLang, charLangName, charPicUrl, stateLang, stateLangName, statePicId, statePicUrl sql.NullString
-		var charId, charName, stateId, stateName, parentId string
-		err := rows.Scan(&charId, &charName, &charLang, &charLangName, &charPicId, &charPicUrl,
-			&stateId, &stateName, &stateLang, &stateLangName, &parentId, &statePicId, &statePicUrl)
+		var charId, charName, charDescription, stateId, stateName, stateDescription, parentId string
+		err := rows.Scan(&charId, &charName, &charDescription, &charLang, &charLangName, &charPicId, &charPicUrl,
+			&stateId, &stateName, &stateDescription, &stateLang, &stateLangName, &parentId, &statePicId, &statePicUrl)
 		if err != nil {
 			fmt.Println(err.Error())
 		}
 		if lastCharacter == nil || lastCharacter.Id != charId {
 			charIdsByParentIds[parentId] = append(charIdsByParentIds[parentId], charId)
 			lastCharacter = dataset.NewCharacter(&dataset.Hierarchy{
-				Id: charId,
+				Id:          charId,
+				Description: charDescription,
 				Name: dataset.MultilangText{
 					Scientific:     charName,
 					NamesByLangRef: make(map[string]string),
 				},
 			})
 			charactersById[charId] = lastCharacter
-			if parentId == "c0" {
+			if parentId == charactersRootId {
 				characters = append(characters, lastCharacter)
 			}
 		}
@@ -381,7 +845,8 @@ func (reg *DatasetRegistry) GetAllCharactersExcept(characterIds []string) ([]*da
 		}
 		if lastState == nil || lastState.Id != stateId {
 			lastCharacter.States = append(lastCharacter.States, dataset.State{
-				Id: stateId,
+				Id:          stateId,
+				Description: stateDescription,
 				Name: dataset.MultilangText{
 					Scientific:     stateName,
 					NamesByLangRef: make(map[string]string),
@@ -413,6 +878,7 @@ func (reg *DatasetRegistry) GetAllCharactersExcept(characterIds []string) ([]*da
 func (reg *DatasetRegistry) GetCharactersFromIds(ids []string, stateIds []string) ([]*dataset.Character, error) {
 	characters := make([]*dataset.Character, 0, len(ids))
 	op := NewDatabaseOperation(reg.db)
+	defer op.Close()
 	selectCharacters := op.TryPrepare(
 		fmt.Sprintf(`SELECT Character.id, Character.name, State.id, State.name FROM Items Character 
 				LEFT JOIN States ON States.character = Character.id
@@ -442,50 +908,474 @@ func (reg *DatasetRegistry) GetCharactersFromIds(ids []string, stateIds []string
 	return characters, nil
 }
 
-type picture struct {
-	url     string
-	content []byte
-	err     error
+type TaxonReference struct {
+	Book      *dataset.Book
+	Reference dataset.BookReference
 }
 
-func requestImage(url string, ch chan<- picture) {
-	resp, _ := http.Get(url)
-	resp, err := http.Get(url)
+func (reg *DatasetRegistry) GetBooks() ([]*dataset.Book, error) {
+	op := NewDatabaseOperation(reg.db)
+	defer op.Close()
+	selectBooks := op.TryPrepare(`SELECT Books.id, Books.kind, Books.title, Books.year, Books.journal, Books.volume, Books.issue,
+		Books.pages, Books.publisher, Books.doi, Books.url, IFNULL(BookAuthors.name, '')
+		FROM Books
+		LEFT JOIN BookAuthors ON BookAuthors.book = Books.id
+		ORDER BY Books.ord ASC, BookAuthors.ord ASC`)
+	rows := op.TryQuery(selectBooks)
+	if op.HasFailed() {
+		return nil, op.Error()
+	}
+	defer rows.Close()
+	books := []*dataset.Book{}
+	var lastBook *dataset.Book
+	for rows.Next() {
+		var book dataset.Book
+		var author string
+		err := rows.Scan(&book.Id, &book.Kind, &book.Title, &book.Year, &book.Journal, &book.Volume, &book.Issue,
+			&book.Pages, &book.Publisher, &book.Doi, &book.Url, &author)
+		if err != nil {
+			return nil, err
+		}
+		if lastBook == nil || lastBook.Id != book.Id {
+			lastBook = &book
+			books = append(books, lastBook)
+		}
+		if author != "" {
+			lastBook.Authors = append(lastBook.Authors, author)
+		}
+	}
+	return books, nil
+}
+
+func (reg *DatasetRegistry) GetTaxonReferences(taxonId string) ([]*TaxonReference, error) {
+	books, err := reg.GetBooks()
 	if err != nil {
-		ch <- picture{url: url, content: []byte{}, err: err}
-		return
+		return nil, err
+	}
+	booksById := make(map[string]*dataset.Book, len(books))
+	for _, book := range books {
+		booksById[book.Id] = book
+	}
+	op := NewDatabaseOperation(reg.db)
+	defer op.Close()
+	selectReferences := op.TryPrepare(`SELECT book, page, fasc, detail FROM TaxonReferences WHERE taxon = ? ORDER BY ord ASC`)
+	rows := op.TryQuery(selectReferences, taxonId)
+	if op.HasFailed() {
+		return nil, op.Error()
 	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+	defer rows.Close()
+	refs := []*TaxonReference{}
+	for rows.Next() {
+		ref := &TaxonReference{}
+		if err := rows.Scan(&ref.Reference.BookId, &ref.Reference.Page, &ref.Reference.Fasc, &ref.Reference.Detail); err != nil {
+			return nil, err
+		}
+		ref.Book = booksById[ref.Reference.BookId]
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+// GetTaxon returns the name, author, rank and synonyms of a taxon, or nil
+// when there is no such taxon.
+func (reg *DatasetRegistry) GetTaxon(id string) (*dataset.Taxon, error) {
+	op := NewDatabaseOperation(reg.db)
+	defer op.Close()
+	selectTaxon := op.TryPrepare(`SELECT Taxon.id, Taxon.name, Taxon.description, Taxons.author, Taxons.rank,
+		IFNULL(Syn.name, ''), IFNULL(Syn.author, ''), IFNULL(Syn.year, 0), IFNULL(Syn.status, '')
+		FROM Items Taxon
+		INNER JOIN Taxons ON Taxons.item = Taxon.id
+		LEFT JOIN TaxonSynonyms Syn ON Syn.taxon = Taxon.id
+		WHERE Taxon.id = ?
+		ORDER BY Syn.ord ASC`)
+	rows := op.TryQuery(selectTaxon, id)
+	if op.HasFailed() {
+		return nil, op.Error()
+	}
+	defer rows.Close()
+	var taxon *dataset.Taxon
+	for rows.Next() {
+		t := dataset.NewTaxon(&dataset.Hierarchy{})
+		var synonym dataset.Synonym
+		err := rows.Scan(&t.Id, &t.Name.Scientific, &t.Description, &t.Author, &t.Rank,
+			&synonym.Name, &synonym.Author, &synonym.Year, &synonym.Status)
+		if err != nil {
+			return nil, err
+		}
+		if taxon == nil {
+			taxon = t
+		}
+		if synonym.Name != "" {
+			taxon.Synonyms = append(taxon.Synonyms, synonym)
+		}
+	}
+	if taxon == nil {
+		return nil, rows.Err()
+	}
+	rows.Close()
+	extras, err := reg.GetTaxonExtraValues(id)
 	if err != nil {
-		ch <- picture{url: url, content: []byte{}, err: err}
-		return
+		return nil, err
+	}
+	taxon.ExtraInfo = extras
+	if taxon.Name.NamesByLangRef, err = reg.getItemNames(id); err != nil {
+		return nil, err
 	}
-	ch <- picture{url: url, content: body, err: nil}
+	if taxon.Pictures, err = reg.getItemPictures(id); err != nil {
+		return nil, err
+	}
+	return taxon, nil
 }
 
-func (reg *DatasetRegistry) CacheImages() error {
+func (reg *DatasetRegistry) getItemNames(id string) (map[string]string, error) {
+	rows, err := reg.db.Query(`SELECT lang, text FROM ItemNames WHERE item = ? ORDER BY lang`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	names := map[string]string{}
+	for rows.Next() {
+		var lang, text string
+		if err := rows.Scan(&lang, &text); err != nil {
+			return nil, err
+		}
+		names[lang] = text
+	}
+	return names, rows.Err()
+}
+
+// fillItemNames adds the names in every language to the texts of items
+// indexed by id.
+func (reg *DatasetRegistry) fillItemNames(texts map[string]*dataset.MultilangText) error {
+	if len(texts) == 0 {
+		return nil
+	}
+	ids := make([]string, 0, len(texts))
+	for id := range texts {
+		ids = append(ids, id)
+	}
+	rows, err := reg.db.Query(`SELECT item, lang, text FROM ItemNames WHERE item IN (`+inLen(len(ids))+`)`, strSliceToInterface(ids)...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, lang, text string
+		if err := rows.Scan(&id, &lang, &text); err != nil {
+			return err
+		}
+		texts[id].NamesByLangRef[lang] = text
+	}
+	return rows.Err()
+}
+
+func (reg *DatasetRegistry) getItemPictures(id string) ([]dataset.Picture, error) {
+	rows, err := reg.db.Query(`SELECT id, url, label FROM ItemPictures WHERE item = ? ORDER BY id`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var pictures []dataset.Picture
+	for rows.Next() {
+		var pic dataset.Picture
+		if err := rows.Scan(&pic.Id, &pic.Source, &pic.Legend); err != nil {
+			return nil, err
+		}
+		pictures = append(pictures, pic)
+	}
+	return pictures, rows.Err()
+}
+
+// getRelatedTaxons returns the taxons selected by a query on the id, name,
+// author and rank of items, keeping the order of the query.
+func (reg *DatasetRegistry) getRelatedTaxons(query string, args ...interface{}) ([]*dataset.Taxon, error) {
+	rows, err := reg.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	taxons := []*dataset.Taxon{}
+	for rows.Next() {
+		taxon := dataset.NewTaxon(&dataset.Hierarchy{})
+		if err := rows.Scan(&taxon.Id, &taxon.Name.Scientific, &taxon.Author, &taxon.Rank); err != nil {
+			return nil, err
+		}
+		taxons = append(taxons, taxon)
+	}
+	return taxons, rows.Err()
+}
+
+// GetTaxonAncestors returns the ancestors of a taxon from the top of the
+// hierarchy, the root being left out.
+func (reg *DatasetRegistry) GetTaxonAncestors(id string) ([]*dataset.Taxon, error) {
+	return reg.getRelatedTaxons(`SELECT Ancestor.id, Ancestor.name, Taxons.author, Taxons.rank
+		FROM Hierarchies
+		INNER JOIN Items Ancestor ON Ancestor.id = Hierarchies.ancestor
+		INNER JOIN Taxons ON Taxons.item = Ancestor.id
+		WHERE Hierarchies.descendant = ? AND Hierarchies.length > 0
+		AND EXISTS (SELECT 1 FROM Hierarchies Parent WHERE Parent.descendant = Ancestor.id AND Parent.length = 1)
+		ORDER BY Hierarchies.length DESC`, id)
+}
+
+func (reg *DatasetRegistry) GetTaxonChildren(id string) ([]*dataset.Taxon, error) {
+	return reg.getRelatedTaxons(`SELECT Child.id, Child.name, Taxons.author, Taxons.rank
+		FROM Hierarchies
+		INNER JOIN Items Child ON Child.id = Hierarchies.descendant
+		INNER JOIN Taxons ON Taxons.item = Child.id
+		WHERE Hierarchies.ancestor = ? AND Hierarchies.length = 1
+		ORDER BY Child.ord ASC`, id)
+}
+
+// CodedCharacter is a character with the states a taxon is coded with, Path
+// holding the characters it descends from.
+type CodedCharacter struct {
+	Character *dataset.Character
+	Path      []*dataset.Hierarchy
+	States    []*dataset.State
+}
+
+// GetTaxonCodedStates returns the characters a taxon is coded for, in the
+// order of the characters tree.
+func (reg *DatasetRegistry) GetTaxonCodedStates(id string) ([]*CodedCharacter, error) {
+	rows, err := reg.db.Query(`SELECT Character.id, Character.name, State.id, State.name, State.description
+		FROM TaxonStates
+		INNER JOIN States ON States.item = TaxonStates.state
+		INNER JOIN Items State ON State.id = States.item
+		INNER JOIN Items Character ON Character.id = States.character
+		WHERE TaxonStates.taxon = ?
+		ORDER BY Character.ord ASC, State.ord ASC`, id)
+	if err != nil {
+		return nil, err
+	}
+	coded := []*CodedCharacter{}
+	names := map[string]*dataset.MultilangText{}
+	for rows.Next() {
+		var charId, charName string
+		state := &dataset.State{Name: dataset.MultilangText{NamesByLangRef: map[string]string{}}}
+		if err := rows.Scan(&charId, &charName, &state.Id, &state.Name.Scientific, &state.Description); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if len(coded) == 0 || coded[len(coded)-1].Character.Id != charId {
+			coded = append(coded, &CodedCharacter{Character: dataset.NewCharacter(&dataset.Hierarchy{
+				Id:   charId,
+				Name: dataset.MultilangText{Scientific: charName, NamesByLangRef: map[string]string{}},
+			})})
+		}
+		last := coded[len(coded)-1]
+		last.States = append(last.States, state)
+		names[charId] = &last.Character.Name
+		names[state.Id] = &state.Name
+	}
+	rows.Close()
+	for _, c := range coded {
+		rows, err := reg.db.Query(`SELECT Ancestor.id, Ancestor.name
+			FROM Hierarchies
+			INNER JOIN Items Ancestor ON Ancestor.id = Hierarchies.ancestor
+			WHERE Hierarchies.descendant = ? AND Hierarchies.length > 0
+			AND EXISTS (SELECT 1 FROM Hierarchies Parent WHERE Parent.descendant = Ancestor.id AND Parent.length = 1)
+			ORDER BY Hierarchies.length DESC`, c.Character.Id)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			ancestor := &dataset.Hierarchy{Name: dataset.MultilangText{NamesByLangRef: map[string]string{}}}
+			if err := rows.Scan(&ancestor.Id, &ancestor.Name.Scientific); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			c.Path = append(c.Path, ancestor)
+			names[ancestor.Id] = &ancestor.Name
+		}
+		rows.Close()
+	}
+	if err := reg.fillItemNames(names); err != nil {
+		return nil, err
+	}
+	return coded, nil
+}
+
+// GetGlossary returns the glossary entries, their Scientific name and
+// definition being the first ones available in English, French or Chinese.
+func (reg *DatasetRegistry) GetGlossary() ([]*dataset.DictionaryEntry, error) {
 	op := NewDatabaseOperation(reg.db)
 	defer op.Close()
-	selectPics := op.TryPrepare(`SELECT url FROM ItemPictures;`)
-	insertCache := op.TryPrepare(`INSERT OR REPLACE INTO PictureCache (src, data) VALUES (?,?)`)
-	rows := op.TryQuery(selectPics)
-	ch := make(chan picture)
-	count := 0
+	selectEntries := op.TryPrepare(`SELECT Glossary.id, Glossary.url, IFNULL(GlossaryTexts.lang, ''),
+		IFNULL(GlossaryTexts.name, ''), IFNULL(GlossaryTexts.definition, '')
+		FROM Glossary
+		LEFT JOIN GlossaryTexts ON GlossaryTexts.entry = Glossary.id
+		ORDER BY Glossary.ord ASC, CASE GlossaryTexts.lang WHEN 'EN' THEN 0 WHEN 'FR' THEN 1 WHEN 'CN' THEN 2 ELSE 3 END`)
+	rows := op.TryQuery(selectEntries)
+	if op.HasFailed() {
+		return nil, op.Error()
+	}
+	defer rows.Close()
+	entries := []*dataset.DictionaryEntry{}
+	var last *dataset.DictionaryEntry
 	for rows.Next() {
-		count++
-		var url string
-		rows.Scan(&url)
-		go requestImage(url, ch)
-	}
-	for i := 0; i < count; i++ {
-		pic := <-ch
-		if pic.err != nil {
+		var id, url, lang, name, definition string
+		if err := rows.Scan(&id, &url, &lang, &name, &definition); err != nil {
+			return nil, err
+		}
+		if last == nil || last.Id != id {
+			last = &dataset.DictionaryEntry{
+				Id:         id,
+				Url:        url,
+				Name:       dataset.MultilangText{NamesByLangRef: map[string]string{}},
+				Definition: dataset.MultilangText{NamesByLangRef: map[string]string{}},
+			}
+			entries = append(entries, last)
+		}
+		if lang == "" {
 			continue
 		}
-		op.TryExec(insertCache, pic.url, pic.content)
+		if name != "" {
+			last.Name.NamesByLangRef[lang] = name
+			if last.Name.Scientific == "" {
+				last.Name.Scientific = name
+			}
+		}
+		if definition != "" {
+			last.Definition.NamesByLangRef[lang] = definition
+			if last.Definition.Scientific == "" {
+				last.Definition.Scientific = definition
+			}
+		}
 	}
-	return op.Error()
+	return entries, nil
+}
+
+type TaxonNameMatch struct {
+	Taxon   *dataset.Taxon
+	Synonym *dataset.Synonym
+}
+
+func likePattern(query string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(query)
+	return "%" + escaped + "%"
+}
+
+// SearchTaxonNames returns the taxons whose accepted name or one of whose
+// synonyms contains the query, with the matching synonym if any. An empty
+// query matches every accepted name. Taxons must also pass the filters.
+func (reg *DatasetRegistry) SearchTaxonNames(query string, filters []ExtraFilter) ([]*TaxonNameMatch, error) {
+	fields, err := reg.getExtraFieldsById()
+	if err != nil {
+		return nil, err
+	}
+	var filterSql strings.Builder
+	var filterArgs []interface{}
+	for _, filter := range filters {
+		cond, args, err := filter.sql(fields[filter.Field])
+		if err != nil {
+			return nil, err
+		}
+		filterSql.WriteString(" AND Taxon.id IN (SELECT taxon FROM TaxonExtraValues WHERE field = ? AND " + cond + ")")
+		filterArgs = append(append(filterArgs, filter.Field), args...)
+	}
+	op := NewDatabaseOperation(reg.db)
+	defer op.Close()
+	selectNames := op.TryPrepare(
+		`SELECT Taxon.id, Taxon.name, Taxons.author, Taxons.rank, Taxon.ord, 0, '', '', 0, ''
+		FROM Items Taxon
+		INNER JOIN Taxons ON Taxons.item = Taxon.id
+		WHERE Taxon.name LIKE ? ESCAPE '\'` + filterSql.String() + `
+		UNION ALL
+		SELECT Taxon.id, Taxon.name, Taxons.author, Taxons.rank, Taxon.ord, 1, Syn.name, Syn.author, Syn.year, Syn.status
+		FROM TaxonSynonyms Syn
+		INNER JOIN Items Taxon ON Taxon.id = Syn.taxon
+		INNER JOIN Taxons ON Taxons.item = Taxon.id
+		WHERE ? <> '' AND Syn.name LIKE ? ESCAPE '\'` + filterSql.String() + `
+		ORDER BY 5, 6, 7`)
+	pattern := likePattern(query)
+	args := append([]interface{}{pattern}, filterArgs...)
+	args = append(append(args, query, pattern), filterArgs...)
+	rows := op.TryQuery(selectNames, args...)
+	if op.HasFailed() {
+		return nil, op.Error()
+	}
+	defer rows.Close()
+	matches := []*TaxonNameMatch{}
+	for rows.Next() {
+		var ord, isSynonym int
+		var synonym dataset.Synonym
+		taxon := dataset.NewTaxon(&dataset.Hierarchy{})
+		err := rows.Scan(&taxon.Id, &taxon.Name.Scientific, &taxon.Author, &taxon.Rank, &ord, &isSynonym,
+			&synonym.Name, &synonym.Author, &synonym.Year, &synonym.Status)
+		if err != nil {
+			return nil, err
+		}
+		match := &TaxonNameMatch{Taxon: taxon}
+		if isSynonym == 1 {
+			match.Synonym = &synonym
+		}
+		matches = append(matches, match)
+	}
+	return matches, nil
+}
+
+// GetExtraFields returns the extra fields in the order of the dataset.
+func (reg *DatasetRegistry) GetExtraFields() ([]*dataset.ExtraField, error) {
+	op := NewDatabaseOperation(reg.db)
+	defer op.Close()
+	selectFields := op.TryPrepare(`SELECT id, std, label, icon, type, options FROM ExtraFields ORDER BY ord ASC`)
+	rows := op.TryQuery(selectFields)
+	if op.HasFailed() {
+		return nil, op.Error()
+	}
+	defer rows.Close()
+	fields := []*dataset.ExtraField{}
+	for rows.Next() {
+		field := &dataset.ExtraField{}
+		var options string
+		if err := rows.Scan(&field.Id, &field.IsStandard, &field.Label, &field.Icon, &field.Type, &options); err != nil {
+			return nil, err
+		}
+		if options != "" {
+			field.Options = strings.Split(options, "\n")
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+func (reg *DatasetRegistry) getExtraFieldsById() (map[string]*dataset.ExtraField, error) {
+	fields, err := reg.GetExtraFields()
+	if err != nil {
+		return nil, err
+	}
+	fieldsById := make(map[string]*dataset.ExtraField, len(fields))
+	for _, field := range fields {
+		fieldsById[field.Id] = field
+	}
+	return fieldsById, nil
+}
+
+// GetTaxonExtraValues returns the extra values of a taxon by field id, as
+// float64 for numbers and strings otherwise.
+func (reg *DatasetRegistry) GetTaxonExtraValues(taxonId string) (map[string]interface{}, error) {
+	op := NewDatabaseOperation(reg.db)
+	defer op.Close()
+	selectValues := op.TryPrepare(`SELECT field, value, number FROM TaxonExtraValues WHERE taxon = ?`)
+	rows := op.TryQuery(selectValues, taxonId)
+	if op.HasFailed() {
+		return nil, op.Error()
+	}
+	defer rows.Close()
+	values := map[string]interface{}{}
+	for rows.Next() {
+		var field, value string
+		var number sql.NullFloat64
+		if err := rows.Scan(&field, &value, &number); err != nil {
+			return nil, err
+		}
+		if number.Valid {
+			values[field] = number.Float64
+		} else {
+			values[field] = value
+		}
+	}
+	return values, nil
 }
 
 func (reg *DatasetRegistry) GetCachedImage(url string) ([]byte, bool) {
@@ -509,3 +1399,234 @@ func (reg *DatasetRegistry) GetCachedImage(url string) ([]byte, bool) {
 	fmt.Printf("No such image: %q", url)
 	return nil, false
 }
+
+// GetCharacterTree returns the characters below the root of the characters
+// hierarchy, with their descendants.
+func (reg *DatasetRegistry) GetCharacterTree() ([]*dataset.Hierarchy, error) {
+	return reg.getItemTree("Characters")
+}
+
+// GetTaxonTree returns the taxons below the root of the taxons hierarchy,
+// with their descendants.
+func (reg *DatasetRegistry) GetTaxonTree() ([]*dataset.Hierarchy, error) {
+	return reg.getItemTree("Taxons")
+}
+
+// getItemTree returns the hierarchies of the items of a table of taxons or
+// characters, leaving out their root.
+func (reg *DatasetRegistry) getItemTree(table string) ([]*dataset.Hierarchy, error) {
+	rows, err := reg.db.Query(`SELECT Item.id, Item.name, IFNULL(Parent.ancestor, '')
+		FROM Items Item
+		INNER JOIN ` + table + ` Kind ON Kind.item = Item.id
+		LEFT JOIN Hierarchies Parent ON Parent.descendant = Item.id AND Parent.length = 1
+		ORDER BY Item.ord ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	nodesById := map[string]*dataset.Hierarchy{}
+	var roots []*dataset.Hierarchy
+	var parentIds []string
+	var nodes []*dataset.Hierarchy
+	for rows.Next() {
+		node := &dataset.Hierarchy{Name: dataset.MultilangText{NamesByLangRef: map[string]string{}}}
+		var parentId string
+		if err := rows.Scan(&node.Id, &node.Name.Scientific, &parentId); err != nil {
+			return nil, err
+		}
+		nodesById[node.Id] = node
+		nodes = append(nodes, node)
+		parentIds = append(parentIds, parentId)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	names := make(map[string]*dataset.MultilangText, len(nodes))
+	for _, node := range nodes {
+		names[node.Id] = &node.Name
+	}
+	if err := reg.fillItemNames(names); err != nil {
+		return nil, err
+	}
+	for i, node := range nodes {
+		if parent, ok := nodesById[parentIds[i]]; ok {
+			parent.Children = append(parent.Children, node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+	tree := []*dataset.Hierarchy{}
+	for _, root := range roots {
+		tree = append(tree, root.Children...)
+	}
+	return tree, nil
+}
+
+// GetCharacterAncestors returns the ancestors of a character from the top of
+// the hierarchy, the root being left out.
+func (reg *DatasetRegistry) GetCharacterAncestors(id string) ([]*dataset.Hierarchy, error) {
+	rows, err := reg.db.Query(`SELECT Ancestor.id, Ancestor.name
+		FROM Hierarchies
+		INNER JOIN Items Ancestor ON Ancestor.id = Hierarchies.ancestor
+		WHERE Hierarchies.descendant = ? AND Hierarchies.length > 0
+		AND EXISTS (SELECT 1 FROM Hierarchies Parent WHERE Parent.descendant = Ancestor.id AND Parent.length = 1)
+		ORDER BY Hierarchies.length DESC`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ancestors := []*dataset.Hierarchy{}
+	for rows.Next() {
+		ancestor := &dataset.Hierarchy{Name: dataset.MultilangText{NamesByLangRef: map[string]string{}}}
+		if err := rows.Scan(&ancestor.Id, &ancestor.Name.Scientific); err != nil {
+			return nil, err
+		}
+		ancestors = append(ancestors, ancestor)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	names := make(map[string]*dataset.MultilangText, len(ancestors))
+	for _, ancestor := range ancestors {
+		names[ancestor.Id] = &ancestor.Name
+	}
+	return ancestors, reg.fillItemNames(names)
+}
+
+// GetCharacter returns a character with its names, pictures and states, nil
+// if there is none with this id.
+func (reg *DatasetRegistry) GetCharacter(id string) (*dataset.Character, error) {
+	character := dataset.NewCharacter(&dataset.Hierarchy{Name: dataset.MultilangText{NamesByLangRef: map[string]string{}}})
+	err := reg.db.QueryRow(`SELECT Character.id, Character.name, Character.description
+		FROM Items Character
+		INNER JOIN Characters ON Characters.item = Character.id
+		WHERE Character.id = ?`, id).Scan(&character.Id, &character.Name.Scientific, &character.Description)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if character.Name.NamesByLangRef, err = reg.getItemNames(id); err != nil {
+		return nil, err
+	}
+	if character.Pictures, err = reg.getItemPictures(id); err != nil {
+		return nil, err
+	}
+	rows, err := reg.db.Query(`SELECT State.id, State.name, State.description, States.color
+		FROM States
+		INNER JOIN Items State ON State.id = States.item
+		WHERE States.character = ?
+		ORDER BY State.ord ASC`, id)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		state := dataset.State{Name: dataset.MultilangText{NamesByLangRef: map[string]string{}}}
+		if err := rows.Scan(&state.Id, &state.Name.Scientific, &state.Description, &state.Color); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		character.States = append(character.States, state)
+	}
+	rows.Close()
+	for i := range character.States {
+		state := &character.States[i]
+		if state.Name.NamesByLangRef, err = reg.getItemNames(state.Id); err != nil {
+			return nil, err
+		}
+		if state.Pictures, err = reg.getItemPictures(state.Id); err != nil {
+			return nil, err
+		}
+	}
+	return character, nil
+}
+
+// StateDependency links a character to a state of another character.
+type StateDependency struct {
+	Character *dataset.Character
+	State     *dataset.State
+}
+
+func (reg *DatasetRegistry) getStateDependencies(query string, id string) ([]*StateDependency, error) {
+	rows, err := reg.db.Query(query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	deps := []*StateDependency{}
+	names := map[string]*dataset.MultilangText{}
+	for rows.Next() {
+		dep := &StateDependency{
+			Character: dataset.NewCharacter(&dataset.Hierarchy{Name: dataset.MultilangText{NamesByLangRef: map[string]string{}}}),
+			State:     &dataset.State{Name: dataset.MultilangText{NamesByLangRef: map[string]string{}}},
+		}
+		if err := rows.Scan(&dep.Character.Id, &dep.Character.Name.Scientific, &dep.State.Id, &dep.State.Name.Scientific); err != nil {
+			return nil, err
+		}
+		deps = append(deps, dep)
+		names[dep.Character.Id] = &dep.Character.Name
+		names[dep.State.Id] = &dep.State.Name
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	return deps, reg.fillItemNames(names)
+}
+
+// GetCharacterDependencies returns the states with their characters that a
+// character requires, and those making it inapplicable.
+func (reg *DatasetRegistry) GetCharacterDependencies(id string) (required []*StateDependency, inapplicable []*StateDependency, err error) {
+	query := `SELECT Owner.id, Owner.name, State.id, State.name
+		FROM %s Dependency
+		INNER JOIN Items State ON State.id = Dependency.state
+		INNER JOIN States ON States.item = State.id
+		INNER JOIN Items Owner ON Owner.id = States.character
+		WHERE Dependency.character = ?
+		ORDER BY Owner.ord ASC, State.ord ASC`
+	if required, err = reg.getStateDependencies(fmt.Sprintf(query, "CharacterRequiredStates"), id); err != nil {
+		return nil, nil, err
+	}
+	if inapplicable, err = reg.getStateDependencies(fmt.Sprintf(query, "CharacterInapplicableStates"), id); err != nil {
+		return nil, nil, err
+	}
+	return required, inapplicable, nil
+}
+
+// GetDependentCharacters returns the characters requiring a state of the
+// given character, with that state.
+func (reg *DatasetRegistry) GetDependentCharacters(id string) ([]*StateDependency, error) {
+	return reg.getStateDependencies(`SELECT Dependent.id, Dependent.name, State.id, State.name
+		FROM CharacterRequiredStates Dependency
+		INNER JOIN States ON States.item = Dependency.state
+		INNER JOIN Items State ON State.id = States.item
+		INNER JOIN Items Dependent ON Dependent.id = Dependency.character
+		WHERE States.character = ?
+		ORDER BY Dependent.ord ASC, State.ord ASC`, id)
+}
+
+// GetStateTaxons returns the taxons coded with each state of a character.
+func (reg *DatasetRegistry) GetStateTaxons(characterId string) (map[string][]*dataset.Taxon, error) {
+	rows, err := reg.db.Query(`SELECT TaxonStates.state, Taxon.id, Taxon.name, Taxons.author, Taxons.rank
+		FROM States
+		INNER JOIN TaxonStates ON TaxonStates.state = States.item
+		INNER JOIN Items Taxon ON Taxon.id = TaxonStates.taxon
+		INNER JOIN Taxons ON Taxons.item = Taxon.id
+		WHERE States.character = ?
+		ORDER BY Taxon.ord ASC`, characterId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	taxonsByState := map[string][]*dataset.Taxon{}
+	for rows.Next() {
+		var stateId string
+		taxon := dataset.NewTaxon(&dataset.Hierarchy{})
+		if err := rows.Scan(&stateId, &taxon.Id, &taxon.Name.Scientific, &taxon.Author, &taxon.Rank); err != nil {
+			return nil, err
+		}
+		taxonsByState[stateId] = append(taxonsByState[stateId], taxon)
+	}
+	return taxonsByState, rows.Err()
+}
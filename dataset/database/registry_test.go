@@ -0,0 +1,190 @@
+package database
+
+import (
+	"strings"
+	"testing"
+
+	"nicolas.galipot.net/taxonomia/dataset"
+)
+
+const registryFixture = `{
+	"id": "ds",
+	"taxons": [
+		{ "id": "f1", "name": "Fagaceae", "extra": { "rank": "family" }, "children": ["g1"] },
+		{ "id": "g1", "name": "Quercus", "extra": { "rank": "genus" }, "children": ["t2", "t1"] },
+		{ "id": "t1", "name": "Quercus robur", "descriptions": [
+			{ "descriptorId": "c2", "statesIds": ["s3"] },
+			{ "descriptorId": "c1", "statesIds": ["s2", "s1"] },
+			{ "descriptorId": "c3", "statesIds": ["s4"] }
+		] },
+		{ "id": "t2", "name": "Quercus ilex", "descriptions": [ { "descriptorId": "c1", "statesIds": ["s2"] } ] }
+	],
+	"characters": [
+		{ "id": "c1", "name": "Leaf", "children": ["c3"], "states": ["s1", "s2"] },
+		{ "id": "c2", "name": "Bark", "states": ["s3"], "inapplicablestatesids": ["s2"] },
+		{ "id": "c3", "name": "Leaf margin", "states": ["s4"], "requiredStatesIds": ["s1"] }
+	],
+	"states": [
+		{ "id": "s1", "name": "lobed", "nameEN": "Lobed" },
+		{ "id": "s2", "name": "entire" },
+		{ "id": "s3", "name": "smooth" },
+		{ "id": "s4", "name": "toothed" }
+	]
+}`
+
+func taxonIds(taxons []*dataset.Taxon) string {
+	ids := make([]string, len(taxons))
+	for i, taxon := range taxons {
+		ids[i] = taxon.Id
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestGetTaxonAncestors(t *testing.T) {
+	reg := newDatasetTestRegistry(t, registryFixture)
+	ancestors, err := reg.GetTaxonAncestors("t1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := taxonIds(ancestors); got != "f1,g1" {
+		t.Errorf("Expected the ancestors from the top, without the root, got %q.", got)
+	}
+	if ancestors[1].Rank != dataset.RankGenus || ancestors[1].Name.Scientific != "Quercus" {
+		t.Errorf("Expected the genus Quercus, got %+v.", ancestors[1])
+	}
+	if ancestors, err := reg.GetTaxonAncestors("f1"); err != nil || len(ancestors) != 0 {
+		t.Errorf("Expected a top taxon to have no ancestors, got %q and %v.", taxonIds(ancestors), err)
+	}
+}
+
+func TestGetTaxonChildren(t *testing.T) {
+	reg := newDatasetTestRegistry(t, registryFixture)
+	children, err := reg.GetTaxonChildren("g1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := taxonIds(children); got != "t2,t1" {
+		t.Errorf("Expected the children in the dataset order, got %q.", got)
+	}
+	if children, err := reg.GetTaxonChildren("f1"); err != nil || taxonIds(children) != "g1" {
+		t.Errorf("Expected only the direct children, got %q and %v.", taxonIds(children), err)
+	}
+	if children, err := reg.GetTaxonChildren("t1"); err != nil || len(children) != 0 {
+		t.Errorf("Expected a leaf to have no children, got %q and %v.", taxonIds(children), err)
+	}
+}
+
+func TestGetTaxonCodedStates(t *testing.T) {
+	reg := newDatasetTestRegistry(t, registryFixture)
+	coded, err := reg.GetTaxonCodedStates("t1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, c := range coded {
+		path := make([]string, len(c.Path))
+		for i, node := range c.Path {
+			path[i] = node.Id
+		}
+		states := make([]string, len(c.States))
+		for i, state := range c.States {
+			states[i] = state.Id
+		}
+		got = append(got, strings.Join(path, ">")+"/"+c.Character.Id+":"+strings.Join(states, ","))
+	}
+	// Characters follow the characters tree, whatever the coding order.
+	expected := "/c1:s1,s2 c1/c3:s4 /c2:s3"
+	if strings.Join(got, " ") != expected {
+		t.Errorf("Expected coded characters %q, got %q.", expected, strings.Join(got, " "))
+	}
+	if len(coded) > 0 && coded[0].States[0].Name.Text("EN") != "Lobed" {
+		t.Errorf("Expected the names of the states in every language, got %+v.", coded[0].States[0].Name)
+	}
+	if coded, err := reg.GetTaxonCodedStates("g1"); err != nil || len(coded) != 0 {
+		t.Errorf("Expected no coded characters for g1, got %d and %v.", len(coded), err)
+	}
+}
+
+func hierarchyIds(nodes []*dataset.Hierarchy) string {
+	ids := make([]string, len(nodes))
+	for i, node := range nodes {
+		ids[i] = node.Id
+		if len(node.Children) > 0 {
+			ids[i] += "(" + hierarchyIds(node.Children) + ")"
+		}
+	}
+	return strings.Join(ids, ",")
+}
+
+func dependencyIds(deps []*StateDependency) string {
+	ids := make([]string, len(deps))
+	for i, dep := range deps {
+		ids[i] = dep.Character.Id + ":" + dep.State.Id
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestGetCharacterTree(t *testing.T) {
+	reg := newDatasetTestRegistry(t, registryFixture)
+	tree, err := reg.GetCharacterTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hierarchyIds(tree); got != "c1(c3),c2" {
+		t.Errorf("Expected the characters below the root, got %q.", got)
+	}
+	if len(tree) > 0 && tree[0].Name.Scientific != "Leaf" {
+		t.Errorf("Expected the names of the characters, got %+v.", tree[0].Name)
+	}
+}
+
+func TestGetCharacterDependencies(t *testing.T) {
+	reg := newDatasetTestRegistry(t, registryFixture)
+	required, inapplicable, err := reg.GetCharacterDependencies("c3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dependencyIds(required) != "c1:s1" || len(inapplicable) != 0 {
+		t.Errorf("Expected c3 to require s1 of c1, got %q and %q.", dependencyIds(required), dependencyIds(inapplicable))
+	}
+	if len(required) > 0 && (required[0].Character.Name.Scientific != "Leaf" || required[0].State.Name.Text("EN") != "Lobed") {
+		t.Errorf("Expected the names of the dependency, got %+v and %+v.", required[0].Character.Name, required[0].State.Name)
+	}
+	required, inapplicable, err = reg.GetCharacterDependencies("c2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(required) != 0 || dependencyIds(inapplicable) != "c1:s2" {
+		t.Errorf("Expected c2 to be inapplicable with s2 of c1, got %q and %q.", dependencyIds(required), dependencyIds(inapplicable))
+	}
+}
+
+func TestGetDependentCharacters(t *testing.T) {
+	reg := newDatasetTestRegistry(t, registryFixture)
+	deps, err := reg.GetDependentCharacters("c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Characters made inapplicable by a state do not depend on it.
+	if got := dependencyIds(deps); got != "c3:s1" {
+		t.Errorf("Expected c3 to depend on s1, got %q.", got)
+	}
+	if deps, err := reg.GetDependentCharacters("c3"); err != nil || len(deps) != 0 {
+		t.Errorf("Expected no characters depending on c3, got %q and %v.", dependencyIds(deps), err)
+	}
+}
+
+func TestGetStateTaxons(t *testing.T) {
+	reg := newDatasetTestRegistry(t, registryFixture)
+	taxonsByState, err := reg.GetStateTaxons("c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(taxonsByState) != 2 || taxonIds(taxonsByState["s1"]) != "t1" || taxonIds(taxonsByState["s2"]) != "t2,t1" {
+		t.Errorf("Expected the taxons of each state in the dataset order, got %q and %q.",
+			taxonIds(taxonsByState["s1"]), taxonIds(taxonsByState["s2"]))
+	}
+	if taxonsByState, err := reg.GetStateTaxons("c2"); err != nil || taxonIds(taxonsByState["s3"]) != "t1" {
+		t.Errorf("Expected t1 to be smooth, got %q and %v.", taxonIds(taxonsByState["s3"]), err)
+	}
+}
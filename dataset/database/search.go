@@ -0,0 +1,303 @@
+package database
+
+import (
+	"database/sql"
+	"log"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	SearchKindTaxon     = "taxon"
+	SearchKindCharacter = "character"
+	SearchKindState     = "state"
+)
+
+var SearchKinds = []string{SearchKindTaxon, SearchKindCharacter, SearchKindState}
+
+// Matched words are delimited by these runes in snippets, as they cannot
+// appear in dataset texts.
+const (
+	SnippetStart = "\x02"
+	SnippetEnd   = "\x03"
+)
+
+const (
+	searchLimit      = 50
+	snippetRunes     = 80
+	snippetFtsTokens = 12
+)
+
+// SearchResult is an item matching a search, with the best matching text.
+type SearchResult struct {
+	ItemId  string
+	Kind    string
+	Name    string
+	Lang    string
+	Field   string
+	Snippet string
+	// CharacterId is the character owning a state result.
+	CharacterId string
+}
+
+// createSearchIndex creates the full-text index over the search entries if
+// the SQLite library provides FTS5, searches falling back to LIKE otherwise.
+func createSearchIndex(db *sql.DB) {
+	_, err := db.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS SearchIndex USING fts5(text, content='SearchEntries', content_rowid='id',
+		tokenize='unicode61 remove_diacritics 2');`)
+	if err != nil {
+		log.Printf("Full-text index unavailable, build with -tags sqlite_fts5 to enable it: %q.\n", err.Error())
+	}
+}
+
+func (reg *DatasetRegistry) hasFullTextIndex() bool {
+	var count int
+	err := reg.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'SearchIndex'`).Scan(&count)
+	if err != nil || count == 0 {
+		return false
+	}
+	_, err = reg.db.Exec(`SELECT rowid FROM SearchIndex LIMIT 0`)
+	return err == nil
+}
+
+// searchEntriesQuery selects the search entries of the items matching a
+// condition on Items.id, synonyms being selected by a condition on
+// TaxonSynonyms.taxon.
+func searchEntriesQuery(itemCond string, synonymCond string) string {
+	kinds := `CASE
+			WHEN Items.id IN (SELECT item FROM Taxons) THEN 'taxon'
+			WHEN Items.id IN (SELECT item FROM Characters) THEN 'character'
+			ELSE 'state' END`
+	return `INSERT INTO SearchEntries (item, kind, lang, field, text)
+		SELECT Items.id, ` + kinds + `, 'NS', 'name', Items.name FROM Items WHERE Items.name <> '' AND ` + itemCond + `
+		UNION ALL
+		SELECT Items.id, ` + kinds + `, ItemNames.lang, 'name', ItemNames.text FROM Items
+		INNER JOIN ItemNames ON ItemNames.item = Items.id
+		WHERE ItemNames.text <> '' AND ItemNames.text <> Items.name AND ` + itemCond + `
+		UNION ALL
+		SELECT Items.id, ` + kinds + `, '', 'description', Items.description FROM Items WHERE Items.description <> '' AND ` + itemCond + `
+		UNION ALL
+		SELECT TaxonSynonyms.taxon, 'taxon', 'NS', 'synonym', TaxonSynonyms.name FROM TaxonSynonyms WHERE ` + synonymCond
+}
+
+// indexItems fills the search entries with the names in every language,
+// the descriptions and the synonyms of items, leaving out the given roots.
+func indexItems(op *DatabaseOperation, fullText bool, rootIds ...string) error {
+	op.TryExec(op.TryPrepare(`DELETE FROM SearchEntries`))
+	excluded := `Items.id NOT IN (` + inLen(len(rootIds)) + `)`
+	args := strSliceToInterface(rootIds)
+	args = append(append(args, args...), args...)
+	op.TryExec(op.TryPrepare(searchEntriesQuery(excluded, "1")), args...)
+	if op.HasFailed() {
+		return op.Error()
+	}
+	if fullText {
+		op.TryExec(op.TryPrepare(`INSERT INTO SearchIndex(SearchIndex) VALUES('rebuild')`))
+	}
+	return op.Error()
+}
+
+// unindexItems removes the search entries of items, from the full-text
+// index too.
+func unindexItems(op *DatabaseOperation, fullText bool, ids []string) {
+	args := strSliceToInterface(ids)
+	if fullText {
+		op.TryExec(op.TryPrepare(`INSERT INTO SearchIndex(SearchIndex, rowid, text)
+			SELECT 'delete', id, text FROM SearchEntries WHERE item IN (`+inLen(len(ids))+`)`), args...)
+	}
+	op.TryExec(op.TryPrepare(`DELETE FROM SearchEntries WHERE item IN (`+inLen(len(ids))+`)`), args...)
+}
+
+// reindexItems replaces the search entries of items that were edited.
+func reindexItems(op *DatabaseOperation, fullText bool, ids ...string) {
+	unindexItems(op, fullText, ids)
+	in := inLen(len(ids))
+	args := strSliceToInterface(ids)
+	var queryArgs []interface{}
+	for i := 0; i < 4; i++ {
+		queryArgs = append(queryArgs, args...)
+	}
+	op.TryExec(op.TryPrepare(searchEntriesQuery(`Items.id IN (`+in+`)`, `TaxonSynonyms.taxon IN (`+in+`)`)), queryArgs...)
+	if fullText {
+		op.TryExec(op.TryPrepare(`INSERT INTO SearchIndex(rowid, text)
+			SELECT id, text FROM SearchEntries WHERE item IN (`+in+`)`), args...)
+	}
+}
+
+func searchTokens(query string) []string {
+	return strings.FieldsFunc(query, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// ftsQuery turns the words of a query into prefixes that must all match.
+func ftsQuery(tokens []string) string {
+	parts := make([]string, len(tokens))
+	for i, token := range tokens {
+		parts[i] = `"` + token + `"*`
+	}
+	return strings.Join(parts, " ")
+}
+
+// searchFilters returns the conditions on kinds and language, language
+// neutral entries matching any language.
+func searchFilters(kinds []string, lang string) (string, []interface{}) {
+	var cond strings.Builder
+	var args []interface{}
+	if len(kinds) > 0 {
+		cond.WriteString(" AND SearchEntries.kind IN (" + inLen(len(kinds)) + ")")
+		args = append(args, strSliceToInterface(kinds)...)
+	}
+	if lang != "" {
+		cond.WriteString(" AND SearchEntries.lang IN (?, 'NS', '')")
+		args = append(args, lang)
+	}
+	return cond.String(), args
+}
+
+// Search returns the taxons, characters and states whose names, descriptions
+// or synonyms contain every word of the query, best matches first. With the
+// full-text index, only available when built with -tags sqlite_fts5, words
+// match as prefixes and results are ranked; otherwise they match anywhere
+// with LIKE. Kinds restrict the results to some kinds of items, lang to the
+// names in one language besides scientific ones, empty values allowing all.
+func (reg *DatasetRegistry) Search(query string, kinds []string, lang string) ([]*SearchResult, error) {
+	tokens := searchTokens(query)
+	if len(tokens) == 0 {
+		return []*SearchResult{}, nil
+	}
+	filters, filterArgs := searchFilters(kinds, lang)
+	var rows *sql.Rows
+	var err error
+	fullText := reg.hasFullTextIndex()
+	if fullText {
+		args := append([]interface{}{ftsQuery(tokens)}, filterArgs...)
+		rows, err = reg.db.Query(`SELECT SearchEntries.item, SearchEntries.kind, Items.name, SearchEntries.lang, SearchEntries.field,
+			IFNULL(States.character, ''), snippet(SearchIndex, 0, '`+SnippetStart+`', '`+SnippetEnd+`', '…', `+strconv.Itoa(snippetFtsTokens)+`)
+			FROM SearchIndex
+			INNER JOIN SearchEntries ON SearchEntries.id = SearchIndex.rowid
+			INNER JOIN Items ON Items.id = SearchEntries.item
+			LEFT JOIN States ON States.item = SearchEntries.item
+			WHERE SearchIndex MATCH ?`+filters+`
+			ORDER BY bm25(SearchIndex) * CASE SearchEntries.field WHEN 'name' THEN 4 WHEN 'synonym' THEN 2 ELSE 1 END, Items.ord`,
+			args...)
+	} else {
+		var cond strings.Builder
+		var args []interface{}
+		for _, token := range tokens {
+			cond.WriteString(` AND SearchEntries.text LIKE ? ESCAPE '\'`)
+			args = append(args, likePattern(token))
+		}
+		exact := strings.TrimSuffix(likePattern(strings.TrimSpace(query))[1:], "%")
+		args = append(append(args, filterArgs...), exact, likePattern(tokens[0])[1:])
+		rows, err = reg.db.Query(`SELECT SearchEntries.item, SearchEntries.kind, Items.name, SearchEntries.lang, SearchEntries.field,
+			IFNULL(States.character, ''), SearchEntries.text
+			FROM SearchEntries
+			INNER JOIN Items ON Items.id = SearchEntries.item
+			LEFT JOIN States ON States.item = SearchEntries.item
+			WHERE 1`+cond.String()+filters+`
+			ORDER BY CASE SearchEntries.field WHEN 'name' THEN 0 WHEN 'synonym' THEN 1 ELSE 2 END,
+				CASE WHEN SearchEntries.text LIKE ? ESCAPE '\' THEN 0 WHEN SearchEntries.text LIKE ? ESCAPE '\' THEN 1 ELSE 2 END,
+				Items.ord`,
+			args...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := []*SearchResult{}
+	seen := map[string]bool{}
+	for rows.Next() && len(results) < searchLimit {
+		r := &SearchResult{}
+		if err := rows.Scan(&r.ItemId, &r.Kind, &r.Name, &r.Lang, &r.Field, &r.CharacterId, &r.Snippet); err != nil {
+			return nil, err
+		}
+		if seen[r.ItemId] {
+			continue
+		}
+		seen[r.ItemId] = true
+		if !fullText {
+			r.Snippet = highlightSnippet(r.Snippet, tokens)
+		}
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
+
+// hasFoldPrefix tells if a text starts with a prefix under Unicode case
+// folding, comparing runes as their case variants may differ in length.
+func hasFoldPrefix(text string, prefix string) bool {
+	for _, p := range prefix {
+		r, size := utf8.DecodeRuneInString(text)
+		if size == 0 || !strings.EqualFold(string(r), string(p)) {
+			return false
+		}
+		text = text[size:]
+	}
+	return true
+}
+
+// highlightSnippet cuts a text around the first word starting with a token,
+// marking the words starting with any token.
+func highlightSnippet(text string, tokens []string) string {
+	words := []struct{ start, end int }{}
+	start := -1
+	for i, r := range text {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if isWord && start < 0 {
+			start = i
+		} else if !isWord && start >= 0 {
+			words = append(words, struct{ start, end int }{start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		words = append(words, struct{ start, end int }{start, len(text)})
+	}
+	matches := func(word string) bool {
+		for _, token := range tokens {
+			if hasFoldPrefix(word, token) {
+				return true
+			}
+		}
+		return false
+	}
+	from, to := 0, len(text)
+	for _, w := range words {
+		if matches(text[w.start:w.end]) {
+			if utf8.RuneCountInString(text) > snippetRunes {
+				from = w.start
+				for back := 0; from > 0 && back < snippetRunes/4; back++ {
+					_, size := utf8.DecodeLastRuneInString(text[:from])
+					from -= size
+				}
+				to = from
+				for n := 0; to < len(text) && n < snippetRunes; n++ {
+					_, size := utf8.DecodeRuneInString(text[to:])
+					to += size
+				}
+			}
+			break
+		}
+	}
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("…")
+	}
+	last := from
+	for _, w := range words {
+		if w.start < from || w.end > to || !matches(text[w.start:w.end]) {
+			continue
+		}
+		b.WriteString(text[last:w.start])
+		b.WriteString(SnippetStart + text[w.start:w.end] + SnippetEnd)
+		last = w.end
+	}
+	b.WriteString(text[last:to])
+	if to < len(text) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
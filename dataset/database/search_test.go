@@ -0,0 +1,192 @@
+package database
+
+import (
+	"database/sql"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"nicolas.galipot.net/taxonomia/dataset"
+)
+
+const searchFixture = `{
+	"id": "ds",
+	"taxons": [
+		{ "id": "g1", "name": "Acer", "nameEN": "Maple", "extra": { "rank": "genus" }, "children": ["t1", "t2"] },
+		{ "id": "t1", "name": "Acer saccharum", "nameEN": "Sugar maple", "name2": "Acer barbatum", "detail": "Its sap is boiled into syrup." },
+		{ "id": "t2", "name": "Acer platanoides", "nameEN": "Norway maple", "nameCN": "挪威槭" }
+	],
+	"characters": [ { "id": "c1", "name": "Leaf margin", "states": ["s1", "s2"] } ],
+	"states": [ { "id": "s1", "name": "toothed" }, { "id": "s2", "name": "entire", "nameEN": "Entire" } ]
+}`
+
+// newDatasetTestRegistry returns a registry of a new database holding the
+// dataset of a Hazo document.
+func newDatasetTestRegistry(t *testing.T, hazo string) *DatasetRegistry {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "db.sq3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := CreateTables(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := InsertStandardContent(db); err != nil {
+		t.Fatal(err)
+	}
+	ds, err := dataset.ReadHazo(strings.NewReader(hazo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := NewRegistry(db)
+	if err := reg.InsertDataset(ds); err != nil {
+		t.Fatal(err)
+	}
+	return reg
+}
+
+func resultIds(results []*SearchResult) string {
+	ids := make([]string, len(results))
+	for i, r := range results {
+		ids[i] = r.ItemId
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestIndexItems(t *testing.T) {
+	reg := newDatasetTestRegistry(t, searchFixture)
+	rows, err := reg.db.Query(`SELECT item, kind, lang, field, text FROM SearchEntries`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var entries []string
+	for rows.Next() {
+		var item, kind, lang, field, text string
+		if err := rows.Scan(&item, &kind, &lang, &field, &text); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, strings.Join([]string{item, kind, lang, field, text}, "|"))
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(entries)
+	expected := []string{
+		"c1|character|NS|name|Leaf margin",
+		"g1|taxon|EN|name|Maple",
+		"g1|taxon|NS|name|Acer",
+		"s1|state|NS|name|toothed",
+		"s2|state|EN|name|Entire",
+		"s2|state|NS|name|entire",
+		"t1|taxon||description|Its sap is boiled into syrup.",
+		"t1|taxon|EN|name|Sugar maple",
+		"t1|taxon|NS|name|Acer saccharum",
+		"t1|taxon|NS|synonym|Acer barbatum",
+		"t2|taxon|CN|name|挪威槭",
+		"t2|taxon|EN|name|Norway maple",
+		"t2|taxon|NS|name|Acer platanoides",
+	}
+	sort.Strings(expected)
+	if got, want := strings.Join(entries, "\n"), strings.Join(expected, "\n"); got != want {
+		t.Errorf("Wrong search entries, the roots must be left out and names equal to the scientific one skipped.\nexpected\n%s\ngot\n%s", want, got)
+	}
+}
+
+// checkSearch runs the searches whose results do not depend on the full-text
+// index being available.
+func checkSearch(t *testing.T, reg *DatasetRegistry) {
+	search := func(query string, kinds []string, lang string) []*SearchResult {
+		results, err := reg.Search(query, kinds, lang)
+		if err != nil {
+			t.Fatalf("Search %q: %v.", query, err)
+		}
+		return results
+	}
+	results := search("maple", nil, "")
+	if ids := strings.Split(resultIds(results), ","); len(ids) != 3 || ids[0] != "g1" {
+		t.Errorf("Expected the 3 maples, the exact name first, got %q.", resultIds(results))
+	}
+	if results := search("sap syrup", nil, ""); len(results) != 1 || results[0].ItemId != "t1" || results[0].Field != "description" {
+		t.Errorf("Expected t1 to match by its description, got %q.", resultIds(results))
+	} else if snippet := results[0].Snippet; !strings.Contains(snippet, SnippetStart+"sap"+SnippetEnd) ||
+		!strings.Contains(snippet, SnippetStart+"syrup"+SnippetEnd) {
+		t.Errorf("Expected the words of the query to be marked, got %q.", snippet)
+	}
+	if results := search("barbatum", nil, ""); len(results) != 1 || results[0].Field != "synonym" || results[0].Name != "Acer saccharum" {
+		t.Errorf("Expected t1 to match by its synonym, got %q.", resultIds(results))
+	}
+	if results := search("toothed", []string{SearchKindState}, ""); len(results) != 1 || results[0].CharacterId != "c1" {
+		t.Errorf("Expected state s1 of c1, got %q.", resultIds(results))
+	}
+	if results := search("acer", []string{SearchKindCharacter}, ""); len(results) != 0 {
+		t.Errorf("Expected no character named acer, got %q.", resultIds(results))
+	}
+	if results := search("maple", nil, "CN"); len(results) != 0 {
+		t.Errorf("Expected the English names to be left out, got %q.", resultIds(results))
+	}
+	if results := search("挪威槭", nil, "CN"); resultIds(results) != "t2" {
+		t.Errorf("Expected t2 by its Chinese name, got %q.", resultIds(results))
+	}
+	if results := search(" -- ", nil, ""); len(results) != 0 {
+		t.Errorf("Expected no results without words, got %q.", resultIds(results))
+	}
+}
+
+func TestSearchWithoutFullTextIndex(t *testing.T) {
+	reg := newDatasetTestRegistry(t, searchFixture)
+	if _, err := reg.db.Exec(`DROP TABLE IF EXISTS SearchIndex`); err != nil {
+		t.Fatal(err)
+	}
+	if reg.hasFullTextIndex() {
+		t.Fatal("Expected the full-text index to be dropped.")
+	}
+	checkSearch(t, reg)
+	if results, _ := reg.Search("maple", nil, ""); resultIds(results) != "g1,t1,t2" {
+		t.Errorf("Expected exact names first then the dataset order, got %q.", resultIds(results))
+	}
+}
+
+func TestSearchWithFullTextIndex(t *testing.T) {
+	reg := newDatasetTestRegistry(t, searchFixture)
+	if !reg.hasFullTextIndex() {
+		t.Skip("SQLite was built without FTS5, run the tests with -tags sqlite_fts5.")
+	}
+	checkSearch(t, reg)
+	if results, _ := reg.Search("sacch", nil, ""); resultIds(results) != "t1" {
+		t.Errorf("Expected words to match as prefixes, got %q.", resultIds(results))
+	}
+}
+
+func TestHighlightSnippet(t *testing.T) {
+	long := strings.Repeat("word ", 30) + "target " + strings.Repeat("word ", 30)
+	cases := []struct {
+		text   string
+		tokens []string
+		want   string
+	}{
+		{"Sugar maple", []string{"map"}, "Sugar " + SnippetStart + "maple" + SnippetEnd},
+		{"Acer saccharum", []string{"ACER", "sac"}, SnippetStart + "Acer" + SnippetEnd + " " + SnippetStart + "saccharum" + SnippetEnd},
+		{"Érable à sucre", []string{"éra"}, SnippetStart + "Érable" + SnippetEnd + " à sucre"},
+		// The Kelvin sign is longer than the k it folds to.
+		{"\u212Aelvin", []string{"kel"}, SnippetStart + "\u212Aelvin" + SnippetEnd},
+		// The first rune of the word is as long as the token but differs.
+		{"éa", []string{"ab"}, "éa"},
+		{"ab", []string{"é"}, "ab"},
+		{"no match", []string{"other"}, "no match"},
+	}
+	for _, c := range cases {
+		if got := highlightSnippet(c.text, c.tokens); got != c.want {
+			t.Errorf("highlightSnippet(%q, %q): expected %q, got %q.", c.text, c.tokens, c.want, got)
+		}
+	}
+	got := highlightSnippet(long, []string{"target"})
+	if !strings.HasPrefix(got, "…") || !strings.HasSuffix(got, "…") || !strings.Contains(got, SnippetStart+"target"+SnippetEnd) {
+		t.Errorf("Expected a long text to be cut around the match, got %q.", got)
+	}
+	if n := len([]rune(got)); n > snippetRunes+4 {
+		t.Errorf("Expected at most %d runes, got %d.", snippetRunes, n)
+	}
+}
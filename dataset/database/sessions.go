@@ -0,0 +1,226 @@
+package database
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+// IdentificationAnswer is a character answered during an identification
+// with the states observed, none when it was passed.
+type IdentificationAnswer struct {
+	CharacterId string
+	StateIds    []string
+}
+
+// IdentificationSession is the stored state of an identification. Code is
+// a short code to resume it from another device, Owner identifies whoever
+// started it.
+type IdentificationSession struct {
+	Id      string
+	Code    string
+	Owner   string
+	Rank    string
+	Answers []IdentificationAnswer
+	Created time.Time
+	Updated time.Time
+	Expires time.Time
+}
+
+// Session codes are typed by hand, so they leave out letters and digits
+// that are easily confused.
+const (
+	sessionCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	sessionCodeLength   = 6
+	sessionCodeAttempts = 10
+)
+
+func randomSessionId() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func randomSessionCode() (string, error) {
+	b := make([]byte, sessionCodeLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		b[i] = sessionCodeAlphabet[int(b[i])%len(sessionCodeAlphabet)]
+	}
+	return string(b), nil
+}
+
+// NormalizeSessionCode returns a session code as stored, whatever the case
+// and spacing it was typed with.
+func NormalizeSessionCode(code string) string {
+	return strings.ToUpper(strings.Join(strings.Fields(code), ""))
+}
+
+// CreateIdentificationSession stores a new empty session expiring after the
+// given lifetime, with a unique id and code.
+func (reg *DatasetRegistry) CreateIdentificationSession(owner string, lifetime time.Duration) (*IdentificationSession, error) {
+	if _, err := reg.DeleteExpiredIdentificationSessions(); err != nil {
+		return nil, err
+	}
+	id, err := randomSessionId()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().Truncate(time.Second)
+	session := &IdentificationSession{
+		Id:      id,
+		Owner:   owner,
+		Answers: []IdentificationAnswer{},
+		Created: now,
+		Updated: now,
+		Expires: now.Add(lifetime),
+	}
+	for attempt := 0; attempt < sessionCodeAttempts; attempt++ {
+		if session.Code, err = randomSessionCode(); err != nil {
+			return nil, err
+		}
+		_, err = reg.db.Exec(`INSERT OR IGNORE INTO IdentificationSessions (id, code, owner, rank, created, updated, expires)
+			VALUES (?, ?, ?, '', ?, ?, ?)`, session.Id, session.Code, owner, now.Unix(), now.Unix(), session.Expires.Unix())
+		if err != nil {
+			return nil, err
+		}
+		stored, err := reg.getIdentificationSession(`id = ?`, session.Id)
+		if err != nil || stored != nil {
+			return stored, err
+		}
+	}
+	return nil, errors.New("cannot find an unused session code")
+}
+
+func (reg *DatasetRegistry) getIdentificationSession(cond string, arg interface{}) (*IdentificationSession, error) {
+	session := &IdentificationSession{Answers: []IdentificationAnswer{}}
+	var created, updated, expires int64
+	err := reg.db.QueryRow(`SELECT id, code, owner, rank, created, updated, expires
+		FROM IdentificationSessions WHERE `+cond+` AND expires > ?`, arg, time.Now().Unix()).
+		Scan(&session.Id, &session.Code, &session.Owner, &session.Rank, &created, &updated, &expires)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	session.Created, session.Updated, session.Expires = time.Unix(created, 0), time.Unix(updated, 0), time.Unix(expires, 0)
+	rows, err := reg.db.Query(`SELECT character, states FROM IdentificationAnswers WHERE session = ? ORDER BY ord`, session.Id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var answer IdentificationAnswer
+		var states string
+		if err := rows.Scan(&answer.CharacterId, &states); err != nil {
+			return nil, err
+		}
+		answer.StateIds = []string{}
+		if states != "" {
+			answer.StateIds = strings.Split(states, "\n")
+		}
+		session.Answers = append(session.Answers, answer)
+	}
+	return session, rows.Err()
+}
+
+// GetIdentificationSession returns the session with this id, nil if there
+// is none or it has expired.
+func (reg *DatasetRegistry) GetIdentificationSession(id string) (*IdentificationSession, error) {
+	return reg.getIdentificationSession(`id = ?`, id)
+}
+
+// GetIdentificationSessionByCode returns the session with this code, nil if
+// there is none or it has expired.
+func (reg *DatasetRegistry) GetIdentificationSessionByCode(code string) (*IdentificationSession, error) {
+	return reg.getIdentificationSession(`code = ?`, NormalizeSessionCode(code))
+}
+
+// GetOwnerIdentificationSessions returns the sessions of an owner that have
+// not expired, the last updated first.
+func (reg *DatasetRegistry) GetOwnerIdentificationSessions(owner string) ([]*IdentificationSession, error) {
+	rows, err := reg.db.Query(`SELECT id FROM IdentificationSessions WHERE owner = ? AND expires > ?
+		ORDER BY updated DESC, created DESC`, owner, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sessions := []*IdentificationSession{}
+	for _, id := range ids {
+		session, err := reg.GetIdentificationSession(id)
+		if err != nil {
+			return nil, err
+		}
+		if session != nil {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, nil
+}
+
+// SaveIdentificationSession stores the rank and answers of a session,
+// extending its life by the given lifetime. It returns false if the session
+// does not exist anymore.
+func (reg *DatasetRegistry) SaveIdentificationSession(session *IdentificationSession, lifetime time.Duration) (bool, error) {
+	op := NewDatabaseOperation(reg.db)
+	defer op.Close()
+	now := time.Now().Truncate(time.Second)
+	res := op.TryExec(op.TryPrepare(`UPDATE IdentificationSessions SET rank = ?, updated = ?, expires = ?
+		WHERE id = ? AND expires > ?`), session.Rank, now.Unix(), now.Add(lifetime).Unix(), session.Id, now.Unix())
+	if op.HasFailed() {
+		return false, op.Error()
+	}
+	if count, err := res.RowsAffected(); err != nil || count == 0 {
+		return false, err
+	}
+	op.TryExec(op.TryPrepare(`DELETE FROM IdentificationAnswers WHERE session = ?`), session.Id)
+	insertAnswer := op.TryPrepare(`INSERT INTO IdentificationAnswers (session, ord, character, states) VALUES (?, ?, ?, ?)`)
+	for i, answer := range session.Answers {
+		op.TryExec(insertAnswer, session.Id, i, answer.CharacterId, strings.Join(answer.StateIds, "\n"))
+	}
+	if op.HasFailed() {
+		return false, op.Error()
+	}
+	session.Updated, session.Expires = now, now.Add(lifetime)
+	return true, nil
+}
+
+// SetIdentificationSessionsOwner gives the sessions of an owner to another.
+func (reg *DatasetRegistry) SetIdentificationSessionsOwner(from string, to string) error {
+	_, err := reg.db.Exec(`UPDATE IdentificationSessions SET owner = ? WHERE owner = ?`, to, from)
+	return err
+}
+
+// DeleteExpiredIdentificationSessions removes the sessions that have
+// expired with their answers, returning how many there were.
+func (reg *DatasetRegistry) DeleteExpiredIdentificationSessions() (int64, error) {
+	op := NewDatabaseOperation(reg.db)
+	defer op.Close()
+	now := time.Now().Unix()
+	op.TryExec(op.TryPrepare(`DELETE FROM IdentificationAnswers WHERE session IN (
+		SELECT id FROM IdentificationSessions WHERE expires <= ?)`), now)
+	res := op.TryExec(op.TryPrepare(`DELETE FROM IdentificationSessions WHERE expires <= ?`), now)
+	if op.HasFailed() {
+		return 0, op.Error()
+	}
+	return res.RowsAffected()
+}
@@ -8,8 +8,11 @@ type Dataset struct {
 	CharactersHierarchy *Hierarchy
 	TaxonsById          map[string]*Taxon
 	CharactersById      map[string]*Character
+	Books               []Book
 	DictionaryEntry     []DictionaryEntry
 	ExtraFields         []ExtraField
+
+	readProblems []*ReferenceError
 }
 
 func New(id string) *Dataset {
@@ -50,6 +53,7 @@ type TaxonInit struct {
 	Name        MultilangText
 	Description string
 	Author      string
+	Rank        string
 	States      []*State
 	References  []BookReference
 	ExtraInfo   map[string]interface{}
@@ -80,19 +84,20 @@ func (ds *Dataset) CreateTaxon(path []int, init TaxonInit) []int {
 	taxon := &Taxon{
 		Hierarchy:  hierarchy,
 		Author:     init.Author,
+		Rank:       init.Rank,
 		States:     init.States,
 		References: init.References,
 		ExtraInfo:  extraInfo,
 	}
 	parent := ds.TaxonsHierarchy.GetIn(path)
 	ds.AddTaxonBelow(taxon, parent)
-	return append(path, len(parent.Children))
+	return append(path, len(parent.Children)-1)
 }
 
 func (ds *Dataset) AddCharacterBelow(ch *Character, parent *Hierarchy) {
 	ds.CharactersById[ch.Id] = ch
 	if parent == nil {
-		ds.TaxonsHierarchy.Children = append(ds.TaxonsHierarchy.Children, ch.Hierarchy)
+		ds.CharactersHierarchy.Children = append(ds.CharactersHierarchy.Children, ch.Hierarchy)
 	} else {
 		parent.Children = append(parent.Children, ch.Hierarchy)
 	}
@@ -0,0 +1,505 @@
+package dataset
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const (
+	ChangeAdded        = "added"
+	ChangeRemoved      = "removed"
+	ChangeMoved        = "moved"
+	ChangeRenamed      = "renamed"
+	ChangeModified     = "modified"
+	ChangeCoding       = "coding"
+	ChangeDependencies = "dependencies"
+	ChangePictures     = "pictures"
+)
+
+const (
+	KindTaxon     = "taxon"
+	KindCharacter = "character"
+	KindState     = "state"
+	KindBook      = "book"
+)
+
+type DiffEntry struct {
+	Kind    string   `json:"kind"`
+	Change  string   `json:"change"`
+	Id      string   `json:"id"`
+	Name    string   `json:"name"`
+	Details []string `json:"details,omitempty"`
+}
+
+func (entry *DiffEntry) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s %s %q", entry.Kind, entry.Id, entry.Change, entry.Name)
+	for _, detail := range entry.Details {
+		fmt.Fprintf(&b, "\n    %s", detail)
+	}
+	return b.String()
+}
+
+// DatasetDiff lists the changes between two datasets, along with the patch
+// turning the first one into the second one when applied with Apply.
+type DatasetDiff struct {
+	Changes []*DiffEntry `json:"changes"`
+	Patch   *Command     `json:"patch"`
+}
+
+func (diff *DatasetDiff) IsEmpty() bool {
+	return len(diff.Changes) == 0 && len(diff.Patch.Commands) == 0
+}
+
+type differ struct {
+	a, b, work *Dataset
+	diff       *DatasetDiff
+	err        error
+}
+
+func (d *differ) emit(cmd *Command) {
+	if d.err != nil {
+		return
+	}
+	if _, err := d.work.Apply(cmd); err != nil {
+		d.err = fmt.Errorf("cannot compute diff, %q on %q failed: %s", cmd.Op, cmd.Id, err.Error())
+		return
+	}
+	d.diff.Patch.Commands = append(d.diff.Patch.Commands, cmd)
+}
+
+func (d *differ) report(kind string, change string, id string, name string, details ...string) {
+	d.diff.Changes = append(d.diff.Changes, &DiffEntry{Kind: kind, Change: change, Id: id, Name: name, Details: details})
+}
+
+func sortedLangs(names ...map[string]string) []string {
+	langs := []string{}
+	seen := map[string]bool{}
+	for _, m := range names {
+		for lang := range m {
+			if !seen[lang] {
+				seen[lang] = true
+				langs = append(langs, lang)
+			}
+		}
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// syncText updates the names and description of an item, returning the
+// details of what changed besides the scientific name.
+func (d *differ) syncText(id string, old MultilangText, new MultilangText, oldDesc string, newDesc string) []string {
+	details := []string{}
+	if old.Scientific != new.Scientific {
+		d.emit(&Command{Op: OpRename, Id: id, Text: new.Scientific})
+	}
+	for _, lang := range sortedLangs(old.NamesByLangRef, new.NamesByLangRef) {
+		oldName, newName := old.NamesByLangRef[lang], new.NamesByLangRef[lang]
+		if oldName != newName {
+			d.emit(&Command{Op: OpSetTranslation, Id: id, Lang: lang, Text: newName})
+			details = append(details, fmt.Sprintf("name[%s]: %q -> %q", lang, oldName, newName))
+		}
+	}
+	if oldDesc != newDesc {
+		d.emit(&Command{Op: OpSetDescription, Id: id, Text: newDesc})
+		details = append(details, fmt.Sprintf("description: %q -> %q", oldDesc, newDesc))
+	}
+	return details
+}
+
+func picturesEqual(a []Picture, b []Picture) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (d *differ) syncPictures(id string, target []Picture) []string {
+	if d.err != nil {
+		return nil
+	}
+	current, err := d.work.picturesOf(id)
+	if err != nil {
+		d.err = err
+		return nil
+	}
+	if picturesEqual(*current, target) {
+		return nil
+	}
+	details := []string{}
+	targetIds := map[string]bool{}
+	for _, pic := range target {
+		targetIds[pic.Id] = true
+	}
+	for _, pic := range append([]Picture{}, *current...) {
+		if !targetIds[pic.Id] {
+			d.emit(&Command{Op: OpRemovePicture, Id: id, Picture: &Picture{Id: pic.Id}})
+			details = append(details, fmt.Sprintf("picture removed: %s", pic.Source))
+		}
+	}
+	for i, pic := range target {
+		if d.err != nil {
+			return details
+		}
+		pics := *current
+		if i < len(pics) && pics[i].Id == pic.Id {
+			if pics[i] != pic {
+				newPic := pic
+				d.emit(&Command{Op: OpUpdatePicture, Id: id, Picture: &newPic})
+				details = append(details, fmt.Sprintf("picture changed: %s", pic.Source))
+			}
+			continue
+		}
+		existed := false
+		for _, p := range pics {
+			if p.Id == pic.Id {
+				existed = true
+				d.emit(&Command{Op: OpRemovePicture, Id: id, Picture: &Picture{Id: pic.Id}})
+			}
+		}
+		newPic := pic
+		d.emit(&Command{Op: OpAddPicture, Id: id, Position: i, Picture: &newPic})
+		if existed {
+			details = append(details, fmt.Sprintf("picture moved: %s", pic.Source))
+		} else {
+			details = append(details, fmt.Sprintf("picture added: %s", pic.Source))
+		}
+	}
+	return details
+}
+
+func childIndex(root *Hierarchy, id string) (string, int) {
+	parent, index := findParent(root, id)
+	if parent == nil || parent == root {
+		return "", index
+	}
+	return parent.Id, index
+}
+
+func walkWithParent(root *Hierarchy, visit func(h *Hierarchy, parentId string, index int)) {
+	var walk func(h *Hierarchy, parentId string)
+	walk = func(h *Hierarchy, parentId string) {
+		for i, child := range h.Children {
+			visit(child, parentId, i)
+			walk(child, child.Id)
+		}
+	}
+	walk(root, "")
+}
+
+func stateOwners(ds *Dataset) map[string]string {
+	owners := map[string]string{}
+	for id, ch := range ds.CharactersById {
+		for _, state := range ch.States {
+			owners[state.Id] = id
+		}
+	}
+	return owners
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (d *differ) diffCharacters() {
+	aOwners, bOwners := stateOwners(d.a), stateOwners(d.b)
+	charIds := map[string]bool{}
+	for id := range d.work.CharactersById {
+		charIds[id] = true
+	}
+	for _, charId := range sortedKeys(charIds) {
+		ch := d.work.CharactersById[charId]
+		for _, state := range append([]State{}, ch.States...) {
+			if bOwners[state.Id] != charId {
+				d.emit(&Command{Op: OpRemoveState, Id: state.Id})
+				if bOwners[state.Id] == "" {
+					d.report(KindState, ChangeRemoved, state.Id, state.Name.Scientific, fmt.Sprintf("from character %s", charId))
+				}
+			}
+		}
+	}
+	walkWithParent(d.b.CharactersHierarchy, func(h *Hierarchy, parentId string, index int) {
+		if d.err != nil {
+			return
+		}
+		target, ok := d.b.CharactersById[h.Id]
+		if !ok {
+			target = NewCharacter(h)
+		}
+		current, exists := d.work.CharactersById[h.Id]
+		if !exists {
+			record := &CharacterRecord{
+				Id:          h.Id,
+				Name:        copyMultilangText(h.Name),
+				Description: h.Description,
+				Pictures:    copyPictures(h.Pictures),
+			}
+			for _, state := range target.States {
+				record.States = append(record.States, copyState(state))
+			}
+			d.emit(&Command{Op: OpInsertCharacters, ParentId: parentId, Position: index, Characters: []*CharacterRecord{record}})
+			d.report(KindCharacter, ChangeAdded, h.Id, h.Name.Scientific, fmt.Sprintf("below %q", parentId))
+			return
+		}
+		oldParentId := d.a.ParentCharacterId(h.Id)
+		if workParentId, workIndex := childIndex(d.work.CharactersHierarchy, h.Id); workParentId != parentId || workIndex != index {
+			d.emit(&Command{Op: OpMoveCharacter, Id: h.Id, ParentId: parentId, Position: index})
+		}
+		if oldParentId != parentId {
+			d.report(KindCharacter, ChangeMoved, h.Id, h.Name.Scientific, fmt.Sprintf("from %q to %q", oldParentId, parentId))
+		}
+		if current.Name.Scientific != h.Name.Scientific {
+			d.report(KindCharacter, ChangeRenamed, h.Id, h.Name.Scientific, fmt.Sprintf("%q -> %q", current.Name.Scientific, h.Name.Scientific))
+		}
+		if details := d.syncText(h.Id, current.Name, h.Name, current.Description, h.Description); len(details) > 0 {
+			d.report(KindCharacter, ChangeModified, h.Id, h.Name.Scientific, details...)
+		}
+		if details := d.syncPictures(h.Id, h.Pictures); len(details) > 0 {
+			d.report(KindCharacter, ChangePictures, h.Id, h.Name.Scientific, details...)
+		}
+		for i, state := range target.States {
+			d.diffState(current, i, state, aOwners[state.Id])
+		}
+	})
+	removed := map[string]bool{}
+	for id := range d.a.CharactersById {
+		if _, ok := d.b.CharactersById[id]; !ok {
+			removed[id] = true
+		}
+	}
+	for _, id := range sortedKeys(removed) {
+		ch := d.a.CharactersById[id]
+		d.report(KindCharacter, ChangeRemoved, id, ch.Name.Scientific)
+		if parentId, index := childIndex(d.work.CharactersHierarchy, id); index >= 0 && !removed[parentId] {
+			d.emit(&Command{Op: OpDeleteCharacter, Id: id})
+		}
+	}
+	walkWithParent(d.b.CharactersHierarchy, func(h *Hierarchy, parentId string, index int) {
+		target, ok := d.b.CharactersById[h.Id]
+		current, exists := d.work.CharactersById[h.Id]
+		if !ok || !exists || d.err != nil {
+			return
+		}
+		want, have := d.work.dependenciesCommand(target), d.work.dependenciesCommand(current)
+		if reflect.DeepEqual(want, have) {
+			return
+		}
+		d.emit(want)
+		if _, existed := d.a.CharactersById[h.Id]; existed {
+			d.report(KindCharacter, ChangeDependencies, h.Id, h.Name.Scientific,
+				fmt.Sprintf("required: %v -> %v", have.RequiredStateIds, want.RequiredStateIds),
+				fmt.Sprintf("inapplicable: %v -> %v", have.InapplicableStateIds, want.InapplicableStateIds),
+				fmt.Sprintf("inherent: %q -> %q", have.InherentStateId, want.InherentStateId))
+		}
+	})
+}
+
+func (d *differ) diffState(ch *Character, index int, target State, oldOwner string) {
+	if d.err != nil {
+		return
+	}
+	currentIndex := indexOfState(ch, target.Id)
+	if currentIndex < 0 {
+		newState := copyState(target)
+		d.emit(&Command{Op: OpAddState, Id: ch.Id, Position: index, State: &newState})
+		if oldOwner == "" {
+			d.report(KindState, ChangeAdded, target.Id, target.Name.Scientific, fmt.Sprintf("to character %s", ch.Id))
+		} else {
+			d.report(KindState, ChangeMoved, target.Id, target.Name.Scientific, fmt.Sprintf("from character %s to %s", oldOwner, ch.Id))
+		}
+		return
+	}
+	current := ch.States[currentIndex]
+	if currentIndex != index {
+		d.emit(&Command{Op: OpMoveState, Id: target.Id, Position: index})
+	}
+	if current.Name.Scientific != target.Name.Scientific {
+		d.report(KindState, ChangeRenamed, target.Id, target.Name.Scientific, fmt.Sprintf("%q -> %q", current.Name.Scientific, target.Name.Scientific))
+	}
+	details := d.syncText(target.Id, current.Name, target.Name, current.Description, target.Description)
+	if current.Color != target.Color {
+		d.emit(&Command{Op: OpSetColor, Id: target.Id, Text: target.Color})
+		details = append(details, fmt.Sprintf("color: %q -> %q", current.Color, target.Color))
+	}
+	details = append(details, d.syncPictures(target.Id, target.Pictures)...)
+	if len(details) > 0 {
+		d.report(KindState, ChangeModified, target.Id, target.Name.Scientific, details...)
+	}
+}
+
+func stateIdSet(states []*State) map[string]bool {
+	set := make(map[string]bool, len(states))
+	for _, state := range states {
+		set[state.Id] = true
+	}
+	return set
+}
+
+func (d *differ) diffBooks() {
+	targetIds := map[string]bool{}
+	for _, book := range d.b.Books {
+		targetIds[book.Id] = true
+	}
+	for _, book := range d.a.Books {
+		if !targetIds[book.Id] {
+			d.emit(&Command{Op: OpRemoveBook, Id: book.Id})
+			d.report(KindBook, ChangeRemoved, book.Id, book.Title)
+		}
+	}
+	for i := range d.b.Books {
+		target := copyBook(d.b.Books[i])
+		current := d.work.FindBook(target.Id)
+		if current == nil {
+			d.emit(&Command{Op: OpAddBook, Position: i, Book: &target})
+			d.report(KindBook, ChangeAdded, target.Id, target.Title)
+			continue
+		}
+		if !reflect.DeepEqual(copyBook(*current), target) {
+			d.emit(&Command{Op: OpUpdateBook, Book: &target})
+			d.report(KindBook, ChangeModified, target.Id, target.Title)
+		}
+		if index := d.work.bookIndex(target.Id); index != i {
+			d.emit(&Command{Op: OpMoveBook, Id: target.Id, Position: i})
+			d.report(KindBook, ChangeMoved, target.Id, target.Title, fmt.Sprintf("from %d to %d", index, i))
+		}
+	}
+}
+
+func (d *differ) diffTaxons() {
+	walkWithParent(d.b.TaxonsHierarchy, func(h *Hierarchy, parentId string, index int) {
+		if d.err != nil {
+			return
+		}
+		target, ok := d.b.TaxonsById[h.Id]
+		if !ok {
+			target = NewTaxon(h)
+		}
+		current, exists := d.work.TaxonsById[h.Id]
+		if !exists {
+			record := &TaxonRecord{
+				Id:          h.Id,
+				Name:        copyMultilangText(h.Name),
+				Description: h.Description,
+				Pictures:    copyPictures(h.Pictures),
+				Author:      target.Author,
+				Rank:        target.Rank,
+				Synonyms:    copySynonyms(target.Synonyms),
+				StateIds:    stateIdsOf(target.States),
+				References:  copyReferences(target.References),
+				ExtraInfo:   target.ExtraInfo,
+			}
+			d.emit(&Command{Op: OpInsertTaxons, ParentId: parentId, Position: index, Taxons: []*TaxonRecord{record}})
+			d.report(KindTaxon, ChangeAdded, h.Id, h.Name.Scientific, fmt.Sprintf("below %q", parentId))
+			return
+		}
+		oldParentId := d.a.ParentTaxonId(h.Id)
+		if workParentId, workIndex := childIndex(d.work.TaxonsHierarchy, h.Id); workParentId != parentId || workIndex != index {
+			d.emit(&Command{Op: OpMoveTaxon, Id: h.Id, ParentId: parentId, Position: index})
+		}
+		if oldParentId != parentId {
+			d.report(KindTaxon, ChangeMoved, h.Id, h.Name.Scientific, fmt.Sprintf("from %q to %q", oldParentId, parentId))
+		}
+		if current.Name.Scientific != h.Name.Scientific {
+			d.report(KindTaxon, ChangeRenamed, h.Id, h.Name.Scientific, fmt.Sprintf("%q -> %q", current.Name.Scientific, h.Name.Scientific))
+		}
+		details := d.syncText(h.Id, current.Name, h.Name, current.Description, h.Description)
+		if current.Author != target.Author {
+			d.emit(&Command{Op: OpSetAuthor, Id: h.Id, Text: target.Author})
+			details = append(details, fmt.Sprintf("author: %q -> %q", current.Author, target.Author))
+		}
+		if current.Rank != target.Rank {
+			d.emit(&Command{Op: OpSetRank, Id: h.Id, Text: target.Rank})
+			details = append(details, fmt.Sprintf("rank: %q -> %q", current.Rank, target.Rank))
+		}
+		if len(current.References)+len(target.References) > 0 && !reflect.DeepEqual(current.References, target.References) {
+			d.emit(&Command{Op: OpSetReferences, Id: h.Id, References: copyReferences(target.References)})
+			details = append(details, fmt.Sprintf("references: %d -> %d", len(current.References), len(target.References)))
+		}
+		if len(current.Synonyms)+len(target.Synonyms) > 0 && !reflect.DeepEqual(current.Synonyms, target.Synonyms) {
+			d.emit(&Command{Op: OpSetSynonyms, Id: h.Id, Synonyms: copySynonyms(target.Synonyms)})
+			details = append(details, fmt.Sprintf("synonyms: %d -> %d", len(current.Synonyms), len(target.Synonyms)))
+		}
+		extraKeys := map[string]bool{}
+		for k := range current.ExtraInfo {
+			extraKeys[k] = true
+		}
+		for k := range target.ExtraInfo {
+			extraKeys[k] = true
+		}
+		for _, k := range sortedKeys(extraKeys) {
+			oldValue, newValue := current.ExtraInfo[k], target.ExtraInfo[k]
+			if !reflect.DeepEqual(oldValue, newValue) {
+				d.emit(&Command{Op: OpSetExtraInfo, Id: h.Id, Key: k, Value: newValue})
+				details = append(details, fmt.Sprintf("%s: %v -> %v", k, oldValue, newValue))
+			}
+		}
+		if len(details) > 0 {
+			d.report(KindTaxon, ChangeModified, h.Id, h.Name.Scientific, details...)
+		}
+		if details := d.syncPictures(h.Id, h.Pictures); len(details) > 0 {
+			d.report(KindTaxon, ChangePictures, h.Id, h.Name.Scientific, details...)
+		}
+		oldStates, newStates := stateIdsOf(current.States), stateIdsOf(target.States)
+		if !reflect.DeepEqual(oldStates, newStates) {
+			d.emit(&Command{Op: OpSetTaxonStates, Id: h.Id, StateIds: newStates})
+		}
+		codingDetails := []string{}
+		oldSet, newSet := stateIdSet(d.a.TaxonsById[h.Id].States), stateIdSet(target.States)
+		for _, stateId := range newStates {
+			if !oldSet[stateId] {
+				codingDetails = append(codingDetails, "+ "+stateId)
+			}
+		}
+		for _, stateId := range stateIdsOf(d.a.TaxonsById[h.Id].States) {
+			if !newSet[stateId] {
+				codingDetails = append(codingDetails, "- "+stateId)
+			}
+		}
+		if len(codingDetails) > 0 {
+			d.report(KindTaxon, ChangeCoding, h.Id, h.Name.Scientific, codingDetails...)
+		}
+	})
+	removed := map[string]bool{}
+	for id := range d.a.TaxonsById {
+		if _, ok := d.b.TaxonsById[id]; !ok {
+			removed[id] = true
+		}
+	}
+	for _, id := range sortedKeys(removed) {
+		d.report(KindTaxon, ChangeRemoved, id, d.a.TaxonsById[id].Name.Scientific)
+		if parentId, index := childIndex(d.work.TaxonsHierarchy, id); index >= 0 && !removed[parentId] {
+			d.emit(&Command{Op: OpDeleteTaxon, Id: id})
+		}
+	}
+}
+
+// Diff compares two datasets. Applying the resulting patch to a copy of a
+// turns it into a copy of b.
+func Diff(a *Dataset, b *Dataset) (*DatasetDiff, error) {
+	d := &differ{
+		a:    a,
+		b:    b,
+		work: a.Clone(),
+		diff: &DatasetDiff{Changes: []*DiffEntry{}, Patch: &Command{Op: OpBatch, Commands: []*Command{}}},
+	}
+	d.diffCharacters()
+	d.diffBooks()
+	d.diffTaxons()
+	if d.err != nil {
+		return nil, d.err
+	}
+	return d.diff, nil
+}
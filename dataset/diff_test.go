@@ -0,0 +1,126 @@
+package dataset
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDiffIdentical(t *testing.T) {
+	a := readHazoString(t, hazoFixture)
+	diff, err := Diff(a, a.Clone())
+	if err != nil {
+		t.Logf("Unexpected error: %q.", err.Error())
+		t.FailNow()
+	}
+	if !diff.IsEmpty() {
+		t.Logf("Expected no changes, got %+v.", diff.Changes)
+		t.Fail()
+	}
+}
+
+func TestDiffThenApply(t *testing.T) {
+	a := readHazoString(t, hazoFixture)
+	b := a.Clone()
+	journal := NewJournal(b, "")
+	for _, cmd := range journalFixtureCommands() {
+		if err := journal.Do(cmd); err != nil {
+			t.Logf("Unexpected error applying %q: %q.", cmd.Op, err.Error())
+			t.FailNow()
+		}
+	}
+	diff, err := Diff(a, b)
+	if err != nil {
+		t.Logf("Unexpected error: %q.", err.Error())
+		t.FailNow()
+	}
+	data, err := json.Marshal(diff.Patch)
+	if err != nil {
+		t.Logf("Unexpected error: %q.", err.Error())
+		t.FailNow()
+	}
+	patch := &Command{}
+	if err := json.Unmarshal(data, patch); err != nil {
+		t.Logf("Unexpected error: %q.", err.Error())
+		t.FailNow()
+	}
+	c := readHazoString(t, hazoFixture)
+	if _, err := c.Apply(patch); err != nil {
+		t.Logf("Unexpected error: %q.", err.Error())
+		t.FailNow()
+	}
+	expected := writeHazoString(t, b, HazoOptions{SortKeys: true})
+	if got := writeHazoString(t, c, HazoOptions{SortKeys: true}); got != expected {
+		t.Logf("Patched dataset differs.\nexpected %s\ngot %s", expected, got)
+		t.Fail()
+	}
+	if a.TaxonsById["t1"] == nil || a.CharactersById["c2"] == nil {
+		t.Logf("Diff must not modify its inputs.")
+		t.Fail()
+	}
+	expectedChanges := map[string]bool{
+		"taxon t1 removed":      false,
+		"taxon t2 removed":      false,
+		"taxon t3 removed":      false,
+		"taxon t10 added":       false,
+		"taxon t11 added":       false,
+		"character c2 removed":  false,
+		"character c1 modified": false,
+		"character c3 moved":    false,
+		"state s1 removed":      false,
+		"state s3 removed":      false,
+		"state s2 modified":     false,
+	}
+	for _, change := range diff.Changes {
+		key := change.Kind + " " + change.Id + " " + change.Change
+		if _, ok := expectedChanges[key]; ok {
+			expectedChanges[key] = true
+		}
+	}
+	for key, found := range expectedChanges {
+		if !found {
+			t.Logf("Expected change %q to be reported.", key)
+			t.Fail()
+		}
+	}
+}
+
+func TestDiffMovesAndRenames(t *testing.T) {
+	a := readHazoString(t, hazoFixture)
+	b := a.Clone()
+	b.MoveTaxon("t1", "t2", 0)
+	b.MoveTaxon("t3", "", 0)
+	b.Rename("t4", "delta")
+	b.MoveCharacter("c3", "c2", -1)
+	b.RemoveState("s4")
+	b.AddState("c1", State{Id: "s4", Name: MultilangText{Scientific: "dark"}}, 0)
+	b.SetTaxonState("t2", "s2")
+	b.SetAuthor("t2", "L.")
+	b.AddPicture("t3", Picture{Id: "p1", Source: "http://example.com/t3.png"}, -1)
+	b.SetCharacterDependencies("c3", []string{"s1"}, nil, "")
+	diff, err := Diff(a, b)
+	if err != nil {
+		t.Logf("Unexpected error: %q.", err.Error())
+		t.FailNow()
+	}
+	reported := map[string]bool{}
+	for _, change := range diff.Changes {
+		reported[change.Kind+" "+change.Id+" "+change.Change] = true
+	}
+	for _, key := range []string{"taxon t1 moved", "taxon t3 moved", "taxon t4 renamed", "character c3 moved", "state s4 moved",
+		"taxon t2 coding", "taxon t2 modified", "taxon t3 pictures", "character c3 dependencies"} {
+		if !reported[key] {
+			t.Logf("Expected change %q to be reported, got %v.", key, reported)
+			t.Fail()
+		}
+	}
+	c := a.Clone()
+	if _, err := c.Apply(diff.Patch); err != nil {
+		t.Logf("Unexpected error: %q.", err.Error())
+		t.FailNow()
+	}
+	expected := writeHazoString(t, b, HazoOptions{SortKeys: true})
+	if got := writeHazoString(t, c, HazoOptions{SortKeys: true}); got != expected {
+		t.Logf("Patched dataset differs.\nexpected %s\ngot %s", expected, got)
+		t.Fail()
+	}
+}
@@ -0,0 +1,339 @@
+package dataset
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const dwcaMeta = `<?xml version="1.0" encoding="UTF-8"?>
+<archive xmlns="http://rs.tdwg.org/dwc/text/" metadata="">
+  <core encoding="UTF-8" fieldsTerminatedBy="\t" linesTerminatedBy="\n" fieldsEnclosedBy="" ignoreHeaderLines="1" rowType="http://rs.tdwg.org/dwc/terms/Taxon">
+    <files>
+      <location>taxon.txt</location>
+    </files>
+    <id index="0" />
+%s  </core>
+</archive>
+`
+
+var dwcaTaxonTerms = []string{
+	"taxonID",
+	"parentNameUsageID",
+	"acceptedNameUsageID",
+	"scientificName",
+	"scientificNameAuthorship",
+	"namePublishedInYear",
+	"taxonRank",
+	"taxonomicStatus",
+}
+
+var dwcaSynonymStatuses = map[string]string{
+	"":                 "synonym",
+	SynonymHomotypic:   "homotypicSynonym",
+	SynonymHeterotypic: "heterotypicSynonym",
+	SynonymMisapplied:  "misapplied",
+}
+
+var dwcaSeparators = strings.NewReplacer("\t", " ", "\n", " ", "\r", " ")
+
+func dwcaRow(w io.Writer, values ...string) error {
+	for i, value := range values {
+		values[i] = dwcaSeparators.Replace(value)
+	}
+	_, err := io.WriteString(w, strings.Join(values, "\t")+"\n")
+	return err
+}
+
+// WriteDwCA writes the taxons and their synonyms as a Darwin Core Archive
+// having a single Taxon core file.
+func WriteDwCA(w io.Writer, ds *Dataset) error {
+	archive := zip.NewWriter(w)
+	meta, err := archive.Create("meta.xml")
+	if err != nil {
+		return err
+	}
+	var fields strings.Builder
+	for i, term := range dwcaTaxonTerms {
+		fmt.Fprintf(&fields, "    <field index=\"%d\" term=\"http://rs.tdwg.org/dwc/terms/%s\" />\n", i, term)
+	}
+	if _, err := fmt.Fprintf(meta, dwcaMeta, fields.String()); err != nil {
+		return err
+	}
+	core, err := archive.Create("taxon.txt")
+	if err != nil {
+		return err
+	}
+	if err := dwcaRow(core, append([]string{}, dwcaTaxonTerms...)...); err != nil {
+		return err
+	}
+	var walk func(h *Hierarchy, parentId string) error
+	walk = func(h *Hierarchy, parentId string) error {
+		taxon, ok := ds.TaxonsById[h.Id]
+		if !ok {
+			taxon = NewTaxon(h)
+		}
+		err := dwcaRow(core, taxon.Id, parentId, "", taxon.Name.Scientific, taxon.Author, "", taxon.Rank, "accepted")
+		if err != nil {
+			return err
+		}
+		for i, synonym := range taxon.Synonyms {
+			year := ""
+			if synonym.Year != 0 {
+				year = fmt.Sprint(synonym.Year)
+			}
+			err := dwcaRow(core, fmt.Sprintf("%s-syn%d", taxon.Id, i+1), "", taxon.Id, synonym.Name, synonym.Author, year,
+				taxon.Rank, dwcaSynonymStatuses[synonym.Status])
+			if err != nil {
+				return err
+			}
+		}
+		for _, child := range h.Children {
+			if err := walk(child, h.Id); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, child := range ds.TaxonsHierarchy.Children {
+		if err := walk(child, ""); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+type dwcaField struct {
+	Index   *int   `xml:"index,attr"`
+	Term    string `xml:"term,attr"`
+	Default string `xml:"default,attr"`
+}
+
+type dwcaCore struct {
+	FieldsTerminatedBy string `xml:"fieldsTerminatedBy,attr"`
+	FieldsEnclosedBy   string `xml:"fieldsEnclosedBy,attr"`
+	IgnoreHeaderLines  int    `xml:"ignoreHeaderLines,attr"`
+	Location           string `xml:"files>location"`
+	Id                 struct {
+		Index *int `xml:"index,attr"`
+	} `xml:"id"`
+	Fields []dwcaField `xml:"field"`
+}
+
+type dwcaMetaFile struct {
+	Core dwcaCore `xml:"core"`
+}
+
+var dwcaEscapes = strings.NewReplacer(`\t`, "\t", `\n`, "\n", `\r`, "\r")
+
+// dwcaTerm returns the name of a Darwin Core term from its URI.
+func dwcaTerm(uri string) string {
+	return uri[strings.LastIndexAny(uri, "/#")+1:]
+}
+
+// dwcaRecords returns the rows of a core file, fields being enclosed by
+// quotes or not enclosed at all.
+func dwcaRecords(r io.Reader, separator string, enclosedBy string) ([][]string, error) {
+	if enclosedBy == "" {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		var records [][]string
+		for _, line := range strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n") {
+			if line != "" {
+				records = append(records, strings.Split(line, separator))
+			}
+		}
+		return records, nil
+	}
+	reader := csv.NewReader(r)
+	reader.Comma = []rune(separator)[0]
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	return reader.ReadAll()
+}
+
+// dwcaCoreRecords returns the rows of the core file of an archive, as maps
+// from Darwin Core term names to values. Archives without meta.xml have a
+// single core file whose first line names the terms.
+func dwcaCoreRecords(archive *zip.Reader) ([]map[string]string, error) {
+	files := map[string]*zip.File{}
+	for _, f := range archive.File {
+		files[f.Name] = f
+	}
+	core := dwcaCore{FieldsTerminatedBy: ",", FieldsEnclosedBy: `"`}
+	if f, ok := files["meta.xml"]; ok {
+		r, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		meta := dwcaMetaFile{Core: core}
+		err = xml.NewDecoder(r).Decode(&meta)
+		r.Close()
+		if err != nil {
+			return nil, fmt.Errorf("wrong meta.xml: %w", err)
+		}
+		core = meta.Core
+		core.FieldsTerminatedBy = dwcaEscapes.Replace(core.FieldsTerminatedBy)
+	} else {
+		for _, f := range archive.File {
+			switch strings.ToLower(path.Ext(f.Name)) {
+			case ".txt", ".tsv":
+				core.FieldsTerminatedBy = "\t"
+				fallthrough
+			case ".csv":
+				core.Location = f.Name
+				core.IgnoreHeaderLines = 1
+			}
+			if core.Location != "" {
+				break
+			}
+		}
+	}
+	f, ok := files[core.Location]
+	if !ok {
+		return nil, fmt.Errorf("missing core file %q", core.Location)
+	}
+	if core.FieldsTerminatedBy == "" {
+		return nil, fmt.Errorf("no field separator for the core file")
+	}
+	r, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	rows, err := dwcaRecords(r, core.FieldsTerminatedBy, core.FieldsEnclosedBy)
+	if err != nil {
+		return nil, err
+	}
+	if core.Fields == nil && len(rows) > 0 {
+		for i, name := range rows[0] {
+			index := i
+			core.Fields = append(core.Fields, dwcaField{Index: &index, Term: strings.TrimSpace(name)})
+		}
+	}
+	if core.IgnoreHeaderLines > len(rows) {
+		core.IgnoreHeaderLines = len(rows)
+	}
+	records := make([]map[string]string, 0, len(rows)-core.IgnoreHeaderLines)
+	for _, row := range rows[core.IgnoreHeaderLines:] {
+		record := map[string]string{}
+		value := func(index *int, def string) string {
+			if index != nil && *index < len(row) {
+				if v := strings.TrimSpace(row[*index]); v != "" {
+					return v
+				}
+			}
+			return def
+		}
+		for _, field := range core.Fields {
+			record[dwcaTerm(field.Term)] = value(field.Index, field.Default)
+		}
+		if record["taxonID"] == "" {
+			record["taxonID"] = value(core.Id.Index, "")
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// ReadDwCA reads the taxons of a Darwin Core Archive whose core is a Taxon
+// file, with their authors, ranks and synonyms. Taxons are placed below
+// their parentNameUsageID, and names whose acceptedNameUsageID is another
+// taxon become synonyms of it.
+func ReadDwCA(r io.Reader) (*Dataset, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	records, err := dwcaCoreRecords(archive)
+	if err != nil {
+		return nil, err
+	}
+	statuses := map[string]string{"synonym": ""}
+	for status, term := range dwcaSynonymStatuses {
+		statuses[term] = status
+	}
+	ds := New("")
+	taxonsById := map[string]*Taxon{}
+	parentIds := map[*Taxon]string{}
+	var taxons []*Taxon
+	var synonymRecords []map[string]string
+	for _, record := range records {
+		id := record["taxonID"]
+		if id == "" {
+			continue
+		}
+		if accepted := record["acceptedNameUsageID"]; accepted != "" && accepted != id {
+			synonymRecords = append(synonymRecords, record)
+			continue
+		}
+		if _, ok := taxonsById[id]; ok {
+			ds.noteProblem(id, "is the id of several taxons")
+			continue
+		}
+		author := record["scientificNameAuthorship"]
+		name := strings.TrimSpace(strings.TrimSuffix(record["scientificName"], " "+author))
+		taxon := NewTaxon(&Hierarchy{Id: id, Name: *NewMultilangText(name)})
+		taxon.Author = author
+		if rank, err := ParseRank(record["taxonRank"]); err == nil {
+			taxon.Rank = rank
+		} else {
+			ds.noteProblem(id, "has unknown rank %q", record["taxonRank"])
+		}
+		taxonsById[id] = taxon
+		parentIds[taxon] = record["parentNameUsageID"]
+		taxons = append(taxons, taxon)
+	}
+	for _, record := range synonymRecords {
+		taxon, ok := taxonsById[record["acceptedNameUsageID"]]
+		if !ok {
+			ds.noteProblem(record["taxonID"], "is a synonym of unknown taxon %q", record["acceptedNameUsageID"])
+			continue
+		}
+		author := record["scientificNameAuthorship"]
+		synonym := Synonym{
+			Name:   strings.TrimSpace(strings.TrimSuffix(record["scientificName"], " "+author)),
+			Author: author,
+		}
+		synonym.Year, _ = strconv.Atoi(record["namePublishedInYear"])
+		synonym.Status = statuses[record["taxonomicStatus"]]
+		taxon.Synonyms = append(taxon.Synonyms, synonym)
+	}
+	placed := map[*Taxon]bool{}
+	var place func(taxon *Taxon, descendants map[*Taxon]bool)
+	place = func(taxon *Taxon, descendants map[*Taxon]bool) {
+		if placed[taxon] {
+			return
+		}
+		parent := ds.TaxonsHierarchy
+		if parentId := parentIds[taxon]; parentId != "" {
+			if parentTaxon, ok := taxonsById[parentId]; !ok {
+				ds.noteProblem(taxon.Id, "has unknown parent %q", parentId)
+			} else if descendants[parentTaxon] || parentTaxon == taxon {
+				ds.noteProblem(taxon.Id, "is an ancestor of its parent %q", parentId)
+			} else {
+				descendants[taxon] = true
+				place(parentTaxon, descendants)
+				parent = parentTaxon.Hierarchy
+			}
+		}
+		ds.AddTaxonBelow(taxon, parent)
+		placed[taxon] = true
+	}
+	for _, taxon := range taxons {
+		place(taxon, map[*Taxon]bool{})
+	}
+	return ds, nil
+}
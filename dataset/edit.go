@@ -0,0 +1,561 @@
+package dataset
+
+import "fmt"
+
+func insertHierarchyAt(children []*Hierarchy, h *Hierarchy, position int) []*Hierarchy {
+	if position < 0 || position > len(children) {
+		position = len(children)
+	}
+	children = append(children, nil)
+	copy(children[position+1:], children[position:])
+	children[position] = h
+	return children
+}
+
+func findParent(root *Hierarchy, id string) (*Hierarchy, int) {
+	for i, child := range root.Children {
+		if child.Id == id {
+			return root, i
+		}
+		if parent, index := findParent(child, id); parent != nil {
+			return parent, index
+		}
+	}
+	return nil, -1
+}
+
+func isInSubtree(h *Hierarchy, id string) bool {
+	if h.Id == id {
+		return true
+	}
+	for _, child := range h.Children {
+		if isInSubtree(child, id) {
+			return true
+		}
+	}
+	return false
+}
+
+func walkHierarchy(h *Hierarchy, visit func(h *Hierarchy)) {
+	visit(h)
+	for _, child := range h.Children {
+		walkHierarchy(child, visit)
+	}
+}
+
+func (ds *Dataset) taxonParent(parentId string) (*Hierarchy, error) {
+	if parentId == "" || parentId == ds.TaxonsHierarchy.Id {
+		return ds.TaxonsHierarchy, nil
+	}
+	parent, ok := ds.TaxonsById[parentId]
+	if !ok {
+		return nil, fmt.Errorf("no parent taxon with id %q", parentId)
+	}
+	return parent.Hierarchy, nil
+}
+
+func (ds *Dataset) characterParent(parentId string) (*Hierarchy, error) {
+	if parentId == "" || parentId == ds.CharactersHierarchy.Id {
+		return ds.CharactersHierarchy, nil
+	}
+	parent, ok := ds.CharactersById[parentId]
+	if !ok {
+		return nil, fmt.Errorf("no parent character with id %q", parentId)
+	}
+	return parent.Hierarchy, nil
+}
+
+func (ds *Dataset) hasItem(id string) bool {
+	if _, ok := ds.TaxonsById[id]; ok {
+		return true
+	}
+	if _, ok := ds.CharactersById[id]; ok {
+		return true
+	}
+	_, state := ds.FindState(id)
+	return state != nil
+}
+
+// FindState returns the character owning the state and the state itself,
+// or nils if no character has a state with this id.
+func (ds *Dataset) FindState(stateId string) (*Character, *State) {
+	for _, ch := range ds.CharactersById {
+		for i := range ch.States {
+			if ch.States[i].Id == stateId {
+				return ch, &ch.States[i]
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (ds *Dataset) ParentTaxonId(id string) string {
+	parent, _ := findParent(ds.TaxonsHierarchy, id)
+	if parent == nil || parent == ds.TaxonsHierarchy {
+		return ""
+	}
+	return parent.Id
+}
+
+func (ds *Dataset) ParentCharacterId(id string) string {
+	parent, _ := findParent(ds.CharactersHierarchy, id)
+	if parent == nil || parent == ds.CharactersHierarchy {
+		return ""
+	}
+	return parent.Id
+}
+
+func (ds *Dataset) checkFreeIds(h *Hierarchy) error {
+	var err error
+	walkHierarchy(h, func(h *Hierarchy) {
+		if err == nil && ds.hasItem(h.Id) {
+			err = fmt.Errorf("id %q already in use", h.Id)
+		}
+	})
+	return err
+}
+
+func (ds *Dataset) InsertTaxon(taxon *Taxon, parentId string, position int) error {
+	if taxon.Hierarchy == nil || taxon.Id == "" {
+		return fmt.Errorf("cannot insert a taxon without id")
+	}
+	if err := ds.checkFreeIds(taxon.Hierarchy); err != nil {
+		return fmt.Errorf("cannot insert taxon %q: %s", taxon.Id, err.Error())
+	}
+	parent, err := ds.taxonParent(parentId)
+	if err != nil {
+		return err
+	}
+	if taxon.ExtraInfo == nil {
+		taxon.ExtraInfo = map[string]interface{}{}
+	}
+	walkHierarchy(taxon.Hierarchy, func(h *Hierarchy) {
+		if _, ok := ds.TaxonsById[h.Id]; !ok {
+			ds.TaxonsById[h.Id] = NewTaxon(h)
+		}
+	})
+	ds.TaxonsById[taxon.Id] = taxon
+	parent.Children = insertHierarchyAt(parent.Children, taxon.Hierarchy, position)
+	return nil
+}
+
+func (ds *Dataset) InsertCharacter(ch *Character, parentId string, position int) error {
+	if ch.Hierarchy == nil || ch.Id == "" {
+		return fmt.Errorf("cannot insert a character without id")
+	}
+	if err := ds.checkFreeIds(ch.Hierarchy); err != nil {
+		return fmt.Errorf("cannot insert character %q: %s", ch.Id, err.Error())
+	}
+	for _, state := range ch.States {
+		if ds.hasItem(state.Id) {
+			return fmt.Errorf("cannot insert character %q: state id %q already in use", ch.Id, state.Id)
+		}
+	}
+	parent, err := ds.characterParent(parentId)
+	if err != nil {
+		return err
+	}
+	walkHierarchy(ch.Hierarchy, func(h *Hierarchy) {
+		if _, ok := ds.CharactersById[h.Id]; !ok {
+			ds.CharactersById[h.Id] = NewCharacter(h)
+		}
+	})
+	ds.CharactersById[ch.Id] = ch
+	parent.Children = insertHierarchyAt(parent.Children, ch.Hierarchy, position)
+	return nil
+}
+
+func (ds *Dataset) DeleteTaxon(id string) error {
+	parent, index := findParent(ds.TaxonsHierarchy, id)
+	if parent == nil {
+		return fmt.Errorf("cannot delete taxon %q: not found in the taxons hierarchy", id)
+	}
+	walkHierarchy(parent.Children[index], func(h *Hierarchy) {
+		delete(ds.TaxonsById, h.Id)
+	})
+	parent.Children = append(parent.Children[:index], parent.Children[index+1:]...)
+	return nil
+}
+
+func (ds *Dataset) DeleteCharacter(id string) error {
+	parent, index := findParent(ds.CharactersHierarchy, id)
+	if parent == nil {
+		return fmt.Errorf("cannot delete character %q: not found in the characters hierarchy", id)
+	}
+	removedStates := map[string]bool{}
+	walkHierarchy(parent.Children[index], func(h *Hierarchy) {
+		if ch, ok := ds.CharactersById[h.Id]; ok {
+			for _, state := range ch.States {
+				removedStates[state.Id] = true
+			}
+		}
+		delete(ds.CharactersById, h.Id)
+	})
+	parent.Children = append(parent.Children[:index], parent.Children[index+1:]...)
+	ds.forgetStates(removedStates)
+	return nil
+}
+
+func removeStateRefs(states []*State, removed map[string]bool) []*State {
+	kept := states[:0]
+	for _, state := range states {
+		if !removed[state.Id] {
+			kept = append(kept, state)
+		}
+	}
+	return kept
+}
+
+func (ds *Dataset) forgetStates(removed map[string]bool) {
+	for _, taxon := range ds.TaxonsById {
+		taxon.States = removeStateRefs(taxon.States, removed)
+	}
+	for _, ch := range ds.CharactersById {
+		ch.RequiredStates = removeStateRefs(ch.RequiredStates, removed)
+		ch.InapplicableStates = removeStateRefs(ch.InapplicableStates, removed)
+		if ch.InherentState != nil && removed[ch.InherentState.Id] {
+			ch.InherentState = nil
+		}
+	}
+}
+
+func (ds *Dataset) MoveTaxon(id string, parentId string, position int) error {
+	oldParent, index := findParent(ds.TaxonsHierarchy, id)
+	if oldParent == nil {
+		return fmt.Errorf("cannot move taxon %q: not found in the taxons hierarchy", id)
+	}
+	newParent, err := ds.taxonParent(parentId)
+	if err != nil {
+		return err
+	}
+	h := oldParent.Children[index]
+	if isInSubtree(h, newParent.Id) {
+		return fmt.Errorf("cannot move taxon %q below itself", id)
+	}
+	oldParent.Children = append(oldParent.Children[:index], oldParent.Children[index+1:]...)
+	newParent.Children = insertHierarchyAt(newParent.Children, h, position)
+	return nil
+}
+
+func (ds *Dataset) MoveCharacter(id string, parentId string, position int) error {
+	oldParent, index := findParent(ds.CharactersHierarchy, id)
+	if oldParent == nil {
+		return fmt.Errorf("cannot move character %q: not found in the characters hierarchy", id)
+	}
+	newParent, err := ds.characterParent(parentId)
+	if err != nil {
+		return err
+	}
+	h := oldParent.Children[index]
+	if isInSubtree(h, newParent.Id) {
+		return fmt.Errorf("cannot move character %q below itself", id)
+	}
+	oldParent.Children = append(oldParent.Children[:index], oldParent.Children[index+1:]...)
+	newParent.Children = insertHierarchyAt(newParent.Children, h, position)
+	return nil
+}
+
+func (ds *Dataset) nameOf(id string) (*MultilangText, error) {
+	if taxon, ok := ds.TaxonsById[id]; ok {
+		return &taxon.Name, nil
+	}
+	if ch, ok := ds.CharactersById[id]; ok {
+		return &ch.Name, nil
+	}
+	if _, state := ds.FindState(id); state != nil {
+		return &state.Name, nil
+	}
+	return nil, fmt.Errorf("no taxon, character or state with id %q", id)
+}
+
+func (ds *Dataset) Rename(id string, sciName string) error {
+	name, err := ds.nameOf(id)
+	if err != nil {
+		return err
+	}
+	name.Scientific = sciName
+	return nil
+}
+
+func (ds *Dataset) SetTranslation(id string, langRef string, text string) error {
+	name, err := ds.nameOf(id)
+	if err != nil {
+		return err
+	}
+	if text == "" {
+		delete(name.NamesByLangRef, langRef)
+		return nil
+	}
+	if name.NamesByLangRef == nil {
+		name.NamesByLangRef = map[string]string{}
+	}
+	name.NamesByLangRef[langRef] = text
+	return nil
+}
+
+func (ds *Dataset) SetDescription(id string, description string) error {
+	if taxon, ok := ds.TaxonsById[id]; ok {
+		taxon.Description = description
+	} else if ch, ok := ds.CharactersById[id]; ok {
+		ch.Description = description
+	} else if _, state := ds.FindState(id); state != nil {
+		state.Description = description
+	} else {
+		return fmt.Errorf("no taxon, character or state with id %q", id)
+	}
+	return nil
+}
+
+func (ds *Dataset) picturesOf(id string) (*[]Picture, error) {
+	if taxon, ok := ds.TaxonsById[id]; ok {
+		return &taxon.Pictures, nil
+	}
+	if ch, ok := ds.CharactersById[id]; ok {
+		return &ch.Pictures, nil
+	}
+	if _, state := ds.FindState(id); state != nil {
+		return &state.Pictures, nil
+	}
+	return nil, fmt.Errorf("no taxon, character or state with id %q", id)
+}
+
+func (ds *Dataset) AddPicture(id string, pic Picture, position int) (string, error) {
+	pics, err := ds.picturesOf(id)
+	if err != nil {
+		return "", err
+	}
+	if pic.Id == "" {
+		pic.Id = generateNewId(id+"-p", len(*pics), func(picId string) bool {
+			for _, p := range *pics {
+				if p.Id == picId {
+					return true
+				}
+			}
+			return false
+		})
+	}
+	for _, p := range *pics {
+		if p.Id == pic.Id {
+			return "", fmt.Errorf("item %q already has a picture with id %q", id, pic.Id)
+		}
+	}
+	if position < 0 || position > len(*pics) {
+		position = len(*pics)
+	}
+	*pics = append(*pics, Picture{})
+	copy((*pics)[position+1:], (*pics)[position:])
+	(*pics)[position] = pic
+	return pic.Id, nil
+}
+
+func (ds *Dataset) UpdatePicture(id string, pic Picture) error {
+	pics, err := ds.picturesOf(id)
+	if err != nil {
+		return err
+	}
+	for i, p := range *pics {
+		if p.Id == pic.Id {
+			(*pics)[i] = pic
+			return nil
+		}
+	}
+	return fmt.Errorf("item %q has no picture with id %q", id, pic.Id)
+}
+
+func (ds *Dataset) RemovePicture(id string, pictureId string) error {
+	pics, err := ds.picturesOf(id)
+	if err != nil {
+		return err
+	}
+	for i, p := range *pics {
+		if p.Id == pictureId {
+			*pics = append((*pics)[:i], (*pics)[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("item %q has no picture with id %q", id, pictureId)
+}
+
+func (ds *Dataset) AddState(characterId string, state State, position int) (string, error) {
+	ch, ok := ds.CharactersById[characterId]
+	if !ok {
+		return "", fmt.Errorf("cannot add state: no character with id %q", characterId)
+	}
+	if state.Id == "" {
+		count := 0
+		for _, ch := range ds.CharactersById {
+			count += len(ch.States)
+		}
+		state.Id = generateNewId("s", count, ds.hasItem)
+	} else if ds.hasItem(state.Id) {
+		return "", fmt.Errorf("cannot add state %q: id already in use", state.Id)
+	}
+	if state.Name.NamesByLangRef == nil {
+		state.Name.NamesByLangRef = map[string]string{}
+	}
+	if position < 0 || position > len(ch.States) {
+		position = len(ch.States)
+	}
+	ch.States = append(ch.States, State{})
+	copy(ch.States[position+1:], ch.States[position:])
+	ch.States[position] = state
+	return state.Id, nil
+}
+
+func (ds *Dataset) RemoveState(stateId string) error {
+	ch, state := ds.FindState(stateId)
+	if state == nil {
+		return fmt.Errorf("cannot remove state: no state with id %q", stateId)
+	}
+	for i := range ch.States {
+		if ch.States[i].Id == stateId {
+			ch.States = append(ch.States[:i], ch.States[i+1:]...)
+			break
+		}
+	}
+	ds.forgetStates(map[string]bool{stateId: true})
+	return nil
+}
+
+func (ds *Dataset) MoveState(stateId string, position int) error {
+	ch, state := ds.FindState(stateId)
+	if state == nil {
+		return fmt.Errorf("cannot move state: no state with id %q", stateId)
+	}
+	moved := *state
+	for i := range ch.States {
+		if ch.States[i].Id == stateId {
+			ch.States = append(ch.States[:i], ch.States[i+1:]...)
+			break
+		}
+	}
+	if position < 0 || position > len(ch.States) {
+		position = len(ch.States)
+	}
+	ch.States = append(ch.States, State{})
+	copy(ch.States[position+1:], ch.States[position:])
+	ch.States[position] = moved
+	return nil
+}
+
+func (ds *Dataset) TaxonHasState(taxonId string, stateId string) bool {
+	if taxon, ok := ds.TaxonsById[taxonId]; ok {
+		for _, state := range taxon.States {
+			if state.Id == stateId {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (ds *Dataset) SetTaxonState(taxonId string, stateId string) error {
+	taxon, ok := ds.TaxonsById[taxonId]
+	if !ok {
+		return fmt.Errorf("cannot set state %q: no taxon with id %q", stateId, taxonId)
+	}
+	states, err := ds.stateRefs([]string{stateId})
+	if err != nil {
+		return fmt.Errorf("cannot set state on taxon %q: %s", taxonId, err.Error())
+	}
+	if !ds.TaxonHasState(taxonId, stateId) {
+		taxon.States = append(taxon.States, states[0])
+	}
+	return nil
+}
+
+func (ds *Dataset) UnsetTaxonState(taxonId string, stateId string) error {
+	taxon, ok := ds.TaxonsById[taxonId]
+	if !ok {
+		return fmt.Errorf("cannot unset state %q: no taxon with id %q", stateId, taxonId)
+	}
+	taxon.States = removeStateRefs(taxon.States, map[string]bool{stateId: true})
+	return nil
+}
+
+func (ds *Dataset) SetAuthor(taxonId string, author string) error {
+	taxon, ok := ds.TaxonsById[taxonId]
+	if !ok {
+		return fmt.Errorf("cannot set author: no taxon with id %q", taxonId)
+	}
+	taxon.Author = author
+	return nil
+}
+
+func (ds *Dataset) SetExtraInfo(taxonId string, key string, value interface{}) error {
+	taxon, ok := ds.TaxonsById[taxonId]
+	if !ok {
+		return fmt.Errorf("cannot set extra info %q: no taxon with id %q", key, taxonId)
+	}
+	if value == nil {
+		delete(taxon.ExtraInfo, key)
+		return nil
+	}
+	if taxon.ExtraInfo == nil {
+		taxon.ExtraInfo = map[string]interface{}{}
+	}
+	taxon.ExtraInfo[key] = value
+	return nil
+}
+
+func (ds *Dataset) SetStateColor(stateId string, color string) error {
+	_, state := ds.FindState(stateId)
+	if state == nil {
+		return fmt.Errorf("cannot set color: no state with id %q", stateId)
+	}
+	state.Color = color
+	return nil
+}
+
+func (ds *Dataset) stateRefs(stateIds []string) ([]*State, error) {
+	states := make([]*State, 0, len(stateIds))
+	for _, stateId := range stateIds {
+		_, state := ds.FindState(stateId)
+		if state == nil {
+			return nil, fmt.Errorf("no state with id %q", stateId)
+		}
+		ref := *state
+		states = append(states, &ref)
+	}
+	return states, nil
+}
+
+func (ds *Dataset) SetTaxonStates(taxonId string, stateIds []string) error {
+	taxon, ok := ds.TaxonsById[taxonId]
+	if !ok {
+		return fmt.Errorf("cannot set states: no taxon with id %q", taxonId)
+	}
+	states, err := ds.stateRefs(stateIds)
+	if err != nil {
+		return fmt.Errorf("cannot set states of taxon %q: %s", taxonId, err.Error())
+	}
+	taxon.States = states
+	return nil
+}
+
+func (ds *Dataset) SetCharacterDependencies(characterId string, requiredStateIds []string, inapplicableStateIds []string, inherentStateId string) error {
+	ch, ok := ds.CharactersById[characterId]
+	if !ok {
+		return fmt.Errorf("cannot set dependencies: no character with id %q", characterId)
+	}
+	required, err := ds.stateRefs(requiredStateIds)
+	if err != nil {
+		return fmt.Errorf("cannot set required states of character %q: %s", characterId, err.Error())
+	}
+	inapplicable, err := ds.stateRefs(inapplicableStateIds)
+	if err != nil {
+		return fmt.Errorf("cannot set inapplicable states of character %q: %s", characterId, err.Error())
+	}
+	var inherent *State
+	if inherentStateId != "" {
+		refs, err := ds.stateRefs([]string{inherentStateId})
+		if err != nil {
+			return fmt.Errorf("cannot set inherent state of character %q: %s", characterId, err.Error())
+		}
+		inherent = refs[0]
+	}
+	ch.RequiredStates = required
+	ch.InapplicableStates = inapplicable
+	ch.InherentState = inherent
+	return nil
+}
@@ -0,0 +1,205 @@
+package dataset
+
+import (
+	"strings"
+	"testing"
+)
+
+func childrenIds(h *Hierarchy) string {
+	ids := make([]string, len(h.Children))
+	for i, child := range h.Children {
+		ids[i] = child.Id
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestAddCharacterBelowRoot(t *testing.T) {
+	ds := New("")
+	ds.AddCharacterBelow(NewCharacter(&Hierarchy{Id: "c1"}), nil)
+	if len(ds.TaxonsHierarchy.Children) != 0 || childrenIds(ds.CharactersHierarchy) != "c1" {
+		t.Logf("Character added below the wrong root.\ntaxons: %q\ncharacters: %q", childrenIds(ds.TaxonsHierarchy), childrenIds(ds.CharactersHierarchy))
+		t.Fail()
+	}
+}
+
+func TestAddCharacterBelowRootKeepsTaxons(t *testing.T) {
+	ds := readHazoString(t, hazoFixture)
+	ds.AddCharacterBelow(NewCharacter(&Hierarchy{Id: "c4"}), nil)
+	if childrenIds(ds.TaxonsHierarchy) != "t1,t2" || childrenIds(ds.CharactersHierarchy) != "c1,c2,c4" {
+		t.Logf("Character added below the wrong root.\ntaxons: %q\ncharacters: %q", childrenIds(ds.TaxonsHierarchy), childrenIds(ds.CharactersHierarchy))
+		t.Fail()
+	}
+}
+
+func TestCreateTaxonReturnsItsPath(t *testing.T) {
+	ds := readHazoString(t, hazoFixture)
+	path := ds.CreateTaxon([]int{0}, TaxonInit{Id: "t5"})
+	if got := ds.TaxonsHierarchy.GetIn(path); got == nil || got.Id != "t5" {
+		t.Logf("Expected the path %v of the new taxon to lead to t5, got %v.", path, got)
+		t.Fail()
+	}
+}
+
+func TestDeleteTaxon(t *testing.T) {
+	ds := readHazoString(t, hazoFixture)
+	if err := ds.DeleteTaxon("t1"); err != nil {
+		t.Logf("Unexpected error: %q.", err.Error())
+		t.FailNow()
+	}
+	for _, id := range []string{"t1", "t3", "t4"} {
+		if _, ok := ds.TaxonsById[id]; ok {
+			t.Logf("Expected taxon '%s' to be deleted.", id)
+			t.Fail()
+		}
+	}
+	if got := childrenIds(ds.TaxonsHierarchy); got != "t2" {
+		t.Logf("Wrong root children.\nexpected %s\ngot %s", "t2", got)
+		t.Fail()
+	}
+	if err := ds.DeleteTaxon("t1"); err == nil {
+		t.Logf("Expected an error when deleting a missing taxon.")
+		t.Fail()
+	}
+}
+
+func TestDeleteCharacterForgetsStates(t *testing.T) {
+	ds := readHazoString(t, hazoFixture)
+	if err := ds.DeleteCharacter("c1"); err != nil {
+		t.Logf("Unexpected error: %q.", err.Error())
+		t.FailNow()
+	}
+	if _, ok := ds.CharactersById["c3"]; ok {
+		t.Logf("Expected sub character 'c3' to be deleted.")
+		t.Fail()
+	}
+	if ds.TaxonHasState("t1", "s1") || !ds.TaxonHasState("t1", "s3") {
+		t.Logf("Wrong states for 't1' after deletion: %+v", ds.TaxonsById["t1"].States)
+		t.Fail()
+	}
+}
+
+func TestMoveTaxon(t *testing.T) {
+	ds := readHazoString(t, hazoFixture)
+	if err := ds.MoveTaxon("t2", "t1", 1); err != nil {
+		t.Logf("Unexpected error: %q.", err.Error())
+		t.FailNow()
+	}
+	if got := childrenIds(ds.TaxonsById["t1"].Hierarchy); got != "t3,t2,t4" {
+		t.Logf("Wrong children.\nexpected %s\ngot %s", "t3,t2,t4", got)
+		t.Fail()
+	}
+	if got := ds.ParentTaxonId("t2"); got != "t1" {
+		t.Logf("Wrong parent.\nexpected %s\ngot %s", "t1", got)
+		t.Fail()
+	}
+	if err := ds.MoveTaxon("t1", "t3", 0); err == nil {
+		t.Logf("Expected an error when moving a taxon below itself.")
+		t.Fail()
+	}
+	if err := ds.MoveTaxon("t3", "", -1); err != nil {
+		t.Logf("Unexpected error: %q.", err.Error())
+		t.FailNow()
+	}
+	if got := childrenIds(ds.TaxonsHierarchy); got != "t1,t3" {
+		t.Logf("Wrong root children.\nexpected %s\ngot %s", "t1,t3", got)
+		t.Fail()
+	}
+}
+
+func TestInsertTaxonRejectsDuplicates(t *testing.T) {
+	ds := readHazoString(t, hazoFixture)
+	if err := ds.InsertTaxon(NewTaxon(&Hierarchy{Id: "c1"}), "", -1); err == nil {
+		t.Logf("Expected an error when inserting a taxon with an id already in use.")
+		t.Fail()
+	}
+	if err := ds.InsertTaxon(NewTaxon(&Hierarchy{Id: "t9"}), "t42", -1); err == nil {
+		t.Logf("Expected an error when inserting a taxon below a missing parent.")
+		t.Fail()
+	}
+	if err := ds.InsertTaxon(NewTaxon(&Hierarchy{Id: "t9"}), "t2", -1); err != nil {
+		t.Logf("Unexpected error: %q.", err.Error())
+		t.FailNow()
+	}
+	if got := ds.ParentTaxonId("t9"); got != "t2" {
+		t.Logf("Wrong parent.\nexpected %s\ngot %s", "t2", got)
+		t.Fail()
+	}
+}
+
+func TestStatesEdition(t *testing.T) {
+	ds := readHazoString(t, hazoFixture)
+	id, err := ds.AddState("c2", State{Name: MultilangText{Scientific: "small"}}, 0)
+	if err != nil {
+		t.Logf("Unexpected error: %q.", err.Error())
+		t.FailNow()
+	}
+	if ds.CharactersById["c2"].States[0].Id != id {
+		t.Logf("Expected state %q to be first, got %+v.", id, ds.CharactersById["c2"].States)
+		t.Fail()
+	}
+	if err := ds.MoveState(id, -1); err != nil {
+		t.Logf("Unexpected error: %q.", err.Error())
+		t.FailNow()
+	}
+	if ds.CharactersById["c2"].States[1].Id != id {
+		t.Logf("Expected state %q to be last, got %+v.", id, ds.CharactersById["c2"].States)
+		t.Fail()
+	}
+	if err := ds.SetTaxonState("t2", id); err != nil || !ds.TaxonHasState("t2", id) {
+		t.Logf("Expected 't2' to have state %q (error: %v).", id, err)
+		t.Fail()
+	}
+	if err := ds.SetTaxonState("t2", "s42"); err == nil {
+		t.Logf("Expected an error when setting a missing state.")
+		t.Fail()
+	}
+	if err := ds.RemoveState(id); err != nil {
+		t.Logf("Unexpected error: %q.", err.Error())
+		t.FailNow()
+	}
+	if ds.TaxonHasState("t2", id) {
+		t.Logf("Expected removed state %q to be unset from 't2'.", id)
+		t.Fail()
+	}
+}
+
+func TestNamesAndPictures(t *testing.T) {
+	ds := readHazoString(t, hazoFixture)
+	if err := ds.Rename("s1", "crimson"); err != nil {
+		t.Logf("Unexpected error: %q.", err.Error())
+		t.FailNow()
+	}
+	if err := ds.SetTranslation("t3", "EN", ""); err != nil {
+		t.Logf("Unexpected error: %q.", err.Error())
+		t.FailNow()
+	}
+	if _, state := ds.FindState("s1"); state.Name.Scientific != "crimson" {
+		t.Logf("Wrong state name: %q.", state.Name.Scientific)
+		t.Fail()
+	}
+	if _, ok := ds.TaxonsById["t3"].Name.NamesByLangRef["EN"]; ok {
+		t.Logf("Expected the english name of 't3' to be removed.")
+		t.Fail()
+	}
+	picId, err := ds.AddPicture("c1", Picture{Source: "http://example.com/a.png"}, -1)
+	if err != nil {
+		t.Logf("Unexpected error: %q.", err.Error())
+		t.FailNow()
+	}
+	if err := ds.UpdatePicture("c1", Picture{Id: picId, Source: "http://example.com/b.png"}); err != nil {
+		t.Logf("Unexpected error: %q.", err.Error())
+		t.FailNow()
+	}
+	if pics := ds.CharactersById["c1"].Pictures; len(pics) != 1 || pics[0].Source != "http://example.com/b.png" {
+		t.Logf("Wrong pictures: %+v.", pics)
+		t.Fail()
+	}
+	if err := ds.RemovePicture("c1", picId); err != nil || len(ds.CharactersById["c1"].Pictures) != 0 {
+		t.Logf("Expected the picture to be removed (error: %v).", err)
+		t.Fail()
+	}
+	if err := ds.Rename("x1", "nothing"); err == nil {
+		t.Logf("Expected an error when renaming a missing item.")
+		t.Fail()
+	}
+}
@@ -0,0 +1,242 @@
+package dataset
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ExtraFieldText   = "text"
+	ExtraFieldNumber = "number"
+	ExtraFieldUrl    = "url"
+	ExtraFieldDate   = "date"
+	ExtraFieldEnum   = "enum"
+)
+
+var ExtraFieldTypes = []string{ExtraFieldText, ExtraFieldNumber, ExtraFieldUrl, ExtraFieldDate, ExtraFieldEnum}
+
+// Dates may be given to the day, the month or the year.
+var extraDateLayouts = []string{"2006-01-02", "2006-01", "2006"}
+
+// standardExtraFields are the taxon properties Hazo stores outside of the
+// extra map, keyed like in the extra infos of decoded taxons.
+var standardExtraFields = []ExtraField{
+	{IsStandard: true, Id: "vernacularName2", Label: "Vernacular name 2", Type: ExtraFieldText},
+	{IsStandard: true, Id: "meaning", Label: "Meaning", Type: ExtraFieldText},
+	{IsStandard: true, Id: "herbariumPicture", Label: "Herbarium picture", Type: ExtraFieldUrl},
+	{IsStandard: true, Id: "website", Label: "Website", Type: ExtraFieldUrl},
+	{IsStandard: true, Id: "noHerbier", Label: "Herbarium number", Type: ExtraFieldText},
+	{IsStandard: true, Id: "fasc", Label: "Fascicle", Type: ExtraFieldText},
+	{IsStandard: true, Id: "page", Label: "Page", Type: ExtraFieldText},
+}
+
+func standardExtraField(id string) (ExtraField, bool) {
+	for _, field := range standardExtraFields {
+		if field.Id == id {
+			return field, true
+		}
+	}
+	return ExtraField{}, false
+}
+
+func validExtraFieldType(fieldType string) bool {
+	for _, t := range ExtraFieldTypes {
+		if t == fieldType {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeExtraFields(encoded []*EncodedExtraField) ([]ExtraField, error) {
+	var fields []ExtraField
+	seen := map[string]bool{}
+	for _, e := range encoded {
+		if e == nil {
+			continue
+		}
+		if seen[e.Id] {
+			return nil, fmt.Errorf("duplicate extra field %q", e.Id)
+		}
+		seen[e.Id] = true
+		field := ExtraField{IsStandard: e.Std, Id: e.Id, Label: e.Label, Icon: e.Icon, Type: e.Type, Options: e.Options}
+		if std, ok := standardExtraField(e.Id); ok && e.Std && field.Type == "" {
+			field.Type = std.Type
+		}
+		if field.Type == "" {
+			field.Type = ExtraFieldText
+		}
+		if err := field.Check(); err != nil {
+			return nil, err
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+func encodeExtraFields(fields []ExtraField) []*EncodedExtraField {
+	encoded := make([]*EncodedExtraField, len(fields))
+	for i, field := range fields {
+		fieldType := field.Type
+		if fieldType == ExtraFieldText {
+			fieldType = ""
+		}
+		encoded[i] = &EncodedExtraField{
+			Std:     field.IsStandard,
+			Id:      field.Id,
+			Label:   field.Label,
+			Icon:    field.Icon,
+			Type:    fieldType,
+			Options: field.Options,
+		}
+	}
+	return encoded
+}
+
+// Check tells if the field definition is usable.
+func (f *ExtraField) Check() error {
+	if f.Id == "" {
+		return fmt.Errorf("extra field has no id")
+	}
+	if !validExtraFieldType(f.Type) {
+		return fmt.Errorf("extra field %q has unknown type %q", f.Id, f.Type)
+	}
+	if f.Type == ExtraFieldEnum && len(f.Options) == 0 {
+		return fmt.Errorf("extra field %q is an enum without options", f.Id)
+	}
+	return nil
+}
+
+// Normalize checks a value against the type of the field, returning it as a
+// float64 for numbers and as a string otherwise.
+func (f *ExtraField) Normalize(value interface{}) (interface{}, error) {
+	var text string
+	switch v := value.(type) {
+	case string:
+		text = strings.TrimSpace(v)
+	case float64:
+		text = strconv.FormatFloat(v, 'f', -1, 64)
+	case int:
+		text = strconv.Itoa(v)
+	case bool:
+		text = strconv.FormatBool(v)
+	default:
+		return nil, fmt.Errorf("%v is not a %s", value, f.Type)
+	}
+	switch f.Type {
+	case ExtraFieldNumber:
+		n, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number", text)
+		}
+		return n, nil
+	case ExtraFieldUrl:
+		u, err := url.Parse(text)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "ftp") {
+			return nil, fmt.Errorf("%q is not an absolute url", text)
+		}
+		return text, nil
+	case ExtraFieldDate:
+		for _, layout := range extraDateLayouts {
+			if _, err := time.Parse(layout, text); err == nil {
+				return text, nil
+			}
+		}
+		return nil, fmt.Errorf("%q is not a date like 2006-01-02", text)
+	case ExtraFieldEnum:
+		for _, option := range f.Options {
+			if strings.EqualFold(option, text) {
+				return option, nil
+			}
+		}
+		return nil, fmt.Errorf("%q is not one of %s", text, strings.Join(f.Options, ", "))
+	}
+	return text, nil
+}
+
+// FormatExtraValue returns the text of a normalized value.
+func FormatExtraValue(value interface{}) string {
+	if n, ok := value.(float64); ok {
+		return strconv.FormatFloat(n, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+// ExtraField returns the declared field with the given id, nil if there is
+// none.
+func (ds *Dataset) ExtraField(id string) *ExtraField {
+	for i := range ds.ExtraFields {
+		if ds.ExtraFields[i].Id == id {
+			return &ds.ExtraFields[i]
+		}
+	}
+	return nil
+}
+
+type ExtraValueError struct {
+	TaxonId string
+	FieldId string
+	Err     error
+}
+
+func (e *ExtraValueError) Error() string {
+	return fmt.Sprintf("taxon %q, field %q: %s", e.TaxonId, e.FieldId, e.Err.Error())
+}
+
+// ValidateExtraInfo returns the errors of the extra infos of taxons that do
+// not match the type of their declared field.
+func (ds *Dataset) ValidateExtraInfo() []*ExtraValueError {
+	errs := []*ExtraValueError{}
+	ids := make([]string, 0, len(ds.TaxonsById))
+	for id := range ds.TaxonsById {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		taxon := ds.TaxonsById[id]
+		for i := range ds.ExtraFields {
+			field := &ds.ExtraFields[i]
+			value, ok := taxon.ExtraInfo[field.Id]
+			if !ok {
+				continue
+			}
+			if _, err := field.Normalize(value); err != nil {
+				errs = append(errs, &ExtraValueError{TaxonId: id, FieldId: field.Id, Err: err})
+			}
+		}
+	}
+	return errs
+}
+
+// AllExtraFields returns the declared fields followed by text fields for the
+// keys of extra infos that no field declares, sorted by key.
+func (ds *Dataset) AllExtraFields() []ExtraField {
+	fields := make([]ExtraField, 0, len(ds.ExtraFields))
+	declared := map[string]bool{}
+	for _, field := range ds.ExtraFields {
+		fields = append(fields, field)
+		declared[field.Id] = true
+	}
+	var undeclared []string
+	for _, taxon := range ds.TaxonsById {
+		for key := range taxon.ExtraInfo {
+			if !declared[key] {
+				declared[key] = true
+				undeclared = append(undeclared, key)
+			}
+		}
+	}
+	sort.Strings(undeclared)
+	for _, key := range undeclared {
+		field, ok := standardExtraField(key)
+		if !ok {
+			field = ExtraField{Id: key, Label: key, Type: ExtraFieldText}
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
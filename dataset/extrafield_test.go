@@ -0,0 +1,94 @@
+package dataset
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const extraFieldsFixture = `{ "id": "ds",
+	"extraFields": [
+		{ "std": true, "id": "website", "label": "Website", "icon": "" },
+		{ "std": false, "id": "height", "label": "Height", "icon": "", "type": "number" },
+		{ "std": false, "id": "flowering", "label": "Flowering", "icon": "", "type": "enum", "options": ["spring", "summer"] },
+		{ "std": false, "id": "collected", "label": "Collected", "icon": "", "type": "date" }
+	],
+	"taxons": [
+		{ "id": "t1", "name": "a", "website": "https://example.org/a", "extra": { "height": 12.5, "flowering": "Spring", "collected": "1999-04" } },
+		{ "id": "t2", "name": "b", "website": "not a url", "extra": { "height": "tall", "flowering": "winter", "collected": "04/1999", "note": "x" } }
+	] }`
+
+func TestDecodeExtraFields(t *testing.T) {
+	ds := readHazoString(t, extraFieldsFixture)
+	expected := []ExtraField{
+		{IsStandard: true, Id: "website", Label: "Website", Type: ExtraFieldUrl},
+		{Id: "height", Label: "Height", Type: ExtraFieldNumber},
+		{Id: "flowering", Label: "Flowering", Type: ExtraFieldEnum, Options: []string{"spring", "summer"}},
+		{Id: "collected", Label: "Collected", Type: ExtraFieldDate},
+	}
+	if !reflect.DeepEqual(ds.ExtraFields, expected) {
+		t.Logf("Wrong extra fields, expected %v, got %v.", expected, ds.ExtraFields)
+		t.FailNow()
+	}
+	roundTrip := readHazoString(t, writeHazoString(t, ds, HazoOptions{}))
+	if !reflect.DeepEqual(roundTrip.ExtraFields, expected) {
+		t.Logf("Extra fields changed after a round trip, got %v.", roundTrip.ExtraFields)
+		t.Fail()
+	}
+	if got := roundTrip.TaxonsById["t1"].ExtraInfo["website"]; got != "https://example.org/a" {
+		t.Logf("Standard property lost after a round trip, got %v.", got)
+		t.Fail()
+	}
+	if _, err := ReadHazo(strings.NewReader(`{ "id": "ds", "extraFields": [ { "id": "x", "type": "color" } ] }`)); err == nil {
+		t.Logf("Expected an error for an unknown field type.")
+		t.Fail()
+	}
+	if _, err := ReadHazo(strings.NewReader(`{ "id": "ds", "extraFields": [ { "id": "x", "type": "enum" } ] }`)); err == nil {
+		t.Logf("Expected an error for an enum without options.")
+		t.Fail()
+	}
+}
+
+func TestExtraFieldNormalize(t *testing.T) {
+	ds := readHazoString(t, extraFieldsFixture)
+	tests := []struct {
+		field    string
+		value    interface{}
+		expected interface{}
+	}{
+		{"height", 12.5, 12.5},
+		{"height", " 3 ", 3.0},
+		{"flowering", "SUMMER", "summer"},
+		{"collected", "1999-04-02", "1999-04-02"},
+		{"website", "http://example.org", "http://example.org"},
+	}
+	for _, test := range tests {
+		got, err := ds.ExtraField(test.field).Normalize(test.value)
+		if err != nil || got != test.expected {
+			t.Logf("Normalizing %v as %s, expected %v, got %v (%v).", test.value, test.field, test.expected, got, err)
+			t.Fail()
+		}
+	}
+	errs := ds.ValidateExtraInfo()
+	fields := []string{}
+	for _, err := range errs {
+		if err.TaxonId != "t2" {
+			t.Logf("Unexpected error: %q.", err.Error())
+			t.Fail()
+		}
+		fields = append(fields, err.FieldId)
+	}
+	if expected := []string{"website", "height", "flowering", "collected"}; !reflect.DeepEqual(fields, expected) {
+		t.Logf("Expected errors for %v, got %v.", expected, fields)
+		t.Fail()
+	}
+}
+
+func TestAllExtraFields(t *testing.T) {
+	ds := readHazoString(t, extraFieldsFixture)
+	fields := ds.AllExtraFields()
+	if len(fields) != 5 || fields[4].Id != "note" || fields[4].Type != ExtraFieldText {
+		t.Logf("Expected the undeclared note field as text, got %v.", fields)
+		t.Fail()
+	}
+}
@@ -0,0 +1,47 @@
+package dataset
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// Formats of the files datasets can be read from.
+const (
+	FormatHazo = "hazo"
+	FormatSDD  = "sdd"
+	FormatCSV  = "csv"
+	FormatDwCA = "dwca"
+)
+
+var Formats = []string{FormatHazo, FormatSDD, FormatCSV, FormatDwCA}
+
+// FormatOf guesses the format of a dataset file from its name, Hazo by
+// default.
+func FormatOf(name string) string {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".xml", ".sdd":
+		return FormatSDD
+	case ".csv", ".tsv", ".txt":
+		return FormatCSV
+	case ".zip":
+		return FormatDwCA
+	}
+	return FormatHazo
+}
+
+// ReadFormat reads a dataset in one of the Formats.
+func ReadFormat(format string, r io.Reader) (*Dataset, error) {
+	switch format {
+	case FormatHazo:
+		return ReadHazo(r)
+	case FormatSDD:
+		return ReadSDD(r)
+	case FormatCSV:
+		return ReadCSVMatrix(r)
+	case FormatDwCA:
+		return ReadDwCA(r)
+	}
+	return nil, fmt.Errorf("unknown dataset format %q", format)
+}
@@ -0,0 +1,166 @@
+package dataset
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var glossaryLangs = []string{"EN", "FR", "CN"}
+
+func decodeDictionaryEntries(encoded map[string]*EncodedDictionaryEntry) []DictionaryEntry {
+	keys := make([]string, 0, len(encoded))
+	for key, entry := range encoded {
+		if entry != nil {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := encoded[keys[i]], encoded[keys[j]]
+		if a.Id != b.Id {
+			return a.Id < b.Id
+		}
+		return keys[i] < keys[j]
+	})
+	var entries []DictionaryEntry
+	for _, key := range keys {
+		e := encoded[key]
+		entry := DictionaryEntry{
+			Id:         key,
+			Url:        e.Url,
+			Name:       MultilangText{NamesByLangRef: map[string]string{}},
+			Definition: MultilangText{NamesByLangRef: map[string]string{}},
+		}
+		names := map[string]string{"EN": e.NameEN, "FR": e.NameFR, "CN": e.NameCN}
+		defs := map[string]string{"EN": e.DefEN, "FR": e.DefFR, "CN": e.DefCN}
+		for _, lang := range glossaryLangs {
+			if names[lang] != "" {
+				entry.Name.NamesByLangRef[lang] = names[lang]
+				if entry.Name.Scientific == "" {
+					entry.Name.Scientific = names[lang]
+				}
+			}
+			if defs[lang] != "" {
+				entry.Definition.NamesByLangRef[lang] = defs[lang]
+				if entry.Definition.Scientific == "" {
+					entry.Definition.Scientific = defs[lang]
+				}
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func encodeDictionaryEntries(entries []DictionaryEntry) map[string]*EncodedDictionaryEntry {
+	encoded := make(map[string]*EncodedDictionaryEntry, len(entries))
+	for _, entry := range entries {
+		id, _ := strconv.Atoi(entry.Id)
+		encoded[entry.Id] = &EncodedDictionaryEntry{
+			Id:     id,
+			NameCN: entry.Name.NamesByLangRef["CN"],
+			NameEN: entry.Name.NamesByLangRef["EN"],
+			NameFR: entry.Name.NamesByLangRef["FR"],
+			DefCN:  entry.Definition.NamesByLangRef["CN"],
+			DefEN:  entry.Definition.NamesByLangRef["EN"],
+			DefFR:  entry.Definition.NamesByLangRef["FR"],
+			Url:    entry.Url,
+		}
+	}
+	return encoded
+}
+
+// TextSegment is a part of a text, pointing to the glossary entry it names
+// when it is a term.
+type TextSegment struct {
+	Text  string
+	Entry *DictionaryEntry
+}
+
+type glossaryTerm struct {
+	text  string
+	entry *DictionaryEntry
+}
+
+// Glossary finds the terms of dictionary entries in texts, in any of the
+// languages of their names.
+type Glossary struct {
+	termsByFirstRune map[rune][]glossaryTerm
+}
+
+func NewGlossary(entries []*DictionaryEntry) *Glossary {
+	g := &Glossary{termsByFirstRune: map[rune][]glossaryTerm{}}
+	for _, entry := range entries {
+		seen := map[string]bool{}
+		names := []string{entry.Name.Scientific}
+		for _, lang := range sortedLangs(entry.Name.NamesByLangRef) {
+			names = append(names, entry.Name.NamesByLangRef[lang])
+		}
+		for _, name := range names {
+			name = strings.TrimSpace(name)
+			if name == "" || seen[strings.ToLower(name)] {
+				continue
+			}
+			seen[strings.ToLower(name)] = true
+			first, _ := utf8.DecodeRuneInString(name)
+			first = unicode.ToLower(first)
+			g.termsByFirstRune[first] = append(g.termsByFirstRune[first], glossaryTerm{text: name, entry: entry})
+		}
+	}
+	for _, terms := range g.termsByFirstRune {
+		sort.SliceStable(terms, func(i, j int) bool { return len(terms[i].text) > len(terms[j].text) })
+	}
+	return g
+}
+
+// isWordRune tells if a rune belongs to a word whose boundaries a term must
+// respect. Ideographs have no such boundaries.
+func isWordRune(r rune) bool {
+	return (unicode.IsLetter(r) || unicode.IsDigit(r)) && !unicode.Is(unicode.Han, r)
+}
+
+// Split cuts a text into plain segments and segments naming glossary terms,
+// preferring the longest term at each position.
+func (g *Glossary) Split(text string) []TextSegment {
+	segments := []TextSegment{}
+	plainStart := 0
+	prev := rune(-1)
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		var match *glossaryTerm
+		if prev < 0 || !isWordRune(prev) || !isWordRune(r) {
+			for k, term := range g.termsByFirstRune[unicode.ToLower(r)] {
+				end := i + len(term.text)
+				if end > len(text) || !strings.EqualFold(text[i:end], term.text) {
+					continue
+				}
+				last, _ := utf8.DecodeLastRuneInString(term.text)
+				next, _ := utf8.DecodeRuneInString(text[end:])
+				if end < len(text) && isWordRune(last) && isWordRune(next) {
+					continue
+				}
+				match = &g.termsByFirstRune[unicode.ToLower(r)][k]
+				break
+			}
+		}
+		if match == nil {
+			prev = r
+			i += size
+			continue
+		}
+		if plainStart < i {
+			segments = append(segments, TextSegment{Text: text[plainStart:i]})
+		}
+		end := i + len(match.text)
+		segments = append(segments, TextSegment{Text: text[i:end], Entry: match.entry})
+		prev, _ = utf8.DecodeLastRuneInString(text[i:end])
+		i = end
+		plainStart = end
+	}
+	if plainStart < len(text) {
+		segments = append(segments, TextSegment{Text: text[plainStart:]})
+	}
+	return segments
+}
@@ -0,0 +1,57 @@
+package dataset
+
+import (
+	"strings"
+	"testing"
+)
+
+const glossaryFixture = `{
+	"id": "ds",
+	"dictionaryEntries": {
+		"2": { "id": 2, "nameEN": "leaf blade", "nameFR": "limbe", "defEN": "The flat part of a leaf." },
+		"1": { "id": 1, "nameEN": "leaf", "nameCN": "叶", "defEN": "An organ.", "url": "http://example.com/leaf" }
+	}
+}`
+
+func TestHazoGlossaryRoundTrip(t *testing.T) {
+	ds := readHazoString(t, glossaryFixture)
+	if len(ds.DictionaryEntry) != 2 || ds.DictionaryEntry[0].Id != "1" || ds.DictionaryEntry[1].Name.NamesByLangRef["FR"] != "limbe" {
+		t.Logf("Wrong dictionary entries: %+v.", ds.DictionaryEntry)
+		t.FailNow()
+	}
+	expected := writeHazoString(t, ds, HazoOptions{SortKeys: true})
+	if got := writeHazoString(t, readHazoString(t, expected), HazoOptions{SortKeys: true}); got != expected {
+		t.Logf("Output changed after a round trip.\nexpected %s\ngot %s", expected, got)
+		t.Fail()
+	}
+}
+
+func TestGlossarySplit(t *testing.T) {
+	ds := readHazoString(t, glossaryFixture)
+	entries := make([]*DictionaryEntry, len(ds.DictionaryEntry))
+	for i := range ds.DictionaryEntry {
+		entries[i] = &ds.DictionaryEntry[i]
+	}
+	g := NewGlossary(entries)
+	cases := map[string]string{
+		"Leaf blade ovate, leaves green.": "[Leaf blade] ovate, leaves green.",
+		"limbe large; leaf":               "[limbe] large; [leaf]",
+		"叶片":                              "[叶]片",
+		"no term here":                    "no term here",
+		"leafy, (leaf)":                   "leafy, ([leaf])",
+	}
+	for text, expected := range cases {
+		var b strings.Builder
+		for _, segment := range g.Split(text) {
+			if segment.Entry != nil {
+				b.WriteString("[" + segment.Text + "]")
+			} else {
+				b.WriteString(segment.Text)
+			}
+		}
+		if got := b.String(); got != expected {
+			t.Logf("Wrong split of %q, expected %q, got %q.", text, expected, got)
+			t.Fail()
+		}
+	}
+}
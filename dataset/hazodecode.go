@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"io"
 	"io/ioutil"
+	"sort"
 	"strconv"
+	"strings"
 )
 
 func stringOrArrayQuirck(stringOrArray interface{}) string {
@@ -53,9 +55,12 @@ func decodeHierarchy(encoded *EncodedItem) *Hierarchy {
 	}
 }
 
-func decodeStatesByIds(states []*EncodedState) map[string]*State {
+func decodeStatesByIds(ds *Dataset, states []*EncodedState) map[string]*State {
 	statesByIds := make(map[string]*State)
 	for _, state := range states {
+		if _, ok := statesByIds[state.Id]; ok {
+			ds.noteProblem(state.Id, "is the id of several states")
+		}
 		statesByIds[state.Id] = &State{
 			Id:          state.Id,
 			Description: state.Description,
@@ -74,30 +79,64 @@ func decodeStatesByIds(states []*EncodedState) map[string]*State {
 	return statesByIds
 }
 
-func decodeTaxonsByIds(encodedTaxons []*EncodedTaxon, statesByIds map[string]*State) map[string]*Taxon {
+func decodeBooks(encodedBooks []*EncodedBook) []Book {
+	var books []Book
+	for _, book := range encodedBooks {
+		books = append(books, Book{
+			Id:        book.Id,
+			Kind:      book.Kind,
+			Title:     book.Label,
+			Authors:   book.Authors,
+			Year:      book.Year,
+			Journal:   book.Journal,
+			Volume:    book.Volume,
+			Issue:     book.Issue,
+			Pages:     book.Pages,
+			Publisher: book.Publisher,
+			Doi:       book.Doi,
+			Url:       book.Url,
+		})
+	}
+	return books
+}
+
+func decodeTaxonsByIds(ds *Dataset, encodedTaxons []*EncodedTaxon, statesByIds map[string]*State, bookOrder map[string]int) map[string]*Taxon {
 	taxonsByIds := map[string]*Taxon{}
 	for _, taxon := range encodedTaxons {
+		if _, ok := taxonsByIds[taxon.Id]; ok {
+			ds.noteProblem(taxon.Id, "is the id of several taxons")
+		}
 		states := make([]*State, 0)
 		for _, desc := range taxon.Descriptions {
 			for _, stateId := range desc.StatesIds {
 				state, ok := statesByIds[stateId]
 				if ok {
 					states = append(states, state)
+				} else {
+					ds.noteProblem(taxon.Id, "has unknown state %q", stateId)
 				}
 			}
 		}
-		refs := make([]BookReference, 0, len(taxon.BookInfoByIds))
-		for _, bookInfo := range taxon.BookInfoByIds {
+		var refs []BookReference
+		for bookId, bookInfo := range taxon.BookInfoByIds {
 			page, err := strconv.Atoi(bookInfo.Page)
 			if err != nil {
 				page = 0
 			}
 			refs = append(refs, BookReference{
+				BookId: bookId,
 				Page:   page,
 				Fasc:   bookInfo.Fasc,
 				Detail: bookInfo.Detail,
 			})
 		}
+		sort.Slice(refs, func(i, j int) bool {
+			oi, oj := bookOrder[refs[i].BookId], bookOrder[refs[j].BookId]
+			if oi != oj {
+				return oi < oj
+			}
+			return refs[i].BookId < refs[j].BookId
+		})
 		extras := map[string]interface{}{}
 		for k, v := range taxon.Extra {
 			extras[k] = v
@@ -108,16 +147,27 @@ func decodeTaxonsByIds(encodedTaxons []*EncodedTaxon, statesByIds map[string]*St
 			}
 		}
 		addExtra("vernacularName2", taxon.VernacularName2)
-		addExtra("name2", taxon.Name2)
-		addExtra("herbariumpicture", taxon.HerbariumPicture)
+		addExtra("meaning", taxon.Meaning)
+		addExtra("herbariumPicture", taxon.HerbariumPicture)
 		addExtra("website", taxon.Website)
 		addExtra("noHerbier", taxon.NoHerbier)
 		addExtra("fasc", taxon.Fasc)
 		addExtra("page", taxon.Page)
+		rank := ""
+		if name, ok := extras["rank"].(string); ok {
+			if parsed, err := ParseRank(name); err == nil {
+				rank = parsed
+				delete(extras, "rank")
+			}
+		}
+		synonyms := decodeSynonyms(taxon.Name2, extras["synonyms"])
+		delete(extras, "synonyms")
 		hierarchy := decodeHierarchy(&taxon.EncodedItem)
 		taxon := &Taxon{
 			Hierarchy:  hierarchy,
 			Author:     taxon.Author,
+			Rank:       rank,
+			Synonyms:   synonyms,
 			States:     states,
 			References: refs,
 			ExtraInfo:  extras,
@@ -127,24 +177,39 @@ func decodeTaxonsByIds(encodedTaxons []*EncodedTaxon, statesByIds map[string]*St
 	return taxonsByIds
 }
 
-func decodeCharactersByIds(encodedCharacters []*EncodedCharacter, statesByIds map[string]*State) map[string]*Character {
+// decodeStateRefs returns the states of the ids, leaving out the unknown
+// ones.
+func decodeStateRefs(ds *Dataset, itemId string, stateIds []string, statesByIds map[string]*State) []*State {
+	states := make([]*State, 0, len(stateIds))
+	for _, stateId := range stateIds {
+		if state, ok := statesByIds[stateId]; ok {
+			states = append(states, state)
+		} else {
+			ds.noteProblem(itemId, "refers to unknown state %q", stateId)
+		}
+	}
+	return states
+}
+
+func decodeCharactersByIds(ds *Dataset, encodedCharacters []*EncodedCharacter, statesByIds map[string]*State) map[string]*Character {
 	charactersByIds := map[string]*Character{}
 	for _, ch := range encodedCharacters {
-		states := make([]State, len(ch.States))
-		for i, stateId := range ch.States {
-			states[i] = *statesByIds[stateId]
+		if _, ok := charactersByIds[ch.Id]; ok {
+			ds.noteProblem(ch.Id, "is the id of several characters")
 		}
-		inapplicableStates := make([]*State, len(ch.InapplicableStatesIds))
-		for i, stateId := range ch.InapplicableStatesIds {
-			inapplicableStates[i] = statesByIds[stateId]
+		states := make([]State, 0, len(ch.States))
+		for _, state := range decodeStateRefs(ds, ch.Id, ch.States, statesByIds) {
+			states = append(states, *state)
 		}
-		requiredStates := make([]*State, len(ch.RequiredStatesIds))
-		for i, stateId := range ch.RequiredStatesIds {
-			requiredStates[i] = statesByIds[stateId]
+		inapplicableStates := decodeStateRefs(ds, ch.Id, ch.InapplicableStatesIds, statesByIds)
+		requiredStates := decodeStateRefs(ds, ch.Id, ch.RequiredStatesIds, statesByIds)
+		inherentState, ok := statesByIds[ch.InherentStateId]
+		if !ok && ch.InherentStateId != "" {
+			ds.noteProblem(ch.Id, "refers to unknown state %q", ch.InherentStateId)
 		}
 		character := &Character{
 			Hierarchy:          decodeHierarchy(&ch.EncodedItem),
-			InherentState:      statesByIds[ch.InherentStateId],
+			InherentState:      inherentState,
 			States:             states,
 			InapplicableStates: inapplicableStates,
 			RequiredStates:     requiredStates,
@@ -154,6 +219,77 @@ func decodeCharactersByIds(encodedCharacters []*EncodedCharacter, statesByIds ma
 	return charactersByIds
 }
 
+// walkEncodedHierarchy visits items parents first, siblings in the order of
+// their parent's children list, then in file order for items only known by
+// their parentId. Items caught in a cycle are visited last, as roots.
+// Parents and children that do not exist are noted and ignored.
+func walkEncodedHierarchy(items []*EncodedItem, note func(itemId string, format string, args ...interface{}), visit func(id string, parentId string)) {
+	exists := make(map[string]bool, len(items))
+	for _, item := range items {
+		exists[item.Id] = true
+	}
+	for _, item := range items {
+		if item.ParentId != "" && !exists[item.ParentId] {
+			note(item.Id, "has unknown parent %q", item.ParentId)
+		}
+		for _, childId := range item.Children {
+			if !exists[childId] {
+				note(item.Id, "has unknown child %q", childId)
+			}
+		}
+	}
+	parentOf := map[string]string{}
+	for _, item := range items {
+		if item.ParentId != "" && item.ParentId != item.Id && exists[item.ParentId] {
+			parentOf[item.Id] = item.ParentId
+		}
+	}
+	for _, item := range items {
+		for _, childId := range item.Children {
+			if _, ok := parentOf[childId]; !ok && childId != item.Id && exists[childId] {
+				parentOf[childId] = item.Id
+			}
+		}
+	}
+	childrenOf := map[string][]string{}
+	listed := map[string]bool{}
+	for _, item := range items {
+		for _, childId := range item.Children {
+			if parentOf[childId] == item.Id && !listed[childId] {
+				listed[childId] = true
+				childrenOf[item.Id] = append(childrenOf[item.Id], childId)
+			}
+		}
+	}
+	roots := []string{}
+	for _, item := range items {
+		if parentId, ok := parentOf[item.Id]; !ok {
+			roots = append(roots, item.Id)
+		} else if !listed[item.Id] {
+			listed[item.Id] = true
+			childrenOf[parentId] = append(childrenOf[parentId], item.Id)
+		}
+	}
+	visited := make(map[string]bool, len(items))
+	var walk func(id string, parentId string)
+	walk = func(id string, parentId string) {
+		if visited[id] {
+			return
+		}
+		visited[id] = true
+		visit(id, parentId)
+		for _, childId := range childrenOf[id] {
+			walk(childId, id)
+		}
+	}
+	for _, id := range roots {
+		walk(id, "")
+	}
+	for _, item := range items {
+		walk(item.Id, "")
+	}
+}
+
 func ReadHazo(r io.Reader) (*Dataset, error) {
 	data, err := ioutil.ReadAll(r)
 	if err != nil {
@@ -165,28 +301,61 @@ func ReadHazo(r io.Reader) (*Dataset, error) {
 		return nil, err
 	}
 	dataset := New(encodedDataset.Id)
-	statesByIds := decodeStatesByIds(encodedDataset.States)
-	dataset.TaxonsById = decodeTaxonsByIds(encodedDataset.Taxons, statesByIds)
-	dataset.CharactersById = decodeCharactersByIds(encodedDataset.Characters, statesByIds)
-	for _, t := range encodedDataset.Taxons {
-		taxon := dataset.TaxonsById[t.Id]
-		parent := dataset.TaxonsById[t.ParentId]
-		if taxon != nil {
-			if parent != nil {
-				dataset.AddTaxonBelow(taxon, parent.Hierarchy)
-			} else {
-				dataset.AddTaxonBelow(taxon, dataset.TaxonsHierarchy)
-			}
+	statesByIds := decodeStatesByIds(dataset, encodedDataset.States)
+	dataset.Books = decodeBooks(encodedDataset.Books)
+	dataset.DictionaryEntry = decodeDictionaryEntries(encodedDataset.DictionaryEntries)
+	if dataset.ExtraFields, err = decodeExtraFields(encodedDataset.ExtraFields); err != nil {
+		return nil, err
+	}
+	bookOrder := make(map[string]int, len(dataset.Books))
+	for i, book := range dataset.Books {
+		bookOrder[book.Id] = i
+	}
+	dataset.TaxonsById = decodeTaxonsByIds(dataset, encodedDataset.Taxons, statesByIds, bookOrder)
+	dataset.CharactersById = decodeCharactersByIds(dataset, encodedDataset.Characters, statesByIds)
+	taxonItems := make([]*EncodedItem, len(encodedDataset.Taxons))
+	for i, t := range encodedDataset.Taxons {
+		taxonItems[i] = &t.EncodedItem
+	}
+	walkEncodedHierarchy(taxonItems, dataset.noteProblem, func(id string, parentId string) {
+		taxon := dataset.TaxonsById[id]
+		if parent, ok := dataset.TaxonsById[parentId]; ok {
+			dataset.AddTaxonBelow(taxon, parent.Hierarchy)
+		} else {
+			dataset.AddTaxonBelow(taxon, dataset.TaxonsHierarchy)
 		}
+	})
+	characterItems := make([]*EncodedItem, len(encodedDataset.Characters))
+	for i, ch := range encodedDataset.Characters {
+		characterItems[i] = &ch.EncodedItem
 	}
-	for _, ch := range encodedDataset.Characters {
-		character := dataset.CharactersById[ch.Id]
-		parent := dataset.CharactersById[ch.ParentId]
-		if parent != nil {
+	walkEncodedHierarchy(characterItems, dataset.noteProblem, func(id string, parentId string) {
+		character := dataset.CharactersById[id]
+		if parent, ok := dataset.CharactersById[parentId]; ok {
 			dataset.AddCharacterBelow(character, parent.Hierarchy)
 		} else {
 			dataset.AddCharacterBelow(character, dataset.CharactersHierarchy)
 		}
-	}
+	})
 	return dataset, nil
 }
+
+// decodeSynonyms reads the detailed synonyms stored in the extras when they
+// exist, and the plain names of name2 otherwise.
+func decodeSynonyms(name2 string, extra interface{}) []Synonym {
+	if extra != nil {
+		if data, err := json.Marshal(extra); err == nil {
+			synonyms := []Synonym{}
+			if err := json.Unmarshal(data, &synonyms); err == nil {
+				return synonyms
+			}
+		}
+	}
+	var synonyms []Synonym
+	for _, name := range strings.Split(name2, ";") {
+		if name = strings.TrimSpace(name); name != "" {
+			synonyms = append(synonyms, Synonym{Name: name})
+		}
+	}
+	return synonyms
+}
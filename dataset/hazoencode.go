@@ -1,13 +1,20 @@
 package dataset
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"io"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 var extraneousProps = []string{
 	"vernacularName2",
 	"name2",
+	"meaning",
 	"herbariumPicture",
 	"website",
 	"noHerbier",
@@ -35,22 +42,30 @@ func encodeItem(hierarchy *Hierarchy, parentId string, childrenIds []string) Enc
 		NameCN:         hierarchy.Name.Text("CN"),
 		NameEN:         hierarchy.Name.Text("EN"),
 		VernacularName: hierarchy.Name.Text("NV"),
+		Detail:         hierarchy.Description,
 		Photos:         encodePictures(hierarchy.Pictures),
 		Children:       childrenIds,
 	}
 }
 
-func encodeTaxon(ds *Dataset, taxon *Taxon, parentId string, charByStateId map[string]string, out *[]*EncodedTaxon) {
+func encodeTaxon(ds *Dataset, taxon *Taxon, parentId string, charByStateId map[string]string, charOrder map[string]int, out *[]*EncodedTaxon) {
 	statesByChar := map[string][]string{}
+	charIds := []string{}
 	for _, state := range taxon.States {
 		charId := charByStateId[state.Id]
+		if _, ok := statesByChar[charId]; !ok {
+			charIds = append(charIds, charId)
+		}
 		statesByChar[charId] = append(statesByChar[charId], state.Id)
 	}
+	sort.SliceStable(charIds, func(i, j int) bool {
+		return charOrder[charIds[i]] < charOrder[charIds[j]]
+	})
 	descriptions := make([]EncodedDescriptions, 0, len(statesByChar))
-	for charId, stateIds := range statesByChar {
+	for _, charId := range charIds {
 		descriptions = append(descriptions, EncodedDescriptions{
 			DescriptorId: charId,
-			StatesIds:    stateIds,
+			StatesIds:    statesByChar[charId],
 		})
 	}
 	extras := map[string]interface{}{}
@@ -60,25 +75,67 @@ func encodeTaxon(ds *Dataset, taxon *Taxon, parentId string, charByStateId map[s
 	for _, extraProp := range extraneousProps {
 		delete(extras, extraProp)
 	}
+	if taxon.Rank != "" {
+		extras["rank"] = taxon.Rank
+	}
+	synonymNames := make([]string, len(taxon.Synonyms))
+	for i, synonym := range taxon.Synonyms {
+		synonymNames[i] = synonym.Name
+		if synonym != (Synonym{Name: synonym.Name}) {
+			extras["synonyms"] = taxon.Synonyms
+		}
+	}
 	bookInfoByIds := map[string]EncodedBookInfo{}
+	for _, ref := range taxon.References {
+		page := ""
+		if ref.Page != 0 {
+			page = strconv.Itoa(ref.Page)
+		}
+		bookInfoByIds[ref.BookId] = EncodedBookInfo{Fasc: ref.Fasc, Page: page, Detail: ref.Detail}
+	}
 	childrenIds := make([]string, len(taxon.Children))
 	for i, child := range taxon.Children {
 		childrenIds[i] = child.Id
 	}
-	*out = append(*out, &EncodedTaxon{
+	encodedTaxon := &EncodedTaxon{
 		EncodedItem:   encodeItem(taxon.Hierarchy, parentId, childrenIds),
 		Author:        taxon.Author,
+		Name2:         strings.Join(synonymNames, "; "),
 		Descriptions:  descriptions,
 		BookInfoByIds: bookInfoByIds,
 		Extra:         extras,
-	})
+	}
+	standardProps := map[string]*string{
+		"vernacularName2":  &encodedTaxon.VernacularName2,
+		"meaning":          &encodedTaxon.Meaning,
+		"herbariumPicture": &encodedTaxon.HerbariumPicture,
+		"website":          &encodedTaxon.Website,
+		"noHerbier":        &encodedTaxon.NoHerbier,
+		"fasc":             &encodedTaxon.Fasc,
+		"page":             &encodedTaxon.Page,
+	}
+	for key, prop := range standardProps {
+		if value, ok := taxon.ExtraInfo[key]; ok {
+			*prop = FormatExtraValue(value)
+		}
+	}
+	*out = append(*out, encodedTaxon)
 	for _, ch := range taxon.Children {
-		child := ds.TaxonsById[ch.Id]
-		encodeTaxon(ds, child, taxon.Id, charByStateId, out)
+		if child, ok := ds.TaxonsById[ch.Id]; ok {
+			encodeTaxon(ds, child, taxon.Id, charByStateId, charOrder, out)
+		}
 	}
 }
 
-func encodeCharacter(ds *Dataset, ch *Character, parentId string, stateIds []string, out *[]*EncodedCharacter) {
+func encodeCharacter(ds *Dataset, ch *Character, parentId string, encoded *Encoded, charByStateId map[string]string, charOrder map[string]int) {
+	charOrder[ch.Id] = len(charOrder)
+	stateIds := make([]string, len(ch.States))
+	for i := range ch.States {
+		state := &ch.States[i]
+		stateIds[i] = state.Id
+		encoded.States = append(encoded.States, encodeState(state))
+		charByStateId[state.Id] = ch.Id
+	}
 	reqIds := make([]string, len(ch.RequiredStates))
 	for i, state := range ch.RequiredStates {
 		reqIds[i] = state.Id
@@ -95,7 +152,7 @@ func encodeCharacter(ds *Dataset, ch *Character, parentId string, stateIds []str
 	for i, child := range ch.Children {
 		childrenIds[i] = child.Id
 	}
-	*out = append(*out, &EncodedCharacter{
+	encoded.Characters = append(encoded.Characters, &EncodedCharacter{
 		EncodedItem:           encodeItem(ch.Hierarchy, parentId, childrenIds),
 		InherentStateId:       inherentStateId,
 		States:                stateIds,
@@ -104,7 +161,7 @@ func encodeCharacter(ds *Dataset, ch *Character, parentId string, stateIds []str
 	})
 	for _, h := range ch.Children {
 		if child, ok := ds.CharactersById[h.Id]; ok {
-			encodeCharacter(ds, child, ch.Id, stateIds, out)
+			encodeCharacter(ds, child, ch.Id, encoded, charByStateId, charOrder)
 		}
 	}
 }
@@ -121,34 +178,90 @@ func encodeState(state *State) *EncodedState {
 	}
 }
 
+type HazoOptions struct {
+	Indent   string
+	SortKeys bool
+}
+
 func WriteHazo(w io.Writer, dataset *Dataset) error {
+	return WriteHazoWithOptions(w, dataset, HazoOptions{})
+}
+
+// Version returns a fingerprint of the content of a dataset, which changes
+// with any edit. It is computed from its canonical Hazo encoding, so that
+// the same content always has the same version.
+func (ds *Dataset) Version() (string, error) {
+	h := sha256.New()
+	if err := WriteHazoWithOptions(h, ds, HazoOptions{SortKeys: true}); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16], nil
+}
+
+func WriteHazoWithOptions(w io.Writer, dataset *Dataset, opts HazoOptions) error {
 	encoded := Encoded{
 		Id:                dataset.Id,
 		Taxons:            []*EncodedTaxon{},
 		Characters:        []*EncodedCharacter{},
 		States:            []*EncodedState{},
 		Books:             []*EncodedBook{},
-		ExtraFields:       []*EncodedExtraField{},
-		DictionaryEntries: make(map[string]*EncodedDictionaryEntry),
+		ExtraFields:       encodeExtraFields(dataset.ExtraFields),
+		DictionaryEntries: encodeDictionaryEntries(dataset.DictionaryEntry),
+	}
+	for _, book := range dataset.Books {
+		encoded.Books = append(encoded.Books, &EncodedBook{
+			Id:        book.Id,
+			Label:     book.Title,
+			Kind:      book.Kind,
+			Authors:   book.Authors,
+			Year:      book.Year,
+			Journal:   book.Journal,
+			Volume:    book.Volume,
+			Issue:     book.Issue,
+			Pages:     book.Pages,
+			Publisher: book.Publisher,
+			Doi:       book.Doi,
+			Url:       book.Url,
+		})
 	}
 	charByStateId := map[string]string{}
-	for _, character := range dataset.CharactersById {
-		charStateIds := make([]string, len(character.States))
-		for i, state := range character.States {
-			encodedState := encodeState(&state)
-			charStateIds[i] = encodedState.Id
-			encoded.States = append(encoded.States, encodedState)
-			charByStateId[state.Id] = character.Id
+	charOrder := map[string]int{}
+	for _, h := range dataset.CharactersHierarchy.Children {
+		if character, ok := dataset.CharactersById[h.Id]; ok {
+			encodeCharacter(dataset, character, "", &encoded, charByStateId, charOrder)
+		}
+	}
+	for _, h := range dataset.TaxonsHierarchy.Children {
+		if taxon, ok := dataset.TaxonsById[h.Id]; ok {
+			encodeTaxon(dataset, taxon, "", charByStateId, charOrder, &encoded.Taxons)
+		}
+	}
+	var data interface{} = &encoded
+	if opts.SortKeys {
+		// Maps are marshalled with sorted keys, so going through a generic
+		// representation sorts the struct fields as well.
+		raw, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		var generic interface{}
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.UseNumber()
+		if err := dec.Decode(&generic); err != nil {
+			return err
 		}
-		encodeCharacter(dataset, character, "", charStateIds, &encoded.Characters)
+		data = generic
 	}
-	for _, taxon := range dataset.TaxonsById {
-		encodeTaxon(dataset, taxon, "", charByStateId, &encoded.Taxons)
+	var result []byte
+	var err error
+	if opts.Indent != "" {
+		result, err = json.MarshalIndent(data, "", opts.Indent)
+	} else {
+		result, err = json.Marshal(data)
 	}
-	result, err := json.Marshal(&encoded)
 	if err != nil {
 		return err
 	}
-	w.Write(result)
-	return nil
+	_, err = w.Write(result)
+	return err
 }
@@ -0,0 +1,147 @@
+package dataset
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const hazoFixture = `{
+	"id": "ds",
+	"taxons": [
+		{ "id": "t1", "name": "a", "children": ["t3", "t4"], "descriptions": [ { "descriptorId": "c2", "statesIds": ["s3"] }, { "descriptorId": "c1", "statesIds": ["s1"] } ] },
+		{ "id": "t2", "name": "b" },
+		{ "id": "t3", "name": "c", "nameEN": "see", "detail": "third" },
+		{ "id": "t4", "name": "d", "extra": { "z": 1, "a": "x" } }
+	],
+	"characters": [
+		{ "id": "c1", "name": "color", "children": ["c3"], "states": ["s1", "s2"] },
+		{ "id": "c2", "name": "size", "states": ["s3"] },
+		{ "id": "c3", "name": "shade", "states": ["s4"] }
+	],
+	"states": [
+		{ "id": "s1", "name": "red" },
+		{ "id": "s2", "name": "blue" },
+		{ "id": "s3", "name": "big" },
+		{ "id": "s4", "name": "dark" }
+	]
+}`
+
+func writeHazoString(t *testing.T, ds *Dataset, opts HazoOptions) string {
+	var b bytes.Buffer
+	if err := WriteHazoWithOptions(&b, ds, opts); err != nil {
+		t.Logf("Unexpected error: %q.", err.Error())
+		t.FailNow()
+	}
+	return b.String()
+}
+
+func readHazoString(t *testing.T, s string) *Dataset {
+	ds, err := ReadHazo(strings.NewReader(s))
+	if err != nil {
+		t.Logf("Unexpected error: %q.", err.Error())
+		t.FailNow()
+	}
+	return ds
+}
+
+func TestWriteHazoIsStable(t *testing.T) {
+	optionsCases := []HazoOptions{{}, {Indent: "  "}, {SortKeys: true}, {Indent: "\t", SortKeys: true}}
+	for _, opts := range optionsCases {
+		ds := readHazoString(t, hazoFixture)
+		expected := writeHazoString(t, ds, opts)
+		for i := 0; i < 20; i++ {
+			if out := writeHazoString(t, ds, opts); out != expected {
+				t.Logf("Output changed between runs with options %+v.\nexpected %s\ngot %s", opts, expected, out)
+				t.FailNow()
+			}
+		}
+		roundTrip := writeHazoString(t, readHazoString(t, expected), opts)
+		if roundTrip != expected {
+			t.Logf("Output changed after a round trip with options %+v.\nexpected %s\ngot %s", opts, expected, roundTrip)
+			t.Fail()
+		}
+	}
+}
+
+func TestWriteHazoTreeOrder(t *testing.T) {
+	ds := readHazoString(t, hazoFixture)
+	encoded := Encoded{}
+	if err := json.Unmarshal([]byte(writeHazoString(t, ds, HazoOptions{})), &encoded); err != nil {
+		t.Logf("Unexpected error: %q.", err.Error())
+		t.FailNow()
+	}
+	taxonIds := []string{}
+	for _, taxon := range encoded.Taxons {
+		taxonIds = append(taxonIds, taxon.Id)
+	}
+	if got := strings.Join(taxonIds, ","); got != "t1,t3,t4,t2" {
+		t.Logf("Wrong taxons order.\nexpected %s\ngot %s", "t1,t3,t4,t2", got)
+		t.Fail()
+	}
+	characterIds := []string{}
+	for _, ch := range encoded.Characters {
+		characterIds = append(characterIds, ch.Id)
+	}
+	if got := strings.Join(characterIds, ","); got != "c1,c3,c2" {
+		t.Logf("Wrong characters order.\nexpected %s\ngot %s", "c1,c3,c2", got)
+		t.Fail()
+	}
+	stateIds := []string{}
+	for _, state := range encoded.States {
+		stateIds = append(stateIds, state.Id)
+	}
+	if got := strings.Join(stateIds, ","); got != "s1,s2,s4,s3" {
+		t.Logf("Wrong states order.\nexpected %s\ngot %s", "s1,s2,s4,s3", got)
+		t.Fail()
+	}
+	if got := encoded.Characters[1].States; len(got) != 1 || got[0] != "s4" {
+		t.Logf("Wrong states for 'c3'.\nexpected %v\ngot %v", []string{"s4"}, got)
+		t.Fail()
+	}
+	descriptorIds := []string{}
+	for _, desc := range encoded.Taxons[0].Descriptions {
+		descriptorIds = append(descriptorIds, desc.DescriptorId)
+	}
+	if got := strings.Join(descriptorIds, ","); got != "c1,c2" {
+		t.Logf("Wrong descriptions order.\nexpected %s\ngot %s", "c1,c2", got)
+		t.Fail()
+	}
+	if encoded.Taxons[1].ParentId != "t1" || encoded.Taxons[1].Detail != "third" {
+		t.Logf("Wrong encoding for 't3': %+v", encoded.Taxons[1].EncodedItem)
+		t.Fail()
+	}
+	if item := encoded.Taxons[1].EncodedItem; item.NameEN != "see" || item.NameCN != "c" || item.VernacularName != "c" {
+		t.Logf("Expected the missing names of 't3' to be its scientific name: %+v", item)
+		t.Fail()
+	}
+}
+
+func TestWriteHazoSortedKeys(t *testing.T) {
+	ds := readHazoString(t, hazoFixture)
+	out := writeHazoString(t, ds, HazoOptions{SortKeys: true})
+	if !strings.HasPrefix(out, `{"books":[],"characters":[{"children":["c3"],`) {
+		t.Logf("Keys are not sorted: %s", out)
+		t.Fail()
+	}
+}
+
+func TestDatasetVersion(t *testing.T) {
+	ds := readHazoString(t, hazoFixture)
+	v1, err := ds.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v2, _ := readHazoString(t, hazoFixture).Version(); v2 != v1 {
+		t.Logf("The same content has different versions %q and %q.", v1, v2)
+		t.Fail()
+	}
+	if err := ds.Rename("t2", "renamed"); err != nil {
+		t.Fatal(err)
+	}
+	if v3, _ := ds.Version(); v3 == v1 || len(v3) != 16 {
+		t.Logf("Expected a new version after an edit, got %q.", v3)
+		t.Fail()
+	}
+}
@@ -17,15 +17,27 @@ type EncodedState struct {
 }
 
 type EncodedBook struct {
-	Id    string `json:"id"`
-	Label string `json:"label"`
+	Id        string   `json:"id"`
+	Label     string   `json:"label"`
+	Kind      string   `json:"type,omitempty"`
+	Authors   []string `json:"authors,omitempty"`
+	Year      int      `json:"year,omitempty"`
+	Journal   string   `json:"journal,omitempty"`
+	Volume    string   `json:"volume,omitempty"`
+	Issue     string   `json:"issue,omitempty"`
+	Pages     string   `json:"pages,omitempty"`
+	Publisher string   `json:"publisher,omitempty"`
+	Doi       string   `json:"doi,omitempty"`
+	Url       string   `json:"url,omitempty"`
 }
 
 type EncodedExtraField struct {
-	Std   bool   `json:"std"`
-	Id    string `json:"id"`
-	Label string `json:"label"`
-	Icon  string `json:"icon"`
+	Std     bool     `json:"std"`
+	Id      string   `json:"id"`
+	Label   string   `json:"label"`
+	Icon    string   `json:"icon"`
+	Type    string   `json:"type,omitempty"`
+	Options []string `json:"options,omitempty"`
 }
 
 type EncodedDictionaryEntry struct {
@@ -0,0 +1,111 @@
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultLanguage is the language messages are written in.
+const DefaultLanguage = "EN"
+
+//go:embed messages/*.json
+var catalogFiles embed.FS
+
+// catalogs holds the translations of messages by language code.
+var catalogs = map[string]map[string]string{DefaultLanguage: {}}
+
+// Language tags whose primary subtag is not the code of the language.
+var tagAliases = map[string]string{"ZH": "CN"}
+
+func init() {
+	entries, err := catalogFiles.ReadDir("messages")
+	if err != nil {
+		log.Fatalf("cannot read message catalogs: %q", err.Error())
+	}
+	for _, entry := range entries {
+		data, err := catalogFiles.ReadFile("messages/" + entry.Name())
+		if err != nil {
+			log.Fatalf("cannot read message catalog %q: %q", entry.Name(), err.Error())
+		}
+		catalog := map[string]string{}
+		if err := json.Unmarshal(data, &catalog); err != nil {
+			log.Fatalf("cannot parse message catalog %q: %q", entry.Name(), err.Error())
+		}
+		catalogs[strings.TrimSuffix(entry.Name(), ".json")] = catalog
+	}
+}
+
+// Languages returns the codes of the languages the interface is translated
+// to, the default one first.
+func Languages() []string {
+	langs := []string{}
+	for lang := range catalogs {
+		if lang != DefaultLanguage {
+			langs = append(langs, lang)
+		}
+	}
+	sort.Strings(langs)
+	return append([]string{DefaultLanguage}, langs...)
+}
+
+// Translate returns the translation of a message, the message itself when
+// the language has none.
+func Translate(lang string, message string) string {
+	if translated, ok := catalogs[lang][message]; ok && translated != "" {
+		return translated
+	}
+	return message
+}
+
+// Fallbacks returns the languages to look texts up in, from the preferred
+// one to the default one.
+func Fallbacks(lang string) []string {
+	if lang == "" || lang == DefaultLanguage {
+		return []string{DefaultLanguage}
+	}
+	return []string{lang, DefaultLanguage}
+}
+
+// MatchAcceptLanguage returns the available language an Accept-Language
+// header prefers, empty if it accepts none of them.
+func MatchAcceptLanguage(header string, available []string) string {
+	type accepted struct {
+		code    string
+		quality float64
+	}
+	var langs []accepted
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.ToUpper(strings.SplitN(strings.TrimSpace(fields[0]), "-", 2)[0])
+		if tag == "" {
+			continue
+		}
+		quality := 1.0
+		for _, param := range fields[1:] {
+			if q := strings.TrimSpace(param); strings.HasPrefix(q, "q=") {
+				if v, err := strconv.ParseFloat(q[2:], 64); err == nil {
+					quality = v
+				}
+			}
+		}
+		if alias, ok := tagAliases[tag]; ok {
+			tag = alias
+		}
+		if quality > 0 {
+			langs = append(langs, accepted{tag, quality})
+		}
+	}
+	sort.SliceStable(langs, func(i, j int) bool { return langs[i].quality > langs[j].quality })
+	for _, lang := range langs {
+		for _, code := range available {
+			if strings.EqualFold(code, lang.code) {
+				return code
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,299 @@
+package identification
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"nicolas.galipot.net/taxonomia/dataset"
+)
+
+var (
+	errNotFound         = errors.New("not found")
+	errMethodNotAllowed = errors.New("method not allowed")
+	errInvalidLimit     = errors.New("limit must be a positive integer")
+	errMissingCode      = errors.New("missing session code")
+	errTooManyCodes     = errors.New("too many session codes tried, try again later")
+)
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+type apiTaxon struct {
+	Id     string `json:"id"`
+	Name   string `json:"name"`
+	Author string `json:"author,omitempty"`
+	Rank   string `json:"rank,omitempty"`
+}
+
+type apiState struct {
+	Id    string `json:"id"`
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+type apiCharacter struct {
+	Id     string     `json:"id"`
+	Name   string     `json:"name"`
+	Score  float64    `json:"score"`
+	States []apiState `json:"states"`
+}
+
+type apiAnswerExplanation struct {
+	Answer
+	TaxonStateIds []string `json:"taxonStates"`
+	Matches       bool     `json:"matches"`
+}
+
+type apiExplanation struct {
+	Taxon     apiTaxon                `json:"taxon"`
+	Candidate bool                    `json:"candidate"`
+	Answers   []*apiAnswerExplanation `json:"answers"`
+}
+
+type apiRank struct {
+	Rank string `json:"rank"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, apiError{err.Error()})
+}
+
+func newApiTaxon(taxon *dataset.Taxon) apiTaxon {
+	return apiTaxon{Id: taxon.Id, Name: taxon.Name.Scientific, Author: taxon.Author, Rank: taxon.Rank}
+}
+
+// decodeBody reads the JSON body of a request, an empty body leaving v as is.
+func decodeBody(r *http.Request, v interface{}) error {
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// SessionsFunc creates identification sessions, optionally with the rank
+// candidates are aggregated to, and finds them by code. New sessions are
+// owned by the user signed in, or else by the browser, as those of the
+// identification page:
+//
+//	POST /api/sessions                    {"rank": "genus"}
+//	GET  /api/sessions?code=K7QX2M
+func (h *Handler) SessionsFunc(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.writeSessionByCode(w, r, r.URL.Query().Get("code"))
+		return
+	case http.MethodPost:
+	default:
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+		writeJSONError(w, http.StatusMethodNotAllowed, errMethodNotAllowed)
+		return
+	}
+	var body apiRank
+	if err := decodeBody(r, &body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	cookie, _ := h.store.Get(r, "identification")
+	owner, err := h.sessionOwner(r, cookie)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	session, err := h.service.NewSession(owner)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if body.Rank != "" {
+		if err := h.service.SetRank(session, body.Rank); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if err := cookie.Save(r, w); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Location", "/api/sessions/"+session.Id)
+	writeJSON(w, http.StatusCreated, session)
+}
+
+func (h *Handler) writeSessionByCode(w http.ResponseWriter, r *http.Request, code string) {
+	if code == "" {
+		writeJSONError(w, http.StatusBadRequest, errMissingCode)
+		return
+	}
+	if !h.allowCodeLookup(w, r) {
+		writeJSONError(w, http.StatusTooManyRequests, errTooManyCodes)
+		return
+	}
+	session, err := h.service.ResumeSession(code)
+	if err == ErrSessionNotFound {
+		writeJSONError(w, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, session)
+}
+
+// SessionFunc serves a session and its resources:
+//
+//	GET  /api/sessions/{id}
+//	POST /api/sessions/{id}/answers       {"character": "c1", "states": ["s1"]}
+//	POST /api/sessions/{id}/undo
+//	POST /api/sessions/{id}/reset
+//	POST /api/sessions/{id}/rank          {"rank": "genus"}
+//	GET  /api/sessions/{id}/candidates
+//	GET  /api/sessions/{id}/characters?limit=10
+//	GET  /api/sessions/{id}/explanations/{taxon}
+func (h *Handler) SessionFunc(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/api/sessions/"), "/", 3)
+	session, err := h.service.Session(parts[0])
+	if err == ErrSessionNotFound {
+		writeJSONError(w, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	resource := ""
+	if len(parts) > 1 {
+		resource = parts[1]
+	}
+	if !isSessionResource(resource) || (resource == "explanations") != (len(parts) == 3) {
+		writeJSONError(w, http.StatusNotFound, errNotFound)
+		return
+	}
+	method := http.MethodGet
+	switch resource {
+	case "answers", "undo", "reset", "rank":
+		method = http.MethodPost
+	}
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeJSONError(w, http.StatusMethodNotAllowed, errMethodNotAllowed)
+		return
+	}
+	switch resource {
+	case "":
+		writeJSON(w, http.StatusOK, session)
+	case "answers":
+		var answer Answer
+		if err := decodeBody(r, &answer); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		h.writeSessionUpdate(w, session, h.service.Answer(session, answer.CharacterId, answer.StateIds))
+	case "undo":
+		h.writeSessionUpdate(w, session, h.service.Undo(session))
+	case "reset":
+		h.writeSessionUpdate(w, session, h.service.Reset(session))
+	case "rank":
+		var body apiRank
+		if err := decodeBody(r, &body); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		h.writeSessionUpdate(w, session, h.service.SetRank(session, body.Rank))
+	case "candidates":
+		taxons, err := h.service.Candidates(session)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		candidates := make([]apiTaxon, len(taxons))
+		for i, taxon := range taxons {
+			candidates[i] = newApiTaxon(taxon)
+		}
+		writeJSON(w, http.StatusOK, candidates)
+	case "characters":
+		h.writeNextCharacters(w, r, session)
+	case "explanations":
+		h.writeExplanation(w, session, parts[2])
+	}
+}
+
+func isSessionResource(resource string) bool {
+	switch resource {
+	case "", "answers", "undo", "reset", "rank", "candidates", "characters", "explanations":
+		return true
+	}
+	return false
+}
+
+// writeSessionUpdate answers the session after a change, or the error that
+// prevented it.
+func (h *Handler) writeSessionUpdate(w http.ResponseWriter, session *Session, err error) {
+	if _, ok := err.(*AnswerError); ok {
+		writeJSONError(w, http.StatusUnprocessableEntity, err)
+		return
+	} else if err == ErrSessionNotFound {
+		// The session expired while the request was served.
+		writeJSONError(w, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, session)
+}
+
+func (h *Handler) writeNextCharacters(w http.ResponseWriter, r *http.Request, session *Session) {
+	limit := 0
+	if param := r.URL.Query().Get("limit"); param != "" {
+		var err error
+		if limit, err = strconv.Atoi(param); err != nil || limit < 0 {
+			writeJSONError(w, http.StatusBadRequest, errInvalidLimit)
+			return
+		}
+	}
+	ranked, err := h.service.NextCharacters(session)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if limit > 0 && len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+	langs := nameLangs(h.language(r))
+	characters := make([]apiCharacter, len(ranked))
+	for i, rc := range ranked {
+		c := apiCharacter{Id: rc.Character.Id, Name: rc.Character.Name.TextIn(langs...), Score: rc.Score, States: []apiState{}}
+		for _, sc := range rc.States {
+			c.States = append(c.States, apiState{Id: sc.State.Id, Name: sc.State.Name.TextIn(langs...), Count: sc.Count})
+		}
+		characters[i] = c
+	}
+	writeJSON(w, http.StatusOK, characters)
+}
+
+func (h *Handler) writeExplanation(w http.ResponseWriter, session *Session, taxonId string) {
+	explanation, err := h.service.Explain(session, taxonId)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if explanation == nil {
+		writeJSONError(w, http.StatusNotFound, errNotFound)
+		return
+	}
+	answers := make([]*apiAnswerExplanation, len(explanation.Answers))
+	for i, ae := range explanation.Answers {
+		answers[i] = &apiAnswerExplanation{Answer: ae.Answer, TaxonStateIds: ae.TaxonStateIds, Matches: ae.Matches}
+	}
+	writeJSON(w, http.StatusOK, apiExplanation{Taxon: newApiTaxon(explanation.Taxon), Candidate: explanation.Candidate, Answers: answers})
+}
@@ -0,0 +1,94 @@
+package identification
+
+import (
+	"net/http"
+	"strings"
+
+	"nicolas.galipot.net/taxonomia/dataset"
+	"nicolas.galipot.net/taxonomia/dataset/database"
+)
+
+type CharacterTreeTemplateData struct {
+	Page
+	Characters []*dataset.Hierarchy
+}
+
+func (h *Handler) CharacterTreeFunc(w http.ResponseWriter, r *http.Request) {
+	tree, err := h.reg.GetCharacterTree()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.render(w, r, "charactertree", CharacterTreeTemplateData{Page: h.page(r), Characters: tree})
+}
+
+type CharacterTemplateData struct {
+	Page
+	Character     *dataset.Character
+	Ancestors     []*dataset.Hierarchy
+	Children      []*dataset.Hierarchy
+	Required      []*database.StateDependency
+	Inapplicable  []*database.StateDependency
+	Dependents    []*database.StateDependency
+	TaxonsByState map[string][]*dataset.Taxon
+	Languages     map[string]string
+}
+
+func (h *Handler) CharacterFunc(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/character/")
+	character, err := h.reg.GetCharacter(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if character == nil {
+		http.NotFound(w, r)
+		return
+	}
+	tplData := CharacterTemplateData{Page: h.page(r), Character: character, Languages: map[string]string{}}
+	langs, err := h.reg.GetLanguages()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, lang := range langs {
+		tplData.Languages[lang.Code] = lang.Label
+	}
+	if tplData.Ancestors, err = h.reg.GetCharacterAncestors(id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tree, err := h.reg.GetCharacterTree()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if node := findHierarchy(tree, id); node != nil {
+		tplData.Children = node.Children
+	}
+	if tplData.Required, tplData.Inapplicable, err = h.reg.GetCharacterDependencies(id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if tplData.Dependents, err = h.reg.GetDependentCharacters(id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if tplData.TaxonsByState, err = h.reg.GetStateTaxons(id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.render(w, r, "character", tplData)
+}
+
+func findHierarchy(nodes []*dataset.Hierarchy, id string) *dataset.Hierarchy {
+	for _, node := range nodes {
+		if node.Id == id {
+			return node
+		}
+		if found := findHierarchy(node.Children, id); found != nil {
+			return found
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package identification
+
+import (
+	"net/url"
+	"strings"
+
+	"nicolas.galipot.net/taxonomia/dataset"
+	"nicolas.galipot.net/taxonomia/dataset/database"
+)
+
+// extraFilterParams reads the filters of the search form: a range for
+// numbers and dates, a choice for enums and a part of the text otherwise.
+func extraFilterParams(fields []*dataset.ExtraField, query url.Values) ([]database.ExtraFilter, map[string]string) {
+	filters := []database.ExtraFilter{}
+	params := map[string]string{}
+	add := func(param string, field string, op string) {
+		value := strings.TrimSpace(query.Get(param))
+		if value == "" {
+			return
+		}
+		params[param] = value
+		filters = append(filters, database.ExtraFilter{Field: field, Op: op, Value: value})
+	}
+	for _, field := range fields {
+		switch field.Type {
+		case dataset.ExtraFieldNumber, dataset.ExtraFieldDate:
+			add("x."+field.Id+".min", field.Id, ">=")
+			add("x."+field.Id+".max", field.Id, "<=")
+		case dataset.ExtraFieldEnum:
+			add("x."+field.Id, field.Id, "=")
+		default:
+			add("x."+field.Id, field.Id, "~")
+		}
+	}
+	return filters, params
+}
+
+// extraValue formats the value of a field for display.
+func extraValue(values map[string]interface{}, id string) string {
+	value, ok := values[id]
+	if !ok {
+		return ""
+	}
+	return dataset.FormatExtraValue(value)
+}
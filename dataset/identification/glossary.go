@@ -0,0 +1,60 @@
+package identification
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+
+	"nicolas.galipot.net/taxonomia/dataset"
+)
+
+// linkGlossaryTerms escapes a text, turning the glossary terms it contains
+// into links to their definition in the first available language.
+func (h *Handler) linkGlossaryTerms(text string, langs []string) template.HTML {
+	h.glossaryMutex.RLock()
+	segments := h.glossary.Split(text)
+	h.glossaryMutex.RUnlock()
+	var b strings.Builder
+	for _, segment := range segments {
+		if segment.Entry == nil {
+			b.WriteString(template.HTMLEscapeString(segment.Text))
+			continue
+		}
+		b.WriteString(`<a class="glossary-term" href="/glossary#term-`)
+		b.WriteString(template.HTMLEscapeString(segment.Entry.Id))
+		b.WriteString(`" title="`)
+		b.WriteString(template.HTMLEscapeString(segment.Entry.Definition.TextIn(langs...)))
+		b.WriteString(`">`)
+		b.WriteString(template.HTMLEscapeString(segment.Text))
+		b.WriteString(`</a>`)
+	}
+	return template.HTML(b.String())
+}
+
+// ReloadGlossary reads the glossary terms linked in texts again, after the
+// dataset was replaced.
+func (h *Handler) ReloadGlossary() error {
+	entries, err := h.reg.GetGlossary()
+	if err != nil {
+		return err
+	}
+	glossary := dataset.NewGlossary(entries)
+	h.glossaryMutex.Lock()
+	h.glossary = glossary
+	h.glossaryMutex.Unlock()
+	return nil
+}
+
+type GlossaryTemplateData struct {
+	Page
+	Entries []*dataset.DictionaryEntry
+}
+
+func (h *Handler) GlossaryFunc(w http.ResponseWriter, r *http.Request) {
+	entries, err := h.reg.GetGlossary()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.render(w, r, "glossary", GlossaryTemplateData{Page: h.page(r), Entries: entries})
+}
@@ -1,16 +1,23 @@
 package identification
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
 
 	_ "embed"
 
 	"github.com/gorilla/sessions"
 	"nicolas.galipot.net/taxonomia/dataset"
+	"nicolas.galipot.net/taxonomia/dataset/accounts"
 	"nicolas.galipot.net/taxonomia/dataset/database"
+	"nicolas.galipot.net/taxonomia/dataset/i18n"
 )
 
 //go:embed header.html
@@ -22,13 +29,64 @@ var listCharactersTxt string
 //go:embed identify.html
 var identifyTemplateTxt string
 
+//go:embed found.html
+var foundTemplateTxt string
+
+//go:embed names.html
+var namesTemplateTxt string
+
+//go:embed references.html
+var referencesTemplateTxt string
+
+//go:embed taxon.html
+var taxonTemplateTxt string
+
+//go:embed glossary.html
+var glossaryTemplateTxt string
+
+//go:embed search.html
+var searchTemplateTxt string
+
+//go:embed charactertree.html
+var characterTreeTemplateTxt string
+
+//go:embed character.html
+var characterTemplateTxt string
+
+//go:embed sessions.html
+var sessionsTemplateTxt string
+
+//go:embed report.html
+var reportTemplateTxt string
+
+//go:embed shared.html
+var sharedTemplateTxt string
+
 type Handler struct {
-	reg      *database.DatasetRegistry
-	template *template.Template
-	store    *sessions.CookieStore
+	reg       *database.DatasetRegistry
+	service   *Service
+	templates map[string]*template.Template
+	store     *sessions.CookieStore
+	glossary  *dataset.Glossary
+	languages []dataset.Lang
+	// codeLimiter limits how many session codes a client can try.
+	codeLimiter *rateLimiter
+
+	glossaryMutex sync.RWMutex
+}
+
+// Page holds what the pages show besides their own content.
+type Page struct {
+	Account *database.User
+}
+
+// page returns the common content of the page answering a request.
+func (h *Handler) page(r *http.Request) Page {
+	return Page{Account: accounts.CurrentUser(r)}
 }
 
 type TemplateData struct {
+	Page
 	PickedCharacter  *dataset.Character
 	UnansweredChars  []*dataset.Character
 	AnsweredChars    []*dataset.Character
@@ -36,88 +94,221 @@ type TemplateData struct {
 	AnsweredCharIds  []string
 	AnsweredStateIds []string
 	IdentifiedTaxons []*dataset.Taxon
+	Rank             string
+	Ranks            []string
+	Session          *Session
+	ShareToken       string
+	ShareURL         string
 }
 
-func NewHandler(reg *database.DatasetRegistry, sessionKey string) *Handler {
-	tpl := template.New("identify")
-	_, err := tpl.Parse(headerTemplateTxt)
+// NewHandler returns the handler of the identification pages, sessions
+// expiring when they have not been used for sessionLifetime. The cookie
+// only keeps the ids of the browser and of its current session.
+func NewHandler(reg *database.DatasetRegistry, sessionKey string, sessionLifetime time.Duration) *Handler {
+	h := &Handler{
+		reg:         reg,
+		service:     NewService(reg, NewRegistrySessionStore(reg, sessionLifetime)),
+		store:       sessions.NewCookieStore([]byte(sessionKey)),
+		codeLimiter: newRateLimiter(codeAttempts, codeAttemptPeriod),
+	}
+	h.store.Options = &sessions.Options{
+		Path:     "/",
+		MaxAge:   int(sessionLifetime / time.Second),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	entries, err := reg.GetGlossary()
 	if err != nil {
-		log.Fatalf("cannot parse template %q: %q", "header", err.Error())
+		log.Fatalf("cannot load glossary: %q", err.Error())
+	}
+	h.glossary = dataset.NewGlossary(entries)
+	if h.languages, err = reg.GetLanguages(); err != nil {
+		log.Fatalf("cannot load languages: %q", err.Error())
+	}
+	tpl := template.New("identify").Funcs(template.FuncMap{
+		"extraValue": extraValue,
+		"snippet":    highlightSnippet,
+		"resultUrl":  resultUrl,
+	}).Funcs(h.templateFuncs(i18n.DefaultLanguage))
+	templates := []struct {
+		name string
+		txt  string
+	}{
+		{"header", headerTemplateTxt},
+		{"characters", listCharactersTxt},
+		{"identify", identifyTemplateTxt},
+		{"found", foundTemplateTxt},
+		{"names", namesTemplateTxt},
+		{"references", referencesTemplateTxt},
+		{"taxon", taxonTemplateTxt},
+		{"glossary", glossaryTemplateTxt},
+		{"search", searchTemplateTxt},
+		{"charactertree", characterTreeTemplateTxt},
+		{"character", characterTemplateTxt},
+		{"sessions", sessionsTemplateTxt},
+		{"report", reportTemplateTxt},
+		{"shared", sharedTemplateTxt},
+	}
+	for _, t := range templates {
+		if _, err := tpl.Parse(t.txt); err != nil {
+			log.Fatalf("cannot parse template %q: %q", t.name, err.Error())
+		}
+	}
+	// Each language has its own set of templates, so that rendering a page
+	// does not have to clone them.
+	h.templates = map[string]*template.Template{}
+	for _, lang := range append([]dataset.Lang{{Code: i18n.DefaultLanguage}}, h.languages...) {
+		clone, err := tpl.Clone()
+		if err != nil {
+			log.Fatalf("cannot clone templates: %q", err.Error())
+		}
+		h.templates[lang.Code] = clone.Funcs(h.templateFuncs(lang.Code))
+	}
+	return h
+}
+
+// sessionOwner returns the owner of the identifications started by a
+// request: the user signed in, or else the browser. The identifications
+// the browser started before signing in move to the user.
+func (h *Handler) sessionOwner(r *http.Request, cookie *sessions.Session) (string, error) {
+	user := accounts.CurrentUser(r)
+	if user == nil {
+		return h.browserOwner(cookie)
+	}
+	owner := fmt.Sprintf("user:%d", user.Id)
+	if browser, ok := cookie.Values["owner"].(string); ok && browser != "" {
+		if err := h.reg.SetIdentificationSessionsOwner(browser, owner); err != nil {
+			return "", err
+		}
+		delete(cookie.Values, "owner")
+	}
+	return owner, nil
+}
+
+// browserOwner returns the id of the browser stored in the cookie session,
+// the owner of the identifications started from it anonymously.
+func (h *Handler) browserOwner(cookie *sessions.Session) (string, error) {
+	if owner, ok := cookie.Values["owner"].(string); ok && owner != "" {
+		return owner, nil
 	}
-	_, err = tpl.Parse(listCharactersTxt)
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	owner := hex.EncodeToString(b)
+	cookie.Values["owner"] = owner
+	return owner, nil
+}
+
+// browserSession returns the identification session whose id is stored in
+// the cookie session, starting a new one if there is none or it has expired.
+func (h *Handler) browserSession(r *http.Request, cookie *sessions.Session) (*Session, error) {
+	if id, ok := cookie.Values["session"].(string); ok {
+		session, err := h.service.Session(id)
+		if err != ErrSessionNotFound {
+			return session, err
+		}
+	}
+	owner, err := h.sessionOwner(r, cookie)
 	if err != nil {
-		log.Fatalf("cannot parse template %q: %q", "characters", err.Error())
+		return nil, err
 	}
-	_, err = tpl.Parse(identifyTemplateTxt)
+	session, err := h.service.NewSession(owner)
 	if err != nil {
-		log.Fatalf("cannot parse template %q: %q", "identify", err.Error())
+		return nil, err
 	}
-	return &Handler{reg: reg, template: tpl, store: sessions.NewCookieStore([]byte(sessionKey))}
+	cookie.Values["session"] = session.Id
+	return session, nil
 }
 
 func (h *Handler) Func(w http.ResponseWriter, r *http.Request) {
-	session, _ := h.store.Get(r, "identification")
-	answeredCharIds, _ := session.Values["characters"].([]string)
-	answeredStateIds, _ := session.Values["states"].([]string)
-
-	err := r.ParseForm()
+	if token := r.URL.Query().Get("s"); token != "" {
+		if r.Method == http.MethodPost {
+			h.restoreShared(w, r, token)
+		} else {
+			h.showShared(w, r, token)
+		}
+		return
+	}
+	cookie, _ := h.store.Get(r, "identification")
+	session, err := h.browserSession(r, cookie)
 	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if len(r.Form["action"]) > 0 {
-		switch r.Form["action"][0] {
-		case "reset":
-			answeredCharIds = nil
-			answeredStateIds = nil
-		case "pass":
-			if charId := r.Form["selected-character"]; len(charId) > 0 {
-				answeredCharIds = append(answeredCharIds, charId...)
-				answeredStateIds = append(answeredStateIds, "")
-			}
-		case "cancel":
-			if len(answeredCharIds) > 0 {
-				answeredCharIds = answeredCharIds[:len(answeredCharIds)-1]
-				answeredStateIds = answeredStateIds[:len(answeredStateIds)-1]
-			}
+	if ranks, ok := r.Form["rank"]; ok && len(ranks) > 0 {
+		if err := h.service.SetRank(session, ranks[0]); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
-	} else {
-		if charId := r.Form["selected-character"]; len(charId) > 0 {
-			answeredCharIds = append(answeredCharIds, charId...)
+	}
+	charId := r.Form.Get("selected-character")
+	switch r.Form.Get("action") {
+	case "reset":
+		err = h.service.Reset(session)
+	case "pass":
+		if charId != "" {
+			err = h.service.Answer(session, charId, nil)
 		}
-		if stateId := r.Form["selected-state"]; len(stateId) > 0 {
-			answeredStateIds = append(answeredStateIds, stateId...)
+	case "cancel":
+		err = h.service.Undo(session)
+	case "":
+		if charId != "" {
+			err = h.service.Answer(session, charId, r.Form["selected-state"])
 		}
 	}
-	session.Values["characters"] = answeredCharIds
-	session.Values["states"] = answeredStateIds
+	// Answering twice happens when the form is submitted again, the page
+	// then shows the answers already given.
+	if _, ok := err.(*AnswerError); err != nil && !ok {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	taxons := []*dataset.Taxon{}
-	if len(answeredStateIds) > 0 {
-		taxons, err = h.reg.GetTaxonsHavingStates(answeredStateIds)
+	if len(session.StateIds()) > 0 {
+		if taxons, err = h.service.Candidates(session); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 	}
-	answeredChars, err := h.reg.GetCharactersFromIds(answeredCharIds, answeredStateIds)
+	ranks, err := h.reg.GetTaxonRanks()
 	if err != nil {
-		log.Fatalf("Cannot retrieve characters: %q.\n", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	characters, charsByIds, err := h.reg.GetAllCharactersExcept(answeredCharIds)
+	answeredChars, err := h.reg.GetCharactersFromIds(session.CharacterIds(), session.StateIds())
 	if err != nil {
-		log.Fatalf("Cannot retrieve characters: %q.\n", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	characters, charsByIds, err := h.reg.GetAllCharactersExcept(session.CharacterIds())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 	tplData := TemplateData{
+		Page:             h.page(r),
 		UnansweredChars:  characters,
 		AnsweredChars:    answeredChars,
-		AnsweredCharIds:  answeredCharIds,
-		AnsweredStateIds: answeredStateIds,
+		AnsweredCharIds:  session.CharacterIds(),
+		AnsweredStateIds: session.StateIds(),
 		IdentifiedTaxons: taxons,
+		Rank:             session.Rank,
+		Ranks:            ranks,
+		Session:          session,
+		ShareToken:       EncodeShareToken(session),
 	}
-	err = session.Save(r, w)
+	tplData.ShareURL = shareURL(r, tplData.ShareToken)
+	err = cookie.Save(r, w)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	query := r.URL.Query()
 	if openCharacter := query.Get("in"); len(openCharacter) > 0 {
-		fmt.Printf("%v\n", charsByIds[openCharacter].Children)
 		if ch, ok := charsByIds[openCharacter]; ok {
 			chars := make([]*dataset.Character, 0, len(ch.Children))
 			for _, child := range ch.Children {
@@ -134,8 +325,116 @@ func (h *Handler) Func(w http.ResponseWriter, r *http.Request) {
 		} else if len(characters) > 0 {
 			tplData.PickedCharacter = characters[0]
 		}
-		h.template.ExecuteTemplate(w, "identify", tplData)
+	}
+	if tplData.PickedCharacter != nil {
+		h.render(w, r, "identify", tplData)
 	} else {
-		h.template.ExecuteTemplate(w, "characters", tplData)
+		h.render(w, r, "characters", tplData)
+	}
+}
+
+type NamesTemplateData struct {
+	Page
+	Query   string
+	Fields  []*dataset.ExtraField
+	Params  map[string]string
+	Error   string
+	Matches []*database.TaxonNameMatch
+}
+
+func (h *Handler) NamesFunc(w http.ResponseWriter, r *http.Request) {
+	fields, err := h.reg.GetExtraFields()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	filters, params := extraFilterParams(fields, r.URL.Query())
+	tplData := NamesTemplateData{Page: h.page(r), Query: strings.TrimSpace(r.URL.Query().Get("q")), Fields: fields, Params: params}
+	status := http.StatusOK
+	if tplData.Query != "" || len(filters) > 0 {
+		matches, err := h.reg.SearchTaxonNames(tplData.Query, filters)
+		if err != nil {
+			status = http.StatusBadRequest
+			tplData.Error = err.Error()
+		}
+		tplData.Matches = matches
+	}
+	h.renderStatus(w, r, status, "names", tplData)
+}
+
+// CodedGroup holds the coded characters sharing the same ancestors.
+type CodedGroup struct {
+	Path       []*dataset.Hierarchy
+	Characters []*database.CodedCharacter
+}
+
+func pathKey(path []*dataset.Hierarchy) string {
+	ids := make([]string, len(path))
+	for i, node := range path {
+		ids[i] = node.Id
+	}
+	return strings.Join(ids, "\x00")
+}
+
+func groupCodedCharacters(coded []*database.CodedCharacter) []*CodedGroup {
+	groups := []*CodedGroup{}
+	for _, c := range coded {
+		if len(groups) == 0 || pathKey(groups[len(groups)-1].Path) != pathKey(c.Path) {
+			groups = append(groups, &CodedGroup{Path: c.Path})
+		}
+		last := groups[len(groups)-1]
+		last.Characters = append(last.Characters, c)
+	}
+	return groups
+}
+
+type TaxonTemplateData struct {
+	Page
+	Taxon       *dataset.Taxon
+	Ancestors   []*dataset.Taxon
+	Children    []*dataset.Taxon
+	Coded       []*CodedGroup
+	Languages   map[string]string
+	References  []*database.TaxonReference
+	ExtraFields []*dataset.ExtraField
+}
+
+func (h *Handler) TaxonFunc(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/taxon/")
+	taxon, err := h.reg.GetTaxon(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if taxon == nil {
+		http.NotFound(w, r)
+		return
+	}
+	tplData := TaxonTemplateData{Page: h.page(r), Taxon: taxon, Languages: map[string]string{}}
+	langs, err := h.reg.GetLanguages()
+	if err == nil {
+		for _, lang := range langs {
+			tplData.Languages[lang.Code] = lang.Label
+		}
+		tplData.Ancestors, err = h.reg.GetTaxonAncestors(id)
+	}
+	if err == nil {
+		tplData.Children, err = h.reg.GetTaxonChildren(id)
+	}
+	var coded []*database.CodedCharacter
+	if err == nil {
+		coded, err = h.reg.GetTaxonCodedStates(id)
+		tplData.Coded = groupCodedCharacters(coded)
+	}
+	if err == nil {
+		tplData.References, err = h.reg.GetTaxonReferences(id)
+	}
+	if err == nil {
+		tplData.ExtraFields, err = h.reg.GetExtraFields()
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
+	h.render(w, r, "taxon", tplData)
 }
@@ -0,0 +1,50 @@
+package identification
+
+import (
+	"strings"
+	"testing"
+
+	"nicolas.galipot.net/taxonomia/dataset"
+	"nicolas.galipot.net/taxonomia/dataset/database"
+)
+
+func codedCharacter(id string, pathIds ...string) *database.CodedCharacter {
+	path := make([]*dataset.Hierarchy, len(pathIds))
+	for i, pathId := range pathIds {
+		path[i] = &dataset.Hierarchy{Id: pathId}
+	}
+	return &database.CodedCharacter{Character: dataset.NewCharacter(&dataset.Hierarchy{Id: id}), Path: path}
+}
+
+func TestGroupCodedCharacters(t *testing.T) {
+	groups := groupCodedCharacters([]*database.CodedCharacter{
+		codedCharacter("c1"),
+		codedCharacter("c2"),
+		codedCharacter("c4", "c3"),
+		codedCharacter("c5", "c3"),
+		codedCharacter("c7", "c3", "c6"),
+		// Same ancestors as an earlier group, but not next to it.
+		codedCharacter("c8", "c3"),
+		// Same ids joined differently.
+		codedCharacter("c9", "c3c6"),
+	})
+	var got []string
+	for _, group := range groups {
+		path := make([]string, len(group.Path))
+		for i, node := range group.Path {
+			path[i] = node.Id
+		}
+		ids := make([]string, len(group.Characters))
+		for i, c := range group.Characters {
+			ids[i] = c.Character.Id
+		}
+		got = append(got, strings.Join(path, ">")+"/"+strings.Join(ids, ","))
+	}
+	expected := "/c1,c2 c3/c4,c5 c3>c6/c7 c3/c8 c3c6/c9"
+	if strings.Join(got, " ") != expected {
+		t.Errorf("Expected groups %q, got %q.", expected, strings.Join(got, " "))
+	}
+	if groups := groupCodedCharacters(nil); len(groups) != 0 {
+		t.Errorf("Expected no groups, got %d.", len(groups))
+	}
+}
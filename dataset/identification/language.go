@@ -0,0 +1,126 @@
+package identification
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"nicolas.galipot.net/taxonomia/dataset"
+	"nicolas.galipot.net/taxonomia/dataset/i18n"
+)
+
+// scientificLang is the code of scientific names, which every text has.
+const scientificLang = "NS"
+
+func (h *Handler) isLanguage(code string) bool {
+	for _, lang := range h.languages {
+		if lang.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// language returns the language chosen during the session, the one the
+// browser prefers otherwise.
+func (h *Handler) language(r *http.Request) string {
+	session, _ := h.store.Get(r, "identification")
+	if lang, ok := session.Values["lang"].(string); ok && h.isLanguage(lang) {
+		return lang
+	}
+	codes := make([]string, len(h.languages))
+	for i, lang := range h.languages {
+		codes[i] = lang.Code
+	}
+	if lang := i18n.MatchAcceptLanguage(r.Header.Get("Accept-Language"), codes); lang != "" {
+		return lang
+	}
+	return i18n.DefaultLanguage
+}
+
+// nameLangs returns the languages to look names up in before falling back
+// to the scientific name.
+func nameLangs(lang string) []string {
+	if lang == scientificLang {
+		return nil
+	}
+	return i18n.Fallbacks(lang)
+}
+
+// translate returns a message in a language, formatted with args if any.
+func translate(lang, message string, args ...interface{}) string {
+	if len(args) > 0 {
+		return fmt.Sprintf(i18n.Translate(lang, message), args...)
+	}
+	return i18n.Translate(lang, message)
+}
+
+// templateFuncs returns the functions rendering texts in a language. The
+// templates are parsed with the default language and cloned for each
+// language.
+func (h *Handler) templateFuncs(lang string) template.FuncMap {
+	langs := nameLangs(lang)
+	return template.FuncMap{
+		"glossary": func(text string) template.HTML { return h.linkGlossaryTerms(text, langs) },
+		"t": func(message string, args ...interface{}) string {
+			return translate(lang, message, args...)
+		},
+		"name":      func(text dataset.MultilangText) string { return text.TextIn(langs...) },
+		"lang":      func() string { return lang },
+		"languages": func() []dataset.Lang { return h.languages },
+		"htmlLang": func() string {
+			if lang == "CN" {
+				return "zh"
+			}
+			return strings.ToLower(lang)
+		},
+	}
+}
+
+// render executes a template in the language of the request.
+func (h *Handler) render(w http.ResponseWriter, r *http.Request, name string, data interface{}) {
+	h.renderStatus(w, r, http.StatusOK, name, data)
+}
+
+func (h *Handler) renderStatus(w http.ResponseWriter, r *http.Request, status int, name string, data interface{}) {
+	tpl := h.templates[h.language(r)]
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := tpl.ExecuteTemplate(w, name, data); err != nil {
+		log.Printf("cannot render template %q: %q", name, err.Error())
+	}
+}
+
+// LanguageFunc stores the language chosen with the code parameter in the
+// session, and goes back to the previous page if it is one of the server.
+func (h *Handler) LanguageFunc(w http.ResponseWriter, r *http.Request) {
+	code := r.URL.Query().Get("code")
+	if !h.isLanguage(code) {
+		http.Error(w, fmt.Sprintf("Unknown language: %q.", code), http.StatusBadRequest)
+		return
+	}
+	session, _ := h.store.Get(r, "identification")
+	session.Values["lang"] = code
+	if err := session.Save(r, w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, refererPath(r, "/identify"), http.StatusSeeOther)
+}
+
+// refererPath returns the path of the page of the server a request comes
+// from, the fallback when the referer is missing or on another host.
+func refererPath(r *http.Request, fallback string) string {
+	u, err := url.Parse(r.Referer())
+	if err != nil || u.Host != r.Host {
+		return fallback
+	}
+	path := u.RequestURI()
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.HasPrefix(path, "/\\") {
+		return fallback
+	}
+	return path
+}
@@ -0,0 +1,112 @@
+package identification
+
+import (
+	"database/sql"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"nicolas.galipot.net/taxonomia/dataset"
+	"nicolas.galipot.net/taxonomia/dataset/database"
+)
+
+// newTestRegistry returns a registry of a new database, holding the dataset
+// of a Hazo document unless it is empty.
+func newTestRegistry(t *testing.T, hazo string) *database.DatasetRegistry {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "db.sq3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := database.CreateTables(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.InsertStandardContent(db); err != nil {
+		t.Fatal(err)
+	}
+	reg := database.NewRegistry(db)
+	if hazo != "" {
+		ds, err := dataset.ReadHazo(strings.NewReader(hazo))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := reg.InsertDataset(ds); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return reg
+}
+
+func newTestHandler(t *testing.T) *Handler {
+	return NewHandler(newTestRegistry(t, ""), "test-session-key", time.Hour)
+}
+
+func TestRefererPath(t *testing.T) {
+	cases := []struct {
+		referer string
+		want    string
+	}{
+		{"", "/identify"},
+		{"http://example.com/taxon/t1?lang=FR", "/taxon/t1?lang=FR"},
+		{"http://example.com", "/"},
+		{"https://other.org/taxon/t1", "/identify"},
+		{"//other.org/taxon/t1", "/identify"},
+		{"http://example.com//other.org/", "/identify"},
+		{"/taxon/t1", "/identify"},
+		{"http://example.com:8080/taxon/t1", "/identify"},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "http://example.com/lang?code=FR", nil)
+		if c.referer != "" {
+			r.Header.Set("Referer", c.referer)
+		}
+		if got := refererPath(r, "/identify"); got != c.want {
+			t.Errorf("refererPath(%q): expected %q, got %q.", c.referer, c.want, got)
+		}
+	}
+}
+
+func TestLanguageFuncStaysOnServer(t *testing.T) {
+	h := newTestHandler(t)
+	r := httptest.NewRequest("GET", "http://example.com/lang?code=FR", nil)
+	r.Header.Set("Referer", "https://other.org/")
+	w := httptest.NewRecorder()
+	h.LanguageFunc(w, r)
+	if w.Code != 303 || w.Header().Get("Location") != "/identify" {
+		t.Errorf("Expected a redirection to /identify, got %d to %q.", w.Code, w.Header().Get("Location"))
+	}
+}
+
+func TestRenderInLanguage(t *testing.T) {
+	h := newTestHandler(t)
+	render := func(acceptLanguage string, page Page) string {
+		r := httptest.NewRequest("GET", "/glossary", nil)
+		r.Header.Set("Accept-Language", acceptLanguage)
+		w := httptest.NewRecorder()
+		h.render(w, r, "glossary", GlossaryTemplateData{Page: page})
+		return w.Body.String()
+	}
+	// Pages in several languages are rendered at the same time from the
+	// templates of each language.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if body := render("fr", Page{}); !strings.Contains(body, `lang="fr"`) || !strings.Contains(body, "Se connecter") {
+				t.Errorf("Expected the page in French, got:\n%s", body)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if body := render("en", Page{Account: &database.User{Name: "ana"}}); !strings.Contains(body, `lang="en"`) || !strings.Contains(body, ">ana</a>") {
+				t.Errorf("Expected the page in English with the account, got:\n%s", body)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,87 @@
+package identification
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Session codes are short enough to be typed, so a client can only look up
+// a few of them in a period, guessing the codes of others taking too long.
+const (
+	codeAttempts      = 10
+	codeAttemptPeriod = time.Minute
+)
+
+// rateLimiter allows each client a number of attempts in a fixed period.
+// Clients whose period is over are forgotten once per period, so that it
+// keeps at most the clients of the last two periods.
+type rateLimiter struct {
+	attempts  int
+	period    time.Duration
+	mutex     sync.Mutex
+	windows   map[string]*rateWindow
+	nextPurge time.Time
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+func newRateLimiter(attempts int, period time.Duration) *rateLimiter {
+	return &rateLimiter{attempts: attempts, period: period, windows: map[string]*rateWindow{}}
+}
+
+// allow counts an attempt of a client, returning false with how long to
+// wait when it has none left in the period.
+func (l *rateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if !now.Before(l.nextPurge) {
+		l.forgetPast(now)
+		l.nextPurge = now.Add(l.period)
+	}
+	w, ok := l.windows[client]
+	if !ok || now.Sub(w.start) >= l.period {
+		w = &rateWindow{start: now}
+		l.windows[client] = w
+	}
+	if w.count >= l.attempts {
+		return false, w.start.Add(l.period).Sub(now)
+	}
+	w.count++
+	return true, 0
+}
+
+// forgetPast removes the clients whose period is over. It is called with
+// the mutex locked.
+func (l *rateLimiter) forgetPast(now time.Time) {
+	for client, w := range l.windows {
+		if now.Sub(w.start) >= l.period {
+			delete(l.windows, client)
+		}
+	}
+}
+
+// allowCodeLookup counts a lookup of a session code by the client of a
+// request, telling it when to try again if it made too many.
+func (h *Handler) allowCodeLookup(w http.ResponseWriter, r *http.Request) bool {
+	ok, wait := h.codeLimiter.allow(clientAddress(r), time.Now())
+	if !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	}
+	return ok
+}
+
+// clientAddress returns the address a request comes from.
+func clientAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,130 @@
+package identification
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"nicolas.galipot.net/taxonomia/dataset/accounts"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(2, time.Minute)
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.allow("a", start.Add(time.Duration(i)*time.Second)); !ok {
+			t.Fatalf("Expected attempt %d to be allowed.", i+1)
+		}
+	}
+	if ok, wait := l.allow("a", start.Add(10*time.Second)); ok || wait != 50*time.Second {
+		t.Errorf("Expected to wait 50s, got %v and %v.", ok, wait)
+	}
+	if ok, _ := l.allow("b", start.Add(10*time.Second)); !ok {
+		t.Errorf("Expected other clients to be allowed.")
+	}
+	if ok, _ := l.allow("a", start.Add(time.Minute)); !ok {
+		t.Errorf("Expected a new period to be allowed.")
+	}
+	// b is forgotten once its period is over.
+	l.allow("c", start.Add(2*time.Minute+10*time.Second))
+	if _, ok := l.windows["b"]; ok || len(l.windows) != 1 {
+		t.Errorf("Expected the past clients to be forgotten, got %v.", l.windows)
+	}
+}
+
+func TestSessionCodesAreLimited(t *testing.T) {
+	h := newTestHandler(t)
+	lookup := func(address string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/api/sessions?code=nope", nil)
+		r.RemoteAddr = address
+		w := httptest.NewRecorder()
+		h.SessionsFunc(w, r)
+		return w
+	}
+	for i := 0; i < codeAttempts; i++ {
+		if w := lookup("192.0.2.1:1234"); w.Code != http.StatusNotFound {
+			t.Fatalf("Expected lookup %d to find nothing, got %d.", i+1, w.Code)
+		}
+	}
+	// Changing the port does not give more attempts.
+	w := lookup("192.0.2.1:5678")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected to be told to try again later, got %d.", w.Code)
+	}
+	if w := lookup("192.0.2.2:1234"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected other clients to be allowed, got %d.", w.Code)
+	}
+}
+
+func TestSessionsFollowTheAccount(t *testing.T) {
+	h := newTestHandler(t)
+	user, err := h.reg.CreateUser("ana", "test password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := h.reg.CreateApiToken(user.Id, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("GET", "/sessions", nil)
+	cookie, _ := h.store.Get(r, "identification")
+	browser, err := h.sessionOwner(r, cookie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.service.NewSession(browser); err != nil {
+		t.Fatal(err)
+	}
+	// The same browser signs in.
+	r.Header.Set("Authorization", "Bearer "+token)
+	var owner string
+	accounts.NewHandler(h.reg, "key").Require(accounts.SignedIn, func(w http.ResponseWriter, r *http.Request) {
+		owner, err = h.sessionOwner(r, cookie)
+	})(httptest.NewRecorder(), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owner != fmt.Sprintf("user:%d", user.Id) {
+		t.Errorf("Expected the user to own the identifications, got %q.", owner)
+	}
+	if _, ok := cookie.Values["owner"]; ok {
+		t.Errorf("Expected the browser to be forgotten, got %v.", cookie.Values)
+	}
+	if sessions, err := h.service.OwnerSessions(owner); err != nil || len(sessions) != 1 {
+		t.Errorf("Expected the identification of the browser to move to the user, got %d and %v.", len(sessions), err)
+	}
+	if sessions, err := h.service.OwnerSessions(browser); err != nil || len(sessions) != 0 {
+		t.Errorf("Expected the browser to have no identification left, got %d and %v.", len(sessions), err)
+	}
+}
+
+func TestAPISessionsBelongToTheAccount(t *testing.T) {
+	h := newTestHandler(t)
+	user, err := h.reg.CreateUser("ana", "test password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := h.reg.CreateApiToken(user.Id, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("POST", "/api/sessions", strings.NewReader(`{}`))
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	accounts.NewHandler(h.reg, "key").Require(accounts.SignedIn, h.SessionsFunc)(w, r)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected the session to be created, got %d: %s.", w.Code, w.Body)
+	}
+	var session struct{ Id string }
+	if err := json.Unmarshal(w.Body.Bytes(), &session); err != nil {
+		t.Fatal(err)
+	}
+	sessions, err := h.service.OwnerSessions(fmt.Sprintf("user:%d", user.Id))
+	if err != nil || len(sessions) != 1 || sessions[0].Id != session.Id {
+		t.Errorf("Expected the session to belong to the user, got %v and %v.", sessions, err)
+	}
+}
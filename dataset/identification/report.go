@@ -0,0 +1,184 @@
+package identification
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"nicolas.galipot.net/taxonomia/dataset"
+	"nicolas.galipot.net/taxonomia/dataset/database"
+	"nicolas.galipot.net/taxonomia/dataset/pdf"
+)
+
+// ReportStep is an answer of an identification with the candidates left
+// after it.
+type ReportStep struct {
+	Character *dataset.Character
+	// States are the states observed, none when the character was passed.
+	States     []*dataset.State
+	Candidates []*dataset.Taxon
+}
+
+// Report tells how an identification reached its result, to be kept with
+// the specimen.
+type Report struct {
+	Session   *Session
+	Steps     []*ReportStep
+	Result    []*dataset.Taxon
+	Dataset   *database.DatasetInfo
+	Generated time.Time
+}
+
+// Report replays the answers of a session, recording the candidates at each
+// step.
+func (s *Service) Report(session *Session) (*Report, error) {
+	report := &Report{Session: session, Steps: []*ReportStep{}, Generated: time.Now()}
+	var err error
+	if report.Dataset, err = s.reg.GetDatasetInfo(); err != nil {
+		return nil, err
+	}
+	stateIds := []string{}
+	for _, answer := range session.Answers {
+		character, err := s.reg.GetCharacter(answer.CharacterId)
+		if err != nil {
+			return nil, err
+		}
+		if character == nil {
+			// The dataset changed since the answer was given.
+			character = dataset.NewCharacter(&dataset.Hierarchy{Id: answer.CharacterId, Name: dataset.MultilangText{Scientific: answer.CharacterId}})
+		}
+		step := &ReportStep{Character: character, States: []*dataset.State{}}
+		for _, stateId := range answer.StateIds {
+			for i := range character.States {
+				if character.States[i].Id == stateId {
+					step.States = append(step.States, &character.States[i])
+				}
+			}
+		}
+		stateIds = append(stateIds, answer.StateIds...)
+		if step.Candidates, err = s.reg.GetCandidateTaxons(stateIds, session.Rank); err != nil {
+			return nil, err
+		}
+		report.Steps = append(report.Steps, step)
+	}
+	report.Result = []*dataset.Taxon{}
+	if len(stateIds) > 0 {
+		if report.Result, err = s.Candidates(session); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// ReportFunc shows the report of the identification of the browser, as a
+// page to print or, with format=pdf, as a document to download.
+func (h *Handler) ReportFunc(w http.ResponseWriter, r *http.Request) {
+	cookie, _ := h.store.Get(r, "identification")
+	session, err := h.browserSession(r, cookie)
+	if err == nil {
+		err = cookie.Save(r, w)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	report, err := h.service.Report(session)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	switch r.URL.Query().Get("format") {
+	case "":
+		h.render(w, r, "report", report)
+	case "pdf":
+		doc := h.reportDocument(report, h.language(r))
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="identification-%s.pdf"`, session.Code))
+		doc.WriteTo(w)
+	default:
+		http.Error(w, fmt.Sprintf("Unknown report format: %q.", r.URL.Query().Get("format")), http.StatusBadRequest)
+	}
+}
+
+// reportDocument lays out a report as a PDF document. The standard PDF fonts
+// only draw Latin scripts, so texts fall back to English messages and
+// scientific names when needed.
+func (h *Handler) reportDocument(report *Report, lang string) *pdf.Document {
+	t := func(message string, args ...interface{}) string {
+		if text := translate(lang, message, args...); pdf.Encodable(text) {
+			return text
+		}
+		return fmt.Sprintf(message, args...)
+	}
+	langs := nameLangs(lang)
+	name := func(text dataset.MultilangText) string {
+		if name := text.TextIn(langs...); pdf.Encodable(name) {
+			return name
+		}
+		return text.Scientific
+	}
+	taxonNames := func(taxons []*dataset.Taxon) string {
+		names := make([]string, len(taxons))
+		for i, taxon := range taxons {
+			names[i] = taxon.Name.Scientific
+		}
+		return strings.Join(names, ", ")
+	}
+	pictures := func(pics []dataset.Picture) [][]byte {
+		var sources [][]byte
+		for _, pic := range pics {
+			if data, ok := h.reg.GetCachedImage(pic.Source); ok {
+				sources = append(sources, data)
+			}
+		}
+		return sources
+	}
+	doc := pdf.New(t("Identification report"))
+	doc.Created = report.Generated
+	doc.Paragraph(pdf.Bold, 18, 0, t("Identification report"))
+	doc.Space(6)
+	doc.Paragraph(pdf.Regular, 10, 0, t("Identification code: %s", report.Session.Code))
+	doc.Paragraph(pdf.Regular, 10, 0, t("Generated on %s", report.Generated.Format("2006-01-02 15:04 MST")))
+	if report.Dataset != nil {
+		doc.Paragraph(pdf.Regular, 10, 0, t("Dataset %s, version %s, imported on %s",
+			report.Dataset.Id, report.Dataset.Version, report.Dataset.Imported.Format("2006-01-02 15:04 MST")))
+	}
+	if report.Session.Rank != "" {
+		doc.Paragraph(pdf.Regular, 10, 0, t("Identified to %s", t(report.Session.Rank)))
+	}
+	doc.Space(12)
+	doc.Paragraph(pdf.Bold, 14, 0, t("Answers"))
+	if len(report.Steps) == 0 {
+		doc.Paragraph(pdf.Regular, 11, 0, t("No characters answered"))
+	}
+	for i, step := range report.Steps {
+		doc.Space(6)
+		doc.Paragraph(pdf.Bold, 12, 0, fmt.Sprintf("%d. %s", i+1, name(step.Character.Name)))
+		doc.Pictures(pictures(step.Character.Pictures), 60, 20)
+		if len(step.States) == 0 {
+			doc.Paragraph(pdf.Regular, 11, 20, t("Passed"))
+		}
+		for _, state := range step.States {
+			doc.Paragraph(pdf.Regular, 11, 20, name(state.Name))
+			doc.Pictures(pictures(state.Pictures), 60, 20)
+		}
+		doc.Paragraph(pdf.Regular, 10, 20, t("%d candidates", len(step.Candidates))+": "+taxonNames(step.Candidates))
+	}
+	doc.Space(12)
+	doc.Paragraph(pdf.Bold, 14, 0, t("Result"))
+	if len(report.Result) == 0 {
+		doc.Paragraph(pdf.Regular, 11, 0, t("No taxons identified"))
+	}
+	for _, taxon := range report.Result {
+		text := taxon.Name.Scientific
+		if taxon.Author != "" {
+			text += " " + taxon.Author
+		}
+		if taxon.Rank != "" {
+			text += " (" + t(taxon.Rank) + ")"
+		}
+		doc.Paragraph(pdf.Regular, 11, 0, text)
+	}
+	return doc
+}
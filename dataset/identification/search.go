@@ -0,0 +1,121 @@
+package identification
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"nicolas.galipot.net/taxonomia/dataset"
+	"nicolas.galipot.net/taxonomia/dataset/database"
+)
+
+const suggestionsCount = 10
+
+// highlightSnippet escapes a search snippet, marking the matched words.
+func highlightSnippet(snippet string) template.HTML {
+	escaped := template.HTMLEscapeString(snippet)
+	return template.HTML(strings.NewReplacer(database.SnippetStart, "<mark>", database.SnippetEnd, "</mark>").Replace(escaped))
+}
+
+func plainSnippet(snippet string) string {
+	return strings.NewReplacer(database.SnippetStart, "", database.SnippetEnd, "").Replace(snippet)
+}
+
+// resultUrl returns the page showing a search result.
+func resultUrl(result *database.SearchResult) string {
+	switch result.Kind {
+	case database.SearchKindTaxon:
+		return "/taxon/" + url.PathEscape(result.ItemId)
+	case database.SearchKindCharacter:
+		return "/character/" + url.PathEscape(result.ItemId)
+	case database.SearchKindState:
+		return "/character/" + url.PathEscape(result.CharacterId) + "#state-" + url.PathEscape(result.ItemId)
+	}
+	return ""
+}
+
+func searchParams(r *http.Request) (string, []string, string) {
+	query := r.URL.Query()
+	kinds := []string{}
+	for _, kind := range query["kind"] {
+		if kind != "" {
+			kinds = append(kinds, kind)
+		}
+	}
+	return strings.TrimSpace(query.Get("q")), kinds, query.Get("lang")
+}
+
+type SearchTemplateData struct {
+	Page
+	Query     string
+	Kinds     map[string]bool
+	Lang      string
+	AllKinds  []string
+	Languages []dataset.Lang
+	Results   []*database.SearchResult
+}
+
+func (h *Handler) SearchFunc(w http.ResponseWriter, r *http.Request) {
+	query, kinds, lang := searchParams(r)
+	langs, err := h.reg.GetLanguages()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tplData := SearchTemplateData{
+		Page:      h.page(r),
+		Query:     query,
+		Kinds:     map[string]bool{},
+		Lang:      lang,
+		AllKinds:  database.SearchKinds,
+		Languages: langs,
+	}
+	for _, kind := range kinds {
+		tplData.Kinds[kind] = true
+	}
+	if query != "" {
+		results, err := h.reg.Search(query, kinds, lang)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		tplData.Results = results
+	}
+	h.render(w, r, "search", tplData)
+}
+
+type suggestion struct {
+	Id   string `json:"id"`
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	Text string `json:"text"`
+	Url  string `json:"url"`
+}
+
+// SuggestFunc answers the best search results as JSON, to complete what is
+// being typed in the search box.
+func (h *Handler) SuggestFunc(w http.ResponseWriter, r *http.Request) {
+	query, kinds, lang := searchParams(r)
+	results, err := h.reg.Search(query, kinds, lang)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(results) > suggestionsCount {
+		results = results[:suggestionsCount]
+	}
+	suggestions := make([]suggestion, len(results))
+	for i, result := range results {
+		suggestions[i] = suggestion{
+			Id:   result.ItemId,
+			Kind: result.Kind,
+			Name: result.Name,
+			Text: plainSnippet(result.Snippet),
+			Url:  resultUrl(result),
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(suggestions)
+}
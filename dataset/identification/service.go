@@ -0,0 +1,436 @@
+package identification
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"nicolas.galipot.net/taxonomia/dataset"
+	"nicolas.galipot.net/taxonomia/dataset/database"
+)
+
+var ErrSessionNotFound = errors.New("no such identification session")
+
+// Answer is the description of a character, with the states observed, none
+// when the character was passed.
+type Answer struct {
+	CharacterId string   `json:"character"`
+	StateIds    []string `json:"states"`
+}
+
+// Session is an identification in progress, answers being kept in the order
+// they were given. Code resumes it from another device, Owner is the
+// browser that started it.
+type Session struct {
+	Id      string    `json:"id"`
+	Code    string    `json:"code"`
+	Owner   string    `json:"-"`
+	Answers []Answer  `json:"answers"`
+	Rank    string    `json:"rank"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+	Expires time.Time `json:"expires"`
+}
+
+func (s *Session) CharacterIds() []string {
+	ids := make([]string, len(s.Answers))
+	for i, answer := range s.Answers {
+		ids[i] = answer.CharacterId
+	}
+	return ids
+}
+
+// StateIds returns the states of every answer.
+func (s *Session) StateIds() []string {
+	ids := []string{}
+	for _, answer := range s.Answers {
+		ids = append(ids, answer.StateIds...)
+	}
+	return ids
+}
+
+// SessionStore keeps identification sessions by id.
+type SessionStore interface {
+	// CreateSession returns a new empty session with a unique id and code.
+	CreateSession(owner string) (*Session, error)
+	// Session returns the session with this id, ErrSessionNotFound if there
+	// is none.
+	Session(id string) (*Session, error)
+	// SessionByCode returns the session with this code, ErrSessionNotFound if
+	// there is none.
+	SessionByCode(code string) (*Session, error)
+	// OwnerSessions returns the sessions of an owner, the last updated first.
+	OwnerSessions(owner string) ([]*Session, error)
+	SaveSession(session *Session) error
+}
+
+// RegistrySessionStore keeps sessions in the registry, each one expiring
+// when it has not been used for its lifetime.
+type RegistrySessionStore struct {
+	reg      *database.DatasetRegistry
+	lifetime time.Duration
+}
+
+func NewRegistrySessionStore(reg *database.DatasetRegistry, lifetime time.Duration) *RegistrySessionStore {
+	return &RegistrySessionStore{reg: reg, lifetime: lifetime}
+}
+
+func newSession(stored *database.IdentificationSession) *Session {
+	session := &Session{
+		Id:      stored.Id,
+		Code:    stored.Code,
+		Owner:   stored.Owner,
+		Answers: make([]Answer, len(stored.Answers)),
+		Rank:    stored.Rank,
+		Created: stored.Created,
+		Updated: stored.Updated,
+		Expires: stored.Expires,
+	}
+	for i, answer := range stored.Answers {
+		session.Answers[i] = Answer{CharacterId: answer.CharacterId, StateIds: answer.StateIds}
+	}
+	return session
+}
+
+func foundSession(stored *database.IdentificationSession, err error) (*Session, error) {
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil {
+		return nil, ErrSessionNotFound
+	}
+	return newSession(stored), nil
+}
+
+func (store *RegistrySessionStore) CreateSession(owner string) (*Session, error) {
+	return foundSession(store.reg.CreateIdentificationSession(owner, store.lifetime))
+}
+
+func (store *RegistrySessionStore) Session(id string) (*Session, error) {
+	return foundSession(store.reg.GetIdentificationSession(id))
+}
+
+func (store *RegistrySessionStore) SessionByCode(code string) (*Session, error) {
+	return foundSession(store.reg.GetIdentificationSessionByCode(code))
+}
+
+func (store *RegistrySessionStore) OwnerSessions(owner string) ([]*Session, error) {
+	stored, err := store.reg.GetOwnerIdentificationSessions(owner)
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]*Session, len(stored))
+	for i, s := range stored {
+		sessions[i] = newSession(s)
+	}
+	return sessions, nil
+}
+
+func (store *RegistrySessionStore) SaveSession(session *Session) error {
+	stored := &database.IdentificationSession{
+		Id:      session.Id,
+		Rank:    session.Rank,
+		Answers: make([]database.IdentificationAnswer, len(session.Answers)),
+	}
+	for i, answer := range session.Answers {
+		stored.Answers[i] = database.IdentificationAnswer{CharacterId: answer.CharacterId, StateIds: answer.StateIds}
+	}
+	ok, err := store.reg.SaveIdentificationSession(stored, store.lifetime)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrSessionNotFound
+	}
+	session.Updated, session.Expires = stored.Updated, stored.Expires
+	return nil
+}
+
+// AnswerError reports an answer that cannot be given in a session.
+type AnswerError struct {
+	CharacterId string
+	Reason      string
+}
+
+func (e *AnswerError) Error() string {
+	return fmt.Sprintf("cannot answer character %q: %s", e.CharacterId, e.Reason)
+}
+
+// Service runs identifications against the registry, keeping their state in
+// a session store. Both the HTML pages and the JSON API go through it.
+type Service struct {
+	reg      *database.DatasetRegistry
+	sessions SessionStore
+
+	// updateMutex makes the changes of sessions one at a time, so that
+	// concurrent requests on a session do not lose answers.
+	updateMutex sync.Mutex
+}
+
+func NewService(reg *database.DatasetRegistry, sessions SessionStore) *Service {
+	return &Service{reg: reg, sessions: sessions}
+}
+
+func (s *Service) NewSession(owner string) (*Session, error) {
+	return s.sessions.CreateSession(owner)
+}
+
+func (s *Service) Session(id string) (*Session, error) {
+	return s.sessions.Session(id)
+}
+
+// ResumeSession returns the session with this code, to go on with an
+// identification started elsewhere.
+func (s *Service) ResumeSession(code string) (*Session, error) {
+	return s.sessions.SessionByCode(code)
+}
+
+// OwnerSessions returns the identifications started by an owner which have
+// not expired, the last updated first.
+func (s *Service) OwnerSessions(owner string) ([]*Session, error) {
+	return s.sessions.OwnerSessions(owner)
+}
+
+// update applies a change to the stored state of a session and saves it,
+// session being replaced by the result. The change sees the answers given
+// by other requests since session was read.
+func (s *Service) update(session *Session, change func(current *Session) error) error {
+	s.updateMutex.Lock()
+	defer s.updateMutex.Unlock()
+	current, err := s.sessions.Session(session.Id)
+	if err != nil {
+		return err
+	}
+	if err := change(current); err != nil {
+		return err
+	}
+	if err := s.sessions.SaveSession(current); err != nil {
+		return err
+	}
+	*session = *current
+	return nil
+}
+
+// Answer records the states observed for a character, passing it when there
+// are none.
+func (s *Service) Answer(session *Session, characterId string, stateIds []string) error {
+	return s.update(session, func(current *Session) error {
+		if err := s.checkAnswer(current, characterId, stateIds); err != nil {
+			return err
+		}
+		current.Answers = append(current.Answers, Answer{CharacterId: characterId, StateIds: append([]string{}, stateIds...)})
+		return nil
+	})
+}
+
+func (s *Service) checkAnswer(session *Session, characterId string, stateIds []string) error {
+	for _, answer := range session.Answers {
+		if answer.CharacterId == characterId {
+			return &AnswerError{characterId, "already answered"}
+		}
+	}
+	character, err := s.reg.GetCharacter(characterId)
+	if err != nil {
+		return err
+	}
+	if character == nil {
+		return &AnswerError{characterId, "unknown character"}
+	}
+	for _, stateId := range stateIds {
+		found := false
+		for _, state := range character.States {
+			if state.Id == stateId {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return &AnswerError{characterId, fmt.Sprintf("unknown state %q", stateId)}
+		}
+	}
+	return nil
+}
+
+// Restore replaces the rank and answers of a session with those of another,
+// such as one decoded from a share token.
+func (s *Service) Restore(session *Session, from *Session) error {
+	rank, err := dataset.ParseRank(from.Rank)
+	if err != nil {
+		return err
+	}
+	restored := &Session{Answers: []Answer{}}
+	for _, answer := range from.Answers {
+		if err := s.checkAnswer(restored, answer.CharacterId, answer.StateIds); err != nil {
+			return err
+		}
+		restored.Answers = append(restored.Answers, Answer{CharacterId: answer.CharacterId, StateIds: append([]string{}, answer.StateIds...)})
+	}
+	return s.update(session, func(current *Session) error {
+		current.Rank, current.Answers = rank, restored.Answers
+		return nil
+	})
+}
+
+// Undo removes the last answer.
+func (s *Service) Undo(session *Session) error {
+	return s.update(session, func(current *Session) error {
+		if len(current.Answers) > 0 {
+			current.Answers = current.Answers[:len(current.Answers)-1]
+		}
+		return nil
+	})
+}
+
+// Reset removes every answer.
+func (s *Service) Reset(session *Session) error {
+	return s.update(session, func(current *Session) error {
+		current.Answers = []Answer{}
+		return nil
+	})
+}
+
+// SetRank changes the rank candidates are aggregated to, any rank when empty.
+func (s *Service) SetRank(session *Session, rank string) error {
+	rank, err := dataset.ParseRank(rank)
+	if err != nil {
+		return err
+	}
+	return s.update(session, func(current *Session) error {
+		current.Rank = rank
+		return nil
+	})
+}
+
+// Candidates returns the taxons matching every answer at the rank of the
+// session.
+func (s *Service) Candidates(session *Session) ([]*dataset.Taxon, error) {
+	return s.reg.GetCandidateTaxons(session.StateIds(), session.Rank)
+}
+
+// StateCount is the number of candidates having a state.
+type StateCount struct {
+	State *dataset.State
+	Count int
+}
+
+// RankedCharacter is a character not answered yet, Score being the expected
+// fraction of the candidates its answer would eliminate.
+type RankedCharacter struct {
+	Character *dataset.Character
+	Score     float64
+	States    []StateCount
+}
+
+// separation returns the expected fraction of the candidates eliminated by
+// choosing one state, states being chosen in proportion of the candidates
+// having them. Candidates not coded for the character are always eliminated.
+func separation(counts []int, total int) float64 {
+	sum := 0
+	for _, count := range counts {
+		sum += count
+	}
+	if sum == 0 || total == 0 {
+		return 0
+	}
+	remaining := 0.0
+	for _, count := range counts {
+		remaining += float64(count) / float64(sum) * float64(count) / float64(total)
+	}
+	return 1 - remaining
+}
+
+// NextCharacters returns the characters not answered yet which are coded for
+// some candidates, those best splitting the candidates first.
+func (s *Service) NextCharacters(session *Session) ([]*RankedCharacter, error) {
+	answered := session.CharacterIds()
+	counts, total, err := s.reg.GetCandidateStateCounts(session.StateIds(), answered)
+	if err != nil {
+		return nil, err
+	}
+	_, charactersById, err := s.reg.GetAllCharactersExcept(answered)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := s.reg.GetCharacterTree()
+	if err != nil {
+		return nil, err
+	}
+	ranked := []*RankedCharacter{}
+	var visit func(nodes []*dataset.Hierarchy)
+	visit = func(nodes []*dataset.Hierarchy) {
+		for _, node := range nodes {
+			character, ok := charactersById[node.Id]
+			if stateCounts, coded := counts[node.Id]; ok && coded {
+				rc := &RankedCharacter{Character: character}
+				values := make([]int, 0, len(character.States))
+				for i := range character.States {
+					count := stateCounts[character.States[i].Id]
+					rc.States = append(rc.States, StateCount{State: &character.States[i], Count: count})
+					values = append(values, count)
+				}
+				rc.Score = separation(values, total)
+				ranked = append(ranked, rc)
+			}
+			visit(node.Children)
+		}
+	}
+	visit(tree)
+	sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].Score > ranked[j].Score })
+	return ranked, nil
+}
+
+// AnswerExplanation tells whether a taxon has all the states of an answer,
+// passed characters matching every taxon.
+type AnswerExplanation struct {
+	Answer
+	TaxonStateIds []string
+	Matches       bool
+}
+
+// Explanation tells why a taxon is a candidate or not.
+type Explanation struct {
+	Taxon     *dataset.Taxon
+	Candidate bool
+	Answers   []*AnswerExplanation
+}
+
+// Explain compares the states of a taxon with the answers of a session, nil
+// if there is no such taxon.
+func (s *Service) Explain(session *Session, taxonId string) (*Explanation, error) {
+	taxon, err := s.reg.GetTaxon(taxonId)
+	if err != nil || taxon == nil {
+		return nil, err
+	}
+	coded, err := s.reg.GetTaxonCodedStates(taxonId)
+	if err != nil {
+		return nil, err
+	}
+	statesByCharacter := map[string][]string{}
+	for _, c := range coded {
+		for _, state := range c.States {
+			statesByCharacter[c.Character.Id] = append(statesByCharacter[c.Character.Id], state.Id)
+		}
+	}
+	explanation := &Explanation{Taxon: taxon, Candidate: true, Answers: []*AnswerExplanation{}}
+	for _, answer := range session.Answers {
+		ae := &AnswerExplanation{Answer: answer, TaxonStateIds: statesByCharacter[answer.CharacterId], Matches: true}
+		if ae.TaxonStateIds == nil {
+			ae.TaxonStateIds = []string{}
+		}
+		for _, stateId := range answer.StateIds {
+			found := false
+			for _, taxonStateId := range ae.TaxonStateIds {
+				if stateId == taxonStateId {
+					found = true
+					break
+				}
+			}
+			ae.Matches = ae.Matches && found
+		}
+		explanation.Candidate = explanation.Candidate && ae.Matches
+		explanation.Answers = append(explanation.Answers, ae)
+	}
+	return explanation, nil
+}
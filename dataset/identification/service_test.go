@@ -0,0 +1,221 @@
+package identification
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"nicolas.galipot.net/taxonomia/dataset"
+)
+
+const serviceFixture = `{
+	"id": "ds",
+	"taxons": [
+		{ "id": "g1", "name": "Quercus", "extra": { "rank": "genus" }, "children": ["t1", "t2"] },
+		{ "id": "t1", "name": "Quercus robur", "descriptions": [
+			{ "descriptorId": "c1", "statesIds": ["s1", "s2"] },
+			{ "descriptorId": "c2", "statesIds": ["s3"] },
+			{ "descriptorId": "c3", "statesIds": ["s5"] }
+		] },
+		{ "id": "t2", "name": "Quercus ilex", "descriptions": [
+			{ "descriptorId": "c1", "statesIds": ["s2"] },
+			{ "descriptorId": "c3", "statesIds": ["s6"] }
+		] }
+	],
+	"characters": [
+		{ "id": "c1", "name": "Leaf", "children": ["c3"], "states": ["s1", "s2"] },
+		{ "id": "c2", "name": "Bark", "states": ["s3", "s4"] },
+		{ "id": "c3", "name": "Leaf margin", "states": ["s5", "s6"] }
+	],
+	"states": [
+		{ "id": "s1", "name": "lobed" },
+		{ "id": "s2", "name": "entire" },
+		{ "id": "s3", "name": "smooth" },
+		{ "id": "s4", "name": "fissured" },
+		{ "id": "s5", "name": "toothed" },
+		{ "id": "s6", "name": "spiny" }
+	]
+}`
+
+func newTestService(t *testing.T) *Service {
+	reg := newTestRegistry(t, serviceFixture)
+	return NewService(reg, NewRegistrySessionStore(reg, time.Hour))
+}
+
+func TestSeparation(t *testing.T) {
+	cases := []struct {
+		counts []int
+		total  int
+		want   float64
+	}{
+		{[]int{1, 1}, 2, 0.5},
+		{[]int{2, 0}, 2, 0},
+		// The candidate not coded is eliminated whatever the state.
+		{[]int{1, 0}, 2, 0.5},
+		// A candidate with both states stays whatever the state.
+		{[]int{1, 2}, 2, 1.0 / 6},
+		{[]int{0, 0}, 2, 0},
+		{nil, 0, 0},
+	}
+	for _, c := range cases {
+		if got := separation(c.counts, c.total); math.Abs(got-c.want) > 1e-9 {
+			t.Errorf("separation(%v, %d): expected %v, got %v.", c.counts, c.total, c.want, got)
+		}
+	}
+}
+
+func taxonIds(taxons []*dataset.Taxon) string {
+	ids := make([]string, len(taxons))
+	for i, taxon := range taxons {
+		ids[i] = taxon.Id
+	}
+	return strings.Join(ids, ",")
+}
+
+func rankedIds(ranked []*RankedCharacter) string {
+	ids := make([]string, len(ranked))
+	for i, rc := range ranked {
+		ids[i] = rc.Character.Id
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestNextCharacters(t *testing.T) {
+	s := newTestService(t)
+	session, err := s.NewSession("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ranked, err := s.NextCharacters(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Equal scores keep the order of the characters tree.
+	if got := rankedIds(ranked); got != "c3,c2,c1" {
+		t.Errorf("Expected the best splitting characters first, got %q.", got)
+	}
+	if len(ranked) == 3 && (len(ranked[1].States) != 2 || ranked[1].States[0].Count != 1 || ranked[1].States[1].Count != 0) {
+		t.Errorf("Expected the candidates of each state of c2, got %+v.", ranked[1].States)
+	}
+	if err := s.Answer(session, "c1", []string{"s2"}); err != nil {
+		t.Fatal(err)
+	}
+	if ranked, err = s.NextCharacters(session); err != nil {
+		t.Fatal(err)
+	}
+	if got := rankedIds(ranked); got != "c3,c2" {
+		t.Errorf("Expected the answered character to be left out, got %q.", got)
+	}
+	if err := s.Answer(session, "c3", []string{"s6"}); err != nil {
+		t.Fatal(err)
+	}
+	if ranked, err = s.NextCharacters(session); err != nil {
+		t.Fatal(err)
+	}
+	// c2 is not coded for the only candidate left.
+	if len(ranked) != 0 {
+		t.Errorf("Expected no characters for the last candidate, got %q.", rankedIds(ranked))
+	}
+}
+
+func TestAnswerIsChecked(t *testing.T) {
+	s := newTestService(t)
+	session, err := s.NewSession("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Answer(session, "c1", []string{"s1"}); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		characterId string
+		stateIds    []string
+		reason      string
+	}{
+		{"c1", nil, "already answered"},
+		{"c9", nil, "unknown character"},
+		{"c2", []string{"s3", "s1"}, `unknown state "s1"`},
+	}
+	for _, c := range cases {
+		err := s.Answer(session, c.characterId, c.stateIds)
+		if answerErr, ok := err.(*AnswerError); !ok || answerErr.Reason != c.reason {
+			t.Errorf("Answer(%q, %q): expected %q, got %v.", c.characterId, c.stateIds, c.reason, err)
+		}
+	}
+	stored, err := s.Session(session.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.Answers) != 1 || len(session.Answers) != 1 {
+		t.Errorf("Expected the rejected answers to be left out, got %+v.", stored.Answers)
+	}
+	if candidates, err := s.Candidates(stored); err != nil || taxonIds(candidates) != "t1" {
+		t.Errorf("Expected t1 to be lobed, got %q and %v.", taxonIds(candidates), err)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	s := newTestService(t)
+	session, err := s.NewSession("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, answer := range []Answer{{"c1", []string{"s1", "s2"}}, {"c2", nil}} {
+		if err := s.Answer(session, answer.CharacterId, answer.StateIds); err != nil {
+			t.Fatal(err)
+		}
+	}
+	explanation, err := s.Explain(session, "t1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !explanation.Candidate || len(explanation.Answers) != 2 || !explanation.Answers[0].Matches || !explanation.Answers[1].Matches {
+		t.Errorf("Expected t1 to match every answer, got %+v.", explanation)
+	}
+	if explanation, err = s.Explain(session, "t2"); err != nil {
+		t.Fatal(err)
+	}
+	// t2 is entire but not lobed.
+	if explanation.Candidate || explanation.Answers[0].Matches || strings.Join(explanation.Answers[0].TaxonStateIds, ",") != "s2" {
+		t.Errorf("Expected t2 to miss the first answer, got %+v.", explanation.Answers[0])
+	}
+	if !explanation.Answers[1].Matches || len(explanation.Answers[1].TaxonStateIds) != 0 {
+		t.Errorf("Expected a passed character to match, got %+v.", explanation.Answers[1])
+	}
+	if explanation, err := s.Explain(session, "t9"); err != nil || explanation != nil {
+		t.Errorf("Expected no explanation for an unknown taxon, got %+v and %v.", explanation, err)
+	}
+}
+
+func TestConcurrentAnswersAreKept(t *testing.T) {
+	s := newTestService(t)
+	session, err := s.NewSession("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every request reads the session before answering.
+	var wg sync.WaitGroup
+	for _, characterId := range []string{"c1", "c2", "c3"} {
+		read, err := s.Session(session.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func(read *Session, characterId string) {
+			defer wg.Done()
+			if err := s.Answer(read, characterId, nil); err != nil {
+				t.Error(err)
+			}
+		}(read, characterId)
+	}
+	wg.Wait()
+	stored, err := s.Session(session.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.Answers) != 3 {
+		t.Errorf("Expected the 3 answers to be kept, got %+v.", stored.Answers)
+	}
+}
@@ -0,0 +1,82 @@
+package identification
+
+import (
+	"net/http"
+)
+
+type SessionsTemplateData struct {
+	Page
+	Current  string
+	Sessions []*Session
+	Code     string
+	Error    string
+}
+
+// SessionsPageFunc lists the identifications of the user signed in, or else
+// of the browser. Posting a code resumes the identification it belongs to,
+// possibly started on another device, and posting the new action starts
+// another one.
+func (h *Handler) SessionsPageFunc(w http.ResponseWriter, r *http.Request) {
+	cookie, _ := h.store.Get(r, "identification")
+	owner, err := h.sessionOwner(r, cookie)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tplData := SessionsTemplateData{Page: h.page(r)}
+	status := http.StatusOK
+	if r.Method == http.MethodPost {
+		var session *Session
+		if r.PostFormValue("action") == "new" {
+			session, err = h.service.NewSession(owner)
+		} else if tplData.Code = r.PostFormValue("code"); !h.allowCodeLookup(w, r) {
+			err = errTooManyCodes
+		} else {
+			session, err = h.service.ResumeSession(tplData.Code)
+		}
+		switch err {
+		case nil:
+			cookie.Values["session"] = session.Id
+			if err := cookie.Save(r, w); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			http.Redirect(w, r, "/identify", http.StatusSeeOther)
+			return
+		case errTooManyCodes:
+			status = http.StatusTooManyRequests
+			tplData.Error = "Too many codes tried, try again later"
+		case ErrSessionNotFound:
+			status = http.StatusNotFound
+			tplData.Error = "No identification has this code"
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	tplData.Current, _ = cookie.Values["session"].(string)
+	if tplData.Sessions, err = h.service.OwnerSessions(owner); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// The current identification may have been resumed from another device.
+	if tplData.Current != "" && !hasSession(tplData.Sessions, tplData.Current) {
+		if session, err := h.service.Session(tplData.Current); err == nil {
+			tplData.Sessions = append([]*Session{session}, tplData.Sessions...)
+		}
+	}
+	if err := cookie.Save(r, w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.renderStatus(w, r, status, "sessions", tplData)
+}
+
+func hasSession(sessions []*Session, id string) bool {
+	for _, session := range sessions {
+		if session.Id == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,187 @@
+package identification
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image/png"
+	"net/http"
+	"net/url"
+
+	"nicolas.galipot.net/taxonomia/dataset/qrcode"
+)
+
+var ErrInvalidShareToken = errors.New("invalid identification link")
+
+// shareTokenVersion is the first byte of the share tokens made now. When
+// their layout changes it is increased and a decoder of the new layout is
+// added to shareTokenDecoders, keeping those of the links already sent.
+const shareTokenVersion = 1
+
+// shareTokenDecoders read the tokens of each version after their first byte.
+var shareTokenDecoders = map[byte]func(r *bytes.Reader) (*Session, error){
+	1: decodeShareTokenV1,
+}
+
+// EncodeShareToken returns the rank and answers of a session in a short
+// URL-safe token. After the version byte come length-prefixed strings: the
+// rank, then for each answer the character and its states.
+func EncodeShareToken(session *Session) string {
+	var b bytes.Buffer
+	b.WriteByte(shareTokenVersion)
+	writeUvarint := func(n int) {
+		var buf [binary.MaxVarintLen64]byte
+		b.Write(buf[:binary.PutUvarint(buf[:], uint64(n))])
+	}
+	writeString := func(s string) {
+		writeUvarint(len(s))
+		b.WriteString(s)
+	}
+	writeString(session.Rank)
+	writeUvarint(len(session.Answers))
+	for _, answer := range session.Answers {
+		writeString(answer.CharacterId)
+		writeUvarint(len(answer.StateIds))
+		for _, stateId := range answer.StateIds {
+			writeString(stateId)
+		}
+	}
+	return base64.RawURLEncoding.EncodeToString(b.Bytes())
+}
+
+// DecodeShareToken returns a session holding the rank and answers of a
+// token of any version, which still need to be checked against the dataset.
+func DecodeShareToken(token string) (*Session, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(data) == 0 {
+		return nil, ErrInvalidShareToken
+	}
+	decode, ok := shareTokenDecoders[data[0]]
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidShareToken, data[0])
+	}
+	return decode(bytes.NewReader(data[1:]))
+}
+
+// decodeShareTokenV1 reads the length-prefixed strings of the first version
+// of share tokens.
+func decodeShareTokenV1(r *bytes.Reader) (*Session, error) {
+	var err error
+	readUvarint := func() int {
+		n, e := binary.ReadUvarint(r)
+		if e != nil || n > uint64(r.Len()) {
+			err = ErrInvalidShareToken
+			return 0
+		}
+		return int(n)
+	}
+	readString := func() string {
+		n := readUvarint()
+		if err != nil {
+			return ""
+		}
+		s := make([]byte, n)
+		r.Read(s)
+		return string(s)
+	}
+	session := &Session{Answers: []Answer{}}
+	session.Rank = readString()
+	count := readUvarint()
+	for i := 0; i < count && err == nil; i++ {
+		answer := Answer{CharacterId: readString(), StateIds: []string{}}
+		states := readUvarint()
+		for j := 0; j < states && err == nil; j++ {
+			answer.StateIds = append(answer.StateIds, readString())
+		}
+		session.Answers = append(session.Answers, answer)
+	}
+	if err != nil || r.Len() > 0 {
+		return nil, ErrInvalidShareToken
+	}
+	return session, nil
+}
+
+// shareURL returns the absolute link restoring the answers of a session.
+func shareURL(r *http.Request, token string) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	u := url.URL{Scheme: scheme, Host: r.Host, Path: "/identify", RawQuery: url.Values{"s": {token}}.Encode()}
+	return u.String()
+}
+
+// SharedTemplateData is the page offering to open the answers of a link.
+type SharedTemplateData struct {
+	Page
+	Token  string
+	Shared *Session
+}
+
+// showShared shows the answers of a share token without starting an
+// identification, which only a person confirming on the page does: links
+// are followed by crawlers and link previews as well.
+func (h *Handler) showShared(w http.ResponseWriter, r *http.Request, token string) {
+	shared, err := DecodeShareToken(token)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.render(w, r, "shared", SharedTemplateData{Page: h.page(r), Token: token, Shared: shared})
+}
+
+// restoreShared starts an identification for the browser with the answers
+// of a share token.
+func (h *Handler) restoreShared(w http.ResponseWriter, r *http.Request, token string) {
+	shared, err := DecodeShareToken(token)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cookie, _ := h.store.Get(r, "identification")
+	owner, err := h.sessionOwner(r, cookie)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	session, err := h.service.NewSession(owner)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := h.service.Restore(session, shared); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cookie.Values["session"] = session.Id
+	if err := cookie.Save(r, w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/identify", http.StatusSeeOther)
+}
+
+// ShareQRFunc draws the QR code of the link of the s parameter, to open an
+// identification on a field device.
+func (h *Handler) ShareQRFunc(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("s")
+	if _, err := DecodeShareToken(token); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	code, err := qrcode.Encode([]byte(shareURL(r, token)), qrcode.Medium)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var b bytes.Buffer
+	if err := png.Encode(&b, code.Image(4)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Write(b.Bytes())
+}
@@ -0,0 +1,47 @@
+package identification
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestShareTokenRoundTrip(t *testing.T) {
+	session := &Session{Rank: "species", Answers: []Answer{
+		{CharacterId: "c1", StateIds: []string{"s1", "s2"}},
+		{CharacterId: "c2", StateIds: []string{}},
+	}}
+	decoded, err := DecodeShareToken(EncodeShareToken(session))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Rank != session.Rank || !reflect.DeepEqual(decoded.Answers, session.Answers) {
+		t.Errorf("Expected %+v, got %+v.", session, decoded)
+	}
+	for _, token := range []string{"", "!", "AgA", "AQ", "AQFzAAA"} {
+		if _, err := DecodeShareToken(token); !errors.Is(err, ErrInvalidShareToken) {
+			t.Errorf("DecodeShareToken(%q): expected an invalid token, got %v.", token, err)
+		}
+	}
+}
+
+func TestSharedLinkNeedsConfirming(t *testing.T) {
+	h := NewHandler(newTestRegistry(t, serviceFixture), "test-session-key", time.Hour)
+	token := EncodeShareToken(&Session{Answers: []Answer{{CharacterId: "c1", StateIds: []string{"s1"}}}})
+	request := func(method string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/identify?s="+token, nil)
+		w := httptest.NewRecorder()
+		h.Func(w, r)
+		return w
+	}
+	// Following the link only shows what it holds.
+	if w := request("GET"); w.Code != http.StatusOK || len(w.Result().Cookies()) != 0 {
+		t.Errorf("Expected the page without a new identification, got %d.", w.Code)
+	}
+	if w := request("POST"); w.Code != http.StatusSeeOther || len(w.Result().Cookies()) != 1 {
+		t.Errorf("Expected a new identification, got %d.", w.Code)
+	}
+}
@@ -0,0 +1,175 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"nicolas.galipot.net/taxonomia/dataset"
+	"nicolas.galipot.net/taxonomia/dataset/database"
+)
+
+// Kinds of the jobs of the server.
+const (
+	KindCacheImages = "cache-images"
+	KindReindex     = "reindex"
+	KindImport      = "import"
+	KindKey         = "key"
+)
+
+// CacheImages downloads the pictures of the dataset into the image cache.
+// The job fails when some pictures could not be cached, its retries
+// downloading only those.
+func CacheImages(reg *database.DatasetRegistry, opts database.CacheOptions) *Kind {
+	return &Kind{
+		Name:        KindCacheImages,
+		MaxAttempts: 3,
+		Run: func(ctx context.Context, job *database.Job, progress Progress) error {
+			opts := opts
+			opts.Progress = func(done int, total int) { progress("downloading", done, total) }
+			report, err := reg.CacheImages(ctx, opts)
+			if err != nil {
+				return err
+			}
+			if len(report.Failures) > 0 {
+				first := report.Failures[0]
+				return fmt.Errorf("%d of %d pictures could not be cached, such as %s: %v",
+					len(report.Failures), report.Total, first.URL, first.Err)
+			}
+			return nil
+		},
+	}
+}
+
+// Reindex rebuilds the search index of the dataset.
+func Reindex(reg *database.DatasetRegistry) *Kind {
+	return &Kind{
+		Name:        KindReindex,
+		MaxAttempts: 2,
+		Exclusive:   true,
+		Run: func(ctx context.Context, job *database.Job, progress Progress) error {
+			progress(database.ImportStepIndex, 0, 0)
+			return reg.RebuildSearchIndex()
+		},
+	}
+}
+
+// ImportDataset imports the file of a dataset import, whose id is the
+// params of the job, then calls onImport.
+func ImportDataset(reg *database.DatasetRegistry, onImport func()) *Kind {
+	return &Kind{
+		Name:        KindImport,
+		MaxAttempts: 1,
+		Exclusive:   true,
+		Run: func(ctx context.Context, job *database.Job, progress Progress) error {
+			id, err := strconv.ParseInt(job.Params, 10, 64)
+			if err != nil {
+				return fmt.Errorf("wrong import id %q", job.Params)
+			}
+			imp, err := reg.GetDatasetImport(id)
+			if err != nil {
+				return err
+			} else if imp == nil {
+				return fmt.Errorf("no import %d", id)
+			}
+			data, err := reg.GetDatasetImportData(id)
+			if err != nil {
+				return err
+			} else if len(data) == 0 {
+				return fmt.Errorf("the file of import %d was already imported or cancelled", id)
+			}
+			ds, err := dataset.ReadFormat(imp.Format, bytes.NewReader(data))
+			if err != nil {
+				return err
+			}
+			ds.Id = imp.DatasetId
+			if err := reg.InsertDatasetWithProgress(ctx, ds, database.ImportProgress(progress)); err != nil {
+				return err
+			}
+			if err := reg.ClearDatasetImportData(id); err != nil {
+				return err
+			}
+			if onImport != nil {
+				onImport()
+			}
+			return nil
+		},
+	}
+}
+
+// GenerateKey builds a single-access identification key of the taxons
+// without subtaxons, from the characters with states, and stores it with the
+// version of the dataset it was built from.
+func GenerateKey(reg *database.DatasetRegistry) *Kind {
+	return &Kind{
+		Name:        KindKey,
+		MaxAttempts: 2,
+		Run: func(ctx context.Context, job *database.Job, progress Progress) error {
+			info, err := reg.GetDatasetInfo()
+			if err != nil {
+				return err
+			}
+			progress("reading", 0, 0)
+			taxonTree, err := reg.GetTaxonTree()
+			if err != nil {
+				return err
+			}
+			coded, err := reg.GetCodedStates()
+			if err != nil {
+				return err
+			}
+			var taxons []*dataset.Taxon
+			walkLeaves(taxonTree, func(node *dataset.Hierarchy) {
+				taxon := dataset.NewTaxon(node)
+				for stateId := range coded[node.Id] {
+					taxon.States = append(taxon.States, &dataset.State{Id: stateId})
+				}
+				taxons = append(taxons, taxon)
+			})
+			characterTree, err := reg.GetCharacterTree()
+			if err != nil {
+				return err
+			}
+			var characterIds []string
+			walkLeaves(characterTree, func(node *dataset.Hierarchy) {
+				characterIds = append(characterIds, node.Id)
+			})
+			var characters []*dataset.Character
+			for i, id := range characterIds {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				progress("reading", i, len(characterIds))
+				character, err := reg.GetCharacter(id)
+				if err != nil {
+					return err
+				} else if character != nil && len(character.States) > 0 {
+					characters = append(characters, character)
+				}
+			}
+			progress("building", 0, 0)
+			var content strings.Builder
+			if err := dataset.WriteKey(&content, dataset.NewKey(taxons, characters)); err != nil {
+				return err
+			}
+			key := &database.IdentificationKey{JobId: job.Id, Content: content.String()}
+			if info != nil {
+				key.Version = info.Version
+			}
+			return reg.SaveIdentificationKey(key)
+		},
+	}
+}
+
+// walkLeaves calls f on the items of a tree without children.
+func walkLeaves(nodes []*dataset.Hierarchy, f func(node *dataset.Hierarchy)) {
+	for _, node := range nodes {
+		if len(node.Children) == 0 {
+			f(node)
+		} else {
+			walkLeaves(node.Children, f)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package jobs
+
+import (
+	"strings"
+	"testing"
+
+	"nicolas.galipot.net/taxonomia/dataset"
+	"nicolas.galipot.net/taxonomia/dataset/database"
+)
+
+const keyFixture = `{
+	"id": "ds",
+	"taxons": [
+		{ "id": "g1", "name": "Quercus", "children": ["t1", "t2", "t3"] },
+		{ "id": "t1", "name": "Quercus robur", "descriptions": [ { "descriptorId": "c1", "statesIds": ["s1"] }, { "descriptorId": "c2", "statesIds": ["s4"] } ] },
+		{ "id": "t2", "name": "Quercus petraea", "descriptions": [ { "descriptorId": "c1", "statesIds": ["s1"] }, { "descriptorId": "c2", "statesIds": ["s3"] } ] },
+		{ "id": "t3", "name": "Quercus ilex", "descriptions": [ { "descriptorId": "c1", "statesIds": ["s2"] } ] }
+	],
+	"characters": [
+		{ "id": "c1", "name": "Leaf", "states": ["s1", "s2"] },
+		{ "id": "c2", "name": "Acorn", "states": ["s3", "s4"] }
+	],
+	"states": [
+		{ "id": "s1", "name": "lobed" },
+		{ "id": "s2", "name": "entire" },
+		{ "id": "s3", "name": "stalkless" },
+		{ "id": "s4", "name": "stalked" }
+	]
+}`
+
+func TestGenerateKey(t *testing.T) {
+	reg := newTestRegistry(t)
+	ds, err := dataset.ReadHazo(strings.NewReader(keyFixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reg.InsertDataset(ds); err != nil {
+		t.Fatal(err)
+	}
+	runner := NewRunner(reg, 1)
+	runner.Register(GenerateKey(reg))
+	job, err := runner.Submit(KindKey, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runner.Wait()
+	if job, err := reg.GetJob(job.Id); err != nil || job.Status != database.JobDone {
+		t.Fatalf("Expected the key job to be done, got %+v and %v.", job, err)
+	}
+	key, err := reg.GetLastIdentificationKey()
+	if err != nil || key == nil {
+		t.Fatalf("Expected the key to be stored, got %v.", err)
+	}
+	// The genus is left out of the key.
+	expected := "1. Leaf\n" +
+		"   lobed: 2\n" +
+		"   entire: Quercus ilex\n" +
+		"2. Acorn\n" +
+		"   stalkless: Quercus petraea\n" +
+		"   stalked: Quercus robur\n"
+	if key.Content != expected {
+		t.Errorf("Expected the key:\n%s\ngot:\n%s", expected, key.Content)
+	}
+	if info, err := reg.GetDatasetInfo(); err != nil || key.JobId != job.Id || key.Version != info.Version {
+		t.Errorf("Expected the key of job %d at the dataset version, got %+v.", job.Id, key)
+	}
+}
@@ -0,0 +1,336 @@
+// Package jobs runs the long operations of the server in the background,
+// such as imports, keeping track of them in the Jobs table so that they can
+// be monitored, cancelled and retried.
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"nicolas.galipot.net/taxonomia/dataset/database"
+)
+
+var (
+	ErrUnknownKind   = errors.New("unknown job kind")
+	ErrNotCancelable = errors.New("this job already finished")
+	ErrNotRetryable  = errors.New("only failed or cancelled jobs can be retried")
+)
+
+// RetryDelay is how long a job waits before its first retry, the delay
+// doubling at each attempt.
+var RetryDelay = 30 * time.Second
+
+// Progress is told the step a job is at, and how many of its items are
+// done.
+type Progress func(step string, done int, total int)
+
+// Kind is a kind of jobs, run by Run. Run returns the error of ctx when it
+// is cancelled. A failing job is run up to MaxAttempts times, and an
+// exclusive job runs alone as it writes the whole dataset.
+type Kind struct {
+	Name        string
+	Run         func(ctx context.Context, job *database.Job, progress Progress) error
+	MaxAttempts int
+	Exclusive   bool
+}
+
+// running is a job being run, with the progress of its attempt.
+type running struct {
+	job    database.Job
+	kind   *Kind
+	cancel context.CancelFunc
+}
+
+// Runner runs the pending jobs in the order they were created, with up to
+// a number of workers and never two jobs of a kind at once. The progress of
+// running jobs is kept in memory, as the database may be locked by their
+// transactions, and stored when they finish. The mutex guards the memory
+// only, the database being queried without it.
+type Runner struct {
+	reg     *database.DatasetRegistry
+	workers int
+	kinds   map[string]*Kind
+	mutex   sync.Mutex
+	running map[int64]*running
+	timer   *time.Timer
+	// dispatching counts the dispatches started or waiting for another to
+	// end, which dispatchMutex lets run one at a time.
+	dispatching   int
+	dispatchMutex sync.Mutex
+	// idle is signalled when no job is running or about to.
+	idle *sync.Cond
+}
+
+func NewRunner(reg *database.DatasetRegistry, workers int) *Runner {
+	if workers < 1 {
+		workers = 1
+	}
+	rn := &Runner{
+		reg:     reg,
+		workers: workers,
+		kinds:   map[string]*Kind{},
+		running: map[int64]*running{},
+	}
+	rn.idle = sync.NewCond(&rn.mutex)
+	return rn
+}
+
+// Register adds kinds of jobs the runner can run.
+func (rn *Runner) Register(kinds ...*Kind) {
+	rn.mutex.Lock()
+	defer rn.mutex.Unlock()
+	for _, kind := range kinds {
+		rn.kinds[kind.Name] = kind
+	}
+}
+
+// Workers returns how many jobs can run at once.
+func (rn *Runner) Workers() int {
+	return rn.workers
+}
+
+// Start requeues the jobs interrupted by a restart of the server, then runs
+// the pending jobs.
+func (rn *Runner) Start() error {
+	if err := rn.reg.RequeueInterruptedJobs(); err != nil {
+		return err
+	}
+	rn.dispatch()
+	return nil
+}
+
+// Submit stores a job of a kind and runs it as soon as a worker is free.
+func (rn *Runner) Submit(kindName string, params string, userId int64) (*database.Job, error) {
+	rn.mutex.Lock()
+	kind, ok := rn.kinds[kindName]
+	rn.mutex.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKind, kindName)
+	}
+	job := &database.Job{Kind: kindName, Params: params, UserId: userId, MaxAttempts: kind.MaxAttempts}
+	if err := rn.reg.CreateJob(job); err != nil {
+		return nil, err
+	}
+	rn.dispatch()
+	return job, nil
+}
+
+// Cancel cancels a pending job, or stops a running one.
+func (rn *Runner) Cancel(id int64) error {
+	if rn.stop(id) {
+		return nil
+	}
+	ok, err := rn.reg.CancelJob(id)
+	if err == nil && !ok {
+		// The job may have been started meanwhile.
+		if rn.stop(id) {
+			return nil
+		}
+		err = ErrNotCancelable
+	}
+	return err
+}
+
+// stop cancels the context of a running job, telling if it is running.
+func (rn *Runner) stop(id int64) bool {
+	rn.mutex.Lock()
+	defer rn.mutex.Unlock()
+	r, ok := rn.running[id]
+	if ok {
+		r.cancel()
+	}
+	return ok
+}
+
+// Retry runs a failed or cancelled job again.
+func (rn *Runner) Retry(id int64) error {
+	ok, err := rn.reg.RetryJob(id)
+	if err != nil {
+		return err
+	} else if !ok {
+		return ErrNotRetryable
+	}
+	rn.dispatch()
+	return nil
+}
+
+// Running returns a running job with its progress.
+func (rn *Runner) Running(id int64) (database.Job, bool) {
+	rn.mutex.Lock()
+	defer rn.mutex.Unlock()
+	if r, ok := rn.running[id]; ok {
+		return r.job, true
+	}
+	return database.Job{}, false
+}
+
+// Wait blocks until no job is running, the jobs waiting for a retry being
+// left pending.
+func (rn *Runner) Wait() {
+	rn.mutex.Lock()
+	defer rn.mutex.Unlock()
+	for len(rn.running) > 0 || rn.dispatching > 0 {
+		rn.idle.Wait()
+	}
+}
+
+// Progress updates jobs with the progress of the running ones.
+func (rn *Runner) Progress(jobs ...*database.Job) {
+	for _, job := range jobs {
+		if running, ok := rn.Running(job.Id); ok {
+			*job = running
+		}
+	}
+}
+
+// dispatch starts pending jobs while workers are free. It is called
+// without the mutex.
+func (rn *Runner) dispatch() {
+	rn.mutex.Lock()
+	rn.dispatching++
+	rn.mutex.Unlock()
+	rn.startPending()
+}
+
+// startPending starts pending jobs for a dispatch counted in dispatching.
+// Jobs being only started here, one dispatch at a time, the running jobs
+// can only finish while it queries the database, and the jobs left pending
+// are started by the dispatch of the jobs finishing.
+func (rn *Runner) startPending() {
+	rn.dispatchMutex.Lock()
+	defer rn.dispatchMutex.Unlock()
+	defer func() {
+		rn.mutex.Lock()
+		defer rn.mutex.Unlock()
+		rn.dispatching--
+		if len(rn.running) == 0 && rn.dispatching == 0 {
+			rn.idle.Broadcast()
+		}
+	}()
+	for {
+		busyKinds, count, exclusive := rn.busy()
+		if exclusive || count >= rn.workers {
+			return
+		}
+		job, err := rn.reg.NextPendingJob(busyKinds)
+		if err != nil {
+			log.Printf("cannot find the next job: %q", err.Error())
+			return
+		} else if job == nil {
+			return
+		}
+		if wait := time.Until(job.RunAfter); wait > 0 {
+			rn.wakeAfter(wait)
+			return
+		}
+		rn.mutex.Lock()
+		kind, ok := rn.kinds[job.Kind]
+		rn.mutex.Unlock()
+		if !ok {
+			job.Status, job.Error = database.JobFailed, fmt.Sprintf("%s %q", ErrUnknownKind, job.Kind)
+			if err := rn.reg.FinishJob(job); err != nil {
+				log.Printf("cannot fail job %d: %q", job.Id, err.Error())
+				return
+			}
+			continue
+		}
+		if kind.Exclusive && count > 0 {
+			return
+		}
+		// The job is running before it is started, so that cancelling it
+		// meanwhile stops it.
+		job.Status = database.JobRunning
+		job.Attempts++
+		ctx, cancel := context.WithCancel(context.Background())
+		r := &running{job: *job, kind: kind, cancel: cancel}
+		rn.mutex.Lock()
+		rn.running[job.Id] = r
+		rn.mutex.Unlock()
+		ok, err = rn.reg.StartJob(job.Id)
+		if err != nil || !ok {
+			cancel()
+			rn.mutex.Lock()
+			delete(rn.running, job.Id)
+			rn.mutex.Unlock()
+		}
+		if err != nil {
+			log.Printf("cannot start job %d: %q", job.Id, err.Error())
+			return
+		} else if !ok {
+			continue
+		}
+		go rn.run(ctx, r)
+	}
+}
+
+// busy returns the kinds of the running jobs, how many run, and if one of
+// them is exclusive.
+func (rn *Runner) busy() (kinds []string, count int, exclusive bool) {
+	rn.mutex.Lock()
+	defer rn.mutex.Unlock()
+	for _, r := range rn.running {
+		kinds = append(kinds, r.kind.Name)
+		exclusive = exclusive || r.kind.Exclusive
+	}
+	return kinds, len(rn.running), exclusive
+}
+
+// wakeAfter dispatches the pending jobs again after a delay, for a retry.
+func (rn *Runner) wakeAfter(delay time.Duration) {
+	rn.mutex.Lock()
+	defer rn.mutex.Unlock()
+	if rn.timer != nil {
+		rn.timer.Stop()
+	}
+	rn.timer = time.AfterFunc(delay, rn.dispatch)
+}
+
+func (rn *Runner) run(ctx context.Context, r *running) {
+	err := rn.attempt(ctx, r)
+	rn.mutex.Lock()
+	job := r.job
+	rn.mutex.Unlock()
+	switch {
+	case err == nil:
+		job.Status, job.Error = database.JobDone, ""
+	case ctx.Err() != nil:
+		job.Status, job.Error = database.JobCancelled, "cancelled"
+	case job.Attempts < job.MaxAttempts:
+		job.Status, job.Error = database.JobPending, err.Error()
+		job.RunAfter = time.Now().Add(RetryDelay << (job.Attempts - 1))
+	default:
+		job.Status, job.Error = database.JobFailed, err.Error()
+	}
+	// The job stays running until it is saved, so that its status never
+	// goes back.
+	if err := rn.reg.FinishJob(&job); err != nil {
+		log.Printf("cannot save the end of job %d: %q", job.Id, err.Error())
+	}
+	r.cancel()
+	// The dispatch is counted along with the end of the job, so that Wait
+	// does not return before the next job starts.
+	rn.mutex.Lock()
+	delete(rn.running, job.Id)
+	rn.dispatching++
+	rn.mutex.Unlock()
+	rn.startPending()
+}
+
+// attempt runs a job once, a panic making it fail.
+func (rn *Runner) attempt(ctx context.Context, r *running) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("job panicked: %v", p)
+		}
+	}()
+	job := r.job
+	return r.kind.Run(ctx, &job, func(step string, done int, total int) {
+		rn.mutex.Lock()
+		r.job.Step, r.job.Done, r.job.Total = step, done, total
+		rn.mutex.Unlock()
+	})
+}
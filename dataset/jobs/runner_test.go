@@ -0,0 +1,188 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"nicolas.galipot.net/taxonomia/dataset/database"
+)
+
+func newTestRegistry(t *testing.T) *database.DatasetRegistry {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "db.sq3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := database.CreateTables(db); err != nil {
+		t.Fatal(err)
+	}
+	return database.NewRegistry(db)
+}
+
+func waitForJob(t *testing.T, reg *database.DatasetRegistry, id int64) *database.Job {
+	for i := 0; i < 200; i++ {
+		job, err := reg.GetJob(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Finished() {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Job %d did not finish.", id)
+	return nil
+}
+
+func TestRunnerRetriesFailingJobs(t *testing.T) {
+	defer func(delay time.Duration) { RetryDelay = delay }(RetryDelay)
+	RetryDelay = 10 * time.Millisecond
+	reg := newTestRegistry(t)
+	runner := NewRunner(reg, 2)
+	var runs int32
+	runner.Register(&Kind{
+		Name:        "flaky",
+		MaxAttempts: 3,
+		Run: func(ctx context.Context, job *database.Job, progress Progress) error {
+			progress("counting", 1, 2)
+			if atomic.AddInt32(&runs, 1) < 2 {
+				return errors.New("unavailable")
+			}
+			return nil
+		},
+	}, &Kind{
+		Name: "broken",
+		Run: func(ctx context.Context, job *database.Job, progress Progress) error {
+			panic("broken")
+		},
+	})
+	flaky, err := runner.Submit("flaky", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job := waitForJob(t, reg, flaky.Id); job.Status != database.JobDone || job.Attempts != 2 || job.Done != 1 {
+		t.Errorf("Expected the flaky job done at the second attempt, got %s after %d attempts, %d done.",
+			job.Status, job.Attempts, job.Done)
+	}
+	broken, err := runner.Submit("broken", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job := waitForJob(t, reg, broken.Id); job.Status != database.JobFailed || job.Error != "job panicked: broken" {
+		t.Errorf("Expected the broken job to fail, got %s: %q.", job.Status, job.Error)
+	}
+	if err := runner.Retry(flaky.Id); err != ErrNotRetryable {
+		t.Errorf("Expected a done job not to be retried, got %v.", err)
+	}
+	if err := runner.Retry(broken.Id); err != nil {
+		t.Fatal(err)
+	}
+	if job := waitForJob(t, reg, broken.Id); job.Status != database.JobFailed || job.Attempts != 1 {
+		t.Errorf("Expected the broken job to fail again once, got %s after %d attempts.", job.Status, job.Attempts)
+	}
+	if _, err := runner.Submit("missing", "", 0); !errors.Is(err, ErrUnknownKind) {
+		t.Errorf("Expected an unknown kind error, got %v.", err)
+	}
+}
+
+func TestRunnerCancelsJobs(t *testing.T) {
+	reg := newTestRegistry(t)
+	runner := NewRunner(reg, 1)
+	started := make(chan bool)
+	runner.Register(&Kind{
+		Name: "blocking",
+		Run: func(ctx context.Context, job *database.Job, progress Progress) error {
+			started <- true
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	})
+	running, err := runner.Submit("blocking", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	// The only worker is busy, and jobs of a kind never run at once.
+	pending, err := runner.Submit("blocking", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runner.Cancel(pending.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err := runner.Cancel(running.Id); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []int64{running.Id, pending.Id} {
+		if job := waitForJob(t, reg, id); job.Status != database.JobCancelled {
+			t.Errorf("Expected job %d to be cancelled, got %s.", id, job.Status)
+		}
+	}
+	if err := runner.Cancel(running.Id); err != ErrNotCancelable {
+		t.Errorf("Expected a cancelled job not to be cancelled again, got %v.", err)
+	}
+}
+
+func TestRunnerRequeuesInterruptedJobs(t *testing.T) {
+	reg := newTestRegistry(t)
+	job := &database.Job{Kind: "count", MaxAttempts: 2}
+	if err := reg.CreateJob(job); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reg.StartJob(job.Id); err != nil {
+		t.Fatal(err)
+	}
+	runner := NewRunner(reg, 1)
+	runner.Register(&Kind{
+		Name: "count",
+		Run: func(ctx context.Context, job *database.Job, progress Progress) error {
+			return nil
+		},
+	})
+	if err := runner.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if job := waitForJob(t, reg, job.Id); job.Status != database.JobDone || job.Attempts != 2 {
+		t.Errorf("Expected the interrupted job to be done at the second attempt, got %s after %d attempts.",
+			job.Status, job.Attempts)
+	}
+}
+
+func TestRunnerWaitsForJobs(t *testing.T) {
+	reg := newTestRegistry(t)
+	runner := NewRunner(reg, 2)
+	release := make(chan bool)
+	runner.Register(&Kind{
+		Name: "slow",
+		Run: func(ctx context.Context, job *database.Job, progress Progress) error {
+			<-release
+			return nil
+		},
+	}, &Kind{
+		Name: "quick",
+		Run:  func(ctx context.Context, job *database.Job, progress Progress) error { return nil },
+	})
+	// Waiting without jobs returns at once.
+	runner.Wait()
+	var ids []int64
+	for _, kind := range []string{"slow", "quick", "slow"} {
+		job, err := runner.Submit(kind, "", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, job.Id)
+	}
+	go close(release)
+	runner.Wait()
+	for _, id := range ids {
+		if job, err := reg.GetJob(id); err != nil || job.Status != database.JobDone {
+			t.Errorf("Expected job %d to be done after waiting, got %+v and %v.", id, job, err)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package dataset
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+type JournalEntry struct {
+	Author string    `json:"author,omitempty"`
+	Time   time.Time `json:"time"`
+	Do     *Command  `json:"do"`
+	Undo   *Command  `json:"undo"`
+}
+
+// Journal applies commands to a dataset and keeps track of them so that they
+// can be undone, redone, saved and replayed onto another copy of the dataset.
+type Journal struct {
+	Author  string
+	ds      *Dataset
+	entries []*JournalEntry
+	applied int
+}
+
+func NewJournal(ds *Dataset, author string) *Journal {
+	return &Journal{Author: author, ds: ds}
+}
+
+func (j *Journal) Dataset() *Dataset {
+	return j.ds
+}
+
+func (j *Journal) record(cmd *Command, author string, at time.Time) error {
+	undo, err := j.ds.Apply(cmd)
+	if err != nil {
+		return err
+	}
+	j.entries = append(j.entries[:j.applied], &JournalEntry{Author: author, Time: at, Do: cmd, Undo: undo})
+	j.applied++
+	return nil
+}
+
+func (j *Journal) Do(cmd *Command) error {
+	return j.record(cmd, j.Author, time.Now().UTC())
+}
+
+func (j *Journal) CanUndo() bool {
+	return j.applied > 0
+}
+
+func (j *Journal) CanRedo() bool {
+	return j.applied < len(j.entries)
+}
+
+func (j *Journal) Undo() error {
+	if !j.CanUndo() {
+		return fmt.Errorf("nothing to undo")
+	}
+	entry := j.entries[j.applied-1]
+	if _, err := j.ds.Apply(entry.Undo); err != nil {
+		return fmt.Errorf("cannot undo %q: %s", entry.Do.Op, err.Error())
+	}
+	j.applied--
+	return nil
+}
+
+func (j *Journal) Redo() error {
+	if !j.CanRedo() {
+		return fmt.Errorf("nothing to redo")
+	}
+	entry := j.entries[j.applied]
+	undo, err := j.ds.Apply(entry.Do)
+	if err != nil {
+		return fmt.Errorf("cannot redo %q: %s", entry.Do.Op, err.Error())
+	}
+	entry.Undo = undo
+	j.applied++
+	return nil
+}
+
+// Entries returns the entries currently applied to the dataset, oldest first.
+func (j *Journal) Entries() []*JournalEntry {
+	return j.entries[:j.applied]
+}
+
+// Replay applies the commands of the entries in order, keeping their original
+// author and time. It stops at the first command that cannot be applied.
+func (j *Journal) Replay(entries []*JournalEntry) error {
+	for i, entry := range entries {
+		if err := j.record(entry.Do, entry.Author, entry.Time); err != nil {
+			return fmt.Errorf("cannot replay entry %d (%q): %s", i, entry.Do.Op, err.Error())
+		}
+	}
+	return nil
+}
+
+func WriteJournal(w io.Writer, j *Journal) error {
+	result, err := json.Marshal(j.Entries())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(result)
+	return err
+}
+
+func ReadJournal(r io.Reader) ([]*JournalEntry, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	entries := []*JournalEntry{}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	for i, entry := range entries {
+		if entry.Do == nil {
+			return nil, fmt.Errorf("journal entry %d has no command", i)
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,158 @@
+package dataset
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func journalFixtureCommands() []*Command {
+	return []*Command{
+		{Op: OpRename, Id: "t1", Text: "alpha"},
+		{Op: OpSetTranslation, Id: "c1", Lang: "EN", Text: "colour"},
+		{Op: OpSetDescription, Id: "s2", Text: "like the sky"},
+		{Op: OpSetAuthor, Id: "t2", Text: "L."},
+		{Op: OpSetExtraInfo, Id: "t4", Key: "z", Value: nil},
+		{Op: OpSetColor, Id: "s1", Text: "#ff0000"},
+		{Op: OpAddPicture, Id: "t3", Position: -1, Picture: &Picture{Source: "http://example.com/t3.png"}},
+		{Op: OpMoveTaxon, Id: "t2", ParentId: "t1", Position: 0},
+		{Op: OpAddState, Id: "c2", Position: 0, State: &State{Name: MultilangText{Scientific: "small"}}},
+		{Op: OpSetTaxonState, Id: "t2", StateId: "s2"},
+		{Op: OpSetDependencies, Id: "c3", RequiredStateIds: []string{"s1"}},
+		{Op: OpRemoveState, Id: "s1"},
+		{Op: OpDeleteCharacter, Id: "c2"},
+		{Op: OpDeleteTaxon, Id: "t1"},
+		{Op: OpInsertTaxons, Position: -1, Taxons: []*TaxonRecord{
+			{Id: "t10", Name: MultilangText{Scientific: "new"}},
+			{Id: "t11", ParentId: "t10", Name: MultilangText{Scientific: "newer"}, StateIds: []string{"s4"}},
+		}},
+		{Op: OpBatch, Commands: []*Command{
+			{Op: OpMoveCharacter, Id: "c3", Position: 0},
+			{Op: OpMoveState, Id: "s2", Position: 0},
+		}},
+	}
+}
+
+func TestJournalUndoRedo(t *testing.T) {
+	ds := readHazoString(t, hazoFixture)
+	initial := writeHazoString(t, ds, HazoOptions{SortKeys: true})
+	journal := NewJournal(ds, "tester")
+	snapshots := []string{initial}
+	for _, cmd := range journalFixtureCommands() {
+		if err := journal.Do(cmd); err != nil {
+			t.Logf("Unexpected error applying %q: %q.", cmd.Op, err.Error())
+			t.FailNow()
+		}
+		snapshots = append(snapshots, writeHazoString(t, ds, HazoOptions{SortKeys: true}))
+	}
+	if _, ok := ds.TaxonsById["t11"]; !ok || ds.ParentTaxonId("t11") != "t10" {
+		t.Logf("Expected inserted taxon 't11' below 't10'.")
+		t.Fail()
+	}
+	for i := len(snapshots) - 2; i >= 0; i-- {
+		if err := journal.Undo(); err != nil {
+			t.Logf("Unexpected error: %q.", err.Error())
+			t.FailNow()
+		}
+		if got := writeHazoString(t, ds, HazoOptions{SortKeys: true}); got != snapshots[i] {
+			t.Logf("Wrong dataset after undoing %d commands.\nexpected %s\ngot %s", len(snapshots)-1-i, snapshots[i], got)
+			t.FailNow()
+		}
+	}
+	if err := journal.Undo(); err == nil {
+		t.Logf("Expected an error when nothing is left to undo.")
+		t.Fail()
+	}
+	for i := 1; i < len(snapshots); i++ {
+		if err := journal.Redo(); err != nil {
+			t.Logf("Unexpected error: %q.", err.Error())
+			t.FailNow()
+		}
+		if got := writeHazoString(t, ds, HazoOptions{SortKeys: true}); got != snapshots[i] {
+			t.Logf("Wrong dataset after redoing %d commands.\nexpected %s\ngot %s", i, snapshots[i], got)
+			t.FailNow()
+		}
+	}
+	if journal.CanRedo() {
+		t.Logf("Expected nothing left to redo.")
+		t.Fail()
+	}
+}
+
+func TestJournalDoDiscardsRedo(t *testing.T) {
+	ds := readHazoString(t, hazoFixture)
+	journal := NewJournal(ds, "")
+	journal.Do(&Command{Op: OpRename, Id: "t1", Text: "x"})
+	journal.Undo()
+	journal.Do(&Command{Op: OpRename, Id: "t2", Text: "y"})
+	if journal.CanRedo() || len(journal.Entries()) != 1 {
+		t.Logf("Expected the undone command to be discarded, got %d entries.", len(journal.Entries()))
+		t.Fail()
+	}
+	if err := journal.Do(&Command{Op: OpRename, Id: "nope", Text: "z"}); err == nil || len(journal.Entries()) != 1 {
+		t.Logf("Expected a failing command to be rejected and not recorded.")
+		t.Fail()
+	}
+}
+
+func TestJournalReplay(t *testing.T) {
+	ds := readHazoString(t, hazoFixture)
+	journal := NewJournal(ds, "tester")
+	for _, cmd := range journalFixtureCommands() {
+		if err := journal.Do(cmd); err != nil {
+			t.Logf("Unexpected error applying %q: %q.", cmd.Op, err.Error())
+			t.FailNow()
+		}
+	}
+	var b bytes.Buffer
+	if err := WriteJournal(&b, journal); err != nil {
+		t.Logf("Unexpected error: %q.", err.Error())
+		t.FailNow()
+	}
+	entries, err := ReadJournal(&b)
+	if err != nil {
+		t.Logf("Unexpected error: %q.", err.Error())
+		t.FailNow()
+	}
+	other := readHazoString(t, hazoFixture)
+	replayed := NewJournal(other, "")
+	if err := replayed.Replay(entries); err != nil {
+		t.Logf("Unexpected error: %q.", err.Error())
+		t.FailNow()
+	}
+	expected := writeHazoString(t, ds, HazoOptions{SortKeys: true})
+	if got := writeHazoString(t, other, HazoOptions{SortKeys: true}); got != expected {
+		t.Logf("Replayed dataset differs.\nexpected %s\ngot %s", expected, got)
+		t.Fail()
+	}
+	for _, entry := range replayed.Entries() {
+		if entry.Author != "tester" {
+			t.Logf("Expected replayed entries to keep their author, got %q.", entry.Author)
+			t.FailNow()
+		}
+	}
+	for replayed.CanUndo() {
+		if err := replayed.Undo(); err != nil {
+			t.Logf("Unexpected error: %q.", err.Error())
+			t.FailNow()
+		}
+	}
+	initial := writeHazoString(t, readHazoString(t, hazoFixture), HazoOptions{SortKeys: true})
+	if got := writeHazoString(t, other, HazoOptions{SortKeys: true}); got != initial {
+		t.Logf("Undoing a replayed journal did not restore the dataset.\nexpected %s\ngot %s", initial, got)
+		t.Fail()
+	}
+}
+
+func TestCommandKeepsFirstPosition(t *testing.T) {
+	data, err := json.Marshal(&Command{Op: OpMoveTaxon, Id: "t2", Position: 0})
+	if err != nil {
+		t.Logf("Unexpected error: %q.", err.Error())
+		t.FailNow()
+	}
+	if !strings.Contains(string(data), `"position":0`) {
+		t.Logf("Expected the first position to be written, got %s.", data)
+		t.Fail()
+	}
+}
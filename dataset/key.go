@@ -0,0 +1,139 @@
+package dataset
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// KeyNode is a step of a single-access identification key. It either asks
+// for the state of a character, each branch leading to another step, or
+// ends at taxons, several when no character tells them apart.
+type KeyNode struct {
+	Character *Character
+	Branches  []*KeyBranch
+	Taxons    []*Taxon
+}
+
+// KeyBranch is the step reached by observing a state of a character.
+type KeyBranch struct {
+	State *State
+	Node  *KeyNode
+}
+
+// NewKey builds a single-access key telling taxons apart by the states they
+// are coded with. Each step asks for the character leaving the fewest
+// taxons on average. A taxon with several states of the character follows
+// each of their branches, and a taxon not coded for it follows all of them.
+func NewKey(taxons []*Taxon, characters []*Character) *KeyNode {
+	coded := make(map[string]map[string]bool, len(taxons))
+	for _, taxon := range taxons {
+		coded[taxon.Id] = map[string]bool{}
+		for _, state := range taxon.States {
+			coded[taxon.Id][state.Id] = true
+		}
+	}
+	return newKeyNode(coded, taxons, characters)
+}
+
+func newKeyNode(coded map[string]map[string]bool, taxons []*Taxon, characters []*Character) *KeyNode {
+	if len(taxons) < 2 {
+		return &KeyNode{Taxons: taxons}
+	}
+	var best *Character
+	var bestBranches [][]*Taxon
+	bestScore := 0
+	for _, ch := range characters {
+		branches, ok := keyBranches(coded, taxons, ch)
+		if !ok {
+			continue
+		}
+		score := 0
+		for _, branch := range branches {
+			score += len(branch) * len(branch)
+		}
+		if best == nil || score < bestScore {
+			best, bestBranches, bestScore = ch, branches, score
+		}
+	}
+	if best == nil {
+		return &KeyNode{Taxons: taxons}
+	}
+	remaining := make([]*Character, 0, len(characters)-1)
+	for _, ch := range characters {
+		if ch != best {
+			remaining = append(remaining, ch)
+		}
+	}
+	node := &KeyNode{Character: best}
+	for i, branch := range bestBranches {
+		if len(branch) > 0 {
+			node.Branches = append(node.Branches, &KeyBranch{State: &best.States[i], Node: newKeyNode(coded, branch, remaining)})
+		}
+	}
+	return node
+}
+
+// keyBranches returns the taxons following each state of a character, and
+// whether it helps: at least two branches, each with fewer taxons.
+func keyBranches(coded map[string]map[string]bool, taxons []*Taxon, ch *Character) ([][]*Taxon, bool) {
+	branches := make([][]*Taxon, len(ch.States))
+	for _, taxon := range taxons {
+		found := false
+		for i, state := range ch.States {
+			if coded[taxon.Id][state.Id] {
+				branches[i] = append(branches[i], taxon)
+				found = true
+			}
+		}
+		if !found {
+			for i := range branches {
+				branches[i] = append(branches[i], taxon)
+			}
+		}
+	}
+	count := 0
+	for _, branch := range branches {
+		if len(branch) == len(taxons) {
+			return nil, false
+		}
+		if len(branch) > 0 {
+			count++
+		}
+	}
+	return branches, count >= 2
+}
+
+// WriteKey writes a key as numbered steps, each state leading to the
+// number of another step or to the names of the taxons it ends at.
+func WriteKey(w io.Writer, key *KeyNode) error {
+	if key.Character == nil {
+		_, err := fmt.Fprintln(w, keyTaxonNames(key.Taxons))
+		return err
+	}
+	var b strings.Builder
+	steps := []*KeyNode{key}
+	for i := 0; i < len(steps); i++ {
+		node := steps[i]
+		fmt.Fprintf(&b, "%d. %s\n", i+1, node.Character.Name.Scientific)
+		for _, branch := range node.Branches {
+			to := keyTaxonNames(branch.Node.Taxons)
+			if branch.Node.Character != nil {
+				steps = append(steps, branch.Node)
+				to = strconv.Itoa(len(steps))
+			}
+			fmt.Fprintf(&b, "   %s: %s\n", branch.State.Name.Scientific, to)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func keyTaxonNames(taxons []*Taxon) string {
+	names := make([]string, len(taxons))
+	for i, taxon := range taxons {
+		names[i] = taxon.Name.Scientific
+	}
+	return strings.Join(names, ", ")
+}
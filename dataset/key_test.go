@@ -0,0 +1,60 @@
+package dataset
+
+import (
+	"strings"
+	"testing"
+)
+
+const keyFixture = `{
+	"id": "ds",
+	"taxons": [
+		{ "id": "t1", "name": "a", "descriptions": [ { "descriptorId": "c1", "statesIds": ["s1"] }, { "descriptorId": "c2", "statesIds": ["s3"] } ] },
+		{ "id": "t2", "name": "b", "descriptions": [ { "descriptorId": "c1", "statesIds": ["s1"] }, { "descriptorId": "c2", "statesIds": ["s4"] } ] },
+		{ "id": "t3", "name": "c", "descriptions": [ { "descriptorId": "c1", "statesIds": ["s2"] }, { "descriptorId": "c2", "statesIds": ["s3"] } ] },
+		{ "id": "t4", "name": "d", "descriptions": [ { "descriptorId": "c1", "statesIds": ["s2"] } ] }
+	],
+	"characters": [
+		{ "id": "c2", "name": "Bark", "states": ["s3", "s4"] },
+		{ "id": "c1", "name": "Leaf", "states": ["s1", "s2"] },
+		{ "id": "c3", "name": "Fruit", "states": ["s5", "s6"] }
+	],
+	"states": [
+		{ "id": "s1", "name": "lobed" },
+		{ "id": "s2", "name": "entire" },
+		{ "id": "s3", "name": "smooth" },
+		{ "id": "s4", "name": "fissured" },
+		{ "id": "s5", "name": "acorn" },
+		{ "id": "s6", "name": "nut" }
+	]
+}`
+
+func writeKeyString(t *testing.T, key *KeyNode) string {
+	var b strings.Builder
+	if err := WriteKey(&b, key); err != nil {
+		t.Logf("Unexpected error: %q.", err.Error())
+		t.FailNow()
+	}
+	return b.String()
+}
+
+func TestNewKey(t *testing.T) {
+	ds := readHazoString(t, keyFixture)
+	taxons := []*Taxon{ds.TaxonsById["t1"], ds.TaxonsById["t2"], ds.TaxonsById["t3"], ds.TaxonsById["t4"]}
+	characters := []*Character{ds.CharactersById["c2"], ds.CharactersById["c1"], ds.CharactersById["c3"]}
+	// Leaf splits the taxons in halves. Bark cannot tell c from d, which is
+	// not coded for it, and no taxon is coded for Fruit.
+	expected := "1. Leaf\n" +
+		"   lobed: 2\n" +
+		"   entire: c, d\n" +
+		"2. Bark\n" +
+		"   smooth: a\n" +
+		"   fissured: b\n"
+	if got := writeKeyString(t, NewKey(taxons, characters)); got != expected {
+		t.Logf("Wrong key, expected:\n%s\ngot:\n%s", expected, got)
+		t.Fail()
+	}
+	if got := writeKeyString(t, NewKey(taxons[:1], characters)); got != "a\n" {
+		t.Logf("A single taxon should end the key at once, got %q.", got)
+		t.Fail()
+	}
+}
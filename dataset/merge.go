@@ -0,0 +1,434 @@
+package dataset
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type Conflict struct {
+	Kind     string `json:"kind"`
+	Id       string `json:"id"`
+	Property string `json:"property,omitempty"`
+	Base     string `json:"base"`
+	Ours     string `json:"ours"`
+	Theirs   string `json:"theirs"`
+	Message  string `json:"message,omitempty"`
+}
+
+func (c *Conflict) String() string {
+	property := c.Property
+	if property == "" {
+		property = "existence"
+	}
+	s := fmt.Sprintf("%s %s %s: base %q, ours %q, theirs %q", c.Kind, c.Id, property, c.Base, c.Ours, c.Theirs)
+	if c.Message != "" {
+		s += " (" + c.Message + ")"
+	}
+	return s
+}
+
+type MergeResult struct {
+	Merged    *Dataset
+	Conflicts []*Conflict
+}
+
+const facetPresent = "present"
+
+// facetSet flattens a dataset into independent properties, so that the
+// changes of both sides of a merge can be compared property by property.
+type facetSet struct {
+	values map[string]string
+	byItem map[string][]string
+}
+
+func (fs *facetSet) set(key string, value string) {
+	fs.values[key] = value
+	parts := strings.SplitN(key, ":", 3)
+	item := parts[0] + ":" + parts[1]
+	fs.byItem[item] = append(fs.byItem[item], key)
+	if len(parts) == 3 && parts[0] == KindTaxon && strings.HasPrefix(parts[2], "state:") {
+		coding := "coding:" + strings.TrimPrefix(parts[2], "state:")
+		fs.byItem[coding] = append(fs.byItem[coding], key)
+	}
+}
+
+func jsonFacet(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
+
+func (fs *facetSet) setText(prefix string, name MultilangText, description string, pictures []Picture) {
+	fs.set(prefix+":name", name.Scientific)
+	for lang, text := range name.NamesByLangRef {
+		if text != "" {
+			fs.set(prefix+":name:"+lang, text)
+		}
+	}
+	if description != "" {
+		fs.set(prefix+":description", description)
+	}
+	if len(pictures) > 0 {
+		fs.set(prefix+":pictures", jsonFacet(pictures))
+	}
+}
+
+func newFacetSet(ds *Dataset) *facetSet {
+	fs := &facetSet{values: map[string]string{}, byItem: map[string][]string{}}
+	walkWithParent(ds.CharactersHierarchy, func(h *Hierarchy, parentId string, index int) {
+		prefix := KindCharacter + ":" + h.Id
+		fs.set(prefix, facetPresent)
+		fs.set(prefix+":parent", parentId)
+		fs.setText(prefix, h.Name, h.Description, h.Pictures)
+		ch, ok := ds.CharactersById[h.Id]
+		if !ok {
+			return
+		}
+		fs.set(prefix+":deps", jsonFacet(ds.dependenciesCommand(ch)))
+		for _, state := range ch.States {
+			statePrefix := KindState + ":" + state.Id
+			fs.set(statePrefix, ch.Id)
+			fs.setText(statePrefix, state.Name, state.Description, state.Pictures)
+			if state.Color != "" {
+				fs.set(statePrefix+":color", state.Color)
+			}
+		}
+	})
+	walkWithParent(ds.TaxonsHierarchy, func(h *Hierarchy, parentId string, index int) {
+		prefix := KindTaxon + ":" + h.Id
+		fs.set(prefix, facetPresent)
+		fs.set(prefix+":parent", parentId)
+		fs.setText(prefix, h.Name, h.Description, h.Pictures)
+		taxon, ok := ds.TaxonsById[h.Id]
+		if !ok {
+			return
+		}
+		if taxon.Author != "" {
+			fs.set(prefix+":author", taxon.Author)
+		}
+		if taxon.Rank != "" {
+			fs.set(prefix+":rank", taxon.Rank)
+		}
+		if len(taxon.References) > 0 {
+			fs.set(prefix+":references", jsonFacet(taxon.References))
+		}
+		if len(taxon.Synonyms) > 0 {
+			fs.set(prefix+":synonyms", jsonFacet(taxon.Synonyms))
+		}
+		for k, v := range taxon.ExtraInfo {
+			fs.set(prefix+":extra:"+k, jsonFacet(v))
+		}
+		for _, state := range taxon.States {
+			fs.set(prefix+":state:"+state.Id, facetPresent)
+		}
+	})
+	for _, book := range ds.Books {
+		fs.set(KindBook+":"+book.Id, jsonFacet(book))
+	}
+	return fs
+}
+
+func (fs *facetSet) itemKeys(kind string, id string, withCodings bool) []string {
+	keys := []string{}
+	for _, key := range fs.byItem[kind+":"+id] {
+		if withCodings || !strings.HasPrefix(key, kind+":"+id+":state:") {
+			keys = append(keys, key)
+		}
+	}
+	if kind == KindState && withCodings {
+		keys = append(keys, fs.byItem["coding:"+id]...)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type merger struct {
+	base, ours, theirs *Dataset
+	fb, fo, ft         *facetSet
+	result             *MergeResult
+	conflicted         map[string]bool
+}
+
+func (m *merger) itemKind(id string) string {
+	if _, ok := m.theirs.TaxonsById[id]; ok {
+		return KindTaxon
+	}
+	if _, ok := m.theirs.CharactersById[id]; ok {
+		return KindCharacter
+	}
+	if _, ok := m.base.TaxonsById[id]; ok {
+		return KindTaxon
+	}
+	if _, ok := m.base.CharactersById[id]; ok {
+		return KindCharacter
+	}
+	return KindState
+}
+
+func subtreeIds(root *Hierarchy, id string) []string {
+	parent, index := findParent(root, id)
+	if parent == nil {
+		return []string{id}
+	}
+	ids := []string{}
+	walkHierarchy(parent.Children[index], func(h *Hierarchy) {
+		ids = append(ids, h.Id)
+	})
+	return ids
+}
+
+// commandKeys lists the properties a command of the base to theirs patch
+// changes.
+func (m *merger) commandKeys(cmd *Command) []string {
+	switch cmd.Op {
+	case OpRename:
+		return []string{m.itemKind(cmd.Id) + ":" + cmd.Id + ":name"}
+	case OpSetTranslation:
+		return []string{m.itemKind(cmd.Id) + ":" + cmd.Id + ":name:" + cmd.Lang}
+	case OpSetDescription:
+		return []string{m.itemKind(cmd.Id) + ":" + cmd.Id + ":description"}
+	case OpAddPicture, OpUpdatePicture, OpRemovePicture:
+		return []string{m.itemKind(cmd.Id) + ":" + cmd.Id + ":pictures"}
+	case OpSetAuthor:
+		return []string{KindTaxon + ":" + cmd.Id + ":author"}
+	case OpSetRank:
+		return []string{KindTaxon + ":" + cmd.Id + ":rank"}
+	case OpSetReferences:
+		return []string{KindTaxon + ":" + cmd.Id + ":references"}
+	case OpAddBook, OpUpdateBook:
+		if cmd.Book == nil {
+			return nil
+		}
+		return []string{KindBook + ":" + cmd.Book.Id}
+	case OpRemoveBook:
+		return []string{KindBook + ":" + cmd.Id}
+	case OpSetSynonyms:
+		return []string{KindTaxon + ":" + cmd.Id + ":synonyms"}
+	case OpSetExtraInfo:
+		return []string{KindTaxon + ":" + cmd.Id + ":extra:" + cmd.Key}
+	case OpSetColor:
+		return []string{KindState + ":" + cmd.Id + ":color"}
+	case OpMoveTaxon:
+		return []string{KindTaxon + ":" + cmd.Id + ":parent"}
+	case OpMoveCharacter:
+		return []string{KindCharacter + ":" + cmd.Id + ":parent"}
+	case OpMoveState:
+		return []string{KindState + ":" + cmd.Id}
+	case OpSetDependencies:
+		return []string{KindCharacter + ":" + cmd.Id + ":deps"}
+	case OpInsertTaxons:
+		keys := []string{}
+		for _, record := range cmd.Taxons {
+			keys = append(keys, m.ft.itemKeys(KindTaxon, record.Id, false)...)
+		}
+		return keys
+	case OpInsertCharacters:
+		keys := []string{}
+		for _, record := range cmd.Characters {
+			keys = append(keys, m.ft.itemKeys(KindCharacter, record.Id, false)...)
+			for _, state := range record.States {
+				keys = append(keys, m.ft.itemKeys(KindState, state.Id, false)...)
+			}
+		}
+		return keys
+	case OpAddState:
+		if cmd.State == nil {
+			return nil
+		}
+		return m.ft.itemKeys(KindState, cmd.State.Id, false)
+	case OpRemoveState:
+		return m.fb.itemKeys(KindState, cmd.Id, true)
+	case OpDeleteTaxon:
+		keys := []string{}
+		for _, id := range subtreeIds(m.base.TaxonsHierarchy, cmd.Id) {
+			keys = append(keys, m.fb.itemKeys(KindTaxon, id, true)...)
+		}
+		return keys
+	case OpDeleteCharacter:
+		keys := []string{}
+		for _, id := range subtreeIds(m.base.CharactersHierarchy, cmd.Id) {
+			keys = append(keys, m.fb.itemKeys(KindCharacter, id, true)...)
+			if ch, ok := m.base.CharactersById[id]; ok {
+				for _, state := range ch.States {
+					keys = append(keys, m.fb.itemKeys(KindState, state.Id, true)...)
+				}
+			}
+		}
+		return keys
+	}
+	return nil
+}
+
+func (m *merger) conflict(key string, message string) {
+	if m.conflicted[key] {
+		return
+	}
+	m.conflicted[key] = true
+	parts := strings.SplitN(key, ":", 3)
+	c := &Conflict{
+		Kind:    parts[0],
+		Id:      parts[1],
+		Base:    m.fb.values[key],
+		Ours:    m.fo.values[key],
+		Theirs:  m.ft.values[key],
+		Message: message,
+	}
+	if len(parts) == 3 {
+		c.Property = parts[2]
+	}
+	m.result.Conflicts = append(m.result.Conflicts, c)
+}
+
+// newItemsBelow tells if ours added items to the subtree that theirs deleted.
+func (m *merger) newItemsBelow(root *Hierarchy, baseRoot *Hierarchy, id string) bool {
+	baseIds := map[string]bool{}
+	for _, baseId := range subtreeIds(baseRoot, id) {
+		baseIds[baseId] = true
+	}
+	for _, mergedId := range subtreeIds(root, id) {
+		if !baseIds[mergedId] {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *merger) conflictMessage(key string, isDeletion bool) string {
+	parts := strings.SplitN(key, ":", 3)
+	if isDeletion {
+		return "deleted by theirs, changed by ours"
+	}
+	if m.fo.values[parts[0]+":"+parts[1]] == "" {
+		return "deleted by ours, changed by theirs"
+	}
+	return "changed on both sides"
+}
+
+func (m *merger) mergeCommand(cmd *Command) {
+	if cmd.Op == OpSetTaxonStates {
+		return
+	}
+	changedByTheirs := []string{}
+	for _, key := range m.commandKeys(cmd) {
+		if m.fb.values[key] != m.ft.values[key] {
+			changedByTheirs = append(changedByTheirs, key)
+		}
+	}
+	isDeletion := cmd.Op == OpDeleteTaxon || cmd.Op == OpDeleteCharacter || cmd.Op == OpRemoveState
+	if len(changedByTheirs) == 0 && !isDeletion {
+		return
+	}
+	alreadyApplied := true
+	hasConflict := false
+	for _, key := range changedByTheirs {
+		ours, base, theirs := m.fo.values[key], m.fb.values[key], m.ft.values[key]
+		if ours != theirs {
+			alreadyApplied = false
+			if ours != base {
+				hasConflict = true
+				m.conflict(key, m.conflictMessage(key, isDeletion))
+			}
+		}
+	}
+	merged := m.result.Merged
+	if isDeletion {
+		kind := KindTaxon
+		root, baseRoot := merged.TaxonsHierarchy, m.base.TaxonsHierarchy
+		if cmd.Op == OpDeleteCharacter {
+			kind = KindCharacter
+			root, baseRoot = merged.CharactersHierarchy, m.base.CharactersHierarchy
+		}
+		if cmd.Op != OpRemoveState && m.newItemsBelow(root, baseRoot, cmd.Id) {
+			hasConflict = true
+			m.conflict(kind+":"+cmd.Id, "deleted by theirs while ours added items below it")
+		}
+		if _, state := merged.FindState(cmd.Id); cmd.Op == OpRemoveState && state == nil {
+			return
+		}
+		if cmd.Op != OpRemoveState {
+			if parent, _ := findParent(root, cmd.Id); parent == nil {
+				return
+			}
+		}
+		alreadyApplied = false
+	}
+	if hasConflict || alreadyApplied {
+		return
+	}
+	if _, err := merged.Apply(cmd); err != nil {
+		key := m.itemKind(cmd.Id) + ":" + cmd.Id
+		if len(changedByTheirs) > 0 {
+			key = changedByTheirs[0]
+		}
+		m.conflict(key, err.Error())
+	}
+}
+
+func (m *merger) mergeCodings() {
+	merged := m.result.Merged
+	taxonIds := make([]string, 0, len(merged.TaxonsById))
+	for id := range merged.TaxonsById {
+		taxonIds = append(taxonIds, id)
+	}
+	sort.Strings(taxonIds)
+	for _, id := range taxonIds {
+		theirs, ok := m.theirs.TaxonsById[id]
+		if !ok {
+			continue
+		}
+		var baseSet map[string]bool
+		if base, ok := m.base.TaxonsById[id]; ok {
+			baseSet = stateIdSet(base.States)
+		}
+		theirsSet := stateIdSet(theirs.States)
+		current := stateIdsOf(merged.TaxonsById[id].States)
+		stateIds := []string{}
+		kept := map[string]bool{}
+		for _, stateId := range current {
+			if baseSet[stateId] && !theirsSet[stateId] {
+				continue
+			}
+			kept[stateId] = true
+			stateIds = append(stateIds, stateId)
+		}
+		for _, stateId := range stateIdsOf(theirs.States) {
+			if _, state := merged.FindState(stateId); !kept[stateId] && !baseSet[stateId] && state != nil {
+				kept[stateId] = true
+				stateIds = append(stateIds, stateId)
+			}
+		}
+		if strings.Join(stateIds, ",") != strings.Join(current, ",") {
+			merged.SetTaxonStates(id, stateIds)
+		}
+	}
+}
+
+// Merge performs a three-way merge of two datasets derived from the same
+// base. Changes made on a single side are kept, changes made identically on
+// both sides are kept once, and properties changed differently on both sides
+// keep the value of ours and are reported as conflicts.
+func Merge(base *Dataset, ours *Dataset, theirs *Dataset) (*MergeResult, error) {
+	theirsDiff, err := Diff(base, theirs)
+	if err != nil {
+		return nil, err
+	}
+	m := &merger{
+		base:       base,
+		ours:       ours,
+		theirs:     theirs,
+		fb:         newFacetSet(base),
+		fo:         newFacetSet(ours),
+		ft:         newFacetSet(theirs),
+		result:     &MergeResult{Merged: ours.Clone(), Conflicts: []*Conflict{}},
+		conflicted: map[string]bool{},
+	}
+	for _, cmd := range theirsDiff.Patch.Commands {
+		m.mergeCommand(cmd)
+	}
+	m.mergeCodings()
+	return m.result, nil
+}
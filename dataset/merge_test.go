@@ -0,0 +1,101 @@
+package dataset
+
+import (
+	"testing"
+)
+
+func mergeFixture(t *testing.T) (*Dataset, *Dataset, *Dataset) {
+	base := readHazoString(t, hazoFixture)
+	return base, base.Clone(), base.Clone()
+}
+
+func conflictKeys(conflicts []*Conflict) map[string]bool {
+	keys := map[string]bool{}
+	for _, c := range conflicts {
+		keys[c.Kind+":"+c.Id+":"+c.Property] = true
+	}
+	return keys
+}
+
+func TestMergeWithoutConflicts(t *testing.T) {
+	base, ours, theirs := mergeFixture(t)
+	ours.Rename("t1", "alpha")
+	ours.SetTaxonState("t2", "s2")
+	ours.SetTranslation("c1", "EN", "colour")
+	theirs.MoveTaxon("t2", "t1", -1)
+	theirs.SetTaxonState("t2", "s3")
+	theirs.UnsetTaxonState("t1", "s1")
+	theirs.AddState("c2", State{Id: "s9", Name: MultilangText{Scientific: "huge"}}, -1)
+	theirs.DeleteTaxon("t4")
+	theirs.SetTranslation("c1", "EN", "colour")
+	theirs.InsertTaxon(NewTaxon(&Hierarchy{Id: "t7", Name: MultilangText{Scientific: "g"}}), "t3", -1)
+	result, err := Merge(base, ours, theirs)
+	if err != nil {
+		t.Logf("Unexpected error: %q.", err.Error())
+		t.FailNow()
+	}
+	if len(result.Conflicts) > 0 {
+		for _, c := range result.Conflicts {
+			t.Logf("Unexpected conflict: %s", c.String())
+		}
+		t.FailNow()
+	}
+	merged := result.Merged
+	if merged.TaxonsById["t1"].Name.Scientific != "alpha" {
+		t.Logf("Expected ours rename to be kept.")
+		t.Fail()
+	}
+	if merged.ParentTaxonId("t2") != "t1" || merged.ParentTaxonId("t7") != "t3" {
+		t.Logf("Expected theirs moves and insertions to be merged.")
+		t.Fail()
+	}
+	if _, ok := merged.TaxonsById["t4"]; ok {
+		t.Logf("Expected theirs deletion to be merged.")
+		t.Fail()
+	}
+	if !merged.TaxonHasState("t2", "s2") || !merged.TaxonHasState("t2", "s3") || merged.TaxonHasState("t1", "s1") || !merged.TaxonHasState("t1", "s3") {
+		t.Logf("Wrong merged codings: t1 %v, t2 %v.", stateIdsOf(merged.TaxonsById["t1"].States), stateIdsOf(merged.TaxonsById["t2"].States))
+		t.Fail()
+	}
+	if ch, state := merged.FindState("s9"); state == nil || ch.Id != "c2" {
+		t.Logf("Expected theirs new state to be merged.")
+		t.Fail()
+	}
+	if got := merged.CharactersById["c1"].Name.NamesByLangRef["EN"]; got != "colour" {
+		t.Logf("Wrong merged translation: %q.", got)
+		t.Fail()
+	}
+}
+
+func TestMergeConflicts(t *testing.T) {
+	base, ours, theirs := mergeFixture(t)
+	ours.Rename("t1", "alpha")
+	theirs.Rename("t1", "aleph")
+	ours.MoveTaxon("t2", "t1", -1)
+	theirs.MoveTaxon("t2", "t3", -1)
+	ours.Rename("t4", "delta")
+	theirs.DeleteTaxon("t4")
+	ours.DeleteCharacter("c2")
+	theirs.SetStateColor("s3", "#000000")
+	result, err := Merge(base, ours, theirs)
+	if err != nil {
+		t.Logf("Unexpected error: %q.", err.Error())
+		t.FailNow()
+	}
+	keys := conflictKeys(result.Conflicts)
+	for _, key := range []string{"taxon:t1:name", "taxon:t2:parent", "taxon:t4:name", "state:s3:color"} {
+		if !keys[key] {
+			t.Logf("Expected a conflict on %q, got %v.", key, keys)
+			t.Fail()
+		}
+	}
+	merged := result.Merged
+	if merged.TaxonsById["t1"].Name.Scientific != "alpha" || merged.ParentTaxonId("t2") != "t1" {
+		t.Logf("Expected ours values to be kept on conflicts.")
+		t.Fail()
+	}
+	if taxon, ok := merged.TaxonsById["t4"]; !ok || taxon.Name.Scientific != "delta" {
+		t.Logf("Expected the modified taxon to be kept when theirs deleted it.")
+		t.Fail()
+	}
+}
@@ -0,0 +1,381 @@
+// Package pdf writes simple PDF documents made of flowing paragraphs and rows
+// of pictures, using the standard Helvetica fonts so that nothing needs to be
+// embedded but the pictures.
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"io"
+	"strings"
+	"time"
+
+	_ "image/gif"
+	_ "image/png"
+)
+
+// Font is one of the standard fonts of the documents.
+type Font int
+
+const (
+	Regular Font = iota
+	Bold
+)
+
+var fontNames = [...]string{"Helvetica", "Helvetica-Bold"}
+
+// A4 page size and margins, in points.
+const (
+	PageWidth  = 595.0
+	PageHeight = 842.0
+	Margin     = 50.0
+)
+
+// maxPictureSide is the largest side of the pictures once embedded, in
+// pixels, keeping documents small.
+const maxPictureSide = 800
+
+type picture struct {
+	width, height int
+	data          []byte
+}
+
+type page struct {
+	content bytes.Buffer
+	// pictures are the indexes of the pictures drawn on the page.
+	pictures []int
+}
+
+// Document is a PDF document being laid out from the top of its first page.
+type Document struct {
+	Title    string
+	Created  time.Time
+	pages    []*page
+	pictures []*picture
+	y        float64
+}
+
+func New(title string) *Document {
+	d := &Document{Title: title, Created: time.Now()}
+	d.newPage()
+	return d
+}
+
+func (d *Document) newPage() {
+	d.pages = append(d.pages, &page{})
+	d.y = PageHeight - Margin
+}
+
+func (d *Document) currentPage() *page {
+	return d.pages[len(d.pages)-1]
+}
+
+// reserve makes room for a block of the given height, starting a new page
+// when the current one is full.
+func (d *Document) reserve(height float64) {
+	if d.y-height < Margin && d.y < PageHeight-Margin {
+		d.newPage()
+	}
+	d.y -= height
+}
+
+// Space leaves a vertical gap.
+func (d *Document) Space(height float64) {
+	d.y -= height
+	if d.y < Margin {
+		d.newPage()
+	}
+}
+
+// Paragraph writes a text, wrapped to the width of the page less indent.
+func (d *Document) Paragraph(font Font, size, indent float64, text string) {
+	for _, line := range wrap(text, font, size, PageWidth-2*Margin-indent) {
+		d.reserve(size * 1.3)
+		fmt.Fprintf(&d.currentPage().content, "BT /F%d %.1f Tf %.2f %.2f Td (%s) Tj ET\n",
+			font+1, size, Margin+indent, d.y+size*0.3, escape(encode(line)))
+	}
+}
+
+// Pictures draws a row of pictures of the given height, wrapping to other
+// rows when they are too wide. Pictures that cannot be decoded are left
+// out, and their number returned.
+func (d *Document) Pictures(sources [][]byte, height, indent float64) (skipped int) {
+	x := Margin + indent
+	rowStarted := false
+	for _, source := range sources {
+		pic, err := newPicture(source)
+		if err != nil {
+			skipped++
+			continue
+		}
+		width, h := height*float64(pic.width)/float64(pic.height), height
+		if max := PageWidth - 2*Margin - indent; width > max {
+			width, h = max, max*float64(pic.height)/float64(pic.width)
+		}
+		if !rowStarted || x+width > PageWidth-Margin {
+			d.reserve(h + 6)
+			x = Margin + indent
+			rowStarted = true
+		}
+		d.pictures = append(d.pictures, pic)
+		p := d.currentPage()
+		p.pictures = append(p.pictures, len(d.pictures)-1)
+		fmt.Fprintf(&p.content, "q %.2f 0 0 %.2f %.2f %.2f cm /Im%d Do Q\n", width, h, x, d.y+6, len(d.pictures))
+		x += width + 6
+	}
+	return skipped
+}
+
+// newPicture decodes an image and encodes it again as a JPEG, the format
+// PDF readers draw without other help.
+func newPicture(source []byte) (*picture, error) {
+	img, _, err := image.Decode(bytes.NewReader(source))
+	if err != nil {
+		return nil, err
+	}
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w == 0 || h == 0 {
+		return nil, fmt.Errorf("empty image")
+	}
+	scale := 1.0
+	if w > maxPictureSide || h > maxPictureSide {
+		if w > h {
+			scale = float64(maxPictureSide) / float64(w)
+		} else {
+			scale = float64(maxPictureSide) / float64(h)
+		}
+	}
+	sw, sh := int(float64(w)*scale), int(float64(h)*scale)
+	if sw < 1 {
+		sw = 1
+	}
+	if sh < 1 {
+		sh = 1
+	}
+	// Transparent parts are drawn over white, as on paper.
+	rgb := image.NewRGBA(image.Rect(0, 0, sw, sh))
+	draw.Draw(rgb, rgb.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	for y := 0; y < sh; y++ {
+		for x := 0; x < sw; x++ {
+			src := img.At(bounds.Min.X+int(float64(x)/scale), bounds.Min.Y+int(float64(y)/scale))
+			r, g, b, a := src.RGBA()
+			white := 0xFFFF - a
+			rgb.Set(x, y, color.RGBA64{uint16(r + white), uint16(g + white), uint16(b + white), 0xFFFF})
+		}
+	}
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, rgb, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, err
+	}
+	return &picture{width: sw, height: sh, data: b.Bytes()}, nil
+}
+
+// Helvetica widths of the printable ASCII characters, in thousandths of
+// the font size. Other characters are counted as wide as a digit.
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+// TextWidth returns the width of a text in points.
+func TextWidth(text string, font Font, size float64) float64 {
+	width := 0
+	for _, r := range text {
+		if r >= 32 && r < 127 {
+			width += helveticaWidths[r-32]
+		} else {
+			width += 556
+		}
+	}
+	if font == Bold {
+		// Bold glyphs are a little wider, this keeps wrapped lines inside
+		// the margins.
+		width += width / 16
+	}
+	return float64(width) * size / 1000
+}
+
+func wrap(text string, font Font, size, width float64) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(text, "\n") {
+		line := ""
+		for _, word := range strings.Fields(paragraph) {
+			candidate := word
+			if line != "" {
+				candidate = line + " " + word
+			}
+			if line != "" && TextWidth(candidate, font, size) > width {
+				lines = append(lines, line)
+				candidate = word
+			}
+			line = candidate
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// winAnsi holds the characters of the Windows-1252 encoding of the standard
+// fonts which are not at the same place in Unicode.
+var winAnsi = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87, 'ˆ': 0x88,
+	'‰': 0x89, 'Š': 0x8A, '‹': 0x8B, 'Œ': 0x8C, 'Ž': 0x8E, '‘': 0x91, '’': 0x92, '“': 0x93,
+	'”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '˜': 0x98, '™': 0x99, 'š': 0x9A, '›': 0x9B,
+	'œ': 0x9C, 'ž': 0x9E, 'Ÿ': 0x9F,
+}
+
+func encodeRune(r rune) (byte, bool) {
+	if r < 0x80 || (r >= 0xA0 && r <= 0xFF) {
+		return byte(r), true
+	}
+	b, ok := winAnsi[r]
+	return b, ok
+}
+
+// Encodable tells whether the standard fonts can draw every character of a
+// text, which is not the case of most scripts besides the Latin one.
+func Encodable(text string) bool {
+	for _, r := range text {
+		if _, ok := encodeRune(r); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func encode(text string) []byte {
+	var b []byte
+	for _, r := range text {
+		if c, ok := encodeRune(r); ok {
+			b = append(b, c)
+		} else {
+			b = append(b, '?')
+		}
+	}
+	return b
+}
+
+func escape(s []byte) string {
+	var b strings.Builder
+	for _, c := range s {
+		switch c {
+		case '\\', '(', ')':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\r', '\n':
+			b.WriteByte(' ')
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func textString(text string) string {
+	return "(" + escape(encode(text)) + ")"
+}
+
+type writer struct {
+	w       io.Writer
+	n       int64
+	err     error
+	offsets []int64
+}
+
+func (w *writer) printf(format string, args ...interface{}) {
+	if w.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(w.w, format, args...)
+	w.n += int64(n)
+	w.err = err
+}
+
+func (w *writer) write(data []byte) {
+	if w.err != nil {
+		return
+	}
+	n, err := w.w.Write(data)
+	w.n += int64(n)
+	w.err = err
+}
+
+// object starts the object of the given number, which must follow the
+// previous one.
+func (w *writer) object(number int) {
+	w.offsets = append(w.offsets, w.n)
+	w.printf("%d 0 obj\n", number)
+}
+
+func (w *writer) stream(dict string, data []byte) {
+	w.printf("<< %s /Length %d >>\nstream\n", dict, len(data))
+	w.write(data)
+	w.printf("\nendstream\nendobj\n")
+}
+
+func deflate(data []byte) []byte {
+	var b bytes.Buffer
+	z := zlib.NewWriter(&b)
+	z.Write(data)
+	z.Close()
+	return b.Bytes()
+}
+
+// WriteTo writes the document. Objects are numbered as follows: catalog,
+// pages, information, the two fonts, the pictures, then each page and its
+// content.
+func (d *Document) WriteTo(out io.Writer) (int64, error) {
+	w := &writer{w: out}
+	const firstPicture = 6
+	firstPage := firstPicture + len(d.pictures)
+	w.printf("%%PDF-1.4\n%%\xE2\xE3\xCF\xD3\n")
+	w.object(1)
+	w.printf("<< /Type /Catalog /Pages 2 0 R >>\nendobj\n")
+	w.object(2)
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", firstPage+2*i)
+	}
+	w.printf("<< /Type /Pages /Kids [%s] /Count %d >>\nendobj\n", strings.Join(kids, " "), len(d.pages))
+	w.object(3)
+	w.printf("<< /Title %s /Producer (Taxonomia) /CreationDate (D:%s) >>\nendobj\n",
+		textString(d.Title), d.Created.UTC().Format("20060102150405Z"))
+	for i, name := range fontNames {
+		w.object(4 + i)
+		w.printf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>\nendobj\n", name)
+	}
+	for i, pic := range d.pictures {
+		w.object(firstPicture + i)
+		w.stream(fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /DCTDecode",
+			pic.width, pic.height), pic.data)
+	}
+	for i, p := range d.pages {
+		number := firstPage + 2*i
+		var images strings.Builder
+		for _, index := range p.pictures {
+			fmt.Fprintf(&images, " /Im%d %d 0 R", index+1, firstPicture+index)
+		}
+		w.object(number)
+		w.printf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /F1 4 0 R /F2 5 0 R >> /XObject <<%s >> >> /Contents %d 0 R >>\nendobj\n",
+			PageWidth, PageHeight, images.String(), number+1)
+		w.object(number + 1)
+		w.stream("/Filter /FlateDecode", deflate(p.content.Bytes()))
+	}
+	xref := w.n
+	w.printf("xref\n0 %d\n0000000000 65535 f \n", len(w.offsets)+1)
+	for _, offset := range w.offsets {
+		w.printf("%010d 00000 n \n", offset)
+	}
+	w.printf("trailer\n<< /Size %d /Root 1 0 R /Info 3 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(w.offsets)+1, xref)
+	return w.n, w.err
+}
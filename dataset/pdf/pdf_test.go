@@ -0,0 +1,90 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func pngPicture(t *testing.T, w, h int) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.NRGBA{uint8(x), uint8(y), 0x80, 0xFF})
+		}
+	}
+	var b bytes.Buffer
+	if err := png.Encode(&b, img); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestWriteTo(t *testing.T) {
+	d := New("Report (draft)")
+	d.Paragraph(Bold, 16, 0, "Quercus robur")
+	for i := 0; i < 80; i++ {
+		d.Paragraph(Regular, 11, 20, "Leaf shape: lobed, with a long text wrapping over several lines of the page.")
+	}
+	if skipped := d.Pictures([][]byte{pngPicture(t, 40, 20), []byte("not a picture")}, 60, 20); skipped != 1 {
+		t.Errorf("Expected 1 skipped picture, got %d.", skipped)
+	}
+	var b bytes.Buffer
+	n, err := d.WriteTo(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := b.Bytes()
+	if int(n) != len(out) || !bytes.HasPrefix(out, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(out, []byte("%%EOF\n")) {
+		t.Fatalf("Malformed document of %d bytes.", n)
+	}
+	if len(d.pages) < 2 {
+		t.Errorf("Expected the paragraphs to flow on several pages, got %d.", len(d.pages))
+	}
+	// Every cross-reference entry must point to the start of its object.
+	start := bytes.LastIndex(out, []byte("startxref\n"))
+	xref, _ := strconv.Atoi(strings.Fields(string(out[start+len("startxref\n"):]))[0])
+	entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(out[xref:], -1)
+	if count := 5 + len(d.pictures) + 2*len(d.pages); len(entries) != count {
+		t.Fatalf("Expected %d objects, got %d.", count, len(entries))
+	}
+	for i, entry := range entries {
+		offset, _ := strconv.Atoi(string(entry[1]))
+		if prefix := fmt.Sprintf("%d 0 obj\n", i+1); !bytes.HasPrefix(out[offset:], []byte(prefix)) {
+			t.Errorf("Object %d is not at offset %d.", i+1, offset)
+		}
+	}
+	if !bytes.Contains(out, []byte(`/Title (Report \(draft\))`)) {
+		t.Error("Expected the title to be escaped in the information dictionary.")
+	}
+}
+
+func TestWrap(t *testing.T) {
+	lines := wrap("one two three four five six seven eight nine ten", Regular, 12, 100)
+	if len(lines) < 2 {
+		t.Fatalf("Expected several lines, got %q.", lines)
+	}
+	for _, line := range lines {
+		if TextWidth(line, Regular, 12) > 100 {
+			t.Errorf("Line %q is wider than 100 points.", line)
+		}
+	}
+}
+
+func TestEncodable(t *testing.T) {
+	if !Encodable("Chêne pédonculé – “rouvre”") {
+		t.Error("Expected Latin text to be encodable.")
+	}
+	if Encodable("栎属") {
+		t.Error("Expected Chinese text not to be encodable.")
+	}
+	if got := string(encode("é€栎")); got != "\xE9\x80?" {
+		t.Errorf("Expected Windows-1252 bytes, got %q.", got)
+	}
+}
@@ -0,0 +1,206 @@
+package qrcode
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestReedSolomonRemainder(t *testing.T) {
+	// HELLO WORLD at version 1-M, from the worked example of the standard.
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	expected := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	if got := reedSolomonRemainder(data, reedSolomonDivisor(10)); !bytes.Equal(got, expected) {
+		t.Errorf("Expected error correction %v, got %v.", expected, got)
+	}
+}
+
+func TestFormatAndVersionInformation(t *testing.T) {
+	formats := []struct {
+		level    Level
+		mask     int
+		expected int
+	}{
+		{Medium, 0, 0x5412},
+		{Low, 4, 0x662F},
+		{High, 7, 0x083B},
+	}
+	for _, f := range formats {
+		if got := formatInformation(f.level, f.mask); got != f.expected {
+			t.Errorf("Format of level %d mask %d: expected %015b, got %015b.", f.level, f.mask, f.expected, got)
+		}
+	}
+	if got := versionInformation(7); got != 0x07C94 {
+		t.Errorf("Version 7: expected %018b, got %018b.", 0x07C94, got)
+	}
+}
+
+func TestDataCodewords(t *testing.T) {
+	capacities := []struct {
+		version  int
+		level    Level
+		expected int
+	}{
+		{1, Medium, 16},
+		{5, Quartile, 62},
+		{10, Medium, 216},
+		{40, Low, 2956},
+		{40, High, 1276},
+	}
+	for _, c := range capacities {
+		if got := dataCodewords(c.version, c.level); got != c.expected {
+			t.Errorf("Version %d level %d: expected %d data codewords, got %d.", c.version, c.level, c.expected, got)
+		}
+	}
+}
+
+// decode reads back the bytes of a code, checking its format information
+// and the error correction of every block.
+func decode(t *testing.T, c *Code) []byte {
+	bits := 0
+	for i := 0; i <= 5; i++ {
+		if c.Dark(8, i) {
+			bits |= 1 << uint(i)
+		}
+	}
+	for i, xy := range [][2]int{{8, 7}, {8, 8}, {7, 8}} {
+		if c.Dark(xy[0], xy[1]) {
+			bits |= 1 << uint(6+i)
+		}
+	}
+	for i := 9; i < 15; i++ {
+		if c.Dark(14-i, 8) {
+			bits |= 1 << uint(i)
+		}
+	}
+	mask := -1
+	for m := 0; m < 8; m++ {
+		if formatInformation(c.Level, m) == bits {
+			mask = m
+		}
+	}
+	if mask < 0 {
+		t.Fatalf("Format information %015b does not match level %d.", bits, c.Level)
+	}
+	// Remove the mask from a copy and read the codewords in drawing order.
+	plain := newCode(c.Version, c.Level)
+	plain.drawFunctionPatterns()
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if !plain.function[y][x] {
+				plain.modules[y][x] = c.Dark(x, y)
+			}
+		}
+	}
+	plain.applyMask(mask)
+	raw := rawCodewords(c.Version)
+	codewords := make([]byte, raw)
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < c.Size; vert++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vert
+				if (right+1)&2 == 0 {
+					y = c.Size - 1 - vert
+				}
+				if !plain.function[y][x] && i < raw*8 {
+					if plain.modules[y][x] {
+						codewords[i>>3] |= 0x80 >> uint(i&7)
+					}
+					i++
+				}
+			}
+		}
+	}
+	blocks := eccBlocks[c.Level][c.Version]
+	eccLen := eccCodewordsPerBlock[c.Level][c.Version]
+	shortBlocks := blocks - raw%blocks
+	shortLen := raw / blocks
+	dataBlocks := make([][]byte, blocks)
+	eccs := make([][]byte, blocks)
+	k := 0
+	for i := 0; i <= shortLen-eccLen; i++ {
+		for j := range dataBlocks {
+			if i < shortLen-eccLen || j >= shortBlocks {
+				dataBlocks[j] = append(dataBlocks[j], codewords[k])
+				k++
+			}
+		}
+	}
+	for i := 0; i < eccLen; i++ {
+		for j := range eccs {
+			eccs[j] = append(eccs[j], codewords[k])
+			k++
+		}
+	}
+	var data []byte
+	for j, block := range dataBlocks {
+		if !bytes.Equal(reedSolomonRemainder(block, reedSolomonDivisor(eccLen)), eccs[j]) {
+			t.Fatalf("Block %d has wrong error correction codewords.", j)
+		}
+		data = append(data, block...)
+	}
+	if data[0]>>4 != 0x4 {
+		t.Fatalf("Expected byte mode, got %04b.", data[0]>>4)
+	}
+	var length int
+	var content []byte
+	if countBits(c.Version) == 8 {
+		length = int(data[0]&0xF)<<4 | int(data[1]>>4)
+		content = data[1:]
+	} else {
+		length = int(data[0]&0xF)<<12 | int(data[1])<<4 | int(data[2]>>4)
+		content = data[2:]
+	}
+	result := make([]byte, length)
+	for i := range result {
+		result[i] = content[i]<<4 | content[i+1]>>4
+	}
+	return result
+}
+
+func TestEncode(t *testing.T) {
+	inputs := []struct {
+		data    string
+		level   Level
+		version int
+	}{
+		{"HELLO WORLD", Medium, 1},
+		{"https://example.org/identify?s=AQZzcGVjaWVzAgJkMQECczECZDIA", Medium, 4},
+		{strings.Repeat("taxonomia ", 30), Low, 11},
+		{strings.Repeat("0123456789", 50), High, 24},
+	}
+	for _, input := range inputs {
+		c, err := Encode([]byte(input.data), input.level)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Version != input.version || c.Size != input.version*4+17 {
+			t.Errorf("Expected version %d for %d bytes, got %d.", input.version, len(input.data), c.Version)
+		}
+		if got := string(decode(t, c)); got != input.data {
+			t.Errorf("Expected %q, decoded %q.", input.data, got)
+		}
+	}
+	if _, err := Encode(make([]byte, 3000), Low); err != ErrTooLong {
+		t.Errorf("Expected ErrTooLong, got %v.", err)
+	}
+}
+
+func TestImage(t *testing.T) {
+	c, _ := Encode([]byte("HELLO WORLD"), Medium)
+	img := c.Image(2)
+	if side := img.Bounds().Dx(); side != (21+2*QuietZone)*2 {
+		t.Errorf("Expected a side of %d pixels, got %d.", (21+2*QuietZone)*2, side)
+	}
+	// The top left module of the finder pattern is dark, the quiet zone light.
+	if r, _, _, _ := img.At(QuietZone*2, QuietZone*2).RGBA(); r != 0 {
+		t.Error("Expected the first module to be dark.")
+	}
+	if r, _, _, _ := img.At(0, 0).RGBA(); r == 0 {
+		t.Error("Expected the quiet zone to be light.")
+	}
+}
@@ -0,0 +1,144 @@
+package dataset
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	RankKingdom    = "kingdom"
+	RankPhylum     = "phylum"
+	RankClass      = "class"
+	RankOrder      = "order"
+	RankFamily     = "family"
+	RankSubfamily  = "subfamily"
+	RankTribe      = "tribe"
+	RankGenus      = "genus"
+	RankSubgenus   = "subgenus"
+	RankSection    = "section"
+	RankSpecies    = "species"
+	RankSubspecies = "subspecies"
+	RankVariety    = "variety"
+	RankForm       = "form"
+)
+
+// Ranks lists the standard ranks from the highest to the lowest.
+var Ranks = []string{
+	RankKingdom, RankPhylum, RankClass, RankOrder, RankFamily, RankSubfamily, RankTribe,
+	RankGenus, RankSubgenus, RankSection, RankSpecies, RankSubspecies, RankVariety, RankForm,
+}
+
+var rankAliases = map[string]string{
+	"regnum":       RankKingdom,
+	"reg":          RankKingdom,
+	"phyl_div":     RankPhylum,
+	"division":     RankPhylum,
+	"divisio":      RankPhylum,
+	"classis":      RankClass,
+	"cl":           RankClass,
+	"ordo":         RankOrder,
+	"ord":          RankOrder,
+	"familia":      RankFamily,
+	"fam":          RankFamily,
+	"subfamilia":   RankSubfamily,
+	"subfam":       RankSubfamily,
+	"tribus":       RankTribe,
+	"trib":         RankTribe,
+	"gen":          RankGenus,
+	"subgen":       RankSubgenus,
+	"sect":         RankSection,
+	"sectio":       RankSection,
+	"sp":           RankSpecies,
+	"spec":         RankSpecies,
+	"subsp":        RankSubspecies,
+	"ssp":          RankSubspecies,
+	"infraspecies": RankSubspecies,
+	"var":          RankVariety,
+	"varietas":     RankVariety,
+	"f":            RankForm,
+	"forma":        RankForm,
+}
+
+// ParseRank returns the standard rank matching a rank name, as found in
+// Hazo extras, DwC taxonRank terms or SDD rank abbreviations.
+func ParseRank(name string) (string, error) {
+	key := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+	if key == "" {
+		return "", nil
+	}
+	if RankLevel(key) >= 0 {
+		return key, nil
+	}
+	if rank, ok := rankAliases[key]; ok {
+		return rank, nil
+	}
+	return "", fmt.Errorf("unknown rank %q", name)
+}
+
+// RankLevel returns the position of a rank in Ranks, or -1 when the rank is
+// not standard.
+func RankLevel(rank string) int {
+	for i, r := range Ranks {
+		if r == rank {
+			return i
+		}
+	}
+	return -1
+}
+
+func (ds *Dataset) SetRank(taxonId string, rank string) error {
+	taxon, ok := ds.TaxonsById[taxonId]
+	if !ok {
+		return fmt.Errorf("cannot set rank: no taxon with id %q", taxonId)
+	}
+	if rank != "" && RankLevel(rank) < 0 {
+		return fmt.Errorf("cannot set rank of taxon %q: unknown rank %q", taxonId, rank)
+	}
+	taxon.Rank = rank
+	return nil
+}
+
+type RankError struct {
+	TaxonId      string
+	Rank         string
+	AncestorId   string
+	AncestorRank string
+}
+
+func (e *RankError) Error() string {
+	return fmt.Sprintf("taxon %q of rank %s is below taxon %q of rank %s", e.TaxonId, e.Rank, e.AncestorId, e.AncestorRank)
+}
+
+// ValidateRanks reports the taxons whose rank is not strictly lower than the
+// rank of their closest ranked ancestor.
+func (ds *Dataset) ValidateRanks() []*RankError {
+	errs := []*RankError{}
+	var walk func(h *Hierarchy, ancestor *Taxon)
+	walk = func(h *Hierarchy, ancestor *Taxon) {
+		if taxon, ok := ds.TaxonsById[h.Id]; ok && taxon.Rank != "" {
+			if ancestor != nil && RankLevel(taxon.Rank) <= RankLevel(ancestor.Rank) {
+				errs = append(errs, &RankError{TaxonId: taxon.Id, Rank: taxon.Rank, AncestorId: ancestor.Id, AncestorRank: ancestor.Rank})
+			}
+			ancestor = taxon
+		}
+		for _, child := range h.Children {
+			walk(child, ancestor)
+		}
+	}
+	walk(ds.TaxonsHierarchy, nil)
+	return errs
+}
+
+// TaxonAtRank returns the taxon or its closest ancestor having the given rank.
+func (ds *Dataset) TaxonAtRank(taxonId string, rank string) (*Taxon, bool) {
+	for id := taxonId; id != "" && id != ds.TaxonsHierarchy.Id; id = ds.ParentTaxonId(id) {
+		taxon, ok := ds.TaxonsById[id]
+		if !ok {
+			return nil, false
+		}
+		if taxon.Rank == rank {
+			return taxon, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,92 @@
+package dataset
+
+import "testing"
+
+func TestParseRank(t *testing.T) {
+	cases := map[string]string{
+		"Genus":   RankGenus,
+		"gen.":    RankGenus,
+		"sp.":     RankSpecies,
+		"subsp.":  RankSubspecies,
+		"var.":    RankVariety,
+		"f.":      RankForm,
+		"Familia": RankFamily,
+		"":        "",
+	}
+	for name, expected := range cases {
+		rank, err := ParseRank(name)
+		if err != nil {
+			t.Logf("Unexpected error parsing %q: %q.", name, err.Error())
+			t.Fail()
+		} else if rank != expected {
+			t.Logf("Wrong rank for %q, expected %q, got %q.", name, expected, rank)
+			t.Fail()
+		}
+	}
+	if _, err := ParseRank("clade"); err == nil {
+		t.Logf("Expected an error for an unknown rank.")
+		t.Fail()
+	}
+}
+
+func TestValidateRanks(t *testing.T) {
+	ds := readHazoString(t, hazoFixture)
+	ds.SetRank("t1", RankGenus)
+	ds.SetRank("t3", RankSpecies)
+	if errs := ds.ValidateRanks(); len(errs) != 0 {
+		t.Logf("Unexpected rank errors: %v.", errs)
+		t.Fail()
+	}
+	ds.SetRank("t4", RankFamily)
+	errs := ds.ValidateRanks()
+	if len(errs) != 1 || errs[0].TaxonId != "t4" || errs[0].AncestorId != "t1" {
+		t.Logf("Expected t4 to be reported below t1, got %v.", errs)
+		t.Fail()
+	}
+	if err := ds.SetRank("t2", "clade"); err == nil {
+		t.Logf("Expected an error for an unknown rank.")
+		t.Fail()
+	}
+}
+
+func TestTaxonAtRank(t *testing.T) {
+	ds := readHazoString(t, hazoFixture)
+	ds.SetRank("t1", RankGenus)
+	ds.SetRank("t3", RankSpecies)
+	if taxon, ok := ds.TaxonAtRank("t3", RankGenus); !ok || taxon.Id != "t1" {
+		t.Logf("Expected t1 to be the genus of t3.")
+		t.Fail()
+	}
+	if _, ok := ds.TaxonAtRank("t2", RankGenus); ok {
+		t.Logf("Expected t2 to have no genus.")
+		t.Fail()
+	}
+}
+
+func TestHazoRank(t *testing.T) {
+	ds := readHazoString(t, `{ "id": "ds", "taxons": [ { "id": "t1", "name": "a", "extra": { "rank": "gen." } } ] }`)
+	if rank := ds.TaxonsById["t1"].Rank; rank != RankGenus {
+		t.Logf("Wrong rank, expected %q, got %q.", RankGenus, rank)
+		t.FailNow()
+	}
+	if _, ok := ds.TaxonsById["t1"].ExtraInfo["rank"]; ok {
+		t.Logf("The rank should not be kept as an extra info.")
+		t.Fail()
+	}
+	roundTrip := readHazoString(t, writeHazoString(t, ds, HazoOptions{}))
+	if rank := roundTrip.TaxonsById["t1"].Rank; rank != RankGenus {
+		t.Logf("Rank lost after a round trip, got %q.", rank)
+		t.Fail()
+	}
+	other := ds.Clone()
+	other.SetRank("t1", RankSpecies)
+	diff, err := Diff(ds, other)
+	if err != nil {
+		t.Logf("Unexpected error: %q.", err.Error())
+		t.FailNow()
+	}
+	if _, err := ds.Apply(diff.Patch); err != nil || ds.TaxonsById["t1"].Rank != RankSpecies {
+		t.Logf("Rank change not applied by the patch.")
+		t.Fail()
+	}
+}
@@ -0,0 +1,225 @@
+package dataset
+
+import (
+	"encoding/xml"
+	"io"
+)
+
+type sddRef struct {
+	Ref string `xml:"ref,attr"`
+}
+
+type sddRepresentation struct {
+	Label        string   `xml:"Label"`
+	Detail       string   `xml:"Detail"`
+	MediaObjects []sddRef `xml:"MediaObject"`
+}
+
+type sddItem struct {
+	Id             string            `xml:"id,attr"`
+	Representation sddRepresentation `xml:"Representation"`
+}
+
+// sddRank is the rank of a taxon name, an SDD rank code such as "sp." or
+// else a literal name.
+type sddRank struct {
+	Code    string `xml:"code,attr"`
+	Literal string `xml:"literal,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type sddTaxonName struct {
+	sddItem
+	Rank *sddRank `xml:"Rank"`
+}
+
+type sddCharacter struct {
+	sddItem
+	States []sddItem `xml:"States>StateDefinition"`
+}
+
+type sddNode struct {
+	Id        string  `xml:"id,attr"`
+	Parent    *sddRef `xml:"Parent"`
+	TaxonName sddRef  `xml:"TaxonName"`
+}
+
+type sddCategorical struct {
+	Ref    string   `xml:"ref,attr"`
+	States []sddRef `xml:"State"`
+}
+
+type sddDescription struct {
+	sddItem
+	Scope       []sddRef         `xml:"Scope>TaxonName"`
+	Categorical []sddCategorical `xml:"SummaryData>Categorical"`
+}
+
+type sddMediaObject struct {
+	sddItem
+	Source struct {
+		Href string `xml:"href,attr"`
+	} `xml:"Source"`
+}
+
+type sddDataset struct {
+	TaxonNames   []sddTaxonName   `xml:"TaxonNames>TaxonName"`
+	Hierarchies  []sddNodes       `xml:"TaxonHierarchies>TaxonHierarchy>Nodes"`
+	Characters   []sddCharacter   `xml:"Characters>CategoricalCharacter"`
+	Descriptions []sddDescription `xml:"CodedDescriptions>CodedDescription"`
+	MediaObjects []sddMediaObject `xml:"MediaObjects>MediaObject"`
+}
+
+type sddNodes struct {
+	Nodes []sddNode `xml:"Node"`
+}
+
+type sddFile struct {
+	Datasets []sddDataset `xml:"Dataset"`
+}
+
+// sddHierarchy returns the hierarchy of an SDD item, with the pictures of
+// the media objects it refers to.
+func sddHierarchy(item sddItem, mediaById map[string]*sddMediaObject) *Hierarchy {
+	h := &Hierarchy{
+		Id:          item.Id,
+		Name:        *NewMultilangText(item.Representation.Label),
+		Description: item.Representation.Detail,
+	}
+	for _, ref := range item.Representation.MediaObjects {
+		if media, ok := mediaById[ref.Ref]; ok && media.Source.Href != "" {
+			h.Pictures = append(h.Pictures, Picture{
+				Id:     media.Id,
+				Source: media.Source.Href,
+				Legend: media.Representation.Label,
+			})
+		}
+	}
+	return h
+}
+
+// ReadSDD reads the first dataset of an SDD 1.1 document, keeping its taxon
+// names with their ranks and the first taxon hierarchy, its categorical characters and the
+// states of the coded descriptions. Descriptions without a taxon name in
+// their scope become taxons of their own.
+func ReadSDD(r io.Reader) (*Dataset, error) {
+	file := sddFile{}
+	if err := xml.NewDecoder(r).Decode(&file); err != nil {
+		return nil, err
+	}
+	ds := New("")
+	if len(file.Datasets) == 0 {
+		return ds, nil
+	}
+	sdd := file.Datasets[0]
+	mediaById := make(map[string]*sddMediaObject, len(sdd.MediaObjects))
+	for i := range sdd.MediaObjects {
+		mediaById[sdd.MediaObjects[i].Id] = &sdd.MediaObjects[i]
+	}
+	statesByIds := map[string]*State{}
+	stateOwners := map[string]string{}
+	for _, encoded := range sdd.Characters {
+		if _, ok := ds.CharactersById[encoded.Id]; ok {
+			ds.noteProblem(encoded.Id, "is the id of several characters")
+			continue
+		}
+		ch := NewCharacter(sddHierarchy(encoded.sddItem, mediaById))
+		for _, encodedState := range encoded.States {
+			if _, ok := statesByIds[encodedState.Id]; ok {
+				ds.noteProblem(encodedState.Id, "is the id of several states")
+				continue
+			}
+			h := sddHierarchy(encodedState, mediaById)
+			state := State{Id: h.Id, Name: h.Name, Description: h.Description, Pictures: h.Pictures}
+			ch.States = append(ch.States, state)
+			statesByIds[state.Id] = &state
+			stateOwners[state.Id] = ch.Id
+		}
+		ds.AddCharacterBelow(ch, ds.CharactersHierarchy)
+	}
+	taxonsById := map[string]*Taxon{}
+	var taxonIds []string
+	for _, name := range sdd.TaxonNames {
+		if _, ok := taxonsById[name.Id]; ok {
+			ds.noteProblem(name.Id, "is the id of several taxons")
+			continue
+		}
+		taxon := NewTaxon(sddHierarchy(name.sddItem, mediaById))
+		if name.Rank != nil {
+			rankName := name.Rank.Code
+			if rankName == "" {
+				rankName = name.Rank.Literal
+			}
+			if rankName == "" {
+				rankName = name.Rank.Text
+			}
+			if rank, err := ParseRank(rankName); err == nil {
+				taxon.Rank = rank
+			} else {
+				ds.noteProblem(name.Id, "has unknown rank %q", rankName)
+			}
+		}
+		taxonsById[name.Id] = taxon
+		taxonIds = append(taxonIds, name.Id)
+	}
+	for _, desc := range sdd.Descriptions {
+		var taxons []*Taxon
+		for _, ref := range desc.Scope {
+			if taxon, ok := taxonsById[ref.Ref]; ok {
+				taxons = append(taxons, taxon)
+			} else {
+				ds.noteProblem(desc.Id, "describes unknown taxon %q", ref.Ref)
+			}
+		}
+		if len(desc.Scope) == 0 {
+			if _, ok := taxonsById[desc.Id]; ok {
+				ds.noteProblem(desc.Id, "is the id of several taxons")
+				continue
+			}
+			taxon := NewTaxon(sddHierarchy(desc.sddItem, mediaById))
+			taxonsById[taxon.Id] = taxon
+			taxonIds = append(taxonIds, taxon.Id)
+			taxons = append(taxons, taxon)
+		}
+		for _, categorical := range desc.Categorical {
+			for _, ref := range categorical.States {
+				state, ok := statesByIds[ref.Ref]
+				if !ok || stateOwners[ref.Ref] != categorical.Ref {
+					ds.noteProblem(desc.Id, "has unknown state %q of character %q", ref.Ref, categorical.Ref)
+					continue
+				}
+				for _, taxon := range taxons {
+					taxon.States = append(taxon.States, state)
+				}
+			}
+		}
+	}
+	taxonOfNode := map[string]*Taxon{}
+	placed := map[string]bool{}
+	if len(sdd.Hierarchies) > 0 {
+		for _, node := range sdd.Hierarchies[0].Nodes {
+			taxon, ok := taxonsById[node.TaxonName.Ref]
+			if !ok || placed[taxon.Id] {
+				ds.noteProblem(node.Id, "is a node of unknown or already placed taxon %q", node.TaxonName.Ref)
+				continue
+			}
+			parent := ds.TaxonsHierarchy
+			if node.Parent != nil {
+				if parentTaxon, ok := taxonOfNode[node.Parent.Ref]; ok {
+					parent = parentTaxon.Hierarchy
+				} else {
+					ds.noteProblem(node.Id, "has unknown parent node %q", node.Parent.Ref)
+				}
+			}
+			ds.AddTaxonBelow(taxon, parent)
+			taxonOfNode[node.Id] = taxon
+			placed[taxon.Id] = true
+		}
+	}
+	for _, id := range taxonIds {
+		if !placed[id] {
+			ds.AddTaxonBelow(taxonsById[id], ds.TaxonsHierarchy)
+		}
+	}
+	return ds, nil
+}
@@ -0,0 +1,92 @@
+package dataset
+
+import (
+	"strings"
+	"testing"
+)
+
+const sddFixture = `<?xml version="1.0" encoding="UTF-8"?>
+<Datasets xmlns="http://rs.tdwg.org/UBIF/2006/">
+	<Dataset xml:lang="en">
+		<TaxonNames>
+			<TaxonName id="t1"><Representation><Label>Quercus</Label></Representation><Rank code="gen." literal="genus"/></TaxonName>
+			<TaxonName id="t2"><Representation><Label>Quercus robur</Label><MediaObject ref="m1"/></Representation><Rank literal="species"/></TaxonName>
+		</TaxonNames>
+		<TaxonHierarchies>
+			<TaxonHierarchy id="h1">
+				<Nodes>
+					<Node id="n1"><TaxonName ref="t1"/></Node>
+					<Node id="n2"><Parent ref="n1"/><TaxonName ref="t2"/></Node>
+				</Nodes>
+			</TaxonHierarchy>
+		</TaxonHierarchies>
+		<Characters>
+			<CategoricalCharacter id="c1">
+				<Representation><Label>Leaf shape</Label></Representation>
+				<States>
+					<StateDefinition id="s1"><Representation><Label>lobed</Label></Representation></StateDefinition>
+					<StateDefinition id="s2"><Representation><Label>entire</Label></Representation></StateDefinition>
+				</States>
+			</CategoricalCharacter>
+			<QuantitativeCharacter id="c2"><Representation><Label>Height</Label></Representation></QuantitativeCharacter>
+		</Characters>
+		<CodedDescriptions>
+			<CodedDescription id="D1">
+				<Representation><Label>Quercus robur</Label></Representation>
+				<Scope><TaxonName ref="t2"/></Scope>
+				<SummaryData>
+					<Categorical ref="c1"><State ref="s1"/><State ref="s9"/></Categorical>
+				</SummaryData>
+			</CodedDescription>
+			<CodedDescription id="D2">
+				<Representation><Label>Fagus</Label></Representation>
+				<SummaryData><Categorical ref="c1"><State ref="s2"/></Categorical></SummaryData>
+			</CodedDescription>
+		</CodedDescriptions>
+		<MediaObjects>
+			<MediaObject id="m1"><Representation><Label>Leaves</Label></Representation><Source href="http://example.org/q.jpg"/></MediaObject>
+		</MediaObjects>
+	</Dataset>
+</Datasets>`
+
+func TestReadSDD(t *testing.T) {
+	ds, err := ReadSDD(strings.NewReader(sddFixture))
+	if err != nil {
+		t.Logf("Unexpected error: %q.", err.Error())
+		t.FailNow()
+	}
+	if len(ds.TaxonsById) != 3 || len(ds.CharactersById) != 1 {
+		t.Logf("Expected 3 taxons and 1 character, got %d and %d.", len(ds.TaxonsById), len(ds.CharactersById))
+		t.FailNow()
+	}
+	if parentId := ds.ParentTaxonId("t2"); parentId != "t1" {
+		t.Logf("Expected t2 below t1, got %q.", parentId)
+		t.Fail()
+	}
+	if rank := ds.TaxonsById["t1"].Rank; rank != RankGenus {
+		t.Logf("Expected t1 to be a genus, got %q.", rank)
+		t.Fail()
+	}
+	robur := ds.TaxonsById["t2"]
+	if robur.Rank != RankSpecies {
+		t.Logf("Expected t2 to be a species, got %q.", robur.Rank)
+		t.Fail()
+	}
+	if len(robur.States) != 1 || robur.States[0].Id != "s1" {
+		t.Logf("Expected t2 to have state s1, got %v.", robur.States)
+		t.Fail()
+	}
+	if len(robur.Pictures) != 1 || robur.Pictures[0].Source != "http://example.org/q.jpg" {
+		t.Logf("Expected t2 to have the picture of m1, got %v.", robur.Pictures)
+		t.Fail()
+	}
+	if fagus, ok := ds.TaxonsById["D2"]; !ok || fagus.Name.Scientific != "Fagus" || len(fagus.States) != 1 {
+		t.Logf("Expected description D2 to become a taxon.")
+		t.Fail()
+	}
+	errs := ds.ValidateReferences()
+	if len(errs) != 1 || errs[0].ItemId != "D1" {
+		t.Logf("Expected the unknown state of D1 to be reported, got %v.", errs)
+		t.Fail()
+	}
+}
@@ -0,0 +1,210 @@
+package dataset
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type SubsetOptions struct {
+	DropNonDiscriminating bool
+}
+
+func (ds *Dataset) Subset(taxonIds []string, characterIds []string) (*Dataset, error) {
+	return ds.SubsetWithOptions(taxonIds, characterIds, SubsetOptions{})
+}
+
+// pruneHierarchy removes the nodes that are not kept, attaching their kept
+// descendants to the closest kept ancestor.
+func pruneHierarchy(h *Hierarchy, kept map[string]bool) []*Hierarchy {
+	children := []*Hierarchy{}
+	for _, child := range h.Children {
+		children = append(children, pruneHierarchy(child, kept)...)
+	}
+	if !kept[h.Id] {
+		return children
+	}
+	h.Children = children
+	return []*Hierarchy{h}
+}
+
+func markSubtree(root *Hierarchy, id string, kept map[string]bool) bool {
+	parent, index := findParent(root, id)
+	if parent == nil {
+		return false
+	}
+	walkHierarchy(parent.Children[index], func(h *Hierarchy) {
+		kept[h.Id] = true
+	})
+	return true
+}
+
+// SubsetWithOptions returns a copy of the dataset restricted to the subtrees
+// of the given taxons and characters, an empty list selecting everything.
+// Characters holding states other kept characters depend on are kept too.
+func (ds *Dataset) SubsetWithOptions(taxonIds []string, characterIds []string, opts SubsetOptions) (*Dataset, error) {
+	subset := ds.Clone()
+	keptTaxons := map[string]bool{}
+	if len(taxonIds) == 0 {
+		taxonIds = []string{subset.TaxonsHierarchy.Id}
+		keptTaxons[subset.TaxonsHierarchy.Id] = true
+	}
+	for _, id := range taxonIds {
+		if id != subset.TaxonsHierarchy.Id && !markSubtree(subset.TaxonsHierarchy, id, keptTaxons) {
+			return nil, fmt.Errorf("cannot extract subset: no taxon with id %q", id)
+		}
+	}
+	keptCharacters := map[string]bool{}
+	if len(characterIds) == 0 {
+		characterIds = []string{subset.CharactersHierarchy.Id}
+		keptCharacters[subset.CharactersHierarchy.Id] = true
+	}
+	for _, id := range characterIds {
+		if id != subset.CharactersHierarchy.Id && !markSubtree(subset.CharactersHierarchy, id, keptCharacters) {
+			return nil, fmt.Errorf("cannot extract subset: no character with id %q", id)
+		}
+	}
+	if keptCharacters[subset.CharactersHierarchy.Id] {
+		walkHierarchy(subset.CharactersHierarchy, func(h *Hierarchy) { keptCharacters[h.Id] = true })
+	}
+	if keptTaxons[subset.TaxonsHierarchy.Id] {
+		walkHierarchy(subset.TaxonsHierarchy, func(h *Hierarchy) { keptTaxons[h.Id] = true })
+	}
+	owners := stateOwners(subset)
+	dependencyStates := map[string]bool{}
+	for changed := true; changed; {
+		changed = false
+		for id := range keptCharacters {
+			ch, ok := subset.CharactersById[id]
+			if !ok {
+				continue
+			}
+			deps := append(append([]*State{}, ch.RequiredStates...), ch.InapplicableStates...)
+			if ch.InherentState != nil {
+				deps = append(deps, ch.InherentState)
+			}
+			for _, state := range deps {
+				dependencyStates[state.Id] = true
+				if owner, ok := owners[state.Id]; ok && !keptCharacters[owner] {
+					keptCharacters[owner] = true
+					changed = true
+				}
+			}
+		}
+	}
+	keptTaxons[subset.TaxonsHierarchy.Id] = true
+	keptCharacters[subset.CharactersHierarchy.Id] = true
+	pruneHierarchy(subset.TaxonsHierarchy, keptTaxons)
+	pruneHierarchy(subset.CharactersHierarchy, keptCharacters)
+	for id := range subset.TaxonsById {
+		if !keptTaxons[id] {
+			delete(subset.TaxonsById, id)
+		}
+	}
+	for id := range subset.CharactersById {
+		if !keptCharacters[id] {
+			delete(subset.CharactersById, id)
+		}
+	}
+	usedStates := map[string]bool{}
+	for _, taxon := range subset.TaxonsById {
+		states := taxon.States[:0]
+		for _, state := range taxon.States {
+			if keptCharacters[owners[state.Id]] {
+				states = append(states, state)
+				usedStates[state.Id] = true
+			}
+		}
+		taxon.States = states
+	}
+	for _, ch := range subset.CharactersById {
+		states := ch.States[:0]
+		for _, state := range ch.States {
+			if usedStates[state.Id] || dependencyStates[state.Id] {
+				states = append(states, state)
+			}
+		}
+		ch.States = states
+	}
+	referencedBooks := map[string]bool{}
+	for _, taxon := range subset.TaxonsById {
+		for _, ref := range taxon.References {
+			referencedBooks[ref.BookId] = true
+		}
+	}
+	books := subset.Books[:0]
+	for _, book := range subset.Books {
+		if referencedBooks[book.Id] {
+			books = append(books, book)
+		}
+	}
+	subset.Books = books
+	if opts.DropNonDiscriminating {
+		subset.dropNonDiscriminating(dependencyStates)
+	}
+	return subset, nil
+}
+
+// discriminates tells if the taxa coded for a character do not all have the
+// same set of its states, whatever the order they were coded in.
+func (ds *Dataset) discriminates(ch *Character) bool {
+	ownStates := map[string]bool{}
+	for _, state := range ch.States {
+		ownStates[state.Id] = true
+	}
+	firstCoding := ""
+	for _, taxon := range ds.TaxonsById {
+		stateIds := []string{}
+		for _, state := range taxon.States {
+			if ownStates[state.Id] {
+				stateIds = append(stateIds, state.Id)
+			}
+		}
+		if len(stateIds) == 0 {
+			continue
+		}
+		sort.Strings(stateIds)
+		coding := strings.Join(stateIds, ",")
+		if firstCoding == "" {
+			firstCoding = coding
+		} else if coding != firstCoding {
+			return true
+		}
+	}
+	return false
+}
+
+// dropNonDiscriminating removes the leaf characters for which every coded
+// taxon has the same states, unless another character depends on them.
+func (ds *Dataset) dropNonDiscriminating(dependencyStates map[string]bool) {
+	var drop func(h *Hierarchy)
+	drop = func(h *Hierarchy) {
+		children := h.Children[:0]
+		for _, child := range h.Children {
+			drop(child)
+			ch, ok := ds.CharactersById[child.Id]
+			if ok && len(child.Children) == 0 && !ds.discriminates(ch) {
+				dependedOn := false
+				for _, state := range ch.States {
+					dependedOn = dependedOn || dependencyStates[state.Id]
+				}
+				if !dependedOn {
+					delete(ds.CharactersById, child.Id)
+					ds.forgetStates(stateIdSet(stateRefsOf(ch.States)))
+					continue
+				}
+			}
+			children = append(children, child)
+		}
+		h.Children = children
+	}
+	drop(ds.CharactersHierarchy)
+}
+
+func stateRefsOf(states []State) []*State {
+	refs := make([]*State, len(states))
+	for i := range states {
+		refs[i] = &states[i]
+	}
+	return refs
+}
@@ -0,0 +1,102 @@
+package dataset
+
+import "testing"
+
+func TestSubsetByTaxon(t *testing.T) {
+	ds := readHazoString(t, hazoFixture)
+	subset, err := ds.Subset([]string{"t1"}, nil)
+	if err != nil {
+		t.Logf("Unexpected error: %q.", err.Error())
+		t.FailNow()
+	}
+	if got := childrenIds(subset.TaxonsHierarchy); got != "t1" {
+		t.Logf("Wrong root taxons, expected %q, got %q.", "t1", got)
+		t.Fail()
+	}
+	if subset.TaxonsById["t2"] != nil || subset.TaxonsById["t4"] == nil {
+		t.Logf("Wrong taxons kept: %v.", subset.TaxonsById)
+		t.Fail()
+	}
+	if _, state := subset.FindState("s2"); state != nil {
+		t.Logf("Unused state s2 should have been pruned.")
+		t.Fail()
+	}
+	if _, state := subset.FindState("s1"); state == nil {
+		t.Logf("Used state s1 should have been kept.")
+		t.Fail()
+	}
+	if ds.TaxonsById["t2"] == nil {
+		t.Logf("Subset must not modify the original dataset.")
+		t.Fail()
+	}
+}
+
+func TestSubsetKeepsDependencies(t *testing.T) {
+	ds := readHazoString(t, hazoFixture)
+	ds.SetCharacterDependencies("c3", []string{"s2"}, nil, "")
+	subset, err := ds.Subset(nil, []string{"c3"})
+	if err != nil {
+		t.Logf("Unexpected error: %q.", err.Error())
+		t.FailNow()
+	}
+	if subset.CharactersById["c1"] == nil || subset.CharactersById["c2"] != nil {
+		t.Logf("Wrong characters kept: %v.", subset.CharactersById)
+		t.FailNow()
+	}
+	if _, state := subset.FindState("s2"); state == nil {
+		t.Logf("Required state s2 should have been kept.")
+		t.Fail()
+	}
+	if len(subset.CharactersById["c3"].RequiredStates) != 1 {
+		t.Logf("Dependency of c3 should have been kept.")
+		t.Fail()
+	}
+	if subset.TaxonHasState("t1", "s3") {
+		t.Logf("Coding of a dropped character should have been removed.")
+		t.Fail()
+	}
+}
+
+func TestSubsetDropsNonDiscriminating(t *testing.T) {
+	ds := readHazoString(t, hazoFixture)
+	ds.SetTaxonState("t3", "s1")
+	ds.SetTaxonState("t4", "s2")
+	ds.SetTaxonState("t3", "s3")
+	subset, err := ds.SubsetWithOptions(nil, nil, SubsetOptions{DropNonDiscriminating: true})
+	if err != nil {
+		t.Logf("Unexpected error: %q.", err.Error())
+		t.FailNow()
+	}
+	if subset.CharactersById["c2"] != nil || subset.CharactersById["c1"] == nil {
+		t.Logf("Wrong characters kept: %v.", subset.CharactersById)
+		t.Fail()
+	}
+	if subset.TaxonHasState("t1", "s3") {
+		t.Logf("Coding of a dropped character should have been removed.")
+		t.Fail()
+	}
+}
+
+func TestDiscriminatesIgnoresCodingOrder(t *testing.T) {
+	ds := readHazoString(t, hazoFixture)
+	ds.SetTaxonState("t1", "s2")
+	ds.SetTaxonState("t3", "s2")
+	ds.SetTaxonState("t3", "s1")
+	if ds.discriminates(ds.CharactersById["c1"]) {
+		t.Logf("Taxa coded with the same states in another order should not be discriminated.")
+		t.Fail()
+	}
+	ds.SetTaxonState("t4", "s2")
+	if !ds.discriminates(ds.CharactersById["c1"]) {
+		t.Logf("Taxa coded with other states should be discriminated.")
+		t.Fail()
+	}
+}
+
+func TestSubsetUnknownId(t *testing.T) {
+	ds := readHazoString(t, hazoFixture)
+	if _, err := ds.Subset([]string{"t99"}, nil); err == nil {
+		t.Logf("Expected an error for an unknown taxon.")
+		t.Fail()
+	}
+}
@@ -0,0 +1,101 @@
+package dataset
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	SynonymHomotypic   = "homotypic"
+	SynonymHeterotypic = "heterotypic"
+	SynonymMisapplied  = "misapplied"
+)
+
+var SynonymStatuses = []string{SynonymHomotypic, SynonymHeterotypic, SynonymMisapplied}
+
+type Synonym struct {
+	Name   string `json:"name"`
+	Author string `json:"author,omitempty"`
+	Year   int    `json:"year,omitempty"`
+	Status string `json:"status,omitempty"`
+}
+
+func (s Synonym) String() string {
+	var b strings.Builder
+	b.WriteString(s.Name)
+	if s.Author != "" {
+		b.WriteString(" " + s.Author)
+	}
+	if s.Year != 0 {
+		fmt.Fprintf(&b, ", %d", s.Year)
+	}
+	return b.String()
+}
+
+func validSynonymStatus(status string) bool {
+	if status == "" {
+		return true
+	}
+	for _, s := range SynonymStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+func copySynonyms(synonyms []Synonym) []Synonym {
+	if synonyms == nil {
+		return nil
+	}
+	return append([]Synonym{}, synonyms...)
+}
+
+func (ds *Dataset) SetSynonyms(taxonId string, synonyms []Synonym) error {
+	taxon, ok := ds.TaxonsById[taxonId]
+	if !ok {
+		return fmt.Errorf("cannot set synonyms: no taxon with id %q", taxonId)
+	}
+	for _, synonym := range synonyms {
+		if strings.TrimSpace(synonym.Name) == "" {
+			return fmt.Errorf("cannot set synonyms of taxon %q: empty name", taxonId)
+		}
+		if !validSynonymStatus(synonym.Status) {
+			return fmt.Errorf("cannot set synonyms of taxon %q: unknown status %q", taxonId, synonym.Status)
+		}
+	}
+	taxon.Synonyms = copySynonyms(synonyms)
+	return nil
+}
+
+func (ds *Dataset) AddSynonym(taxonId string, synonym Synonym) error {
+	taxon, ok := ds.TaxonsById[taxonId]
+	if !ok {
+		return fmt.Errorf("cannot add synonym: no taxon with id %q", taxonId)
+	}
+	return ds.SetSynonyms(taxonId, append(copySynonyms(taxon.Synonyms), synonym))
+}
+
+// FindByName returns the taxons whose accepted name or one of whose synonyms
+// matches the given name, ignoring case.
+func (ds *Dataset) FindByName(name string) []*Taxon {
+	name = strings.ToLower(strings.TrimSpace(name))
+	found := []*Taxon{}
+	walkHierarchy(ds.TaxonsHierarchy, func(h *Hierarchy) {
+		taxon, ok := ds.TaxonsById[h.Id]
+		if !ok {
+			return
+		}
+		if strings.ToLower(taxon.Name.Scientific) == name {
+			found = append(found, taxon)
+			return
+		}
+		for _, synonym := range taxon.Synonyms {
+			if strings.ToLower(synonym.Name) == name {
+				found = append(found, taxon)
+				return
+			}
+		}
+	})
+	return found
+}
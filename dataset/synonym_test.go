@@ -0,0 +1,164 @@
+package dataset
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHazoSynonymsRoundTrip(t *testing.T) {
+	ds := readHazoString(t, `{ "id": "ds", "taxons": [ { "id": "t1", "name": "a", "name2": "b; c" } ] }`)
+	expected := []Synonym{{Name: "b"}, {Name: "c"}}
+	if got := ds.TaxonsById["t1"].Synonyms; !reflect.DeepEqual(got, expected) {
+		t.Logf("Wrong synonyms, expected %v, got %v.", expected, got)
+		t.FailNow()
+	}
+	if err := ds.AddSynonym("t1", Synonym{Name: "d", Author: "L.", Year: 1753, Status: SynonymHomotypic}); err != nil {
+		t.Logf("Unexpected error: %q.", err.Error())
+		t.FailNow()
+	}
+	roundTrip := readHazoString(t, writeHazoString(t, ds, HazoOptions{}))
+	if got, expected := roundTrip.TaxonsById["t1"].Synonyms, ds.TaxonsById["t1"].Synonyms; !reflect.DeepEqual(got, expected) {
+		t.Logf("Synonyms changed after a round trip, expected %v, got %v.", expected, got)
+		t.Fail()
+	}
+	if _, ok := roundTrip.TaxonsById["t1"].ExtraInfo["synonyms"]; ok {
+		t.Logf("Synonyms should not be kept as an extra info.")
+		t.Fail()
+	}
+	if found := roundTrip.FindByName("D"); len(found) != 1 || found[0].Id != "t1" {
+		t.Logf("Expected to find t1 by its synonym, got %v.", found)
+		t.Fail()
+	}
+}
+
+func TestSetSynonymsValidation(t *testing.T) {
+	ds := readHazoString(t, hazoFixture)
+	if err := ds.SetSynonyms("t1", []Synonym{{Name: "x", Status: "wrong"}}); err == nil {
+		t.Logf("Expected an error for an unknown status.")
+		t.Fail()
+	}
+	if err := ds.SetSynonyms("t1", []Synonym{{Name: " "}}); err == nil {
+		t.Logf("Expected an error for an empty name.")
+		t.Fail()
+	}
+	undo, err := ds.Apply(&Command{Op: OpSetSynonyms, Id: "t1", Synonyms: []Synonym{{Name: "x"}}})
+	if err != nil {
+		t.Logf("Unexpected error: %q.", err.Error())
+		t.FailNow()
+	}
+	if _, err := ds.Apply(undo); err != nil || len(ds.TaxonsById["t1"].Synonyms) != 0 {
+		t.Logf("Undo should remove the synonyms.")
+		t.Fail()
+	}
+}
+
+func TestWriteDwCA(t *testing.T) {
+	ds := readHazoString(t, hazoFixture)
+	ds.SetSynonyms("t3", []Synonym{{Name: "old c", Author: "L.", Year: 1753, Status: SynonymHeterotypic}})
+	var b bytes.Buffer
+	if err := WriteDwCA(&b, ds); err != nil {
+		t.Logf("Unexpected error: %q.", err.Error())
+		t.FailNow()
+	}
+	archive, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Logf("Unexpected error: %q.", err.Error())
+		t.FailNow()
+	}
+	files := map[string]string{}
+	for _, f := range archive.File {
+		r, _ := f.Open()
+		data, _ := ioutil.ReadAll(r)
+		r.Close()
+		files[f.Name] = string(data)
+	}
+	if !strings.Contains(files["meta.xml"], "taxon.txt") {
+		t.Logf("Missing core file in meta.xml: %s", files["meta.xml"])
+		t.Fail()
+	}
+	lines := strings.Split(strings.TrimSpace(files["taxon.txt"]), "\n")
+	if len(lines) != 6 {
+		t.Logf("Expected a header, 4 taxons and 1 synonym, got %d lines.", len(lines))
+		t.FailNow()
+	}
+	expected := "t3-syn1\t\tt3\told c\tL.\t1753\t\theterotypicSynonym"
+	found := false
+	for _, line := range lines {
+		found = found || line == expected
+	}
+	if !found {
+		t.Logf("Missing synonym row %q in %v.", expected, lines)
+		t.Fail()
+	}
+}
+
+func TestReadDwCARoundTrip(t *testing.T) {
+	ds := readHazoString(t, hazoFixture)
+	ds.SetSynonyms("t3", []Synonym{{Name: "old c", Author: "L.", Year: 1753, Status: SynonymHeterotypic}})
+	ds.TaxonsById["t1"].Rank = RankGenus
+	ds.TaxonsById["t3"].Author = "Mill."
+	var b bytes.Buffer
+	if err := WriteDwCA(&b, ds); err != nil {
+		t.Logf("Unexpected error: %q.", err.Error())
+		t.FailNow()
+	}
+	read, err := ReadDwCA(&b)
+	if err != nil {
+		t.Logf("Unexpected error: %q.", err.Error())
+		t.FailNow()
+	}
+	if got := childrenIds(read.TaxonsHierarchy); got != "t1,t2" {
+		t.Logf("Wrong root taxons, expected %q, got %q.", "t1,t2", got)
+		t.Fail()
+	}
+	if got := childrenIds(read.TaxonsById["t1"].Hierarchy); got != "t3,t4" {
+		t.Logf("Wrong children of t1, expected %q, got %q.", "t3,t4", got)
+		t.Fail()
+	}
+	if t1 := read.TaxonsById["t1"]; t1.Rank != RankGenus || t1.Name.Scientific != "a" {
+		t.Logf("Wrong taxon t1: %+v.", t1)
+		t.Fail()
+	}
+	t3 := read.TaxonsById["t3"]
+	if t3.Author != "Mill." || !reflect.DeepEqual(t3.Synonyms, ds.TaxonsById["t3"].Synonyms) {
+		t.Logf("Wrong taxon t3: %+v with synonyms %v.", t3, t3.Synonyms)
+		t.Fail()
+	}
+	if len(read.TaxonsById) != 4 || len(read.ValidateReferences()) != 0 {
+		t.Logf("Expected 4 taxons without errors, got %d and %v.", len(read.TaxonsById), read.ValidateReferences())
+		t.Fail()
+	}
+}
+
+func TestReadDwCAWithoutMeta(t *testing.T) {
+	var b bytes.Buffer
+	archive := zip.NewWriter(&b)
+	f, _ := archive.Create("taxa.csv")
+	io.WriteString(f, "taxonID,parentNameUsageID,scientificName,scientificNameAuthorship,taxonRank\n"+
+		"2,1,\"Quercus robur L.\",L.,species\n"+
+		"1,,Quercus,,genus\n"+
+		"3,9,Fagus,,genre\n")
+	archive.Close()
+	ds, err := ReadDwCA(&b)
+	if err != nil {
+		t.Logf("Unexpected error: %q.", err.Error())
+		t.FailNow()
+	}
+	if got := childrenIds(ds.TaxonsHierarchy); got != "1,3" || ds.ParentTaxonId("2") != "1" {
+		t.Logf("Wrong hierarchy, got %q at the root.", got)
+		t.Fail()
+	}
+	if robur := ds.TaxonsById["2"]; robur.Name.Scientific != "Quercus robur" || robur.Rank != RankSpecies {
+		t.Logf("Expected the author to be removed from the name, got %+v.", robur)
+		t.Fail()
+	}
+	if errs := ds.ValidateReferences(); len(errs) != 2 {
+		t.Logf("Expected the unknown rank and parent of 3 to be reported, got %v.", errs)
+		t.Fail()
+	}
+}
@@ -0,0 +1,105 @@
+package dataset
+
+import (
+	"fmt"
+	"sort"
+)
+
+// ReferenceError is an id used by several items or a reference to an item
+// that does not exist.
+type ReferenceError struct {
+	ItemId  string
+	Message string
+}
+
+func (e *ReferenceError) Error() string {
+	return fmt.Sprintf("item %q %s", e.ItemId, e.Message)
+}
+
+// noteProblem records a reference that a reader could not keep in the
+// dataset, to be reported by ValidateReferences.
+func (ds *Dataset) noteProblem(itemId string, format string, args ...interface{}) {
+	ds.readProblems = append(ds.readProblems, &ReferenceError{ItemId: itemId, Message: fmt.Sprintf(format, args...)})
+}
+
+func sortedIds(n int, each func(add func(id string))) []string {
+	ids := make([]string, 0, n)
+	each(func(id string) { ids = append(ids, id) })
+	sort.Strings(ids)
+	return ids
+}
+
+// ValidateReferences reports the references left out while reading the
+// dataset, the ids shared by several items, and the references to states
+// or books that do not exist.
+func (ds *Dataset) ValidateReferences() []*ReferenceError {
+	errs := append([]*ReferenceError{}, ds.readProblems...)
+	note := func(itemId string, format string, args ...interface{}) {
+		errs = append(errs, &ReferenceError{ItemId: itemId, Message: fmt.Sprintf(format, args...)})
+	}
+	taxonIds := sortedIds(len(ds.TaxonsById), func(add func(string)) {
+		for id := range ds.TaxonsById {
+			add(id)
+		}
+	})
+	characterIds := sortedIds(len(ds.CharactersById), func(add func(string)) {
+		for id := range ds.CharactersById {
+			add(id)
+		}
+	})
+	itemIds := make(map[string]bool, len(taxonIds)+len(characterIds))
+	for _, id := range taxonIds {
+		itemIds[id] = true
+	}
+	stateIds := map[string]bool{}
+	for _, id := range characterIds {
+		if itemIds[id] {
+			note(id, "is the id of both a taxon and a character")
+		}
+		itemIds[id] = true
+	}
+	for _, id := range characterIds {
+		for _, state := range ds.CharactersById[id].States {
+			if itemIds[state.Id] || stateIds[state.Id] {
+				note(state.Id, "is the id of a state and of another item")
+			}
+			stateIds[state.Id] = true
+		}
+	}
+	bookIds := make(map[string]bool, len(ds.Books))
+	for _, book := range ds.Books {
+		bookIds[book.Id] = true
+	}
+	for _, id := range taxonIds {
+		taxon := ds.TaxonsById[id]
+		for _, state := range taxon.States {
+			if state == nil {
+				note(id, "has an empty state")
+			} else if !stateIds[state.Id] {
+				note(id, "has state %q of no character", state.Id)
+			}
+		}
+		for _, ref := range taxon.References {
+			if !bookIds[ref.BookId] {
+				note(id, "refers to unknown book %q", ref.BookId)
+			}
+		}
+	}
+	for _, id := range characterIds {
+		ch := ds.CharactersById[id]
+		for _, state := range ch.RequiredStates {
+			if state == nil || !stateIds[state.Id] {
+				note(id, "requires a state of no character")
+			}
+		}
+		for _, state := range ch.InapplicableStates {
+			if state == nil || !stateIds[state.Id] {
+				note(id, "is inapplicable for a state of no character")
+			}
+		}
+		if ch.InherentState != nil && !stateIds[ch.InherentState.Id] {
+			note(id, "has inherent state %q of no character", ch.InherentState.Id)
+		}
+	}
+	return errs
+}
@@ -0,0 +1,58 @@
+package dataset
+
+import "testing"
+
+func TestValidateReferences(t *testing.T) {
+	ds := readHazoString(t, hazoFixture)
+	if errs := ds.ValidateReferences(); len(errs) != 0 {
+		t.Logf("Unexpected reference errors: %v.", errs)
+		t.FailNow()
+	}
+	ds = readHazoString(t, `{
+		"taxons": [
+			{ "id": "t1", "name": "a", "children": ["t9"], "descriptions": [ { "descriptorId": "c1", "statesIds": ["s1", "s9"] } ] },
+			{ "id": "t1", "name": "b" },
+			{ "id": "c2", "name": "c", "bookInfobyids": { "b1": { "page": "3" } } }
+		],
+		"characters": [
+			{ "id": "c1", "name": "color", "states": ["s1", "s8"], "requiredStatesIds": ["s7"] },
+			{ "id": "c2", "name": "size", "states": ["s1"] }
+		],
+		"states": [ { "id": "s1", "name": "red" } ]
+	}`)
+	expected := map[string]int{"t1": 3, "c1": 2, "c2": 2, "s1": 1}
+	got := map[string]int{}
+	for _, err := range ds.ValidateReferences() {
+		got[err.ItemId]++
+	}
+	for id, count := range expected {
+		if got[id] != count {
+			t.Logf("Expected %d errors for %q, got %d.", count, id, got[id])
+			t.Fail()
+		}
+	}
+	if len(got) != len(expected) {
+		t.Logf("Unexpected errors: %v.", got)
+		t.Fail()
+	}
+}
+
+func TestFormatOf(t *testing.T) {
+	cases := map[string]string{
+		"ds.hazo.json": FormatHazo,
+		"ds":           FormatHazo,
+		"Matrix.CSV":   FormatCSV,
+		"ds.sdd.xml":   FormatSDD,
+		"taxa.zip":     FormatDwCA,
+	}
+	for name, expected := range cases {
+		if format := FormatOf(name); format != expected {
+			t.Logf("Wrong format for %q, expected %q, got %q.", name, expected, format)
+			t.Fail()
+		}
+	}
+	if _, err := ReadFormat("doc", nil); err == nil {
+		t.Logf("Expected an error for an unknown format.")
+		t.Fail()
+	}
+}
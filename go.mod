@@ -3,6 +3,7 @@ module nicolas.galipot.net/taxonomia
 go 1.16
 
 require (
-	github.com/gorilla/sessions v1.2.1 // indirect
-	github.com/mattn/go-sqlite3 v1.14.8 // indirect
+	github.com/gorilla/sessions v1.2.1
+	github.com/mattn/go-sqlite3 v1.14.8
+	golang.org/x/crypto v0.0.0-20211117183948-ae814b36b871
 )
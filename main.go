@@ -1,3 +1,14 @@
+// Command taxonomia imports identification datasets into an SQLite database
+// and serves them.
+//
+// Full-text search relies on the FTS5 extension of SQLite, which
+// github.com/mattn/go-sqlite3 only compiles in with a build tag:
+//
+//	go build -tags sqlite_fts5
+//	go test -tags sqlite_fts5 ./...
+//
+// Without it, searches fall back to matching the words with LIKE, without
+// ranking, prefix matching or folding of diacritics.
 package main
 
 import (
@@ -17,13 +28,33 @@ func main() {
 		case "import":
 			cmd.Import()
 		case "cache":
-			cmd.CacheImages()
+			cmd.CacheImages(os.Args[2:])
 		case "identify":
-			cmd.Identify()
+			cmd.Identify(os.Args[2:])
 		case "lschar":
 			cmd.ListCharacters()
 		case "serve":
 			cmd.Serve(os.Args[2:])
+		case "user":
+			cmd.User(os.Args[2:])
+		case "diff":
+			cmd.Diff(os.Args[2:])
+		case "patch":
+			cmd.Patch(os.Args[2:])
+		case "merge":
+			cmd.Merge(os.Args[2:])
+		case "extract":
+			cmd.Extract(os.Args[2:])
+		case "search":
+			cmd.Search(os.Args[2:])
+		case "names":
+			cmd.Names(os.Args[2:])
+		case "dwca":
+			cmd.ExportDwCA(os.Args[2:])
+		case "bibexport":
+			cmd.ExportBibliography(os.Args[2:])
+		case "bibimport":
+			cmd.ImportBibliography(os.Args[2:])
 		}
 	}
 }